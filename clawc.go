@@ -1,25 +1,69 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/bearlytools/claw/internal/imports"
+	"github.com/bearlytools/claw/internal/lint"
+	"github.com/bearlytools/claw/internal/migrate"
 	"github.com/bearlytools/claw/internal/render"
+	"github.com/bearlytools/claw/internal/report"
 	"github.com/bearlytools/claw/internal/writer"
+	"github.com/bearlytools/claw/languages/go/mapping"
 
 	osfs "github.com/gopherfs/fs/io/os"
 
 	// Registers the golang renderer.
-	_ "github.com/bearlytools/claw/internal/render/golang"
+	"github.com/bearlytools/claw/internal/render/golang"
+	// Registers the .proto renderer.
+	_ "github.com/bearlytools/claw/internal/render/proto"
+	// Registers the JSON Schema renderer.
+	_ "github.com/bearlytools/claw/internal/render/jsonschema"
+)
+
+var (
+	langsFlag   = flag.String("langs", "go", "A comma separated list of outputs to render: go, proto, jsonschema")
+	reportFlag  = flag.Bool("report", false, "Print the wire cost of each field in each Struct instead of rendering")
+	noNamesFlag = flag.Bool("nonames", false, "Leave field names out of generated Go code for smaller binaries, which disables JSON and lookups by name")
 )
 
 func main() {
 	ctx := context.Background()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCmd(os.Args[2:]); err != nil {
+			exit(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		if err := lintCmd(ctx, os.Args[2:]); err != nil {
+			exit(err)
+		}
+		return
+	}
+
 	flag.Parse()
+
+	if *noNamesFlag {
+		render.Supported[render.Go] = golang.Renderer{NoNames: true}
+	}
+
+	var langs []render.Lang
+	for _, s := range strings.Split(*langsFlag, ",") {
+		l, err := render.ParseLang(s)
+		if err != nil {
+			exit(err)
+		}
+		langs = append(langs, l)
+	}
+
 	args := flag.Args()
 	path := ""
 	if len(args) == 0 {
@@ -44,7 +88,14 @@ func main() {
 		exitf("error: %s\n", err)
 	}
 
-	rendered, err := render.Render(ctx, config, render.Go)
+	if *reportFlag {
+		if err := writeReport(config); err != nil {
+			exit(err)
+		}
+		return
+	}
+
+	rendered, err := render.Render(ctx, config, langs...)
 	if err != nil {
 		exit(err)
 	}
@@ -57,6 +108,108 @@ func main() {
 	}
 }
 
+// migrateCmd implements "clawc migrate", which reads records of a Struct encoded with one version of
+// a .claw file from stdin and writes them to stdout encoded with another version.
+func migrateCmd(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "The .claw file the records were encoded with")
+	to := fs.String("to", "", "The .claw file to encode the records with")
+	name := fs.String("struct", "", "The name of the Struct the records hold")
+	remapFlag := fs.String("remap", "", "Field numbers that changed, as old=new pairs separated by commas, such as 1=5,2=3")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" || *name == "" {
+		return fmt.Errorf("clawc migrate needs -from, -to and -struct")
+	}
+	remap, err := migrate.ParseRemap(*remapFlag)
+	if err != nil {
+		return err
+	}
+
+	maps := make([]*mapping.Map, 0, 2)
+	for _, path := range []string{*from, *to} {
+		f, err := migrate.ParseFile(path)
+		if err != nil {
+			return err
+		}
+		m, err := migrate.Mapping(f, *name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		maps = append(maps, m)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if _, err := migrate.Records(w, os.Stdin, maps[0], maps[1], remap); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// lintCmd implements "clawc lint", which writes the problems lint.Check() finds in the .claw file
+// in the directory given, or the current directory, and the .claw files it imports. It returns an
+// error if there are any.
+func lintCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Parse(args)
+
+	path := "."
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	ofs, err := osfs.New()
+	if err != nil {
+		return err
+	}
+	clawFile, err := imports.FindClawFile(ofs, path)
+	if err != nil {
+		return fmt.Errorf("problem finding .claw file: %w", err)
+	}
+	config := imports.NewConfig()
+	if err := config.Read(ctx, clawFile); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(config.Imports))
+	for path := range config.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	total := 0
+	for _, path := range paths {
+		n, err := lint.Write(os.Stdout, config.Imports[path])
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+	if total > 0 {
+		return fmt.Errorf("clawc lint found %d problems", total)
+	}
+	return nil
+}
+
+// writeReport writes the report for every .claw file in config to stdout.
+func writeReport(config *imports.Config) error {
+	paths := make([]string, 0, len(config.Imports))
+	for path := range config.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := report.Write(os.Stdout, config.Imports[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func exit(i ...any) {
 	fmt.Println(i...)
 	os.Exit(1)
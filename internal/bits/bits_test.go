@@ -102,3 +102,14 @@ func TestGetSetBit(t *testing.T) {
 		}
 	}
 }
+
+func TestClearBytes(t *testing.T) {
+	b := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ClearBytes(b, 3, 8)
+	want := []byte{1, 2, 3, 0, 0, 0, 0, 0}
+	for i := range b {
+		if b[i] != want[i] {
+			t.Fatalf("TestClearBytes: got %v, want %v", b, want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -88,6 +89,53 @@ func (f *File) Validate() error {
 	return nil
 }
 
+// HasOption reports if the file option "name" was set.
+func (f *File) HasOption(name string) bool {
+	_, ok := f.Options[name]
+	return ok
+}
+
+// GoCodec returns the Go import path and type name for codec "name" from the GoCodecs() file option.
+func (f *File) GoCodec(name string) (importPath, typeName string, ok bool) {
+	for _, arg := range f.Options["GoCodecs"].Args {
+		n, p, t, err := parseGoCodec(arg)
+		if err == nil && n == name {
+			return p, t, true
+		}
+	}
+	return "", "", false
+}
+
+// GoCodecType returns the Go type for codec "name" as it is written in generated code, such as "uuid.UUID".
+func (f *File) GoCodecType(name string) string {
+	p, t, ok := f.GoCodec(name)
+	if !ok {
+		return ""
+	}
+	return path.Base(p) + "." + t
+}
+
+// GoCodecImports returns the import paths of the Go types of the codecs used by fields in the file.
+func (f *File) GoCodecImports() []string {
+	var imports []string
+	seen := map[string]bool{}
+	for _, s := range f.Structs() {
+		for _, field := range s.Fields {
+			if field.Codec == "" {
+				continue
+			}
+			p, _, ok := f.GoCodec(field.Codec)
+			if !ok || seen[p] {
+				continue
+			}
+			seen[p] = true
+			imports = append(imports, p)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
 // Structs returns all Structs that were decoded.
 func (f *File) Structs() []Struct {
 	if f.Identifers == nil {
@@ -104,6 +152,18 @@ func (f *File) Structs() []Struct {
 	return ret
 }
 
+// Interfaces returns all Interfaces that were decoded, sorted by name.
+func (f *File) Interfaces() []Interface {
+	var ret []Interface
+	for _, i := range f.Identifers {
+		if v, ok := i.(Interface); ok {
+			ret = append(ret, v)
+		}
+	}
+	sort.Slice(ret, func(x, y int) bool { return ret[x].Name < ret[y].Name })
+	return ret
+}
+
 // Enums returns all Enums that were decoded.
 func (f *File) Enums() chan Enum {
 	ch := make(chan Enum, 1)
@@ -263,6 +323,17 @@ func (f *File) FindNext(ctx context.Context, p *halfpike.Parser) halfpike.ParseF
 		}
 		f.Identifers[s.Name] = s
 		return f.FindNext
+	case "interface":
+		p.Backup()
+		i := NewInterface(f)
+		if err := i.parse(p); err != nil {
+			return p.Errorf(err.Error())
+		}
+		if _, ok := f.Identifers[i.Name]; ok {
+			return p.Errorf("Error: found two top level identifiers named %q", i.Name)
+		}
+		f.Identifers[i.Name] = i
+		return f.FindNext
 	default:
 		if p.EOF(line) {
 			return nil
@@ -410,8 +481,11 @@ func (i *Import) parse(p *halfpike.Parser) error {
 
 // Enum is a set of name values that translate to a number.
 type Enum struct {
-	Name   string
-	Size   int
+	Name string
+	Size int
+	// Line is the line in the .claw file the Enum is declared on, starting at 1.
+	Line int
+
 	names  map[string]EnumVal
 	values map[uint16]EnumVal
 }
@@ -424,9 +498,9 @@ func (e Enum) OrderByValues() []EnumVal {
 	// TODO(jdoak): This is stupid, just make .names into .names a slice and insert.
 	// We can do a binary search when looking for duplicates, because this will be small in size.
 	// I'm just too tired to do this now.
-	l := make([]EnumVal, len(e.values))
-	for i, v := range e.values {
-		l[i] = v
+	l := make([]EnumVal, 0, len(e.values))
+	for _, v := range e.values {
+		l = append(l, v)
 	}
 	slices.SortFunc(
 		l,
@@ -439,9 +513,9 @@ func (e Enum) OrderByValues() []EnumVal {
 
 func (e Enum) OrderByNames() []EnumVal {
 	// TODO(jdoak): Same as above.
-	l := make([]EnumVal, len(e.values))
-	for i, v := range e.values {
-		l[i] = v
+	l := make([]EnumVal, 0, len(e.values))
+	for _, v := range e.values {
+		l = append(l, v)
 	}
 	slices.SortFunc(
 		l,
@@ -474,6 +548,8 @@ func NewEnum() Enum {
 type EnumVal struct {
 	Name  string
 	Value uint16
+	// Line is the line in the .claw file the value is declared on, starting at 1.
+	Line int
 }
 
 func (e *Enum) parse(p *halfpike.Parser) error {
@@ -487,6 +563,7 @@ func (e *Enum) parse(p *halfpike.Parser) error {
 	}
 
 	e.Name = l.Items[1].Val
+	e.Line = lineOf(l)
 
 	switch l.Items[2].Val {
 	case "uint8":
@@ -540,7 +617,7 @@ func (e *Enum) parse(p *halfpike.Parser) error {
 		if _, ok := e.values[uint16(n)]; ok {
 			return fmt.Errorf("[Line %d]: error: Enum %q already contains enumerator(%s) with value %d", l.LineNum, e.Name, e.values[uint16(n)].Name, n)
 		}
-		v := EnumVal{Name: l.Items[0].Val, Value: uint16(n)}
+		v := EnumVal{Name: l.Items[0].Val, Value: uint16(n), Line: lineOf(l)}
 		e.names[v.Name] = v
 		e.values[v.Value] = v
 
@@ -585,21 +662,131 @@ type StructField struct {
 	IdentName string
 	// SelfReferential indicates this type is the same Struct type as the containing Struct.
 	SelfReferential bool
+	// WellKnown is set to "Timestamp" or "Duration" if the field holds one of those well-known
+	// Structs. See Struct.WellKnown(). The Go renderer uses this to give the field time.Time or
+	// time.Duration accessors.
+	WellKnown string
+	// Default is the value the getter for the field returns when the field is not set, set with
+	// the [default = value] option. For Enums this is the name of the enumerated value. It is only
+	// allowed on bool, number and Enum fields and is never encoded.
+	Default string
+	// Encrypted is set with the [encrypted] option. The value of the field is passed through the
+	// Crypter given when marshaling and unmarshaling. It is only allowed on string and bytes fields.
+	Encrypted bool
+	// Dense is set with the [dense] option. The field is always encoded at its full size, even when
+	// it is the zero value or not set and the rest of the Struct uses zero value compression. It is only
+	// allowed on bool, number and Enum fields.
+	Dense bool
+	// Group is the name of the field group the field is in, set with the [group = name] option.
+	// The Go renderer generates MarshalGroup(), which encodes only the fields in a group.
+	Group string
+	// Codec is the name of the codec for a bytes field, set with the [codec = name] option. The field
+	// is still bytes on the wire, but the Go accessors use the Go type the GoCodecs() file option
+	// gives for the name and convert with the codec registered for it in the codec package.
+	Codec string
+	// Size is the number of bytes in a FixedBytes field, which is written as [Size]byte in the .claw file.
+	Size uint16
+	// Line is the line in the .claw file the field is declared on, starting at 1.
+	Line int
+}
+
+// GoDefault returns the Default as a Go expression of the field's wire type, such as "int32(30)"
+// or "uint8(Toyota)". If there is no Default, this returns "".
+func (s StructField) GoDefault() string {
+	switch {
+	case s.Default == "":
+		return ""
+	case s.Type == field.FTBool:
+		return s.Default
+	case s.IsEnum:
+		if sp := strings.Split(s.IdentName, "."); len(sp) == 2 {
+			return fmt.Sprintf("%s(%s.%s)", field.GoType(s.Type), sp[0], s.Default)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", field.GoType(s.Type), s.Default)
 }
 
 // GoListType will return the list type: "uint8", "int8", "<Enum Name>", ... for use in
 // templates. If called on a non-list type, this will panic.
 func (s StructField) GoListType() string {
-	if !s.IsList {
+	if !s.IsList && !field.IsList(s.Type) {
 		panic(fmt.Sprintf("bug: field name %s is not a list", s.Name))
 	}
 	if s.IdentName != "" {
 		return s.IdentName
 	}
 
+	return strings.TrimPrefix(field.GoType(s.Type), "[]")
+}
+
+// GoGetterType returns the type returned by the Go getter method for the field, such as "int32",
+// "list.Numbers[uint8]" or "time.Time". Lists of bytes or strings are not supported.
+func (s StructField) GoGetterType() string {
+	switch {
+	case s.Type == field.FTStruct:
+		switch s.WellKnown {
+		case "Timestamp":
+			return "time.Time"
+		case "Duration":
+			return "time.Duration"
+		}
+		return s.IdentName
+	case s.Type == field.FTListStructs:
+		return "[]" + s.IdentName
+	case s.Type == field.FTListBools:
+		return "list.Bools"
+	case s.Type == field.FTListBytes, s.Type == field.FTListStrings:
+		panic(fmt.Sprintf("bug: field %s: GoGetterType() does not support %v", s.Name, s.Type))
+	case field.IsList(s.Type):
+		if s.IdentName != "" {
+			return "list.Enums[" + s.IdentName + "]"
+		}
+		return "list.Numbers[" + s.GoListType() + "]"
+	case s.Type == field.FTBytes:
+		return "[]byte"
+	case s.Type == field.FTFixedBytes:
+		return fmt.Sprintf("[%d]byte", s.Size)
+	case s.IdentName != "": // Enum
+		return s.IdentName
+	}
 	return field.GoType(s.Type)
 }
 
+// GoViewGetterType returns the type returned by the getter method of a generated <Name>View for
+// the field. This is GoGetterType(), except that lists are read-only views, such as
+// "list.NumbersView[uint8]", and Structs are <Name>View types. See viewIdent() for Structs from
+// another package.
+func (s StructField) GoViewGetterType() string {
+	switch {
+	case s.Type == field.FTStruct && s.WellKnown == "":
+		return s.viewIdent()
+	case s.Type == field.FTListStructs:
+		return "[]" + s.viewIdent()
+	case s.Type == field.FTListBools:
+		return "list.BoolsView"
+	case s.Type == field.FTListBytes:
+		return "list.BytesView"
+	case s.Type == field.FTListStrings:
+		return "list.StringsView"
+	case field.IsList(s.Type):
+		if s.IdentName != "" {
+			return "list.EnumsView[" + s.IdentName + "]"
+		}
+		return "list.NumbersView[" + s.GoListType() + "]"
+	}
+	return s.GoGetterType()
+}
+
+// viewIdent returns the name of the <Name>View type for a Struct field. A Struct from another
+// package may have been generated by an older clawc that has no <Name>View type, so the Struct's
+// own type is used, which the view gets from its frozen Struct.
+func (s StructField) viewIdent() string {
+	if s.IsExternal {
+		return s.IdentName
+	}
+	return s.IdentName + "View"
+}
+
 // IdentInFile returns the IdentName, removing a package identifier if it
 // proceeds it in .IdentName.
 func (s StructField) IdentInFile() string {
@@ -623,6 +810,8 @@ type Struct struct {
 	Name string
 	// Fields are the fields in the Struct.
 	Fields []StructField
+	// Line is the line in the .claw file the Struct is declared on, starting at 1.
+	Line int
 
 	// File has all the information in the File.
 	File *File
@@ -633,63 +822,37 @@ func NewStruct(file *File) Struct {
 	return Struct{File: file}
 }
 
+// WellKnown returns "Timestamp" or "Duration" if s is one of the well-known time types, otherwise
+// it returns "". A well-known time type is a Struct with that name and exactly these fields:
+//
+//	Seconds int64 @0
+//	Nanos int32 @1
+func (s Struct) WellKnown() string {
+	if s.Name != "Timestamp" && s.Name != "Duration" {
+		return ""
+	}
+	if len(s.Fields) != 2 {
+		return ""
+	}
+	for _, f := range s.Fields {
+		switch {
+		case f.Name == "Seconds" && f.Index == 0 && f.Type == field.FTInt64:
+		case f.Name == "Nanos" && f.Index == 1 && f.Type == field.FTInt32:
+		default:
+			return ""
+		}
+	}
+	return s.Name
+}
+
 //go:embed struct.tmpl
 var structTmplData string
 var structTmpl = template.Must(template.New("struct").Parse(structTmplData))
 
 // Render renders the Struct in its Go form.
 func (s Struct) Render() (string, error) {
-	// Integrate all externally defined types.
-	for i, f := range s.Fields {
-		// This means a field was externally defined, we need to put in the type info.
-		if f.Type == field.FTUnknown {
-			file := s.File.External[f.IdentName]
-			sp := strings.Split(f.IdentName, ".")
-			if len(sp) != 2 {
-				return "", fmt.Errorf("Struct %s had field %s of type %s that looks external but isn't?", s.Name, f.Name, f.IdentName)
-			}
-			f.Package = sp[0]
-			imp, err := s.File.Imports.ByPkgName(sp[0])
-			if err != nil {
-				panic(err)
-			}
-			f.FullPath = imp.Path
-			f.IsExternal = true
-
-			ident := file.Identifers[sp[1]]
-			switch v := ident.(type) {
-			case Enum:
-				f.IsEnum = true
-				switch v.Size {
-				case 8:
-					if f.IsList {
-						f.Type = field.FTListUint8
-					} else {
-						f.Type = field.FTUint8
-					}
-				case 16:
-					if f.IsList {
-						f.Type = field.FTListUint16
-					} else {
-						f.Type = field.FTUint16
-					}
-				default:
-					return "", fmt.Errorf("Struct %s had field %s of type Enum that had invalid size %d", s.Name, f.Name, v.Size)
-				}
-			case Struct:
-				if f.IsList {
-					f.Type = field.FTListStructs
-				} else {
-					f.Type = field.FTStruct
-				}
-			default:
-				return "", fmt.Errorf("Struct %s had field %s defined externally that was an invalid type %T", s.Name, f.Name, ident)
-			}
-		} else {
-			f.FullPath = s.File.FullPath
-			f.Package = s.File.Package
-		}
-		s.Fields[i] = f
+	if err := s.ResolveFields(); err != nil {
+		return "", err
 	}
 
 	b := strings.Builder{}
@@ -699,6 +862,87 @@ func (s Struct) Render() (string, error) {
 	return b.String(), nil
 }
 
+// ResolveFields fills in the type information for fields that hold types defined in other files
+// and the package information for all fields. Renderers must call this before using the Fields.
+func (s Struct) ResolveFields() error {
+	for i, f := range s.Fields {
+		f, err := s.File.resolveField(fmt.Sprintf("Struct %s", s.Name), f)
+		if err != nil {
+			return err
+		}
+		s.Fields[i] = f
+	}
+	return nil
+}
+
+// resolveField fills in the type information for a field that holds a type defined in another
+// file and the package information for all fields. owner describes what holds the field, such as
+// "Struct Car", for error messages.
+func (file *File) resolveField(owner string, f StructField) (StructField, error) {
+	if f.Type != field.FTUnknown {
+		f.FullPath = file.FullPath
+		f.Package = file.Package
+		if f.Type == field.FTStruct && !f.SelfReferential {
+			if v, ok := file.Identifers[f.IdentName].(Struct); ok {
+				f.WellKnown = v.WellKnown()
+			}
+		}
+		return f, nil
+	}
+
+	// This means a field was externally defined, we need to put in the type info.
+	ext := file.External[f.IdentName]
+	sp := strings.Split(f.IdentName, ".")
+	if len(sp) != 2 {
+		return f, fmt.Errorf("%s had field %s of type %s that looks external but isn't?", owner, f.Name, f.IdentName)
+	}
+	f.Package = sp[0]
+	imp, err := file.Imports.ByPkgName(sp[0])
+	if err != nil {
+		panic(err)
+	}
+	f.FullPath = imp.Path
+	f.IsExternal = true
+
+	ident := ext.Identifers[sp[1]]
+	switch v := ident.(type) {
+	case Enum:
+		f.IsEnum = true
+		switch v.Size {
+		case 8:
+			if f.IsList {
+				f.Type = field.FTListUint8
+			} else {
+				f.Type = field.FTUint8
+			}
+		case 16:
+			if f.IsList {
+				f.Type = field.FTListUint16
+			} else {
+				f.Type = field.FTUint16
+			}
+		default:
+			return f, fmt.Errorf("%s had field %s of type Enum that had invalid size %d", owner, f.Name, v.Size)
+		}
+		if err := f.validateOptions(&v); err != nil {
+			return f, fmt.Errorf("%s had field %s: %w", owner, f.Name, err)
+		}
+	case Struct:
+		if f.IsList {
+			f.Type = field.FTListStructs
+		} else {
+			f.Type = field.FTStruct
+			f.WellKnown = v.WellKnown()
+		}
+		if err := f.validateOptions(nil); err != nil {
+			return f, fmt.Errorf("%s had field %s: %w", owner, f.Name, err)
+		}
+	default:
+		return f, fmt.Errorf("%s had field %s defined externally that was an invalid type %T", owner, f.Name, ident)
+	}
+	return f, nil
+}
+
 func (s *Struct) parse(p *halfpike.Parser) error {
 	if err := s.name(p); err != nil {
 		return err
@@ -726,6 +970,7 @@ func (s *Struct) name(p *halfpike.Parser) error {
 	}
 
 	s.Name = l.Items[1].Val
+	s.Line = lineOf(l)
 
 	if err := commentOrEOL(l, 3); err != nil {
 		return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
@@ -762,20 +1007,16 @@ func (s *Struct) fields(p *halfpike.Parser) error {
 		return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
 	}
 
-	// Validate the fields are sequentially ordered. The order in the file doesn't matter, as long
-	// as we start at 0 and don't skip a number.
-	ids := make([]bool, len(s.Fields))
+	// Validate the field numbers are unique. The order in the file doesn't matter and numbers can
+	// be skipped, which allows reserving ranges for future use.
+	ids := make(map[uint16]bool, len(s.Fields))
 	for _, f := range s.Fields {
-		if int(f.Index) >= len(ids) {
-			return fmt.Errorf("Struct %q field %q has an invalid field number %d, fields must start at 0 and be sequential", s.Name, f.Name, f.Index)
-		}
 		if ids[f.Index] {
 			return fmt.Errorf("Struct %q field %q has duplicate field number %d", s.Name, f.Name, f.Index)
 		}
 		ids[f.Index] = true
 	}
-	// We now know we have a sequence starting at 0 that doesn't skip numbers, so 0, 1, 2, 3, 4. But they
-	// can be in random order and we need them to be in field order.
+	// They can be in random order and we need them to be in field order.
 	slices.SortFunc(
 		s.Fields,
 		func(a, b StructField) bool {
@@ -797,8 +1038,225 @@ func (s *Struct) field(p *halfpike.Parser) error {
 	if err := validateIdent(l.Items[0].Val); err != nil {
 		return fmt.Errorf("[Line %d]: Struct name %q is invalid: %w", l.LineNum, l.Items[0].Val, err)
 	}
-	f := StructField{Name: l.Items[0].Val}
+	f := StructField{Name: l.Items[0].Val, Line: lineOf(l)}
+
+	if err := s.File.fieldType(fmt.Sprintf("Struct %q", s.Name), s.Name, l, &f); err != nil {
+		return err
+	}
+
+	fieldNum := l.Items[2].Val
+	if !strings.HasPrefix(fieldNum, "@") {
+		return fmt.Errorf("[Line %d]: Struct %q has field %q without a valid field number, %q", l.LineNum, s.Name, f.Name, fieldNum)
+	}
+	fieldNum = strings.Split(fieldNum, "@")[1]
+	i, err := strconv.Atoi(fieldNum)
+	if err != nil {
+		return fmt.Errorf("[Line %d]: Struct %q has field %q without a valid field number, %q", l.LineNum, s.Name, f.Name, fieldNum)
+	}
+	if i > math.MaxUint16 {
+		return fmt.Errorf("[Line %d]: Struct %q has field %q with a field number > that a uint16 can hold, %q", l.LineNum, s.Name, f.Name, fieldNum)
+	}
+	log.Println("FieldName: ", f.Name)
+
+	f.Index = uint16(i)
+
+	next, err := f.parseOptions(l, 3)
+	if err != nil {
+		return fmt.Errorf("[Line %d]: Struct %q field %q: %w", l.LineNum, s.Name, f.Name, err)
+	}
+	// Enums from other files are checked when we resolve the field.
+	if f.Type != field.FTUnknown {
+		var enum *Enum
+		if v, ok := s.File.Identifers[f.IdentName].(Enum); ok && f.IsEnum {
+			enum = &v
+		}
+		if err := f.validateOptions(enum); err != nil {
+			return fmt.Errorf("[Line %d]: Struct %q field %q: %w", l.LineNum, s.Name, f.Name, err)
+		}
+	}
+	if f.Codec != "" {
+		if _, _, ok := s.File.GoCodec(f.Codec); !ok {
+			return fmt.Errorf("[Line %d]: Struct %q field %q: codec %q is not in the GoCodecs() file option", l.LineNum, s.Name, f.Name, f.Codec)
+		}
+	}
+
+	s.Fields = append(s.Fields, f)
+	if err := commentOrEOL(l, next); err != nil {
+		return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
+	}
+	return nil
+}
+
+// parseOptions parses the field options that may start at item "from" on line l, such as
+// [default = 30]. Each option is a key or key = value, separated by commas. This returns the index
+// of the item after the options.
+func (f *StructField) parseOptions(l halfpike.Line, from int) (int, error) {
+	if from >= len(l.Items) || !strings.HasPrefix(l.Items[from].Val, "[") {
+		return from, nil
+	}
+
+	end := -1
+	for i := from; i < len(l.Items); i++ {
+		if strings.HasSuffix(l.Items[i].Val, "]") {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return 0, fmt.Errorf("field options have no closing ]")
+	}
+
+	opts := strings.TrimSpace(halfpike.ItemJoin(l, from, end+1))
+	opts = strings.TrimSpace(opts[1 : len(opts)-1])
+	if opts == "" {
+		return 0, fmt.Errorf("field options cannot be empty")
+	}
+
+	seen := map[string]bool{}
+	for _, opt := range strings.Split(opts, ",") {
+		key, val, hasVal := strings.Cut(opt, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if seen[key] {
+			return 0, fmt.Errorf("field option %q is set more than once", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "default":
+			if !hasVal || val == "" {
+				return 0, fmt.Errorf("field option 'default' must have a value")
+			}
+			f.Default = val
+		case "encrypted":
+			if hasVal {
+				return 0, fmt.Errorf("field option 'encrypted' does not take a value")
+			}
+			f.Encrypted = true
+		case "dense":
+			if hasVal {
+				return 0, fmt.Errorf("field option 'dense' does not take a value")
+			}
+			f.Dense = true
+		case "group":
+			if !hasVal || val == "" {
+				return 0, fmt.Errorf("field option 'group' must have a value")
+			}
+			if err := validOptionName("field group", val); err != nil {
+				return 0, err
+			}
+			f.Group = val
+		case "codec":
+			if !hasVal || val == "" {
+				return 0, fmt.Errorf("field option 'codec' must have a value")
+			}
+			if err := validOptionName("codec", val); err != nil {
+				return 0, err
+			}
+			f.Codec = val
+		case "":
+			return 0, fmt.Errorf("field options cannot have an empty entry")
+		default:
+			return 0, fmt.Errorf("unknown field option %q", key)
+		}
+	}
+	return end + 1, nil
+}
+
+// validOptionName checks that a name given in an option, such as a field group, only has letters,
+// numbers and _ and does not start with a number. what describes the name for the error.
+func validOptionName(what, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s name cannot be empty", what)
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case unicode.IsNumber(r) && i > 0:
+		default:
+			return fmt.Errorf("%s name %q is not valid, it must be letters, numbers and _ and start with a letter or _", what, name)
+		}
+	}
+	return nil
+}
+
+// bitSize returns the size in bits of a number type.
+func bitSize(t field.Type) int {
+	switch t {
+	case field.FTInt8, field.FTUint8:
+		return 8
+	case field.FTInt16, field.FTUint16:
+		return 16
+	case field.FTInt32, field.FTUint32, field.FTFloat32:
+		return 32
+	}
+	return 64
+}
+
+// validateOptions checks that the field options can be used with the field's type. enum must be
+// provided if the field holds an Enum.
+func (f *StructField) validateOptions(enum *Enum) error {
+	if f.Encrypted && f.Type != field.FTString && f.Type != field.FTBytes {
+		return fmt.Errorf("encrypted can only be set on string or bytes fields, not %v", f.Type)
+	}
+	if f.Codec != "" && f.Type != field.FTBytes {
+		return fmt.Errorf("codec can only be set on bytes fields, not %v", f.Type)
+	}
+	if f.Dense {
+		switch f.Type {
+		case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64, field.FTUint8,
+			field.FTUint16, field.FTUint32, field.FTUint64, field.FTFloat32, field.FTFloat64:
+		default:
+			return fmt.Errorf("dense can only be set on bool, number and Enum fields, not %v", f.Type)
+		}
+	}
+	if f.Default == "" {
+		return nil
+	}
+
+	var err error
+	switch f.Type {
+	case field.FTBool:
+		var b bool
+		b, err = strconv.ParseBool(f.Default)
+		if err == nil {
+			// Makes it a valid Go literal.
+			f.Default = strconv.FormatBool(b)
+		}
+	case field.FTUint8, field.FTUint16:
+		if f.IsEnum {
+			if enum == nil {
+				panic(fmt.Sprintf("bug: field %s is an Enum, but validateOptions() did not get the Enum", f.Name))
+			}
+			if _, ok := enum.names[f.Default]; !ok {
+				return fmt.Errorf("default %q is not a value of Enum %s", f.Default, f.IdentName)
+			}
+			return nil
+		}
+		_, err = strconv.ParseUint(f.Default, 10, bitSize(f.Type))
+	case field.FTUint32, field.FTUint64:
+		_, err = strconv.ParseUint(f.Default, 10, bitSize(f.Type))
+	case field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64:
+		_, err = strconv.ParseInt(f.Default, 10, bitSize(f.Type))
+	case field.FTFloat32, field.FTFloat64:
+		var v float64
+		v, err = strconv.ParseFloat(f.Default, bitSize(f.Type))
+		// ParseFloat() takes NaN and Inf, which are not Go literals the renderer can write out.
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			return fmt.Errorf("default %q must be a finite number", f.Default)
+		}
+	default:
+		return fmt.Errorf("a default can only be set on bool, number or Enum fields, not %v", f.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("default %q is not a valid %s: %w", f.Default, field.GoType(f.Type), err)
+	}
+	return nil
+}
 
+// fieldType sets the type information in f for the type in the second item on line l. owner
+// describes what holds the field, such as `Struct "Car"`, for error messages. self is the name of
+// the Struct holding the field, which may hold itself.
+func (file *File) fieldType(owner, self string, l halfpike.Line, f *StructField) error {
 	switch l.Items[1].Val {
 	case "bool":
 		f.Type = field.FTBool
@@ -852,8 +1310,16 @@ func (s *Struct) field(p *halfpike.Parser) error {
 		f.Type = field.FTListStrings
 	case "[]bytes":
 		f.Type = field.FTListBytes
-	default: // Struct, []Struct, or []{{Enum}}
+	default: // [N]byte, Struct, []Struct, or []{{Enum}}
 		ft := l.Items[1].Val
+		if size, ok := fixedBytesSize(ft); ok {
+			if size == 0 {
+				return fmt.Errorf("[Line %d]: %s has field %q with type %q, the size must be a number from 1 to %d", l.LineNum, owner, f.Name, ft, math.MaxUint16)
+			}
+			f.Type = field.FTFixedBytes
+			f.Size = size
+			return nil
+		}
 		isList := false
 		if strings.HasPrefix(ft, "[]") {
 			ft = strings.Split(ft, "[]")[1]
@@ -863,17 +1329,17 @@ func (s *Struct) field(p *halfpike.Parser) error {
 		log.Println("ft: ", ft)
 
 		// See if field type is an identifer of an Enum or Struct.
-		ident, ok := s.File.Identifers[ft]
+		ident, ok := file.Identifers[ft]
 
 		switch {
 		// We have a Struct field that has itself as a type or is duplicate of an existing type.
-		case s.Name == ft:
-			_, ok := s.File.Identifers[ft]
+		case self == ft:
+			_, ok := file.Identifers[ft]
 			if ok {
 				return fmt.Errorf("[Line %d]: found duplicate top level identifier %q", l.LineNum, ft)
 			}
 
-			f.IdentName = s.Name
+			f.IdentName = self
 			f.SelfReferential = true
 			if isList {
 				f.Type = field.FTListStructs
@@ -914,6 +1380,8 @@ func (s *Struct) field(p *halfpike.Parser) error {
 				} else {
 					f.Type = field.FTStruct
 				}
+			case Interface:
+				return fmt.Errorf("[Line %d]: %s has field %q with type %q, which is an Interface and cannot be a field type", l.LineNum, owner, f.Name, ft)
 			default:
 				panic(fmt.Sprintf("bug: we have an identifier %q that is not Enum or Struct, was %T", f.Name, ident))
 			}
@@ -921,43 +1389,42 @@ func (s *Struct) field(p *halfpike.Parser) error {
 		default:
 			// This checks that the type comes from an outside file we have imported.
 			if strings.Count(strings.Trim(ft, "."), ".") != 1 {
-				return fmt.Errorf("[Line %d]: Struct %q has field %q with unknown type %q", l.LineNum, s.Name, f.Name, ft)
+				return fmt.Errorf("[Line %d]: %s has field %q with unknown type %q", l.LineNum, owner, f.Name, ft)
 			}
 			sp := strings.Split(ft, ".")
 			// Make sure we actually import the package that this external type references.
-			if _, err := s.File.Imports.ByPkgName(sp[0]); err != nil {
+			if _, err := file.Imports.ByPkgName(sp[0]); err != nil {
 				return fmt.Errorf("[Line %d]: found type %q, but %q is not a package we see imported", l.LineNum, ft, sp[0])
 			}
 
 			// Mark this as an external identifier that we have to fill in later.
-			s.File.External[ft] = nil
+			file.External[ft] = nil
 
 			f.IdentName = ft
 			f.Type = field.FTUnknown
 			f.IsList = isList
 		}
 	}
+	return nil
+}
 
-	fieldNum := l.Items[2].Val
-	if !strings.HasPrefix(fieldNum, "@") {
-		return fmt.Errorf("[Line %d]: Struct %q has field %q without a valid field number, %q", l.LineNum, s.Name, f.Name, fieldNum)
+// fixedBytesSize returns N if ft is a FixedBytes type, "[N]byte". ok is true if ft has that form, but
+// size is 0 if N is not a valid size.
+func fixedBytesSize(ft string) (size uint16, ok bool) {
+	if !strings.HasPrefix(ft, "[") || !strings.HasSuffix(ft, "]byte") || strings.HasPrefix(ft, "[]") {
+		return 0, false
 	}
-	fieldNum = strings.Split(fieldNum, "@")[1]
-	i, err := strconv.Atoi(fieldNum)
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(ft, "["), "]byte"), 10, 16)
 	if err != nil {
-		return fmt.Errorf("[Line %d]: Struct %q has field %q without a valid field number, %q", l.LineNum, s.Name, f.Name, fieldNum)
+		return 0, true
 	}
-	if i > math.MaxUint16 {
-		return fmt.Errorf("[Line %d]: Struct %q has field %q with a field number > that a uint16 can hold, %q", l.LineNum, s.Name, f.Name, fieldNum)
-	}
-	log.Println("FieldName: ", f.Name)
+	return uint16(n), true
+}
 
-	f.Index = uint16(i)
-	s.Fields = append(s.Fields, f)
-	if err := commentOrEOL(l, 3); err != nil {
-		return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
-	}
-	return nil
+// lineOf returns the number of line l in the file, starting at 1. halfpike.Line.LineNum starts at 0,
+// even though it is documented to start at 1.
+func lineOf(l halfpike.Line) int {
+	return l.LineNum + 1
 }
 
 func caseSensitiveCheck(want string, item string) error {
@@ -5,6 +5,7 @@ import (
 	"log"
 	"testing"
 
+	"github.com/bearlytools/claw/languages/go/field"
 	"github.com/johnsiilver/halfpike"
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -19,7 +20,7 @@ package hello // Yeah I can comment here
 version 0 // And here too
 
 // Comment.
-options [ NoZeroValueCompression() ]// Comment
+options [ NoZeroValueCompression(), PointerSetters() ]// Comment
 
 import (
 	"github.com/johnsiilver/something"
@@ -44,6 +45,7 @@ Struct Car {
 `
 	wantOpts := map[string]Option{
 		"NoZeroValueCompression": {"NoZeroValueCompression", nil},
+		"PointerSetters":         {"PointerSetters", nil},
 	}
 
 	f := New()
@@ -61,6 +63,9 @@ Struct Car {
 	if diff := pretty.Compare(wantOpts, f.Options); diff != "" {
 		t.Fatalf("TestFile(options) -want/+got:\n%s", diff)
 	}
+	if !f.HasOption("PointerSetters") || f.HasOption("Pointersetters") {
+		t.Errorf("TestFile(HasOption): did not report the options that were set")
+	}
 
 	for _, impName := range []string{"something", "renamed"} {
 		if _, ok := f.Imports.Imports[impName]; !ok {
@@ -95,3 +100,415 @@ func (l *lineLexer) Start(ctx context.Context, p *halfpike.Parser) halfpike.Pars
 func (p *lineLexer) Validate() error {
 	return nil
 }
+
+func TestStructFieldNumbers(t *testing.T) {
+	tests := []struct {
+		desc   string
+		fields string
+		want   []uint16
+		err    bool
+	}{
+		{
+			desc: "sequential, out of order",
+			fields: `
+	Count int32 @1
+	Name string @0`,
+			want: []uint16{0, 1},
+		},
+		{
+			desc: "sparse",
+			fields: `
+	Count int32 @1000
+	Name string @0
+	Data bytes @2`,
+			want: []uint16{0, 2, 1000},
+		},
+		{
+			desc: "Error: duplicate",
+			fields: `
+	Name string @0
+	Count int32 @0`,
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		content := "package hello\n\nStruct Pod {" + test.fields + "\n}\n"
+
+		f := New()
+		err := halfpike.Parse(context.Background(), content, f)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestStructFieldNumbers(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestStructFieldNumbers(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		var got []uint16
+		for _, field := range f.Identifers["Pod"].(Struct).Fields {
+			got = append(got, field.Index)
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestStructFieldNumbers(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestStructFixedBytes(t *testing.T) {
+	tests := []struct {
+		desc  string
+		field string
+		want  uint16
+		err   bool
+	}{
+		{desc: "16 bytes", field: "ID [16]byte @0", want: 16},
+		{desc: "largest", field: "ID [65535]byte @0", want: 65535},
+		{desc: "Error: zero size", field: "ID [0]byte @0", err: true},
+		{desc: "Error: too large", field: "ID [65536]byte @0", err: true},
+		{desc: "Error: not a number", field: "ID [x]byte @0", err: true},
+	}
+
+	for _, test := range tests {
+		content := "package hello\n\nStruct Pod {\n\t" + test.field + "\n}\n"
+
+		f := New()
+		err := halfpike.Parse(context.Background(), content, f)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestStructFixedBytes(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestStructFixedBytes(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		got := f.Identifers["Pod"].(Struct).Fields[0]
+		if got.Type != field.FTFixedBytes {
+			t.Errorf("TestStructFixedBytes(%s): got type %v, want %v", test.desc, got.Type, field.FTFixedBytes)
+		}
+		if got.Size != test.want {
+			t.Errorf("TestStructFixedBytes(%s): got size %d, want %d", test.desc, got.Size, test.want)
+		}
+	}
+}
+
+func TestStructFieldOptions(t *testing.T) {
+	tests := []struct {
+		desc          string
+		field         string
+		want          string
+		wantGo        string
+		wantEncrypted bool
+		wantDense     bool
+		wantGroup     string
+		wantCodec     string
+		err           bool
+	}{
+		{desc: "no default", field: "Count int32 @0"},
+		{desc: "int32", field: "Count int32 @0 [default = 30]", want: "30", wantGo: "int32(30)"},
+		{desc: "no spaces", field: "Count int32 @0 [default=30]", want: "30", wantGo: "int32(30)"},
+		{desc: "with comment", field: "Count int32 @0 [default = -2] // A comment", want: "-2", wantGo: "int32(-2)"},
+		{desc: "bool", field: "On bool @0 [default = T]", want: "true", wantGo: "true"},
+		{desc: "float64", field: "Ratio float64 @0 [default = 1.5]", want: "1.5", wantGo: "float64(1.5)"},
+		{desc: "enum", field: "Maker Maker @0 [default = Toyota]", want: "Toyota", wantGo: "uint8(Toyota)"},
+		{desc: "Error: out of range", field: "Count uint8 @0 [default = 256]", err: true},
+		{desc: "Error: negative unsigned", field: "Count uint32 @0 [default = -1]", err: true},
+		{desc: "Error: not an enum value", field: "Maker Maker @0 [default = Tesla]", err: true},
+		{desc: "Error: NaN", field: "Ratio float64 @0 [default = NaN]", err: true},
+		{desc: "Error: Inf", field: "Ratio float32 @0 [default = Inf]", err: true},
+		{desc: "Error: +Inf", field: "Ratio float64 @0 [default = +Inf]", err: true},
+		{desc: "Error: -Inf", field: "Ratio float64 @0 [default = -Inf]", err: true},
+		{desc: "Error: string", field: "Name string @0 [default = hello]", err: true},
+		{desc: "Error: list", field: "Counts []int32 @0 [default = 1]", err: true},
+		{desc: "Error: no value", field: "Count int32 @0 [default]", err: true},
+		{desc: "Error: unknown option", field: "Count int32 @0 [defualt = 1]", err: true},
+		{desc: "Error: duplicate option", field: "Count int32 @0 [default = 1, default = 2]", err: true},
+		{desc: "Error: no closing ]", field: "Count int32 @0 [default = 1", err: true},
+		{desc: "encrypted", field: "Owner string @0 [encrypted]", wantEncrypted: true},
+		{desc: "Error: encrypted number", field: "Count int32 @0 [encrypted]", err: true},
+		{desc: "Error: encrypted with value", field: "Owner bytes @0 [encrypted = true]", err: true},
+		{desc: "dense", field: "Count int64 @0 [dense]", wantDense: true},
+		{desc: "dense bool", field: "On bool @0 [dense]", wantDense: true},
+		{desc: "dense enum with default", field: "Maker Maker @0 [dense, default = Toyota]", want: "Toyota", wantGo: "uint8(Toyota)", wantDense: true},
+		{desc: "Error: dense string", field: "Name string @0 [dense]", err: true},
+		{desc: "Error: dense list", field: "Counts []int64 @0 [dense]", err: true},
+		{desc: "Error: dense with value", field: "Count int64 @0 [dense = true]", err: true},
+		{desc: "group", field: "Owner string @0 [group = summary]", wantGroup: "summary"},
+		{desc: "group with other options", field: "Count int32 @0 [default = 1, group = stats_2]", want: "1", wantGo: "int32(1)", wantGroup: "stats_2"},
+		{desc: "Error: group no value", field: "Count int32 @0 [group]", err: true},
+		{desc: "Error: group bad name", field: "Count int32 @0 [group = 2fast]", err: true},
+		{desc: "codec", field: "ID bytes @0 [codec = UUID]", wantCodec: "UUID"},
+		{desc: "Error: codec not in GoCodecs", field: "ID bytes @0 [codec = Decimal]", err: true},
+		{desc: "Error: codec on string", field: "ID string @0 [codec = UUID]", err: true},
+		{desc: "Error: codec no value", field: "ID bytes @0 [codec]", err: true},
+	}
+
+	for _, test := range tests {
+		content := "package hello\n\noptions [ GoCodecs(\"UUID=github.com/google/uuid.UUID\") ]\n\nEnum Maker uint8 {\n\tUnknown @0\n\tToyota @1\n}\n\nStruct Car {\n\t" + test.field + "\n}\n"
+
+		f := New()
+		err := halfpike.Parse(context.Background(), content, f)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestStructFieldOptions(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestStructFieldOptions(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		sf := f.Identifers["Car"].(Struct).Fields[0]
+		if sf.Default != test.want {
+			t.Errorf("TestStructFieldOptions(%s): got Default %q, want %q", test.desc, sf.Default, test.want)
+		}
+		if got := sf.GoDefault(); got != test.wantGo {
+			t.Errorf("TestStructFieldOptions(%s): got GoDefault() %q, want %q", test.desc, got, test.wantGo)
+		}
+		if sf.Encrypted != test.wantEncrypted {
+			t.Errorf("TestStructFieldOptions(%s): got Encrypted %v, want %v", test.desc, sf.Encrypted, test.wantEncrypted)
+		}
+		if sf.Dense != test.wantDense {
+			t.Errorf("TestStructFieldOptions(%s): got Dense %v, want %v", test.desc, sf.Dense, test.wantDense)
+		}
+		if sf.Dense {
+		}
+		if sf.Group != test.wantGroup {
+			t.Errorf("TestStructFieldOptions(%s): got Group %q, want %q", test.desc, sf.Group, test.wantGroup)
+		}
+		if sf.Codec != test.wantCodec {
+			t.Errorf("TestStructFieldOptions(%s): got Codec %q, want %q", test.desc, sf.Codec, test.wantCodec)
+		}
+	}
+}
+
+func TestGoCodecs(t *testing.T) {
+	tests := []struct {
+		desc        string
+		options     string
+		wantType    string
+		wantImports []string
+		err         bool
+	}{
+		{
+			desc:        "one codec",
+			options:     `GoCodecs("UUID=github.com/google/uuid.UUID")`,
+			wantType:    "uuid.UUID",
+			wantImports: []string{"github.com/google/uuid"},
+		},
+		{
+			desc:        "two codecs",
+			options:     `GoCodecs("Decimal=github.com/shopspring/decimal.Decimal", "UUID=github.com/google/uuid.UUID")`,
+			wantType:    "uuid.UUID",
+			wantImports: []string{"github.com/google/uuid"},
+		},
+		{desc: "Error: no =", options: `GoCodecs("github.com/google/uuid.UUID")`, err: true},
+		{desc: "Error: no type", options: `GoCodecs("UUID=github.com/google/uuid")`, err: true},
+		{desc: "Error: bad name", options: `GoCodecs("U-ID=github.com/google/uuid.UUID")`, err: true},
+		{desc: "Error: duplicate", options: `GoCodecs("UUID=github.com/google/uuid.UUID", "UUID=a/b.C")`, err: true},
+		{desc: "Error: no args", options: `GoCodecs()`, err: true},
+	}
+
+	for _, test := range tests {
+		content := "package hello\n\noptions [ " + test.options + " ]\n\nStruct Car {\n\tID bytes @0 [codec = UUID]\n\tName string @1\n}\n"
+
+		f := New()
+		err := halfpike.Parse(context.Background(), content, f)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestGoCodecs(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestGoCodecs(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		if got := f.GoCodecType("UUID"); got != test.wantType {
+			t.Errorf("TestGoCodecs(%s): got GoCodecType() %q, want %q", test.desc, got, test.wantType)
+		}
+		if diff := pretty.Compare(test.wantImports, f.GoCodecImports()); diff != "" {
+			t.Errorf("TestGoCodecs(%s): GoCodecImports() -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestStructWellKnown(t *testing.T) {
+	tests := []struct {
+		desc    string
+		content string
+		want    string
+	}{
+		{
+			desc: "Timestamp",
+			content: `
+Struct Timestamp {
+	Seconds int64 @0
+	Nanos int32 @1
+}`,
+			want: "Timestamp",
+		},
+		{
+			desc: "Duration",
+			content: `
+Struct Duration {
+	Nanos int32 @1
+	Seconds int64 @0
+}`,
+			want: "Duration",
+		},
+		{
+			desc: "wrong name",
+			content: `
+Struct Time {
+	Seconds int64 @0
+	Nanos int32 @1
+}`,
+		},
+		{
+			desc: "wrong field type",
+			content: `
+Struct Timestamp {
+	Seconds int32 @0
+	Nanos int32 @1
+}`,
+		},
+		{
+			desc: "extra field",
+			content: `
+Struct Duration {
+	Seconds int64 @0
+	Nanos int32 @1
+	Zone string @2
+}`,
+		},
+	}
+
+	for _, test := range tests {
+		f := New()
+		if err := halfpike.Parse(context.Background(), "package hello\n"+test.content+"\n", f); err != nil {
+			t.Fatalf("TestStructWellKnown(%s): could not parse: %s", test.desc, err)
+		}
+		if got := f.Structs()[0].WellKnown(); got != test.want {
+			t.Errorf("TestStructWellKnown(%s): got %q, want %q", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestInterface(t *testing.T) {
+	structs := `
+Enum Kind uint8 {
+	Unknown @0
+}
+
+Struct Meta {
+	Name string @0
+}
+
+Struct Pod {
+	Meta Meta @0
+	Kind Kind @1
+}
+
+Struct Service {
+	Kind Kind @0
+	Meta Meta @1
+	Ports []uint16 @2
+}
+
+Struct Other {
+	Meta Meta @0
+	Kind uint8 @1
+}
+`
+	tests := []struct {
+		desc  string
+		iface string
+		want  []string
+		err   bool
+	}{
+		{
+			desc: "Success",
+			iface: `
+Interface HasMeta {
+	Meta Meta // Comment
+	Kind Kind
+}`,
+			want: []string{"Pod", "Service"},
+		},
+		{
+			desc: "no implementers",
+			iface: `
+Interface HasPorts {
+	Ports []uint32
+}`,
+		},
+		{
+			desc: "Error: duplicate field",
+			iface: `
+Interface HasMeta {
+	Meta Meta
+	Meta Meta
+}`,
+			err: true,
+		},
+		{
+			desc: "Error: no fields",
+			iface: `
+Interface HasMeta {
+}`,
+			err: true,
+		},
+		{
+			desc: "Error: unknown type",
+			iface: `
+Interface HasMeta {
+	Meta Metadata
+}`,
+			err: true,
+		},
+		{
+			desc: "Error: Interface as a field type",
+			iface: `
+Interface HasMeta {
+	Meta Meta
+}
+
+Struct Holder {
+	Thing HasMeta @0
+}`,
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		f := New()
+		err := halfpike.Parse(context.Background(), "package hello\n"+structs+test.iface+"\n", f)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestInterface(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestInterface(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		var got []string
+		for _, s := range f.Interfaces()[0].Implementers() {
+			got = append(got, s.Name)
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestInterface(%s): Implementers(): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package idl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/johnsiilver/halfpike"
+
+	_ "embed"
+)
+
+// Interface represents a Claw Interface type in the file. An Interface lists fields that a
+// set of Structs share. It has no wire representation, it is used to generate language native
+// interfaces so that code can handle different Structs that have the same fields.
+type Interface struct {
+	// Name is the name of the Interface type.
+	Name string
+	// Fields are the fields a Struct must have to implement the Interface. Index is not used.
+	Fields []StructField
+
+	// File has all the information in the File.
+	File *File
+}
+
+// NewInterface creates a new Interface type.
+func NewInterface(file *File) Interface {
+	return Interface{File: file}
+}
+
+// Implementers returns the Structs in the File that have all the fields in the Interface,
+// sorted by name.
+func (i Interface) Implementers() []Struct {
+	var out []Struct
+	for _, s := range i.File.Structs() {
+		if i.implementedBy(s) {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(x, y int) bool { return out[x].Name < out[y].Name })
+	return out
+}
+
+// implementedBy reports if s has a field with the same name and type for every field in i.
+func (i Interface) implementedBy(s Struct) bool {
+	for _, want := range i.Fields {
+		found := false
+		for _, got := range s.Fields {
+			if got.Name == want.Name {
+				found = sameFieldType(got, want)
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sameFieldType reports if a and b are the same type. This works before external types have
+// been resolved, as those only have an IdentName.
+func sameFieldType(a, b StructField) bool {
+	if a.IdentName != "" || b.IdentName != "" {
+		aList := a.IsList || field.IsList(a.Type)
+		bList := b.IsList || field.IsList(b.Type)
+		return a.IdentName == b.IdentName && aList == bList
+	}
+	return a.Type == b.Type
+}
+
+//go:embed interface.tmpl
+var interfaceTmplData string
+var interfaceTmpl = template.Must(template.New("interface").Parse(interfaceTmplData))
+
+// Render renders the Interface in its Go form.
+func (i Interface) Render() (string, error) {
+	for x, f := range i.Fields {
+		f, err := i.File.resolveField(fmt.Sprintf("Interface %s", i.Name), f)
+		if err != nil {
+			return "", err
+		}
+		i.Fields[x] = f
+	}
+
+	b := strings.Builder{}
+	if err := interfaceTmpl.Execute(&b, i); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (i *Interface) parse(p *halfpike.Parser) error {
+	l := p.Next()
+	if len(l.Items) < 3 {
+		return fmt.Errorf("[Line %d]: error: Interface line has incorrect format", l.LineNum)
+	}
+	if err := validateIdent(l.Items[1].Val); err != nil {
+		return fmt.Errorf("[Line %d]: error: Interface identifier: %w", l.LineNum, err)
+	}
+	if l.Items[2].Val != "{" {
+		return fmt.Errorf("[Line %d]: error: need `{` after Interface identifier: %s", l.LineNum, l.Raw)
+	}
+	i.Name = l.Items[1].Val
+	if err := commentOrEOL(l, 3); err != nil {
+		return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
+	}
+
+	names := map[string]bool{}
+	for {
+		l = p.Next()
+		if p.EOF(l) {
+			return fmt.Errorf("[Line %d]: Malformed Interface, EOF reached before closing '}'", l.LineNum)
+		}
+		if l.Items[0].Val == "}" {
+			if err := commentOrEOL(l, 1); err != nil {
+				return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
+			}
+			break
+		}
+
+		if len(l.Items) < 2 {
+			return fmt.Errorf("[Line %d]: Interface field is invalid format", l.LineNum)
+		}
+		if err := validateIdent(l.Items[0].Val); err != nil {
+			return fmt.Errorf("[Line %d]: Interface field name %q is invalid: %w", l.LineNum, l.Items[0].Val, err)
+		}
+		f := StructField{Name: l.Items[0].Val}
+		if names[f.Name] {
+			return fmt.Errorf("[Line %d]: Interface %q has duplicate field %q", l.LineNum, i.Name, f.Name)
+		}
+		names[f.Name] = true
+
+		if err := i.File.fieldType(fmt.Sprintf("Interface %q", i.Name), "", l, &f); err != nil {
+			return err
+		}
+		switch f.Type {
+		case field.FTListBytes, field.FTListStrings:
+			return fmt.Errorf("[Line %d]: Interface %q field %q: lists of bytes or strings are not supported in an Interface", l.LineNum, i.Name, f.Name)
+		}
+		i.Fields = append(i.Fields, f)
+		if err := commentOrEOL(l, 2); err != nil {
+			return fmt.Errorf("[Line %d]: error: %w", l.LineNum, err)
+		}
+	}
+
+	if len(i.Fields) == 0 {
+		return fmt.Errorf("Interface %q has no entries, which is not valid", i.Name)
+	}
+	return nil
+}
@@ -13,6 +13,8 @@ type validateOptArgs func(args []string) error
 
 var fileOptions = map[string]validateOptArgs{
 	"NoZeroValueCompression": valNoZeroValueCompression,
+	"PointerSetters":         valPointerSetters,
+	"GoCodecs":               valGoCodecs,
 }
 
 func valNoZeroValueCompression(args []string) error {
@@ -22,6 +24,53 @@ func valNoZeroValueCompression(args []string) error {
 	return nil
 }
 
+func valPointerSetters(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("PointerSetters takes no arguments")
+	}
+	return nil
+}
+
+func valGoCodecs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("GoCodecs needs at least one argument")
+	}
+	seen := map[string]bool{}
+	for _, arg := range args {
+		name, _, _, err := parseGoCodec(arg)
+		if err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("GoCodecs has codec %q more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// parseGoCodec parses an argument to the GoCodecs() option, which has the form
+// "name=import/path.Type", such as "UUID=github.com/google/uuid.UUID". The package name
+// must be the last element of the import path.
+func parseGoCodec(arg string) (name, importPath, typeName string, err error) {
+	name, goType, ok := strings.Cut(arg, "=")
+	if !ok {
+		return "", "", "", fmt.Errorf("GoCodecs argument %q must be name=import/path.Type", arg)
+	}
+	if err := validOptionName("codec", name); err != nil {
+		return "", "", "", err
+	}
+	i := strings.LastIndex(goType, ".")
+	if i <= 0 || i < strings.LastIndex(goType, "/") {
+		return "", "", "", fmt.Errorf("GoCodecs argument %q must be name=import/path.Type", arg)
+	}
+	importPath, typeName = goType[:i], goType[i+1:]
+	if err := validOptionName("Go type", typeName); err != nil {
+		return "", "", "", err
+	}
+	return name, importPath, typeName, nil
+}
+
 var optionsDL = lexline.DecodeList{
 	LeftConstraint:  `[`,
 	RightConstraint: `]`,
@@ -217,16 +266,12 @@ func (o *Options) parseArgs(opt Option, reader *strings.Reader) (option Option,
 			}()
 
 			switch {
+			case inQuote && r != '"':
+				buff.WriteRune(r)
+				return nil
 			case unicode.IsSpace(r):
-				if inQuote {
-					buff.WriteRune(r)
-				}
 				return nil
 			case r == ',':
-				if inQuote {
-					buff.WriteRune(r)
-					return nil
-				}
 				if len(opt.Args) == 0 {
 					return fmt.Errorf("cannot have , after %s(", opt.Name)
 				}
@@ -7,12 +7,19 @@ import (
 	"os"
 
 	"github.com/bearlytools/claw/internal/imports"
+
+	osfs "github.com/gopherfs/fs/io/os"
 )
 
 func main() {
 	ctx := context.Background()
 
-	clawPath, err := imports.FindClawFile("../../../testing/imports/vehicles/claw")
+	fs, err := osfs.New()
+	if err != nil {
+		exitln(err)
+	}
+
+	clawPath, err := imports.FindClawFile(fs, "../../../testing/imports/vehicles/claw")
 	if err != nil {
 		exitln(err)
 	}
@@ -0,0 +1,100 @@
+// Package lint finds things in a .claw file that parse, but are likely mistakes or waste space,
+// for `clawc lint`. Unlike parse errors, none of these stop code from being generated.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/bearlytools/claw/internal/idl"
+)
+
+// Finding is a problem found in a .claw file.
+type Finding struct {
+	// Line is the line in the .claw file the problem is on.
+	Line int
+	// Msg describes the problem.
+	Msg string
+}
+
+// String implements fmt.Stringer.
+func (f Finding) String() string {
+	return fmt.Sprintf("[Line %d] %s", f.Line, f.Msg)
+}
+
+// Check returns the problems found in f, in line order. These are:
+//   - Structs that skip field numbers. Every Struct holds a slot for each field number up to its
+//     largest, so skipped numbers cost memory and should only be the numbers of removed fields.
+//   - uint8 Enums with a value that does not fit in a uint8, which is truncated when encoded.
+//   - uint16 Enums whose values all fit in a uint8, which doubles the size of lists of them.
+//   - Fields with the [dense] option in a file with NoZeroValueCompression(), where it does nothing.
+func Check(f *idl.File) []Finding {
+	var out []Finding
+	_, noCompression := f.Options["NoZeroValueCompression"]
+
+	for _, s := range f.Structs() {
+		next := uint16(0)
+		for _, sf := range s.Fields {
+			if sf.Index > next {
+				out = append(out, Finding{
+					Line: sf.Line,
+					Msg:  fmt.Sprintf("Struct %s skips field %s before field %s, each skipped number still takes a slot in every %s", s.Name, numRange(next, sf.Index-1), sf.Name, s.Name),
+				})
+			}
+			next = sf.Index + 1
+
+			if sf.Dense && noCompression {
+				out = append(out, Finding{
+					Line: sf.Line,
+					Msg:  fmt.Sprintf("Struct %s field %s is [dense], which does nothing with NoZeroValueCompression()", s.Name, sf.Name),
+				})
+			}
+		}
+	}
+
+	for e := range f.Enums() {
+		vals := e.OrderByValues()
+		largest := vals[len(vals)-1]
+		switch {
+		case e.Size == 8 && largest.Value > math.MaxUint8:
+			for _, v := range vals {
+				if v.Value > math.MaxUint8 {
+					out = append(out, Finding{
+						Line: v.Line,
+						Msg:  fmt.Sprintf("Enum %s is uint8, but %s has value %d, which does not fit", e.Name, v.Name, v.Value),
+					})
+				}
+			}
+		case e.Size == 16 && largest.Value <= math.MaxUint8:
+			out = append(out, Finding{
+				Line: e.Line,
+				Msg:  fmt.Sprintf("Enum %s is uint16, but all of its values fit in a uint8, which halves the size of lists of it", e.Name),
+			})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Line < out[j].Line })
+	return out
+}
+
+// Write writes the problems found in f to w, one per line, prefixed with the path of f.
+// It returns the number of problems.
+func Write(w io.Writer, f *idl.File) (int, error) {
+	findings := Check(f)
+	for _, fi := range findings {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", f.FullPath, fi); err != nil {
+			return 0, err
+		}
+	}
+	return len(findings), nil
+}
+
+// numRange describes the field numbers from first to last, such as "number 3" or "numbers 3-5".
+func numRange(first, last uint16) string {
+	if first == last {
+		return fmt.Sprintf("number %d", first)
+	}
+	return fmt.Sprintf("numbers %d-%d", first, last)
+}
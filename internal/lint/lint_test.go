@@ -0,0 +1,98 @@
+package lint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/johnsiilver/halfpike"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		desc   string
+		schema string
+		want   []int // The lines of the findings, messages are for people so we don't test their wording.
+	}{
+		{
+			desc: "nothing to find",
+			schema: `package cars
+
+Enum Maker uint8 {
+	Unknown @0
+	Toyota @1
+}
+
+Struct Car {
+	Name string @0
+	Maker Maker @1
+	Year uint16 @2 [dense]
+}
+`,
+		},
+		{
+			desc: "skipped field numbers",
+			schema: `package cars
+
+Struct Car {
+	Year uint16 @1
+	Name string @2
+	Miles uint64 @5
+	Serial uint64 @6
+}
+`,
+			want: []int{4, 6},
+		},
+		{
+			desc: "Enum widths",
+			schema: `package cars
+
+Enum Maker uint8 {
+	Unknown @0
+	Toyota @1
+	Tesla @300
+}
+
+Enum Color uint16 {
+	Unknown @0
+	Red @1
+}
+
+Struct Car {
+	Maker Maker @0
+	Color Color @1
+}
+`,
+			want: []int{6, 9},
+		},
+		{
+			desc: "dense with NoZeroValueCompression",
+			schema: `package cars
+
+options [ NoZeroValueCompression() ]
+
+Struct Car {
+	Name string @0
+	Year uint16 @1 [dense]
+}
+`,
+			want: []int{7},
+		},
+	}
+
+	for _, test := range tests {
+		f := idl.New()
+		if err := halfpike.Parse(context.Background(), test.schema, f); err != nil {
+			t.Fatalf("TestCheck(%s): could not parse schema: %s", test.desc, err)
+		}
+
+		var got []int
+		for _, fi := range Check(f) {
+			got = append(got, fi.Line)
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestCheck(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package migrate rewrites encoded records of a Struct from one version of its .claw file to
+// another, for `clawc migrate`. The mappings are built from the .claw files when it runs, so no
+// generated code is needed for either version. The records are converted with structs.Migrate().
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/structs"
+	"github.com/johnsiilver/halfpike"
+)
+
+// ParseFile parses the .claw file at path. Imports of other .claw files are not followed.
+func ParseFile(path string) (*idl.File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := idl.New()
+	if err := halfpike.Parse(context.Background(), string(b), f); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", path, err)
+	}
+	return f, nil
+}
+
+// Mapping returns the mapping for the Struct called name in f. Defaults are not set in the
+// mapping, as they are never encoded. Fields that hold a type from another .claw file are not
+// supported.
+func Mapping(f *idl.File, name string) (*mapping.Map, error) {
+	return buildMapping(f, name, map[string]*mapping.Map{})
+}
+
+// buildMapping builds the mapping for Struct name. built holds the mappings already built, which
+// lets Structs that hold each other share them.
+func buildMapping(f *idl.File, name string, built map[string]*mapping.Map) (*mapping.Map, error) {
+	if m, ok := built[name]; ok {
+		return m, nil
+	}
+	s, ok := f.Identifers[name].(idl.Struct)
+	if !ok {
+		return nil, fmt.Errorf("%s does not have a Struct called %q", f.Package, name)
+	}
+
+	m := &mapping.Map{Name: s.Name, Pkg: f.Package, Path: f.FullPath}
+	built[name] = m
+	for _, sf := range s.Fields {
+		if sf.Type == field.FTUnknown || sf.IsExternal {
+			return nil, fmt.Errorf("Struct %s field %s holds %s from another .claw file, which is not supported", s.Name, sf.Name, sf.IdentName)
+		}
+		fd := &mapping.FieldDescr{
+			Name:            sf.Name,
+			Type:            sf.Type,
+			FieldNum:        sf.Index,
+			Package:         f.Package,
+			FullPath:        f.FullPath,
+			IsEnum:          sf.IsEnum,
+			SelfReferential: sf.SelfReferential,
+			Encrypted:       sf.Encrypted,
+			Dense:           sf.Dense,
+			Group:           sf.Group,
+			Codec:           sf.Codec,
+		}
+		if sf.IsEnum {
+			fd.EnumGroup = sf.IdentName
+		}
+		if (sf.Type == field.FTStruct || sf.Type == field.FTListStructs) && !sf.SelfReferential {
+			fd.StructName = sf.IdentName
+			sub, err := buildMapping(f, sf.IdentName, built)
+			if err != nil {
+				return nil, err
+			}
+			fd.Mapping = sub
+		}
+		m.Fields = append(m.Fields, fd)
+	}
+	return m.Init(), nil
+}
+
+// ParseRemap parses a field number remap table, such as "1=5,2=3", which moves field 1 of the old
+// version to field 5 of the new version and field 2 to field 3. An empty string is no remap.
+func ParseRemap(s string) (map[uint16]uint16, error) {
+	remap := map[uint16]uint16{}
+	if strings.TrimSpace(s) == "" {
+		return remap, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("remap entry %q must be [old field number]=[new field number]", entry)
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("remap entry %q has a bad old field number: %w", entry, err)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("remap entry %q has a bad new field number: %w", entry, err)
+		}
+		if _, ok := remap[uint16(f)]; ok {
+			return nil, fmt.Errorf("remap has more than one entry for field %d", f)
+		}
+		remap[uint16(f)] = uint16(t)
+	}
+	return remap, nil
+}
+
+// Records reads records encoded with mapping from one after another from r until it is empty,
+// and writes each to w encoded with mapping to. remap is passed to structs.Migrate(). It returns
+// the number of records written.
+func Records(w io.Writer, r io.Reader, from, to *mapping.Map, remap map[uint16]uint16) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for len(data) > 0 {
+		src := structs.New(0, from)
+		if data, err = src.UnmarshalFrom(data); err != nil {
+			return n, fmt.Errorf("record %d: could not decode: %w", n, err)
+		}
+		dst := structs.New(0, to)
+		if err := structs.Migrate(dst, src, remap); err != nil {
+			return n, fmt.Errorf("record %d: %w", n, err)
+		}
+		if _, err := dst.Marshal(w); err != nil {
+			return n, fmt.Errorf("record %d: could not encode: %w", n, err)
+		}
+		n++
+	}
+	return n, nil
+}
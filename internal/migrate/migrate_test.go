@@ -0,0 +1,175 @@
+package migrate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/structs"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const v1Schema = `
+package people
+
+version 0
+
+Enum Color uint8 {
+	Unknown @0
+	Red @1
+}
+
+Struct Pet {
+	Name string @0
+	Legs uint8 @1
+}
+
+Struct Person {
+	Name string @0
+	Age int32 @1
+	Nickname string @2
+	Pets []Pet @3
+	Favorite Color @4
+}
+`
+
+const v2Schema = `
+package people
+
+version 0
+
+Enum Color uint8 {
+	Unknown @0
+	Red @1
+}
+
+Struct Pet {
+	Name string @0
+	Owner string @2
+}
+
+Struct Person {
+	Name string @0
+	Pets []Pet @3
+	Favorite Color @4
+	Age int32 @5
+	Height float32 @6
+}
+`
+
+func mustMapping(t *testing.T, schema string) *mapping.Map {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "people.claw")
+	if err := os.WriteFile(path, []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Mapping(f, "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRecords(t *testing.T) {
+	v1 := mustMapping(t, v1Schema)
+	v2 := mustMapping(t, v2Schema)
+
+	buff := &bytes.Buffer{}
+	for _, name := range []string{"John", "Jane"} {
+		s := structs.New(0, v1)
+		structs.MustSetBytes(s, 0, []byte(name), true)
+		structs.MustSetNumber(s, 1, int32(len(name)))
+		structs.MustSetBytes(s, 2, []byte("nick"), true)
+		pet := structs.New(0, v1.Fields[3].Mapping)
+		structs.MustSetBytes(pet, 0, []byte("Rex"), true)
+		structs.MustSetNumber(pet, 1, uint8(4))
+		structs.MustAppendListStruct(s, 3, pet)
+		structs.MustSetNumber(s, 4, uint8(1))
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remap, err := ParseRemap("1=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	n, err := Records(out, buff, v1, v2, remap)
+	if err != nil {
+		t.Fatalf("TestRecords: got err == %s, want err == nil", err)
+	}
+	if n != 2 {
+		t.Errorf("TestRecords: got %d records, want 2", n)
+	}
+
+	type record struct {
+		Name     string
+		Age      int32
+		Height   float32
+		Pet      string
+		Favorite uint8
+	}
+	var got []record
+	data := out.Bytes()
+	for len(data) > 0 {
+		s := structs.New(0, v2)
+		if data, err = s.UnmarshalFrom(data); err != nil {
+			t.Fatalf("TestRecords: could not decode output: %s", err)
+		}
+		got = append(got, record{
+			Name:     string(*structs.MustGetBytes(s, 0)),
+			Age:      structs.MustGetNumber[int32](s, 5),
+			Height:   structs.MustGetNumber[float32](s, 6),
+			Pet:      string(*structs.MustGetBytes(structs.MustGetListStruct(s, 3).Get(0), 0)),
+			Favorite: structs.MustGetNumber[uint8](s, 4),
+		})
+	}
+	want := []record{
+		{Name: "John", Age: 4, Pet: "Rex", Favorite: 1},
+		{Name: "Jane", Age: 4, Pet: "Rex", Favorite: 1},
+	}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("TestRecords: -want/+got:\n%s", diff)
+	}
+}
+
+func TestParseRemap(t *testing.T) {
+	tests := []struct {
+		desc    string
+		remap   string
+		want    map[uint16]uint16
+		wantErr bool
+	}{
+		{desc: "empty", remap: "", want: map[uint16]uint16{}},
+		{desc: "entries", remap: "1=5, 2=3", want: map[uint16]uint16{1: 5, 2: 3}},
+		{desc: "no equals", remap: "1", wantErr: true},
+		{desc: "bad number", remap: "1=x", wantErr: true},
+		{desc: "too big", remap: "1=70000", wantErr: true},
+		{desc: "duplicate", remap: "1=2,1=3", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseRemap(test.remap)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestParseRemap(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestParseRemap(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestParseRemap(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
@@ -31,13 +31,18 @@ func init() {
 }
 
 type templateData struct {
-	Path   string
-	Config *imports.Config
-	File   *idl.File
+	Path    string
+	Config  *imports.Config
+	File    *idl.File
+	NoNames bool
 }
 
 // Renderer implements render.Renderer for the Go language.
-type Renderer struct{}
+type Renderer struct {
+	// NoNames leaves field names out of the generated mappings to make binaries smaller.
+	// JSON and looking up fields by name do not work with the generated code.
+	NoNames bool
+}
 
 // Render implements render.Renderer.Render().
 func (r Renderer) Render(ctx context.Context, config *imports.Config, path string) ([]byte, error) {
@@ -49,9 +54,10 @@ func (r Renderer) Render(ctx context.Context, config *imports.Config, path strin
 	}
 
 	data := templateData{
-		Path:   path,
-		Config: config,
-		File:   f,
+		Path:    path,
+		Config:  config,
+		File:    f,
+		NoNames: r.NoNames,
 	}
 
 	if err := templates.ExecuteTemplate(&buff, "claw.tmpl", data); err != nil {
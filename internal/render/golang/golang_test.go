@@ -0,0 +1,67 @@
+package golang
+
+import (
+	"context"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/bearlytools/claw/internal/render"
+	"github.com/johnsiilver/halfpike"
+)
+
+// TestRenderCompiles type checks the generated code for schemas that have been a problem for the templates.
+func TestRenderCompiles(t *testing.T) {
+	tests := []struct {
+		desc   string
+		schema string
+	}{
+		{
+			desc: "self referential Struct and list of Structs",
+			schema: `
+package cars
+
+Struct Car {
+	Name string @0
+	Spare Car @1
+	Previous []Car @2
+}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		f := idl.New()
+		if err := halfpike.Parse(context.Background(), test.schema, f); err != nil {
+			t.Fatalf("TestRenderCompiles(%s): could not parse schema: %s", test.desc, err)
+		}
+		if err := f.Validate(); err != nil {
+			t.Fatalf("TestRenderCompiles(%s): schema did not validate: %s", test.desc, err)
+		}
+		f.FullPath = "github.com/example/cars"
+		config := imports.NewConfig()
+		config.Root = f
+		config.Imports[f.FullPath] = f
+
+		out, err := render.Render(context.Background(), config, render.Go)
+		if err != nil {
+			t.Fatalf("TestRenderCompiles(%s): Render() error: %s", test.desc, err)
+		}
+
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "cars.go", out[0].Native, 0)
+		if err != nil {
+			t.Errorf("TestRenderCompiles(%s): generated code did not parse: %s", test.desc, err)
+			continue
+		}
+		conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+		if _, err := conf.Check(f.FullPath, fset, []*ast.File{file}, nil); err != nil {
+			t.Errorf("TestRenderCompiles(%s): generated code did not compile: %s", test.desc, err)
+		}
+	}
+}
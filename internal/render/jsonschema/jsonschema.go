@@ -0,0 +1,312 @@
+// Package jsonschema implements a renderer that exports a .claw file as a JSON Schema
+// (Draft 2020-12) document. The schema describes the JSON written by languages/go/json, which
+// is useful for documenting HTTP APIs that carry Claw as JSON and for validating that JSON.
+//
+// Every Struct and Enum in the file is in "$defs". Fields have their Claw field number in the
+// "x-claw-field-number" extension. As Claw does not encode unset fields, no field is required.
+package jsonschema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/bearlytools/claw/internal/render"
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+func init() {
+	if _, ok := render.Supported[render.JSONSchema]; ok {
+		panic("someone alread registered the JSONSchema renderer")
+	}
+	render.Supported[render.JSONSchema] = Renderer{}
+}
+
+// Renderer implements render.Renderer for JSON Schema files.
+type Renderer struct{}
+
+// Render implements render.Renderer.Render().
+func (r Renderer) Render(ctx context.Context, config *imports.Config, path string) ([]byte, error) {
+	f, ok := config.Imports[path]
+	if !ok {
+		return nil, fmt.Errorf("could not find import path %q in config.Imports", path)
+	}
+
+	doc := &schema{
+		Schema: draft,
+		ID:     SchemaID(path),
+		Title:  f.Package,
+	}
+
+	var enums []idl.Enum
+	for e := range f.Enums() {
+		enums = append(enums, e)
+	}
+	sort.Slice(enums, func(i, j int) bool { return enums[i].Name < enums[j].Name })
+	for _, e := range enums {
+		doc.Defs = append(doc.Defs, namedSchema{Name: e.Name, Schema: enumSchema(e)})
+	}
+
+	structs := f.Structs()
+	sort.Slice(structs, func(i, j int) bool { return structs[i].Name < structs[j].Name })
+	for _, s := range structs {
+		if err := s.ResolveFields(); err != nil {
+			return nil, err
+		}
+		ss, err := structSchema(s)
+		if err != nil {
+			return nil, err
+		}
+		doc.Defs = append(doc.Defs, namedSchema{Name: s.Name, Schema: ss})
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	buff := bytes.Buffer{}
+	if err := json.Indent(&buff, b, "", "  "); err != nil {
+		return nil, err
+	}
+	buff.WriteString("\n")
+	return buff.Bytes(), nil
+}
+
+// SchemaID is the "$id" of the JSON Schema rendered for the Claw package at pkgPath.
+func SchemaID(pkgPath string) string {
+	return "https://" + pkgPath + "/" + pkgPath[strings.LastIndex(pkgPath, "/")+1:] + ".schema.json"
+}
+
+// schema is the part of JSON Schema that we use. Keywords are written in the order they are here.
+type schema struct {
+	Schema          string        `json:"$schema,omitempty"`
+	ID              string        `json:"$id,omitempty"`
+	Title           string        `json:"title,omitempty"`
+	Ref             string        `json:"$ref,omitempty"`
+	Type            string        `json:"type,omitempty"`
+	Enum            []string      `json:"enum,omitempty"`
+	Minimum         any           `json:"minimum,omitempty"`
+	Maximum         any           `json:"maximum,omitempty"`
+	ContentEncoding string        `json:"contentEncoding,omitempty"`
+	Items           *schema       `json:"items,omitempty"`
+	Properties      namedSchemas  `json:"properties,omitempty"`
+	Default         any           `json:"default,omitempty"`
+	FieldNumber     *uint16       `json:"x-claw-field-number,omitempty"`
+	ClawType        string        `json:"x-claw-type,omitempty"`
+	EnumValues      *orderedUints `json:"x-claw-enum-values,omitempty"`
+	Defs            namedSchemas  `json:"$defs,omitempty"`
+}
+
+type namedSchema struct {
+	Name   string
+	Schema *schema
+}
+
+// namedSchemas is written as a JSON object that keeps the order of the entries, so that
+// properties are in field number order.
+type namedSchemas []namedSchema
+
+func (n namedSchemas) MarshalJSON() ([]byte, error) {
+	buff := bytes.Buffer{}
+	buff.WriteByte('{')
+	for i, entry := range n {
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		name, err := json.Marshal(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(entry.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buff.Write(name)
+		buff.WriteByte(':')
+		buff.Write(b)
+	}
+	buff.WriteByte('}')
+	return buff.Bytes(), nil
+}
+
+// orderedUints is a JSON object of names to numbers that keeps the order of the entries.
+type orderedUints struct {
+	names  []string
+	values []uint16
+}
+
+func (o *orderedUints) MarshalJSON() ([]byte, error) {
+	buff := bytes.Buffer{}
+	buff.WriteByte('{')
+	for i, name := range o.names {
+		if i > 0 {
+			buff.WriteByte(',')
+		}
+		b, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buff.Write(b)
+		buff.WriteByte(':')
+		buff.WriteString(strconv.Itoa(int(o.values[i])))
+	}
+	buff.WriteByte('}')
+	return buff.Bytes(), nil
+}
+
+// enumSchema returns the schema for an Enum. languages/go/json writes Enums as their names by
+// default, the numbers are provided in the x-claw-enum-values extension.
+func enumSchema(e idl.Enum) *schema {
+	s := &schema{Type: "string", ClawType: fmt.Sprintf("uint%d", e.Size), EnumValues: &orderedUints{}}
+	for _, v := range e.OrderByValues() {
+		s.Enum = append(s.Enum, v.Name)
+		s.EnumValues.names = append(s.EnumValues.names, v.Name)
+		s.EnumValues.values = append(s.EnumValues.values, v.Value)
+	}
+	return s
+}
+
+func structSchema(s idl.Struct) (*schema, error) {
+	out := &schema{Type: "object"}
+	for _, sf := range s.Fields {
+		fs, err := fieldSchema(sf)
+		if err != nil {
+			return nil, fmt.Errorf("Struct %s: %w", s.Name, err)
+		}
+		num := sf.Index
+		fs.FieldNumber = &num
+		out.Properties = append(out.Properties, namedSchema{Name: sf.Name, Schema: fs})
+	}
+	return out, nil
+}
+
+// fieldSchema returns the schema for a field. The field's types must be resolved.
+func fieldSchema(sf idl.StructField) (*schema, error) {
+	if sf.IsList || field.IsList(sf.Type) {
+		items, err := itemSchema(sf)
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "array", Items: items}, nil
+	}
+
+	s, err := itemSchema(sf)
+	if err != nil {
+		return nil, err
+	}
+	if sf.Default != "" {
+		s.Default, err = defaultValue(sf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// itemSchema returns the schema for a value held in a field, which for lists is each item.
+func itemSchema(sf idl.StructField) (*schema, error) {
+	if sf.IdentName != "" {
+		return &schema{Ref: ref(sf)}, nil
+	}
+
+	ft := sf.Type
+	if field.IsList(ft) {
+		ft = listItemType(ft)
+	}
+
+	switch ft {
+	case field.FTBool:
+		return &schema{Type: "boolean"}, nil
+	case field.FTInt8:
+		return &schema{Type: "integer", Minimum: math.MinInt8, Maximum: math.MaxInt8, ClawType: "int8"}, nil
+	case field.FTInt16:
+		return &schema{Type: "integer", Minimum: math.MinInt16, Maximum: math.MaxInt16, ClawType: "int16"}, nil
+	case field.FTInt32:
+		return &schema{Type: "integer", Minimum: math.MinInt32, Maximum: math.MaxInt32, ClawType: "int32"}, nil
+	case field.FTInt64:
+		return &schema{Type: "integer", Minimum: int64(math.MinInt64), Maximum: int64(math.MaxInt64), ClawType: "int64"}, nil
+	case field.FTUint8:
+		return &schema{Type: "integer", Minimum: 0, Maximum: math.MaxUint8, ClawType: "uint8"}, nil
+	case field.FTUint16:
+		return &schema{Type: "integer", Minimum: 0, Maximum: math.MaxUint16, ClawType: "uint16"}, nil
+	case field.FTUint32:
+		return &schema{Type: "integer", Minimum: 0, Maximum: uint32(math.MaxUint32), ClawType: "uint32"}, nil
+	case field.FTUint64:
+		return &schema{Type: "integer", Minimum: 0, Maximum: uint64(math.MaxUint64), ClawType: "uint64"}, nil
+	case field.FTFloat32:
+		return &schema{Type: "number", ClawType: "float32"}, nil
+	case field.FTFloat64:
+		return &schema{Type: "number", ClawType: "float64"}, nil
+	case field.FTString:
+		return &schema{Type: "string"}, nil
+	case field.FTBytes, field.FTFixedBytes:
+		return &schema{Type: "string", ContentEncoding: "base64"}, nil
+	}
+	return nil, fmt.Errorf("field %s has type %v that has no JSON Schema conversion", sf.Name, sf.Type)
+}
+
+// listItemType returns the type of each item in a list of type ft. Lists of Structs and Enums
+// are handled by ref().
+func listItemType(ft field.Type) field.Type {
+	switch ft {
+	case field.FTListBools:
+		return field.FTBool
+	case field.FTListInt8:
+		return field.FTInt8
+	case field.FTListInt16:
+		return field.FTInt16
+	case field.FTListInt32:
+		return field.FTInt32
+	case field.FTListInt64:
+		return field.FTInt64
+	case field.FTListUint8:
+		return field.FTUint8
+	case field.FTListUint16:
+		return field.FTUint16
+	case field.FTListUint32:
+		return field.FTUint32
+	case field.FTListUint64:
+		return field.FTUint64
+	case field.FTListFloat32:
+		return field.FTFloat32
+	case field.FTListFloat64:
+		return field.FTFloat64
+	case field.FTListStrings:
+		return field.FTString
+	case field.FTListBytes:
+		return field.FTBytes
+	}
+	return field.FTUnknown
+}
+
+// ref returns the "$ref" to the Struct or Enum held in sf, which may be in another package's schema.
+func ref(sf idl.StructField) string {
+	if sf.IsExternal {
+		return SchemaID(sf.FullPath) + "#/$defs/" + sf.IdentInFile()
+	}
+	return "#/$defs/" + sf.IdentName
+}
+
+// defaultValue converts the Default of sf into its JSON value.
+func defaultValue(sf idl.StructField) (any, error) {
+	switch {
+	case sf.IsEnum:
+		return sf.Default, nil
+	case sf.Type == field.FTBool:
+		return strconv.ParseBool(sf.Default)
+	case sf.Type == field.FTFloat32, sf.Type == field.FTFloat64:
+		return strconv.ParseFloat(sf.Default, 64)
+	case sf.Type == field.FTUint8, sf.Type == field.FTUint16, sf.Type == field.FTUint32, sf.Type == field.FTUint64:
+		return strconv.ParseUint(sf.Default, 10, 64)
+	}
+	return strconv.ParseInt(sf.Default, 10, 64)
+}
@@ -0,0 +1,90 @@
+package jsonschema
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/johnsiilver/halfpike"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const schemaFile = `
+package cars
+
+version 0
+
+Enum Maker uint8 {
+	Unknown @0
+	Toyota @1
+}
+
+Struct Car {
+	Name string @0
+	Maker Maker @1 [default = Toyota]
+	Years []uint16 @2
+	Spare Car @3
+}
+`
+
+func TestRender(t *testing.T) {
+	f := idl.New()
+	if err := halfpike.Parse(context.Background(), schemaFile, f); err != nil {
+		t.Fatalf("TestRender: could not parse schema: %s", err)
+	}
+	f.FullPath = "github.com/example/cars"
+	config := imports.NewConfig()
+	config.Root = f
+	config.Imports[f.FullPath] = f
+
+	b, err := Renderer{}.Render(context.Background(), config, f.FullPath)
+	if err != nil {
+		t.Fatalf("TestRender: Render() error: %s", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("TestRender: output was not valid JSON: %s\n%s", err, b)
+	}
+
+	want := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     "https://github.com/example/cars/cars.schema.json",
+		"title":   "cars",
+		"$defs": map[string]any{
+			"Maker": map[string]any{
+				"type":               "string",
+				"enum":               []any{"Unknown", "Toyota"},
+				"x-claw-type":        "uint8",
+				"x-claw-enum-values": map[string]any{"Unknown": 0.0, "Toyota": 1.0},
+			},
+			"Car": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"Name": map[string]any{"type": "string", "x-claw-field-number": 0.0},
+					"Maker": map[string]any{
+						"$ref":                "#/$defs/Maker",
+						"default":             "Toyota",
+						"x-claw-field-number": 1.0,
+					},
+					"Years": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type":        "integer",
+							"minimum":     0.0,
+							"maximum":     65535.0,
+							"x-claw-type": "uint16",
+						},
+						"x-claw-field-number": 2.0,
+					},
+					"Spare": map[string]any{"$ref": "#/$defs/Car", "x-claw-field-number": 3.0},
+				},
+			},
+		},
+	}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("TestRender: -want/+got:\n%s", diff)
+	}
+}
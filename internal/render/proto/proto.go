@@ -0,0 +1,182 @@
+// Package proto implements a renderer that exports a .claw file as a Protocol Buffers
+// (proto3) descriptor file. This is meant for tooling interop (schema registries, linters,
+// documentation generators, ...), not for encoding. The Claw wire format is not the
+// proto wire format.
+package proto
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/bearlytools/claw/internal/render"
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+//go:embed templates/*
+var f embed.FS
+var templates *template.Template
+
+func init() {
+	t, err := template.New("").Funcs(funcs).ParseFS(f, "templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	templates = t
+
+	if _, ok := render.Supported[render.Proto]; ok {
+		panic("someone alread registered the Proto renderer")
+	}
+	render.Supported[render.Proto] = Renderer{}
+}
+
+var funcs = template.FuncMap{
+	"protoType":   protoType,
+	"protoNum":    protoNum,
+	"typeComment": typeComment,
+}
+
+type templateData struct {
+	Path    string
+	File    *idl.File
+	Imports []string
+	Enums   []idl.Enum
+	Structs []idl.Struct
+}
+
+// Renderer implements render.Renderer for .proto files.
+type Renderer struct{}
+
+// Render implements render.Renderer.Render().
+func (r Renderer) Render(ctx context.Context, config *imports.Config, path string) ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	f, ok := config.Imports[path]
+	if !ok {
+		return nil, fmt.Errorf("could not find import path %q in config.Imports", path)
+	}
+
+	data := templateData{
+		Path: path,
+		File: f,
+	}
+	for imp := range f.PkgImports() {
+		data.Imports = append(data.Imports, ProtoPath(imp))
+	}
+	sort.Strings(data.Imports)
+
+	for e := range f.Enums() {
+		data.Enums = append(data.Enums, e)
+	}
+	sort.Slice(data.Enums, func(i, j int) bool { return data.Enums[i].Name < data.Enums[j].Name })
+
+	data.Structs = f.Structs()
+	sort.Slice(data.Structs, func(i, j int) bool { return data.Structs[i].Name < data.Structs[j].Name })
+	for _, s := range data.Structs {
+		for _, sf := range s.Fields {
+			if sf.Index >= protoReservedStart && sf.Index <= protoReservedEnd {
+				return nil, fmt.Errorf("Struct %q field %q has field number %d, which proto reserves (%d-%d), so it can't be rendered as proto", s.Name, sf.Name, sf.Index, protoReservedStart, protoReservedEnd)
+			}
+		}
+	}
+
+	if err := templates.ExecuteTemplate(&buff, "proto.tmpl", data); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// ProtoPath is the import path of the .proto file rendered for the Claw package at pkgPath.
+func ProtoPath(pkgPath string) string {
+	return pkgPath + "/" + pkgPath[strings.LastIndex(pkgPath, "/")+1:] + ".proto"
+}
+
+// protoFieldZero is the proto field number for Claw field number 0, which proto does not allow.
+// It is larger than any Claw field number, so it can't be the number of another field.
+const protoFieldZero = math.MaxUint16 + 1
+
+// Proto reserves the field numbers from protoReservedStart to protoReservedEnd for itself.
+const (
+	protoReservedStart = 19000
+	protoReservedEnd   = 19999
+)
+
+// protoNum converts a Claw field number into a proto field number. They are the same, other
+// than Claw field number 0, which is protoFieldZero.
+func protoNum(i uint16) int {
+	if i == 0 {
+		return protoFieldZero
+	}
+	return int(i)
+}
+
+// protoType returns the proto type name for a field.
+func protoType(sf idl.StructField) string {
+	if sf.IdentName != "" {
+		if sf.IsList || field.IsList(sf.Type) {
+			return "repeated " + sf.IdentName
+		}
+		return sf.IdentName
+	}
+
+	switch sf.Type {
+	case field.FTBool:
+		return "bool"
+	case field.FTInt8, field.FTInt16, field.FTInt32:
+		return "int32"
+	case field.FTInt64:
+		return "int64"
+	case field.FTUint8, field.FTUint16, field.FTUint32:
+		return "uint32"
+	case field.FTUint64:
+		return "uint64"
+	case field.FTFloat32:
+		return "float"
+	case field.FTFloat64:
+		return "double"
+	case field.FTString:
+		return "string"
+	case field.FTBytes, field.FTFixedBytes:
+		return "bytes"
+	case field.FTListBools:
+		return "repeated bool"
+	case field.FTListInt8, field.FTListInt16, field.FTListInt32:
+		return "repeated int32"
+	case field.FTListInt64:
+		return "repeated int64"
+	case field.FTListUint8, field.FTListUint16, field.FTListUint32:
+		return "repeated uint32"
+	case field.FTListUint64:
+		return "repeated uint64"
+	case field.FTListFloat32:
+		return "repeated float"
+	case field.FTListFloat64:
+		return "repeated double"
+	case field.FTListStrings:
+		return "repeated string"
+	case field.FTListBytes:
+		return "repeated bytes"
+	}
+	panic(fmt.Sprintf("bug: field %s has type %v that has no proto conversion", sf.Name, sf.Type))
+}
+
+// typeComment returns a comment for types that get widened when converted to proto, so that
+// tooling can tell what the real Claw type was.
+func typeComment(sf idl.StructField) string {
+	if sf.IdentName != "" {
+		return ""
+	}
+	switch sf.Type {
+	case field.FTInt8, field.FTInt16, field.FTUint8, field.FTUint16,
+		field.FTListInt8, field.FTListInt16, field.FTListUint8, field.FTListUint16:
+		return " // claw type: " + field.GoType(sf.Type)
+	}
+	return ""
+}
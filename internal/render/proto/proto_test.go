@@ -0,0 +1,122 @@
+package proto
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/johnsiilver/halfpike"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const header = `// DO NOT EDIT
+// This file is autogenerated by the clawc compiler from a .claw file and is meant for
+// tooling that understands Protocol Buffer descriptors. It does not describe the Claw
+// wire format.
+//
+// Things that do not translate directly:
+//   - Field numbers are the Claw field numbers, except that proto does not allow field number 0,
+//     so Claw field 0 is field number 65536.
+//   - Proto has no 8 or 16 bit integers, these are widened and marked with a "claw type" comment.
+//   - Proto enumerator names are package scoped, so they are prefixed with the enum name.
+syntax = "proto3";
+`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		desc   string
+		schema string
+		want   string
+		err    bool
+	}{
+		{
+			desc: "enums and structs",
+			schema: `
+package cars
+
+Enum Maker uint8 {
+	Unknown @0
+	Toyota @1
+}
+
+Struct Driver {
+	Name string @0
+	Age uint8 @1
+}
+
+Struct Car {
+	Name string @0
+	Maker Maker @1
+	Years []uint16 @2
+	Spare Car @3
+	Serial [16]byte @4
+	Miles uint64 @18999
+	Drivers []Driver @20000
+}
+`,
+			want: header + `
+package cars;
+
+enum Maker {
+    Maker_Unknown = 0;
+    Maker_Toyota = 1;
+}
+
+message Car {
+    string Name = 65536;
+    Maker Maker = 1;
+    repeated uint32 Years = 2; // claw type: []uint16
+    Car Spare = 3;
+    bytes Serial = 4;
+    uint64 Miles = 18999;
+    repeated Driver Drivers = 20000;
+}
+
+message Driver {
+    string Name = 65536;
+    uint32 Age = 1; // claw type: uint8
+}
+`,
+		},
+		{
+			desc: "Error: field number proto reserves",
+			schema: `
+package cars
+
+Struct Car {
+	Name string @0
+	Miles uint64 @19000
+}
+`,
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		f := idl.New()
+		if err := halfpike.Parse(context.Background(), test.schema, f); err != nil {
+			t.Fatalf("TestRender(%s): could not parse schema: %s", test.desc, err)
+		}
+		f.FullPath = "github.com/example/cars"
+		config := imports.NewConfig()
+		config.Root = f
+		config.Imports[f.FullPath] = f
+
+		got, err := Renderer{}.Render(context.Background(), config, f.FullPath)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestRender(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestRender(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		if diff := pretty.Compare(test.want, string(got)); diff != "" {
+			t.Errorf("TestRender(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/bearlytools/claw/internal/conversions"
@@ -18,8 +19,27 @@ type Lang uint8
 const (
 	Unknown Lang = 0
 	Go      Lang = 1
+	// Proto renders a .proto descriptor file for use with Protocol Buffer tooling.
+	Proto Lang = 2
+	// JSONSchema renders a JSON Schema file describing the JSON form of the Structs.
+	JSONSchema Lang = 3
 )
 
+var langNames = map[string]Lang{
+	"go":         Go,
+	"proto":      Proto,
+	"jsonschema": JSONSchema,
+}
+
+// ParseLang converts a language name, such as "go" or "proto", into a Lang.
+func ParseLang(s string) (Lang, error) {
+	l, ok := langNames[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return Unknown, fmt.Errorf("%q is not a language we can render", s)
+	}
+	return l, nil
+}
+
 // Supported is langauges that we have registered support for.
 var Supported = map[Lang]Renderer{}
 
@@ -111,13 +131,27 @@ type importCheck struct {
 }
 
 var findImports = []importCheck{
+	{"hash/fnv", "fnv."},
+	{"bytes", "bytes.Buffer"},
+	{"bytes", "bytes.NewReader"},
+	{"database/sql/driver", "driver.Value"},
+	// "fmt." alone would match the "fmt.Stringer" in comments.
+	{"fmt", "fmt.Errorf"},
+	{"sync", "sync.Once"},
 	{"github.com/bearlytools/claw/languages/go/mapping", "mapping."},
 	{"github.com/bearlytools/claw/languages/go/reflect", "reflect."},
 	{"github.com/bearlytools/claw/languages/go/reflect/runtime", "runtime."},
 	{"github.com/bearlytools/claw/languages/go/structs", "structs."},
-	{"github.com/bearlytools/claw/languages/go/types/list", "list."},
+	// "list." alone would match the end of a sentence in comments.
+	{"github.com/bearlytools/claw/languages/go/types/list", "list.Bools"},
+	{"github.com/bearlytools/claw/languages/go/types/list", "list.Numbers"},
+	{"github.com/bearlytools/claw/languages/go/types/list", "list.Enums"},
+	{"github.com/bearlytools/claw/languages/go/types/list", "list.XXX"},
 	{"github.com/bearlytools/claw/internal/conversions", "conversions."},
 	{"github.com/bearlytools/claw/languages/go/field", "field."},
+	// "time." alone would match "runtime.".
+	{"time", "time.Time"},
+	{"time", "time.Duration"},
 }
 
 // cleanImports is a crap way to do this, but it does work and I'm being lazy.
@@ -0,0 +1,200 @@
+// Package report calculates the wire cost of the fields in a .claw file's Structs. This helps
+// schema authors choose field types and see where zero value compression and padding matter.
+// The sizes follow the encoding rules implemented in languages/go/structs.
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// Field is the wire cost of a single field in a Struct.
+type Field struct {
+	// Name is the name of the field.
+	Name string
+	// Type is the type of the field as written in the .claw file.
+	Type string
+	// Min is the number of bytes the field takes when it is not set or is the zero value.
+	Min int
+	// Typical is the number of bytes the field takes when it is set to a short non-zero value.
+	// For strings and bytes that is 1 to 8 bytes and for lists that is a single item.
+	Typical int
+	// Notes describes where zero value compression helps or padding is wasted.
+	Notes string
+}
+
+// Struct is the wire cost of a Struct and all of its fields.
+type Struct struct {
+	// Name is the name of the Struct.
+	Name string
+	// Fields are the costs of each field, in field number order.
+	Fields []Field
+	// Min is the smallest the encoded Struct can be, which is its header plus the Min of every field.
+	Min int
+	// Typical is the size of the encoded Struct with every field set, which is its header
+	// plus the Typical of every field.
+	Typical int
+}
+
+// Structs returns the wire cost of every Struct in f, sorted by name.
+func Structs(f *idl.File) []Struct {
+	structs := f.Structs()
+	sort.Slice(structs, func(i, j int) bool { return structs[i].Name < structs[j].Name })
+
+	out := make([]Struct, 0, len(structs))
+	for _, s := range structs {
+		out = append(out, calcStruct(s, map[*idl.File]map[string]bool{}))
+	}
+	return out
+}
+
+// Write writes a table for each Struct in f to w.
+func Write(w io.Writer, f *idl.File) error {
+	for i, s := range Structs(f) {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "Struct %s.%s:\n", f.Package, s.Name); err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FIELD\tTYPE\tMIN\tTYPICAL\tNOTES")
+		fmt.Fprintf(tw, "(header)\t\t8\t8\t\n")
+		for _, fc := range s.Fields {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", fc.Name, fc.Type, fc.Min, fc.Typical, fc.Notes)
+		}
+		fmt.Fprintf(tw, "TOTAL\t\t%d\t%d\t\n", s.Min, s.Typical)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calcStruct calculates the cost of s. visiting holds the Structs we are calculating, which
+// stops us from recursing forever on Structs that contain themselves.
+func calcStruct(s idl.Struct, visiting map[*idl.File]map[string]bool) Struct {
+	if visiting[s.File] == nil {
+		visiting[s.File] = map[string]bool{}
+	}
+	visiting[s.File][s.Name] = true
+	defer delete(visiting[s.File], s.Name)
+
+	_, noCompression := s.File.Options["NoZeroValueCompression"]
+
+	out := Struct{Name: s.Name, Min: 8, Typical: 8}
+	for _, sf := range s.Fields {
+		fc := calcField(s, sf, noCompression, visiting)
+		out.Fields = append(out.Fields, fc)
+		out.Min += fc.Min
+		out.Typical += fc.Typical
+	}
+	return out
+}
+
+func calcField(s idl.Struct, sf idl.StructField, noCompression bool, visiting map[*idl.File]map[string]bool) Field {
+	fc := Field{Name: sf.Name, Type: typeName(sf)}
+
+	switch sf.Type {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8, field.FTUint16,
+		field.FTUint32, field.FTFloat32:
+		fc.Typical = 8
+		fc.Notes = "value is stored in the header"
+		if noCompression {
+			fc.Min = 8
+			fc.Notes += ", always encoded"
+		} else {
+			fc.Notes += ", zero value is not encoded"
+		}
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		fc.Typical = 16
+		fc.Notes = "header + 8 byte value"
+		if noCompression {
+			fc.Min = 16
+			fc.Notes += ", always encoded"
+		} else {
+			fc.Notes += ", zero value is not encoded"
+		}
+	case field.FTString, field.FTBytes:
+		fc.Typical = 16
+		fc.Notes = "header + value padded to 8 bytes (a 7 byte value wastes 1 byte)"
+		if noCompression {
+			fc.Min = 8
+			fc.Notes += ", empty value is a header"
+		} else {
+			fc.Notes += ", empty value is not encoded"
+		}
+	case field.FTFixedBytes:
+		fc.Typical = 8 + (int(sf.Size)+7)/8*8
+		fc.Notes = "header + value padded to 8 bytes, not encoded if not set"
+	case field.FTListBools:
+		fc.Typical = 16
+		fc.Notes = "header + 64 bools per 8 bytes, empty list is not encoded"
+	case field.FTListInt8, field.FTListUint8:
+		fc.Typical = 16
+		fc.Notes = "header + 8 values per 8 bytes, empty list is not encoded"
+	case field.FTListInt16, field.FTListUint16:
+		fc.Typical = 16
+		fc.Notes = "header + 4 values per 8 bytes, empty list is not encoded"
+	case field.FTListInt32, field.FTListUint32, field.FTListFloat32:
+		fc.Typical = 16
+		fc.Notes = "header + 2 values per 8 bytes, empty list is not encoded"
+	case field.FTListInt64, field.FTListUint64, field.FTListFloat64:
+		fc.Typical = 16
+		fc.Notes = "header + 8 bytes per value, empty list is not encoded"
+	case field.FTListStrings, field.FTListBytes:
+		fc.Typical = 16
+		fc.Notes = "header + 4 byte size per item, the list is padded to 8 bytes"
+	case field.FTStruct:
+		fc.Typical = nestedTypical(s, sf, visiting)
+		fc.Notes = "not encoded if not set"
+	case field.FTListStructs:
+		fc.Typical = 8 + nestedTypical(s, sf, visiting)
+		fc.Notes = "header + each Struct, empty list is not encoded"
+	}
+	return fc
+}
+
+// nestedTypical returns the Typical size of the Struct held in field sf of s. If we can't find
+// the Struct or it contains itself, we can only count its header.
+func nestedTypical(s idl.Struct, sf idl.StructField, visiting map[*idl.File]map[string]bool) int {
+	if sf.SelfReferential {
+		return 8
+	}
+
+	file, name := s.File, sf.IdentName
+	if sp := strings.Split(name, "."); len(sp) == 2 {
+		file, name = s.File.External[sp[0]], sp[1]
+	}
+	if file == nil || visiting[file][name] {
+		return 8
+	}
+	nested, ok := file.Identifers[name].(idl.Struct)
+	if !ok {
+		return 8
+	}
+	return calcStruct(nested, visiting).Typical
+}
+
+// typeName returns the type of the field as it would be written in the .claw file.
+func typeName(sf idl.StructField) string {
+	if sf.IdentName != "" {
+		if sf.IsList || field.IsList(sf.Type) {
+			return "[]" + sf.IdentName
+		}
+		return sf.IdentName
+	}
+	if sf.Type == field.FTFixedBytes {
+		return fmt.Sprintf("[%d]byte", sf.Size)
+	}
+	return field.GoType(sf.Type)
+}
@@ -0,0 +1,125 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bearlytools/claw/internal/idl"
+	"github.com/johnsiilver/halfpike"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const schema = `
+package cars
+
+version 0
+
+%s
+
+Enum Maker uint8 {
+	Unknown @0
+	Toyota @1
+}
+
+Struct Wheel {
+	Size uint8 @0
+}
+
+Struct Car {
+	Name string @0
+	Maker Maker @1
+	Serial uint64 @2
+	Wheels []Wheel @3
+	Spare Wheel @4
+	Years []uint16 @5
+	Previous Car @6
+}
+`
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		desc    string
+		options string
+		want    []Struct
+	}{
+		{
+			desc: "zero value compression",
+			want: []Struct{
+				{
+					Name: "Car",
+					Fields: []Field{
+						{Name: "Name", Type: "string", Min: 0, Typical: 16},
+						{Name: "Maker", Type: "Maker", Min: 0, Typical: 8},
+						{Name: "Serial", Type: "uint64", Min: 0, Typical: 16},
+						{Name: "Wheels", Type: "[]Wheel", Min: 0, Typical: 24},
+						{Name: "Spare", Type: "Wheel", Min: 0, Typical: 16},
+						{Name: "Years", Type: "[]uint16", Min: 0, Typical: 16},
+						{Name: "Previous", Type: "Car", Min: 0, Typical: 8},
+					},
+					Min:     8,
+					Typical: 112,
+				},
+				{
+					Name:    "Wheel",
+					Fields:  []Field{{Name: "Size", Type: "uint8", Min: 0, Typical: 8}},
+					Min:     8,
+					Typical: 16,
+				},
+			},
+		},
+		{
+			desc:    "NoZeroValueCompression",
+			options: "options [ NoZeroValueCompression() ]",
+			want: []Struct{
+				{
+					Name: "Car",
+					Fields: []Field{
+						{Name: "Name", Type: "string", Min: 8, Typical: 16},
+						{Name: "Maker", Type: "Maker", Min: 8, Typical: 8},
+						{Name: "Serial", Type: "uint64", Min: 16, Typical: 16},
+						{Name: "Wheels", Type: "[]Wheel", Min: 0, Typical: 24},
+						{Name: "Spare", Type: "Wheel", Min: 0, Typical: 16},
+						{Name: "Years", Type: "[]uint16", Min: 0, Typical: 16},
+						{Name: "Previous", Type: "Car", Min: 0, Typical: 8},
+					},
+					Min:     40,
+					Typical: 112,
+				},
+				{
+					Name:    "Wheel",
+					Fields:  []Field{{Name: "Size", Type: "uint8", Min: 8, Typical: 8}},
+					Min:     16,
+					Typical: 16,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		f := idl.New()
+		if err := halfpike.Parse(context.Background(), strings.Replace(schema, "%s", test.options, 1), f); err != nil {
+			t.Fatalf("TestStructs(%s): could not parse schema: %s", test.desc, err)
+		}
+
+		got := Structs(f)
+		// Notes are for people, we don't test their wording.
+		for i := range got {
+			for j := range got[i].Fields {
+				got[i].Fields[j].Notes = ""
+			}
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestStructs(%s): -want/+got:\n%s", test.desc, diff)
+		}
+
+		buff := &bytes.Buffer{}
+		if err := Write(buff, f); err != nil {
+			t.Errorf("TestStructs(%s): Write(): %s", test.desc, err)
+		}
+		if !strings.Contains(buff.String(), "Struct cars.Car:") {
+			t.Errorf("TestStructs(%s): Write(): output did not have a table for Car:\n%s", test.desc, buff.String())
+		}
+	}
+}
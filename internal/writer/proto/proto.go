@@ -0,0 +1,44 @@
+// Package proto implements writer.WriteFiles for .proto descriptor files.
+package proto
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bearlytools/claw/internal/imports"
+	"github.com/bearlytools/claw/internal/render"
+	"github.com/gopherfs/fs"
+)
+
+// Writer implements writer.WriteFiles for .proto files.
+type Writer struct {
+	fs fs.Writer
+}
+
+func (w *Writer) SetFS(fs fs.Writer) {
+	w.fs = fs
+}
+
+// WriteFiles writes a <package>.proto file next to each .claw file that is in the
+// root repo. Files from other repos are not written, as they are only needed to
+// render the root file.
+func (w *Writer) WriteFiles(ctx context.Context, config *imports.Config, renders []render.Rendered) error {
+	for _, r := range renders {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !config.InRootRepo(r.Path) {
+			continue
+		}
+		p, err := config.Abs(r.Path)
+		if err != nil {
+			return err
+		}
+		p = filepath.Join(p, r.Package+".proto")
+		if err := w.fs.WriteFile(p, r.Native, 0600); err != nil {
+			return fmt.Errorf("problem writing package(%s) to local file(%s): %w", r.Package, p, err)
+		}
+	}
+	return nil
+}
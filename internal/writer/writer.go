@@ -11,12 +11,16 @@ import (
 	"github.com/bearlytools/claw/internal/imports"
 	"github.com/bearlytools/claw/internal/render"
 	"github.com/bearlytools/claw/internal/writer/golang"
+	"github.com/bearlytools/claw/internal/writer/jsonschema"
+	"github.com/bearlytools/claw/internal/writer/proto"
 	"github.com/gopherfs/fs"
 	osfs "github.com/gopherfs/fs/io/os"
 )
 
 var supported = map[render.Lang]WriteFiles{
-	render.Go: &golang.Writer{},
+	render.Go:         &golang.Writer{},
+	render.Proto:      &proto.Writer{},
+	render.JSONSchema: &jsonschema.Writer{},
 }
 
 // WriteFiles writes a file to some location based on the language.
@@ -0,0 +1,57 @@
+// Package claw provides functions that work with any Struct type generated by clawc. This lets
+// generic code, such as caches and queues, encode and decode Claw messages without per-type code.
+package claw
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/structs"
+)
+
+// ClawMessage is implemented by every Struct type generated by clawc.
+type ClawMessage interface {
+	// Struct returns the *structs.Struct the type wraps. See structs.Structer.
+	Struct() *structs.Struct
+	// Map returns the mapping for the type. This works on the zero value.
+	Map() *mapping.Map
+}
+
+// settable is implemented by a pointer to a generated Struct type, which Unmarshal() uses to
+// make a T that holds a decoded Struct.
+type settable[T any] interface {
+	*T
+	ClawMessage
+	XXXSetStruct(s *structs.Struct)
+}
+
+// Marshal returns v in its encoded form.
+func Marshal[T ClawMessage](v T) ([]byte, error) {
+	s := v.Struct()
+	if s == nil {
+		return nil, fmt.Errorf("cannot Marshal() a %T that was not created with its New function", v)
+	}
+	buff := bytes.Buffer{}
+	buff.Grow(s.Size())
+	if _, err := s.Marshal(&buff); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// Unmarshal decodes data into a new T, which is a generated Struct type such as:
+//
+//	car, err := claw.Unmarshal[cars.Car](data)
+//
+// The second type parameter is the pointer to T and is always inferred.
+func Unmarshal[T any, PT settable[T]](data []byte) (T, error) {
+	var v T
+	p := PT(&v)
+	s, err := structs.NewFromReader(bytes.NewReader(data), p.Map())
+	if err != nil {
+		return v, err
+	}
+	p.XXXSetStruct(s)
+	return v, nil
+}
@@ -0,0 +1,31 @@
+package claw
+
+import (
+	"testing"
+
+	vehicles "github.com/bearlytools/claw/testing/imports/vehicles/claw"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	v := vehicles.NewVehicle().SetType(vehicles.Car).SetTypesSlice([]vehicles.Type{vehicles.Car, vehicles.Truck})
+
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("TestMarshalUnmarshal: Marshal(): %s", err)
+	}
+
+	got, err := Unmarshal[vehicles.Vehicle](b)
+	if err != nil {
+		t.Fatalf("TestMarshalUnmarshal: Unmarshal(): %s", err)
+	}
+	if !got.Equal(v) {
+		t.Errorf("TestMarshalUnmarshal: Unmarshal() did not give back the Marshal()ed value")
+	}
+
+	if _, err := Marshal(vehicles.Vehicle{}); err == nil {
+		t.Errorf("TestMarshalUnmarshal(zero value): got err == nil, want err != nil")
+	}
+	if _, err := Unmarshal[vehicles.Vehicle](b[:len(b)-8]); err == nil {
+		t.Errorf("TestMarshalUnmarshal(truncated): got err == nil, want err != nil")
+	}
+}
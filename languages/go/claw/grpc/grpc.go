@@ -0,0 +1,66 @@
+// Package grpc provides a gRPC codec that sends Claw messages instead of protocol buffers. Codec
+// implements google.golang.org/grpc/encoding.Codec. This package does not import gRPC, so that
+// users of Claw that don't use gRPC do not need it, which means the codec must be registered by
+// the program:
+//
+//	import (
+//		clawgrpc "github.com/bearlytools/claw/languages/go/claw/grpc"
+//		"google.golang.org/grpc/encoding"
+//	)
+//
+//	func init() {
+//		encoding.RegisterCodec(clawgrpc.Codec{})
+//	}
+//
+// Clients then select it per call with grpc.CallContentSubtype(clawgrpc.Name). Any Struct type
+// generated by clawc can be sent, as they all implement claw.ClawMessage.
+package grpc
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bearlytools/claw/languages/go/claw"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/structs"
+)
+
+// Name is the name the codec is registered under, which is the content subtype in gRPC.
+const Name = "claw"
+
+// settable is implemented by a pointer to a generated Struct type.
+type settable interface {
+	Map() *mapping.Map
+	XXXSetStruct(s *structs.Struct)
+}
+
+// Codec is a gRPC codec for Claw messages.
+type Codec struct{}
+
+// Marshal returns the encoded form of v, which must be a generated Struct type or a pointer to one.
+func (Codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(claw.ClawMessage)
+	if !ok {
+		return nil, fmt.Errorf("claw codec cannot Marshal() type %T, it is not a claw.ClawMessage", v)
+	}
+	return claw.Marshal(m)
+}
+
+// Unmarshal decodes data into v, which must be a pointer to a generated Struct type.
+func (Codec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(settable)
+	if !ok {
+		return fmt.Errorf("claw codec cannot Unmarshal() into type %T, it must be a pointer to a generated Struct type", v)
+	}
+	s, err := structs.NewFromReader(bytes.NewReader(data), p.Map())
+	if err != nil {
+		return err
+	}
+	p.XXXSetStruct(s)
+	return nil
+}
+
+// Name returns Name.
+func (Codec) Name() string {
+	return Name
+}
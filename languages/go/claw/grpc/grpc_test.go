@@ -0,0 +1,44 @@
+package grpc
+
+import (
+	"testing"
+
+	vehicles "github.com/bearlytools/claw/testing/imports/vehicles/claw"
+)
+
+func TestCodec(t *testing.T) {
+	v := vehicles.NewVehicle().SetType(vehicles.Car).SetTypesSlice([]vehicles.Type{vehicles.Car, vehicles.Truck})
+
+	codec := Codec{}
+	if codec.Name() != "claw" {
+		t.Errorf("TestCodec: Name(): got %q, want %q", codec.Name(), "claw")
+	}
+
+	for _, x := range []any{v, &v} {
+		b, err := codec.Marshal(x)
+		if err != nil {
+			t.Fatalf("TestCodec: Marshal(%T): %s", x, err)
+		}
+		got := &vehicles.Vehicle{}
+		if err := codec.Unmarshal(b, got); err != nil {
+			t.Fatalf("TestCodec: Unmarshal(): %s", err)
+		}
+		if !got.Equal(v) {
+			t.Errorf("TestCodec: Unmarshal() did not give back the Marshal()ed %T", x)
+		}
+	}
+
+	if _, err := codec.Marshal("Corolla"); err == nil {
+		t.Errorf("TestCodec: Marshal(string): got err == nil, want err != nil")
+	}
+	if _, err := codec.Marshal(vehicles.Vehicle{}); err == nil {
+		t.Errorf("TestCodec: Marshal(Vehicle without a Struct): got err == nil, want err != nil")
+	}
+	b, _ := codec.Marshal(v)
+	if err := codec.Unmarshal(b, v); err == nil {
+		t.Errorf("TestCodec: Unmarshal(Vehicle, not *Vehicle): got err == nil, want err != nil")
+	}
+	if err := codec.Unmarshal(b[:8], &vehicles.Vehicle{}); err == nil {
+		t.Errorf("TestCodec: Unmarshal(truncated data): got err == nil, want err != nil")
+	}
+}
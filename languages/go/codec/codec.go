@@ -0,0 +1,80 @@
+// Package codec holds the registry of codecs for bytes fields marked with [codec = name] in a
+// .claw file. The field is stored as bytes, but its generated accessors take and return a Go type,
+// such as a uuid.UUID, and use the codec registered for the name to convert. Register codecs in
+// an init() so they are in place before any generated accessor is called:
+//
+//	func init() {
+//		codec.Register("UUID", codec.Codec[uuid.UUID]{
+//			Encode: func(v uuid.UUID) []byte { return v[:] },
+//			Decode: uuid.FromBytes,
+//		})
+//	}
+package codec
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Codec converts between a Go type and the bytes stored for a field.
+type Codec[T any] struct {
+	// Encode returns the bytes to store for v. The field keeps the returned slice.
+	Encode func(v T) []byte
+	// Decode returns the value stored in b. b is the field's memory and must not be kept or changed.
+	Decode func(b []byte) (T, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]any{}
+)
+
+// Register registers c as the codec for name. This panics if name is already registered or c is
+// missing a function.
+func Register[T any](name string, c Codec[T]) {
+	if c.Encode == nil || c.Decode == nil {
+		panic(fmt.Sprintf("codec %q must have Encode and Decode", name))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("codec %q is already registered", name))
+	}
+	registry[name] = c
+}
+
+// Get returns the codec registered for name. ok is false if there is none or it is not for T.
+func Get[T any](name string) (c Codec[T], ok bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok = registry[name].(Codec[T])
+	return c, ok
+}
+
+// mustGet returns the codec registered for name and panics if there is not one for T. A missing
+// codec is a setup bug, like a missing import, so it is not returned as an error.
+func mustGet[T any](name string) Codec[T] {
+	c, ok := Get[T](name)
+	if !ok {
+		var zero T
+		panic(fmt.Sprintf("no codec for %T is registered as %q", zero, name))
+	}
+	return c
+}
+
+// Encode returns v encoded with the codec registered for name. This is used by generated code and
+// panics if there is no codec for T registered as name.
+func Encode[T any](name string, v T) []byte {
+	return mustGet[T](name).Encode(v)
+}
+
+// Decode returns b decoded with the codec registered for name. This is used by generated code and
+// panics if there is no codec for T registered as name.
+func Decode[T any](name string, b []byte) (T, error) {
+	v, err := mustGet[T](name).Decode(b)
+	if err != nil {
+		return v, fmt.Errorf("codec %q: %w", name, err)
+	}
+	return v, nil
+}
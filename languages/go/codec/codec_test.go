@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type id [4]byte
+
+func init() {
+	Register("ID", Codec[id]{
+		Encode: func(v id) []byte { return v[:] },
+		Decode: func(b []byte) (id, error) {
+			var v id
+			if len(b) != len(v) {
+				return v, fmt.Errorf("must be %d bytes, was %d", len(v), len(b))
+			}
+			copy(v[:], b)
+			return v, nil
+		},
+	})
+}
+
+func TestCodec(t *testing.T) {
+	want := id{1, 2, 3, 4}
+
+	b := Encode("ID", want)
+	got, err := Decode[id]("ID", b)
+	if err != nil {
+		t.Fatalf("TestCodec: Decode(): got err == %s, want err == nil", err)
+	}
+	if got != want {
+		t.Errorf("TestCodec: got %v, want %v", got, want)
+	}
+
+	if _, err := Decode[id]("ID", b[:2]); err == nil {
+		t.Errorf("TestCodec(short): got err == nil, want err != nil")
+	}
+
+	if _, ok := Get[id]("ID"); !ok {
+		t.Errorf("TestCodec: Get() did not find the codec")
+	}
+	if _, ok := Get[string]("ID"); ok {
+		t.Errorf("TestCodec: Get() found a codec for the wrong type")
+	}
+}
+
+func TestCodecPanics(t *testing.T) {
+	tests := []struct {
+		desc string
+		f    func()
+	}{
+		{desc: "not registered", f: func() { Encode("Missing", id{}) }},
+		{desc: "wrong type", f: func() { Encode("ID", "hello") }},
+		{desc: "registered twice", f: func() {
+			Register("ID", Codec[id]{Encode: func(id) []byte { return nil }, Decode: func([]byte) (id, error) { return id{}, errors.New("") }})
+		}},
+		{desc: "missing Decode", f: func() { Register("NoDecode", Codec[id]{Encode: func(id) []byte { return nil }}) }},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("TestCodecPanics(%s): did not panic", test.desc)
+				}
+			}()
+			test.f()
+		}()
+	}
+}
@@ -24,7 +24,9 @@ const (
 	FTString  Type = 12 // string
 	FTBytes   Type = 13 // bytes
 	FTStruct  Type = 14 // struct
-	// Reserve 15 to 40
+	// FTFixedBytes is bytes that always have the same length, which the field's mapping gives.
+	FTFixedBytes Type = 15 // fixedBytes
+	// Reserve 16 to 40
 	FTListBools   Type = 41 // []bool
 	FTListInt8    Type = 42 // []int8
 	FTListInt16   Type = 43 // []int16
@@ -40,14 +42,15 @@ const (
 	FTListStrings Type = 53 // []string
 	FTListStructs Type = 54 // []structs
 	// Reserve 55 to 79
+
+	// FTSchemaHash is not a field type. It marks an optional preamble before a top level
+	// Struct that holds a hash of the Struct's schema.
+	FTSchemaHash Type = 80 // schemaHash
 )
 
 // IsList determines if a Type represents a list of entries.
 func IsList(ft Type) bool {
-	if ft > 14 && ft < 29 {
-		return true
-	}
-	return false
+	return ft >= FTListBools && ft <= FTListStructs
 }
 
 // NumberTypes is a list of field types that represent a number.
@@ -132,6 +135,8 @@ func GoType(t Type) string {
 		return "string"
 	case FTBytes:
 		return "[]bytes"
+	case FTFixedBytes:
+		return "[]byte"
 	case FTListBools:
 		return "[]bool"
 	case FTListInt8:
@@ -23,6 +23,7 @@ func _() {
 	_ = x[FTString-12]
 	_ = x[FTBytes-13]
 	_ = x[FTStruct-14]
+	_ = x[FTFixedBytes-15]
 	_ = x[FTListBools-41]
 	_ = x[FTListInt8-42]
 	_ = x[FTListInt16-43]
@@ -37,25 +38,29 @@ func _() {
 	_ = x[FTListBytes-52]
 	_ = x[FTListStrings-53]
 	_ = x[FTListStructs-54]
+	_ = x[FTSchemaHash-80]
 }
 
 const (
-	_Type_name_0 = "FTUnknownFTBoolFTInt8FTInt16FTInt32FTInt64FTUint8FTUint16FTUint32FTUint64FTFloat32FTFloat64FTStringFTBytesFTStruct"
+	_Type_name_0 = "FTUnknownFTBoolFTInt8FTInt16FTInt32FTInt64FTUint8FTUint16FTUint32FTUint64FTFloat32FTFloat64FTStringFTBytesFTStructFTFixedBytes"
 	_Type_name_1 = "FTListBoolsFTListInt8FTListInt16FTListInt32FTListInt64FTListUint8FTListUint16FTListUint32FTListUint64FTListFloat32FTListFloat64FTListBytesFTListStringsFTListStructs"
+	_Type_name_2 = "FTSchemaHash"
 )
 
 var (
-	_Type_index_0 = [...]uint8{0, 9, 15, 21, 28, 35, 42, 49, 57, 65, 73, 82, 91, 99, 106, 114}
+	_Type_index_0 = [...]uint8{0, 9, 15, 21, 28, 35, 42, 49, 57, 65, 73, 82, 91, 99, 106, 114, 126}
 	_Type_index_1 = [...]uint8{0, 11, 21, 32, 43, 54, 65, 77, 89, 101, 114, 127, 138, 151, 164}
 )
 
 func (i Type) String() string {
 	switch {
-	case i <= 14:
+	case i <= 15:
 		return _Type_name_0[_Type_index_0[i]:_Type_index_0[i+1]]
 	case 41 <= i && i <= 54:
 		i -= 41
 		return _Type_name_1[_Type_index_1[i]:_Type_index_1[i+1]]
+	case i == 80:
+		return _Type_name_2
 	default:
 		return "Type(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -2,9 +2,14 @@ package json
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"testing"
 
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/reflect"
+	"github.com/bearlytools/claw/languages/go/structs"
 	vehicles "github.com/bearlytools/claw/testing/imports/vehicles/claw"
 	"github.com/bearlytools/claw/testing/imports/vehicles/claw/manufacturers"
 	cars "github.com/bearlytools/test_claw_imports/cars/claw"
@@ -43,3 +48,267 @@ func TestArrayWrite(t *testing.T) {
 
 	log.Println(buff.String())
 }
+
+var protoTimestampMapping = (&mapping.Map{
+	Name: "Timestamp",
+	Fields: []*mapping.FieldDescr{
+		{Name: "Seconds", Type: field.FTInt64, FieldNum: 0},
+		{Name: "Nanos", Type: field.FTInt32, FieldNum: 1},
+	},
+}).Init()
+
+var protoEventMapping = (&mapping.Map{
+	Name: "Event",
+	Fields: []*mapping.FieldDescr{
+		{Name: "Name", Type: field.FTString, FieldNum: 0},
+		{Name: "ID", Type: field.FTUint64, FieldNum: 1},
+		{Name: "Offset", Type: field.FTInt64, FieldNum: 2},
+		{Name: "Data", Type: field.FTBytes, FieldNum: 3},
+		{Name: "When", Type: field.FTStruct, FieldNum: 4, Mapping: protoTimestampMapping},
+		{Name: "Ratio", Type: field.FTFloat64, FieldNum: 5},
+		{Name: "Counts", Type: field.FTListInt64, FieldNum: 6},
+	},
+}).Init()
+
+// protoEvent lets us use a hand built mapping with MarshalProtoJSON() and UnmarshalProtoJSON().
+type protoEvent struct {
+	s *structs.Struct
+}
+
+func (p protoEvent) ClawStruct() reflect.Struct {
+	return nil
+}
+
+func (p protoEvent) XXXGetStruct() *structs.Struct {
+	return p.s
+}
+
+func TestProtoJSON(t *testing.T) {
+	vehicle := vehicles.NewVehicle()
+	vehicle.SetType(vehicles.Car)
+	car := cars.NewCar()
+	car.SetModel(cars.ModelS)
+	car.SetManufacturer(manufacturers.Tesla)
+	car.SetYear(2022)
+	vehicle.SetCar(car)
+	vehicle.AppendTypes(vehicles.Car, vehicles.Truck)
+
+	event := structs.New(0, protoEventMapping)
+	structs.MustSetBytes(event, 0, []byte(`say "hi"`), true)
+	structs.MustSetNumber(event, 1, uint64(1<<60))
+	structs.MustSetNumber(event, 2, int64(-5))
+	structs.MustSetBytes(event, 3, []byte{0xfb, 0xff}, false)
+	when := structs.New(4, protoTimestampMapping)
+	structs.MustSetNumber(when, 0, int64(1665792000))
+	structs.MustSetNumber(when, 1, int32(500000000))
+	structs.MustSetStruct(event, 4, when)
+	structs.MustSetNumber(event, 5, 0.5)
+	counts := structs.NewNumbers[int64]()
+	counts.Append(1, 2)
+	structs.MustSetListNumber(event, 6, counts)
+
+	tests := []struct {
+		desc  string
+		v     reflect.ClawStruct
+		empty reflect.ClawStruct
+		want  string
+	}{
+		{
+			desc:  "enums, nested Struct and lists",
+			v:     vehicle,
+			empty: vehicles.NewVehicle(),
+			want:  `{"type":"Car","car":{"manufacturer":"Tesla","model":"ModelS","year":2022},"types":["Car","Truck"]}`,
+		},
+		{
+			desc:  "64 bit numbers, bytes and Timestamp",
+			v:     protoEvent{event},
+			empty: protoEvent{structs.New(0, protoEventMapping)},
+			want:  `{"name":"say \"hi\"","id":"1152921504606846976","offset":"-5","data":"+/8=","when":"2022-10-15T00:00:00.5Z","ratio":0.5,"counts":["1","2"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := MarshalProtoJSON(test.v)
+		if err != nil {
+			t.Errorf("TestProtoJSON(%s): MarshalProtoJSON(): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("TestProtoJSON(%s): MarshalProtoJSON(): got %s, want %s", test.desc, got, test.want)
+			continue
+		}
+
+		if err := UnmarshalProtoJSON(got, test.empty); err != nil {
+			t.Errorf("TestProtoJSON(%s): UnmarshalProtoJSON(): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		again, err := MarshalProtoJSON(test.empty)
+		if err != nil {
+			t.Errorf("TestProtoJSON(%s): MarshalProtoJSON(decoded): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		if string(again) != test.want {
+			t.Errorf("TestProtoJSON(%s): round trip: got %s, want %s", test.desc, again, test.want)
+		}
+	}
+}
+
+// writeRecorder records the size of each Write() and can fail after a number of them.
+type writeRecorder struct {
+	buff   bytes.Buffer
+	writes []int
+	failAt int // If > 0, this Write() fails.
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	if len(w.writes) == w.failAt {
+		return 0, errors.New("connection reset")
+	}
+	return w.buff.Write(p)
+}
+
+func TestMarshalProtoJSONTo(t *testing.T) {
+	event := structs.New(0, protoEventMapping)
+	structs.MustSetBytes(event, 0, []byte("big"), true)
+	counts := structs.NewNumbers[int64]()
+	for i := int64(0); i < 10000; i++ {
+		counts.Append(i)
+	}
+	structs.MustSetListNumber(event, 6, counts)
+	v := protoEvent{event}
+
+	want, err := MarshalProtoJSON(v)
+	if err != nil {
+		t.Fatalf("TestMarshalProtoJSONTo: MarshalProtoJSON(): %s", err)
+	}
+
+	tests := []struct {
+		desc   string
+		failAt int
+		err    bool
+	}{
+		{desc: "success"},
+		{desc: "Error: writer fails", failAt: 2, err: true},
+	}
+
+	for _, test := range tests {
+		w := &writeRecorder{failAt: test.failAt}
+		err := MarshalProtoJSONTo(w, v)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestMarshalProtoJSONTo(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestMarshalProtoJSONTo(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		if !bytes.Equal(w.buff.Bytes(), want) {
+			t.Errorf("TestMarshalProtoJSONTo(%s): did not write what MarshalProtoJSON() returns", test.desc)
+		}
+		// The document must be written in pieces, not built and then written.
+		if len(w.writes) < 2 {
+			t.Errorf("TestMarshalProtoJSONTo(%s): got %d writes of a %d byte document, want it streamed", test.desc, len(w.writes), len(want))
+		}
+		for _, n := range w.writes {
+			if n >= len(want)/2 {
+				t.Errorf("TestMarshalProtoJSONTo(%s): got a write of %d bytes of a %d byte document, want it streamed", test.desc, n, len(want))
+				break
+			}
+		}
+	}
+}
+
+func TestUnmarshalProtoJSON(t *testing.T) {
+	tests := []struct {
+		desc    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			desc:  "original names, unquoted 64 bit numbers, URL base64 and time zone offset",
+			input: `{"Name":"a","ID":7,"Offset":-1,"Data":"-_8","When":"2022-10-15T02:00:00+02:00","Counts":[1,"2"]}`,
+			want:  `{"name":"a","id":"7","offset":"-1","data":"+/8=","when":"2022-10-15T00:00:00Z","counts":["1","2"]}`,
+		},
+		{
+			desc:  "null and zero values are not set",
+			input: `{"name":null,"id":"0","ratio":0,"counts":[]}`,
+			want:  `{}`,
+		},
+		{
+			desc:    "unknown field",
+			input:   `{"color":"red"}`,
+			wantErr: true,
+		},
+		{
+			desc:    "field set twice",
+			input:   `{"name":"a","Name":"b"}`,
+			wantErr: true,
+		},
+		{
+			desc:    "number out of range",
+			input:   `{"offset":"9223372036854775808"}`,
+			wantErr: true,
+		},
+		{
+			desc:    "Timestamp is not RFC 3339",
+			input:   `{"when":"yesterday"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		v := protoEvent{structs.New(0, protoEventMapping)}
+		err := UnmarshalProtoJSON([]byte(test.input), v)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestUnmarshalProtoJSON(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestUnmarshalProtoJSON(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		got, err := MarshalProtoJSON(v)
+		if err != nil {
+			t.Errorf("TestUnmarshalProtoJSON(%s): MarshalProtoJSON(): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("TestUnmarshalProtoJSON(%s): got %s, want %s", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestProtoJSONNoNames(t *testing.T) {
+	noNames := (&mapping.Map{
+		Name: "Event",
+		Fields: []*mapping.FieldDescr{
+			{Type: field.FTString, FieldNum: 0},
+			{Type: field.FTStruct, FieldNum: 1, Mapping: protoTimestampMapping},
+		},
+		NoNames: true,
+	}).Init()
+	holdsNoNames := (&mapping.Map{
+		Name: "Holder",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Event", Type: field.FTStruct, FieldNum: 0, Mapping: noNames},
+		},
+	}).Init()
+
+	for _, m := range []*mapping.Map{noNames, holdsNoNames} {
+		v := protoEvent{structs.New(0, m)}
+		if _, err := MarshalProtoJSON(v); !errors.Is(err, mapping.ErrNoNames) {
+			t.Errorf("TestProtoJSONNoNames(%s): MarshalProtoJSON(): got err == %v, want mapping.ErrNoNames", m.Name, err)
+		}
+		if err := UnmarshalProtoJSON([]byte(`{}`), v); !errors.Is(err, mapping.ErrNoNames) {
+			t.Errorf("TestProtoJSONNoNames(%s): UnmarshalProtoJSON(): got err == %v, want mapping.ErrNoNames", m.Name, err)
+		}
+	}
+}
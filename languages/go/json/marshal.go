@@ -37,6 +37,9 @@ func (a *Array) Reset(w io.Writer) error {
 
 // Write writes the Struct to the io.Writer.
 func (a *Array) Write(v reflect.ClawStruct) error {
+	if err := needNames(v); err != nil {
+		return err
+	}
 	err := a.options.write(a.w, v, a.entries)
 	if err != nil {
 		return err
@@ -103,7 +106,7 @@ func (o Options) writeStruct(w *bufio.Writer, r reflect.Struct) error {
 				writeFloat(w, 32, v.Float())
 			case field.FTFloat64:
 				writeFloat(w, 64, v.Float())
-			case field.FTBytes:
+			case field.FTBytes, field.FTFixedBytes:
 				writeBytes(w, v.Bytes())
 			case field.FTString:
 				writeString(w, v.String())
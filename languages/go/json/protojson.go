@@ -0,0 +1,730 @@
+package json
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/reflect"
+	"github.com/bearlytools/claw/languages/go/reflect/runtime"
+	"github.com/bearlytools/claw/languages/go/structs"
+)
+
+// MarshalProtoJSON encodes v using the protobuf JSON mapping, so the output can be read by
+// clients that expect protobuf JSON. The rules are:
+//   - Field names are lowerCamelCase ("Year" becomes "year", "ID" becomes "id")
+//   - Enums are written as the name of the value, or the number if the value has no name
+//   - int64 and uint64 values are written as strings, other numbers are JSON numbers
+//   - Bytes are written as standard base64 with padding
+//   - A Struct named Timestamp that has "Seconds int64 @0" and "Nanos int32 @1" is written as
+//     an RFC 3339 string in UTC
+//
+// Fields that are not set are not written. Lists of strings are not supported.
+func MarshalProtoJSON(v reflect.ClawStruct) ([]byte, error) {
+	buff := &bytes.Buffer{}
+	if err := MarshalProtoJSONTo(buff, v); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// MarshalProtoJSONTo is MarshalProtoJSON(), but writes to w as v is walked instead of returning the
+// whole document. At most a few KiB are buffered before they are written, so a large message, such
+// as one with long lists, can be written to an http.ResponseWriter without holding its JSON in
+// memory. If an error is returned, part of the document may have been written.
+func MarshalProtoJSONTo(w io.Writer, v reflect.ClawStruct) error {
+	s, err := getStruct(v)
+	if err != nil {
+		return err
+	}
+	if err := s.Map().NeedNames(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeProtoStruct(bw, s); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// UnmarshalProtoJSON decodes b, which is in the protobuf JSON mapping, into v. Any fields
+// already in v are removed first. This accepts everything MarshalProtoJSON writes, plus:
+//   - Field names as written in the .claw file
+//   - Numbers of any size as either JSON numbers or strings
+//   - Enums as either names or numbers
+//   - Bytes in standard or URL safe base64, with or without padding
+//   - Timestamps with any RFC 3339 time zone offset
+//   - null for any field, which leaves the field unset
+//
+// Fields in b that are not in v are an error. Zero values are treated as not set, as they are
+// in protobuf.
+func UnmarshalProtoJSON(b []byte, v reflect.ClawStruct) error {
+	s, err := getStruct(v)
+	if err != nil {
+		return err
+	}
+	if err := s.Map().NeedNames(); err != nil {
+		return err
+	}
+	s.Reset()
+	return decodeProtoStruct(s, b)
+}
+
+// clawStruct is implemented by the types generated for Claw Structs.
+type clawStruct interface {
+	XXXGetStruct() *structs.Struct
+}
+
+func getStruct(v reflect.ClawStruct) (*structs.Struct, error) {
+	cs, ok := v.(clawStruct)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a generated Claw Struct", v)
+	}
+	s := cs.XXXGetStruct()
+	if s == nil {
+		return nil, fmt.Errorf("%T was not created with its New() constructor", v)
+	}
+	return s, nil
+}
+
+// needNames returns an error if v is a generated Claw Struct whose mapping has no field names,
+// as JSON field names come from the mapping.
+func needNames(v reflect.ClawStruct) error {
+	cs, ok := v.(clawStruct)
+	if !ok || cs.XXXGetStruct() == nil {
+		return nil
+	}
+	return cs.XXXGetStruct().Map().NeedNames()
+}
+
+// lowerCamel converts a field name, which is UpperCamelCase in .claw files, to the lowerCamelCase
+// protobuf uses for JSON names. A leading acronym is lowered as a whole, so "HTTPCode" becomes "httpCode".
+func lowerCamel(name string) string {
+	r := []rune(name)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// isTimestamp reports if m is the well-known Timestamp Struct.
+func isTimestamp(m *mapping.Map) bool {
+	return m.Name == "Timestamp" && len(m.Fields) == 2 &&
+		m.Fields[0].Name == "Seconds" && m.Fields[0].Type == field.FTInt64 &&
+		m.Fields[1].Name == "Nanos" && m.Fields[1].Type == field.FTInt32
+}
+
+// structMapping returns the mapping for the Struct or list of Structs held in field fd of a Struct with mapping m.
+func structMapping(m *mapping.Map, fd *mapping.FieldDescr) *mapping.Map {
+	if fd.SelfReferential {
+		return m
+	}
+	return fd.Mapping
+}
+
+// enumGroup finds the EnumGroup for field fd in the runtime registry.
+func enumGroup(fd *mapping.FieldDescr) (reflect.EnumGroup, error) {
+	pkg := runtime.PackageDescr(fd.FullPath)
+	if pkg == nil || pkg.Enums() == nil {
+		return nil, fmt.Errorf("package %q that holds enum %q is not registered", fd.FullPath, fd.EnumGroup)
+	}
+	// EnumGroup is in the form "Name" or "pkg.Name" if the enum was defined in another package.
+	name := fd.EnumGroup
+	if i := strings.LastIndex(name, "."); i != -1 {
+		name = name[i+1:]
+	}
+	eg := pkg.Enums().ByName(name)
+	if eg == nil {
+		return nil, fmt.Errorf("package %q does not have enum %q", fd.FullPath, name)
+	}
+	return eg, nil
+}
+
+func writeProtoStruct(w *bufio.Writer, s *structs.Struct) error {
+	m := s.Map()
+	if isTimestamp(m) {
+		writeTimestamp(w, s)
+		return nil
+	}
+
+	w.WriteByte('{')
+	first := true
+	for i, f := range s.Fields() {
+		fd := m.Fields[i]
+		if f.Header == nil || fd.Type == field.FTUnknown {
+			continue
+		}
+		if fd.Type == field.FTStruct && structs.MustGetStruct(s, fd.FieldNum) == nil {
+			continue
+		}
+
+		if !first {
+			w.WriteByte(',')
+		}
+		first = false
+		writeJSONString(w, lowerCamel(fd.Name))
+		w.WriteByte(':')
+		if err := writeProtoField(w, s, fd); err != nil {
+			return fmt.Errorf("field %s: %w", fd.Name, err)
+		}
+	}
+	w.WriteByte('}')
+	return nil
+}
+
+func writeProtoField(w *bufio.Writer, s *structs.Struct, fd *mapping.FieldDescr) error {
+	n := fd.FieldNum
+
+	switch fd.Type {
+	case field.FTBool:
+		writeBool(structs.MustGetBool(s, n), w)
+	case field.FTInt8:
+		writeInt(w, int64(structs.MustGetNumber[int8](s, n)))
+	case field.FTInt16:
+		writeInt(w, int64(structs.MustGetNumber[int16](s, n)))
+	case field.FTInt32:
+		writeInt(w, int64(structs.MustGetNumber[int32](s, n)))
+	case field.FTInt64:
+		writeJSONString(w, strconv.FormatInt(structs.MustGetNumber[int64](s, n), 10))
+	case field.FTUint8, field.FTUint16:
+		var v uint16
+		if fd.Type == field.FTUint8 {
+			v = uint16(structs.MustGetNumber[uint8](s, n))
+		} else {
+			v = structs.MustGetNumber[uint16](s, n)
+		}
+		if !fd.IsEnum {
+			writeUint(w, uint64(v))
+			return nil
+		}
+		eg, err := enumGroup(fd)
+		if err != nil {
+			return err
+		}
+		writeEnum(w, eg, v)
+	case field.FTUint32:
+		writeUint(w, uint64(structs.MustGetNumber[uint32](s, n)))
+	case field.FTUint64:
+		writeJSONString(w, strconv.FormatUint(structs.MustGetNumber[uint64](s, n), 10))
+	case field.FTFloat32:
+		writeFloat(w, 32, float64(structs.MustGetNumber[float32](s, n)))
+	case field.FTFloat64:
+		writeFloat(w, 64, structs.MustGetNumber[float64](s, n))
+	case field.FTString:
+		var v string
+		if b := structs.MustGetBytes(s, n); b != nil {
+			v = string(*b)
+		}
+		writeJSONString(w, v)
+	case field.FTBytes:
+		var v []byte
+		if b := structs.MustGetBytes(s, n); b != nil {
+			v = *b
+		}
+		writeBytes(w, v)
+	case field.FTFixedBytes:
+		writeBytes(w, structs.MustGetFixedBytes(s, n))
+	case field.FTStruct:
+		return writeProtoStruct(w, structs.MustGetStruct(s, n))
+	case field.FTListBools:
+		l := structs.MustGetListBool(s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeBool(l.Get(i), w)
+			return nil
+		})
+	case field.FTListInt8:
+		l := structs.MustGetListNumber[int8](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeInt(w, int64(l.Get(i)))
+			return nil
+		})
+	case field.FTListInt16:
+		l := structs.MustGetListNumber[int16](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeInt(w, int64(l.Get(i)))
+			return nil
+		})
+	case field.FTListInt32:
+		l := structs.MustGetListNumber[int32](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeInt(w, int64(l.Get(i)))
+			return nil
+		})
+	case field.FTListInt64:
+		l := structs.MustGetListNumber[int64](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeJSONString(w, strconv.FormatInt(l.Get(i), 10))
+			return nil
+		})
+	case field.FTListUint8, field.FTListUint16:
+		var get func(i int) uint16
+		var length int
+		if fd.Type == field.FTListUint8 {
+			l := structs.MustGetListNumber[uint8](s, n)
+			get, length = func(i int) uint16 { return uint16(l.Get(i)) }, l.Len()
+		} else {
+			l := structs.MustGetListNumber[uint16](s, n)
+			get, length = l.Get, l.Len()
+		}
+		if !fd.IsEnum {
+			return writeList(w, length, func(i int) error {
+				writeUint(w, uint64(get(i)))
+				return nil
+			})
+		}
+		eg, err := enumGroup(fd)
+		if err != nil {
+			return err
+		}
+		writeList(w, length, func(i int) error {
+			writeEnum(w, eg, get(i))
+			return nil
+		})
+	case field.FTListUint32:
+		l := structs.MustGetListNumber[uint32](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeUint(w, uint64(l.Get(i)))
+			return nil
+		})
+	case field.FTListUint64:
+		l := structs.MustGetListNumber[uint64](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeJSONString(w, strconv.FormatUint(l.Get(i), 10))
+			return nil
+		})
+	case field.FTListFloat32:
+		l := structs.MustGetListNumber[float32](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeFloat(w, 32, float64(l.Get(i)))
+			return nil
+		})
+	case field.FTListFloat64:
+		l := structs.MustGetListNumber[float64](s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeFloat(w, 64, l.Get(i))
+			return nil
+		})
+	case field.FTListBytes:
+		l := structs.MustGetListBytes(s, n)
+		writeList(w, l.Len(), func(i int) error {
+			writeBytes(w, l.Get(i))
+			return nil
+		})
+	case field.FTListStructs:
+		l := structs.MustGetListStruct(s, n)
+		return writeList(w, l.Len(), func(i int) error {
+			return writeProtoStruct(w, l.Get(i))
+		})
+	default:
+		return fmt.Errorf("field type %v is not supported", fd.Type)
+	}
+	return nil
+}
+
+// writeList writes a JSON array of n items, using item to write each one.
+func writeList(w *bufio.Writer, n int, item func(i int) error) error {
+	w.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := item(i); err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	w.WriteByte(']')
+	return nil
+}
+
+// writeEnum writes the name of enum value v, or its number if eg does not have a name for it.
+func writeEnum(w *bufio.Writer, eg reflect.EnumGroup, v uint16) {
+	if e := eg.ByValue(v); e != nil {
+		writeJSONString(w, e.Name())
+		return
+	}
+	writeUint(w, uint64(v))
+}
+
+func writeTimestamp(w *bufio.Writer, s *structs.Struct) {
+	t := time.Unix(structs.MustGetNumber[int64](s, 0), int64(structs.MustGetNumber[int32](s, 1)))
+	writeJSONString(w, t.UTC().Format(time.RFC3339Nano))
+}
+
+// writeJSONString writes s as a quoted JSON string. Unlike writeString(), s is escaped.
+func writeJSONString(w *bufio.Writer, s string) {
+	b, _ := json.Marshal(s) // Marshalling a string cannot fail.
+	w.Write(b)
+}
+
+func decodeProtoStruct(s *structs.Struct, b []byte) error {
+	m := s.Map()
+	if isTimestamp(m) {
+		return decodeTimestamp(s, b)
+	}
+
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Errorf("Struct %s: %w", m.Name, err)
+	}
+
+	seen := make([]bool, len(m.Fields))
+	for name, raw := range obj {
+		fd := fieldByJSONName(m, name)
+		if fd == nil {
+			return fmt.Errorf("Struct %s does not have a field %q", m.Name, name)
+		}
+		if seen[fd.FieldNum] {
+			return fmt.Errorf("Struct %s: field %s was set more than once", m.Name, fd.Name)
+		}
+		seen[fd.FieldNum] = true
+
+		if isNull(raw) {
+			continue
+		}
+		if err := decodeProtoField(s, fd, raw); err != nil {
+			return fmt.Errorf("Struct %s: field %s: %w", m.Name, fd.Name, err)
+		}
+	}
+	return nil
+}
+
+// fieldByJSONName returns the field in m that has the lowerCamelCase name or .claw file name "name".
+func fieldByJSONName(m *mapping.Map, name string) *mapping.FieldDescr {
+	for _, fd := range m.Fields {
+		if fd.Type == field.FTUnknown {
+			continue
+		}
+		if fd.Name == name || lowerCamel(fd.Name) == name {
+			return fd
+		}
+	}
+	return nil
+}
+
+func decodeProtoField(s *structs.Struct, fd *mapping.FieldDescr, raw json.RawMessage) error {
+	n := fd.FieldNum
+
+	switch fd.Type {
+	case field.FTBool:
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if v {
+			return structs.SetBool(s, n, v)
+		}
+		return nil
+	case field.FTInt8:
+		v, err := parseInt(raw, 8)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, int8(v))
+	case field.FTInt16:
+		v, err := parseInt(raw, 16)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, int16(v))
+	case field.FTInt32:
+		v, err := parseInt(raw, 32)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, int32(v))
+	case field.FTInt64:
+		v, err := parseInt(raw, 64)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, v)
+	case field.FTUint8:
+		v, err := parseEnumOrUint(fd, raw, 8)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, uint8(v))
+	case field.FTUint16:
+		v, err := parseEnumOrUint(fd, raw, 16)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, uint16(v))
+	case field.FTUint32:
+		v, err := parseUint(raw, 32)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, uint32(v))
+	case field.FTUint64:
+		v, err := parseUint(raw, 64)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, v)
+	case field.FTFloat32:
+		v, err := parseFloat(raw, 32)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, float32(v))
+	case field.FTFloat64:
+		v, err := parseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		return setProtoNumber(s, n, v)
+	case field.FTString:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if v == "" {
+			return nil
+		}
+		return structs.SetBytes(s, n, []byte(v), true)
+	case field.FTBytes:
+		v, err := parseBytes(raw)
+		if err != nil {
+			return err
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return structs.SetBytes(s, n, v, false)
+	case field.FTFixedBytes:
+		v, err := parseBytes(raw)
+		if err != nil {
+			return err
+		}
+		return structs.SetFixedBytes(s, n, v)
+	case field.FTStruct:
+		sub := structs.New(n, structMapping(s.Map(), fd))
+		if err := decodeProtoStruct(sub, raw); err != nil {
+			return err
+		}
+		return structs.SetStruct(s, n, sub)
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	switch fd.Type {
+	case field.FTListBools:
+		l := structs.NewBools(n)
+		for i, item := range items {
+			var v bool
+			if err := json.Unmarshal(item, &v); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			l.Append(v)
+		}
+		return structs.SetListBool(s, n, l)
+	case field.FTListInt8:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (int8, error) {
+			v, err := parseInt(raw, 8)
+			return int8(v), err
+		})
+	case field.FTListInt16:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (int16, error) {
+			v, err := parseInt(raw, 16)
+			return int16(v), err
+		})
+	case field.FTListInt32:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (int32, error) {
+			v, err := parseInt(raw, 32)
+			return int32(v), err
+		})
+	case field.FTListInt64:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (int64, error) {
+			return parseInt(raw, 64)
+		})
+	case field.FTListUint8:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (uint8, error) {
+			v, err := parseEnumOrUint(fd, raw, 8)
+			return uint8(v), err
+		})
+	case field.FTListUint16:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (uint16, error) {
+			v, err := parseEnumOrUint(fd, raw, 16)
+			return uint16(v), err
+		})
+	case field.FTListUint32:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (uint32, error) {
+			v, err := parseUint(raw, 32)
+			return uint32(v), err
+		})
+	case field.FTListUint64:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (uint64, error) {
+			return parseUint(raw, 64)
+		})
+	case field.FTListFloat32:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (float32, error) {
+			v, err := parseFloat(raw, 32)
+			return float32(v), err
+		})
+	case field.FTListFloat64:
+		return setProtoListNumber(s, n, items, func(raw json.RawMessage) (float64, error) {
+			return parseFloat(raw, 64)
+		})
+	case field.FTListBytes:
+		l := structs.NewBytes()
+		for i, item := range items {
+			v, err := parseBytes(item)
+			if err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			if err := l.Append(v); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		return structs.SetListBytes(s, n, l)
+	case field.FTListStructs:
+		m := structMapping(s.Map(), fd)
+		values := make([]*structs.Struct, 0, len(items))
+		for i, item := range items {
+			sub := structs.New(0, m)
+			if err := decodeProtoStruct(sub, item); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			values = append(values, sub)
+		}
+		return structs.AppendListStruct(s, n, values...)
+	}
+	return fmt.Errorf("field type %v is not supported", fd.Type)
+}
+
+func decodeTimestamp(s *structs.Struct, raw json.RawMessage) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return fmt.Errorf("Timestamp must be an RFC 3339 string: %w", err)
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return err
+	}
+	if err := setProtoNumber(s, 0, t.Unix()); err != nil {
+		return err
+	}
+	return setProtoNumber(s, 1, int32(t.Nanosecond()))
+}
+
+// setProtoNumber sets field fieldNum to v. Zero values are not set, which keeps them from being
+// encoded when the Struct uses zero value compression.
+func setProtoNumber[N structs.Number](s *structs.Struct, fieldNum uint16, v N) error {
+	if v == 0 {
+		return nil
+	}
+	return structs.SetNumber(s, fieldNum, v)
+}
+
+func setProtoListNumber[N structs.Number](s *structs.Struct, fieldNum uint16, items []json.RawMessage, parse func(json.RawMessage) (N, error)) error {
+	l := structs.NewNumbers[N]()
+	for i, item := range items {
+		v, err := parse(item)
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+		l.Append(v)
+	}
+	return structs.SetListNumber(s, fieldNum, l)
+}
+
+func isNull(raw json.RawMessage) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
+
+// numberText returns the text of a number that is either a JSON number or a JSON string.
+func numberText(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", err
+		}
+		return text, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", fmt.Errorf("%s is not a number", raw)
+	}
+	return n.String(), nil
+}
+
+func parseInt(raw json.RawMessage, bitSize int) (int64, error) {
+	text, err := numberText(raw)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(text, 10, bitSize)
+}
+
+func parseUint(raw json.RawMessage, bitSize int) (uint64, error) {
+	text, err := numberText(raw)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(text, 10, bitSize)
+}
+
+// parseFloat parses a float, which includes the strings "NaN", "Infinity" and "-Infinity".
+func parseFloat(raw json.RawMessage, bitSize int) (float64, error) {
+	text, err := numberText(raw)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(text, bitSize)
+}
+
+// parseEnumOrUint parses an unsigned number. If fd is an enum, this can also be the name of a value.
+func parseEnumOrUint(fd *mapping.FieldDescr, raw json.RawMessage, bitSize int) (uint64, error) {
+	if !fd.IsEnum {
+		return parseUint(raw, bitSize)
+	}
+
+	if v, err := parseUint(raw, bitSize); err == nil {
+		return v, nil
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return 0, fmt.Errorf("%s is not an enum name or number", raw)
+	}
+	eg, err := enumGroup(fd)
+	if err != nil {
+		return 0, err
+	}
+	e := eg.ByName(name)
+	if e == nil {
+		return 0, fmt.Errorf("enum %s does not have a value named %q", eg.Name(), name)
+	}
+	return uint64(e.Number()), nil
+}
+
+// parseBytes decodes standard or URL safe base64, with or without padding.
+func parseBytes(raw json.RawMessage) ([]byte, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return nil, err
+	}
+	text = strings.TrimRight(text, "=")
+	text = strings.NewReplacer("-", "+", "_", "/").Replace(text)
+	return base64.RawStdEncoding.DecodeString(text)
+}
@@ -40,6 +40,9 @@ func newDecoder(r io.Reader) *decoder {
 
 // decode is used to decode data in dec into v. It is the entry point for using a decoder.
 func (d *decoder) decode(dec *json.Decoder, v reflect.ClawStruct) error {
+	if err := needNames(v); err != nil {
+		return err
+	}
 	clawStruct := v.ClawStruct()
 	if d.fields == nil {
 		d.prep(clawStruct)
@@ -139,7 +142,7 @@ func (d *decoder) decodeStruct(m map[string]any, r reflect.Struct) error {
 			if err := setNumber[float64](fd, val, r); err != nil {
 				return fmt.Errorf("received field %q in Struct %q, %w", key, d.descr.StructName(), err)
 			}
-		case field.FTBytes:
+		case field.FTBytes, field.FTFixedBytes:
 			s, ok := val.(string)
 			if !ok {
 				return fmt.Errorf("received field %q in Struct %q, but wasn't expected []byte", key, d.descr.StructName())
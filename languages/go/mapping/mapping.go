@@ -4,11 +4,20 @@
 package mapping
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
 
 	"github.com/bearlytools/claw/languages/go/field"
 )
 
+// ErrNoNames is returned by features that need field names, such as JSON, when the mapping was
+// generated with "clawc -nonames".
+var ErrNoNames = errors.New("the mapping has no field names, it was generated with clawc -nonames")
+
 // FieldDescr describes a field. FieldDescr are created when the IDL renders to a file
 // and are generated from information in the idl.File and idl.Struct types.
 type FieldDescr struct {
@@ -16,7 +25,9 @@ type FieldDescr struct {
 	Name string
 	// Type is the type of field.
 	Type field.Type
-	// FieldNum is the field number in the Struct.
+	// FieldNum is the field number in the Struct. Field numbers start at 0, which is a valid field,
+	// and are the same numbers written in the .claw file and in the headers on the wire. Map.Fields
+	// is sorted by FieldNum, which Init() arranges.
 	FieldNum uint16
 	// StructName is the name of the struct type if Type == FTStruct.
 	// This will be either the name of the Struct in this file or [package].[group].
@@ -36,13 +47,38 @@ type FieldDescr struct {
 	SelfReferential bool
 	// Mapping is provided if .Type == FTStruct || FTListStruct. This will describe the Structs fields.
 	Mapping *Map
+	// Default is returned by the getter when a bool or number field is not set. It must be the Go type
+	// of the field on the wire (uint8 or uint16 for an Enum). It is never encoded.
+	Default any
+	// Encrypted indicates a String or Bytes field's value is passed through the Crypter in
+	// structs.MarshalOptions and structs.UnmarshalOptions.
+	Encrypted bool
+	// Dense indicates a bool or number field is encoded even when it is the zero value or not set,
+	// which keeps the field at a fixed size and offset when the Struct uses zero value compression.
+	Dense bool
+	// Group is the name of the field group the field is in, if any. See structs.MarshalGroup().
+	Group string
+	// Codec is the name of the codec in the codec package that the generated accessors of a Bytes
+	// field use to convert it to and from a Go type. The field is bytes on the wire.
+	Codec string
+	// Size is the length in bytes of a FixedBytes field, which every value of the field has.
+	Size uint16
 }
 
+// Validate checks that the FieldDescr is usable. Fields that hold a Struct or a list of Structs
+// must have a Mapping, unless they are SelfReferential. FixedBytes fields must have a Size.
 func (f *FieldDescr) Validate() error {
 	switch f.Type {
+	case field.FTFixedBytes:
+		if f.Size == 0 {
+			return fmt.Errorf(".%s: type was %v, but had Size == 0", f.Name, f.Type)
+		}
 	case field.FTListStructs, field.FTStruct:
+		if f.SelfReferential {
+			return nil
+		}
 		if f.Mapping == nil {
-			return fmt.Errorf(".%s: type was %v, but had Mapping == nil", f.Name, f.Type)
+			return fmt.Errorf(".%s: type was %v, but had Mapping == nil and was not SelfReferential", f.Name, f.Type)
 		}
 		if err := f.Mapping.validate(); err != nil {
 			return fmt.Errorf(".%s%w", f.Name, err)
@@ -59,12 +95,194 @@ type Map struct {
 	Pkg string
 	// Path is the path to the package.
 	Path string
-	// Fields are the field descriptions for all fields in the Struct.
+	// Fields are the field descriptions for all fields in the Struct, sorted by FieldNum. Fields
+	// are looked up by position, see Index().
 	Fields []*FieldDescr
+	// NoNames is set when the code was generated with "clawc -nonames". The Name of every entry
+	// in Fields is empty, which makes binaries smaller but breaks anything that looks up fields
+	// by name. Use NeedNames() to check for this.
+	NoNames bool
+
+	// byName is the index in Fields of each field name, which Init() builds for FieldByName().
+	byName map[string]int
+}
+
+// Init readies a Map whose Fields have their FieldNum set, but may be in any order and
+// may have gaps in their numbering (such as 0, 1, 1000). Fields is sorted by FieldNum and each
+// gap gets a single reserved entry, with Type == field.FTUnknown and the first FieldNum of the gap,
+// which holds any fields in the gap from a newer version of the Struct. A gap costs one slot no
+// matter how many numbers it skips. Init returns m so that it can be used in a variable declaration.
+//
+// Init panics if two fields share a number or if a field fails MustValidate(), as those are
+// bugs in the generated code that would otherwise show up when data is first decoded.
+func (m *Map) Init() *Map {
+	if len(m.Fields) == 0 {
+		return m
+	}
+	defer func() {
+		m.MustValidate()
+		m.indexNames()
+	}()
+	sort.Slice(m.Fields, func(i, j int) bool {
+		return m.Fields[i].FieldNum < m.Fields[j].FieldNum
+	})
+
+	last := m.Fields[len(m.Fields)-1].FieldNum
+	if int(last) == len(m.Fields)-1 {
+		for i, f := range m.Fields {
+			if int(f.FieldNum) != i {
+				panic(fmt.Sprintf("Struct %s has more than one field with field number %d", m.Name, f.FieldNum))
+			}
+		}
+		return m
+	}
+
+	fields := make([]*FieldDescr, 0, 2*len(m.Fields))
+	next := uint16(0) // The lowest field number not yet in fields.
+	for i, f := range m.Fields {
+		if i > 0 && f.FieldNum == m.Fields[i-1].FieldNum {
+			panic(fmt.Sprintf("Struct %s has more than one field with field number %d", m.Name, f.FieldNum))
+		}
+		if f.FieldNum > next {
+			fields = append(fields, &FieldDescr{Type: field.FTUnknown, FieldNum: next, Package: m.Pkg, FullPath: m.Path})
+		}
+		fields = append(fields, f)
+		next = f.FieldNum + 1
+	}
+	m.Fields = fields
+	return m
+}
+
+// Index returns the index in Fields of the entry that holds field number "num". This is the field's
+// own entry, or the reserved entry (Type == field.FTUnknown) of the gap that num is in. If num is
+// beyond the last field, this returns -1.
+func (m *Map) Index(num uint16) int {
+	// Structs without gaps, which are most of them, have every field at the index of its number.
+	if int(num) < len(m.Fields) && m.Fields[num].FieldNum == num {
+		return int(num)
+	}
+	if len(m.Fields) == 0 || num > m.Fields[len(m.Fields)-1].FieldNum {
+		return -1
+	}
+	// The last entry whose FieldNum is <= num.
+	i := sort.Search(len(m.Fields), func(i int) bool { return m.Fields[i].FieldNum > num }) - 1
+	if i < 0 || (m.Fields[i].FieldNum != num && m.Fields[i].Type != field.FTUnknown) {
+		return -1
+	}
+	return i
+}
+
+// FieldByNumber returns the FieldDescr for field number "num". If the number is not used by
+// the Struct (it is beyond the last field or is a reserved gap), this returns nil.
+func (m Map) FieldByNumber(num uint16) *FieldDescr {
+	i := m.Index(num)
+	if i < 0 {
+		return nil
+	}
+	fd := m.Fields[i]
+	if fd.Type == field.FTUnknown || fd.FieldNum != num {
+		return nil
+	}
+	return fd
+}
+
+// FieldByName returns the FieldDescr for the field called "name". If the Struct has no field by
+// that name, or m has no names (see NoNames), this returns nil. Unlike ByName(), this does not panic,
+// so it can be used with names from user input.
+func (m Map) FieldByName(name string) *FieldDescr {
+	if name == "" {
+		return nil
+	}
+	if m.byName != nil {
+		i, ok := m.byName[name]
+		if !ok {
+			return nil
+		}
+		return m.Fields[i]
+	}
+	// Init() was not called, such as for a Map made in a test.
+	for _, f := range m.Fields {
+		if f.Name == name && f.Type != field.FTUnknown {
+			return f
+		}
+	}
+	return nil
+}
+
+// indexNames builds the table FieldByName() uses.
+func (m *Map) indexNames() {
+	if m.NoNames {
+		return
+	}
+	m.byName = make(map[string]int, len(m.Fields))
+	for i, f := range m.Fields {
+		if f.Name != "" && f.Type != field.FTUnknown {
+			m.byName[f.Name] = i
+		}
+	}
+}
+
+// SchemaHash returns a hash of the schema m describes: the Struct's name and package and the
+// name, number and type of every field, including the schemas of any Struct fields and the Size of
+// FixedBytes fields. Encoded data can carry this hash so that a decoder can detect data from a
+// different schema.
+//
+// Any change to the schema changes the hash, including ones that are compatible on the wire,
+// such as adding a field. Field names are part of the hash, so code generated with
+// "clawc -nonames" has a different hash than code generated with names.
+func (m *Map) SchemaHash() uint64 {
+	h := fnv.New64a()
+	m.writeSchema(h)
+	return h.Sum64()
+}
+
+func (m *Map) writeSchema(h hash.Hash64) {
+	b := make([]byte, 2)
+	writeStr := func(s string) {
+		binary.LittleEndian.PutUint16(b, uint16(len(s)))
+		h.Write(b)
+		h.Write([]byte(s))
+	}
+
+	writeStr(m.Pkg)
+	writeStr(m.Name)
+	for _, f := range m.Fields {
+		if f.Type == field.FTUnknown { // Reserved by Init()
+			continue
+		}
+		binary.LittleEndian.PutUint16(b, f.FieldNum)
+		h.Write(b)
+		h.Write([]byte{byte(f.Type)})
+		writeStr(f.Name)
+		writeStr(f.EnumGroup)
+		if f.Type == field.FTFixedBytes {
+			binary.LittleEndian.PutUint16(b, f.Size)
+			h.Write(b)
+		}
+		switch {
+		case f.SelfReferential:
+			h.Write([]byte{1})
+		case f.Mapping != nil:
+			h.Write([]byte{2})
+			f.Mapping.writeSchema(h)
+		default:
+			h.Write([]byte{0})
+		}
+	}
 }
 
 func (m Map) validate() error {
-	for _, entry := range m.Fields {
+	for i, entry := range m.Fields {
+		// Index() searches Fields by number, so a field out of order would be silently misread.
+		switch {
+		case i == 0 && entry.FieldNum != 0:
+			return fmt.Errorf(".%s: has field number %d, but is the first entry of Fields; the first entry must be field number 0 (see Init())", entry.Name, entry.FieldNum)
+		case i > 0 && entry.FieldNum <= m.Fields[i-1].FieldNum:
+			return fmt.Errorf(".%s: has field number %d, but comes after field number %d in Fields; Fields must be sorted by field number (see Init())", entry.Name, entry.FieldNum, m.Fields[i-1].FieldNum)
+		}
+		if entry.Type == field.FTUnknown { // Reserved by Init()
+			continue
+		}
 		if err := entry.Validate(); err != nil {
 			return err
 		}
@@ -72,6 +290,32 @@ func (m Map) validate() error {
 	return nil
 }
 
+// NeedNames returns an error wrapping ErrNoNames if m, or the mapping of any Struct that m
+// holds, has NoNames set. Anything that reads or writes fields by name should call this first.
+func (m *Map) NeedNames() error {
+	return m.needNames(map[*Map]bool{})
+}
+
+func (m *Map) needNames(seen map[*Map]bool) error {
+	if seen[m] {
+		return nil
+	}
+	seen[m] = true
+
+	if m.NoNames {
+		return fmt.Errorf("Struct %s.%s: %w", m.Pkg, m.Name, ErrNoNames)
+	}
+	for _, f := range m.Fields {
+		if f.Mapping == nil {
+			continue
+		}
+		if err := f.Mapping.needNames(seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ByName retrieves the FieldDesc by name. If the name can't be found, it panics.
 func (m Map) ByName(name string) *FieldDescr {
 	for _, f := range m.Fields {
@@ -82,8 +326,10 @@ func (m Map) ByName(name string) *FieldDescr {
 	panic(fmt.Sprintf("could not find name %q", name))
 }
 
+// MustValidate panics if any field in m, or in the Structs m holds, fails FieldDescr.Validate() or
+// is out of order in Fields.
 func (m Map) MustValidate() {
 	if err := m.validate(); err != nil {
-		panic(err)
+		panic(fmt.Sprintf("Struct %s%s", m.Name, err))
 	}
 }
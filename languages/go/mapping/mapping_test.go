@@ -0,0 +1,276 @@
+package mapping
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+func TestInitValidates(t *testing.T) {
+	inner := (&Map{
+		Name:   "Inner",
+		Fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}},
+	}).Init()
+
+	tests := []struct {
+		desc   string
+		fields []*FieldDescr
+		panics bool
+	}{
+		{
+			desc:   "Struct with a Mapping",
+			fields: []*FieldDescr{{Name: "Sub", Type: field.FTStruct, Mapping: inner}},
+		},
+		{
+			desc:   "SelfReferential list of Structs",
+			fields: []*FieldDescr{{Name: "Subs", Type: field.FTListStructs, SelfReferential: true}},
+		},
+		{
+			desc:   "Struct without a Mapping",
+			fields: []*FieldDescr{{Name: "Sub", Type: field.FTStruct}},
+			panics: true,
+		},
+		{
+			desc:   "list of Structs without a Mapping",
+			fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}, {Name: "Subs", Type: field.FTListStructs, FieldNum: 3}},
+			panics: true,
+		},
+		{
+			desc: "nested Struct without a Mapping",
+			fields: []*FieldDescr{
+				{
+					Name: "Sub",
+					Type: field.FTStruct,
+					Mapping: &Map{
+						Name:   "Bad",
+						Fields: []*FieldDescr{{Name: "Sub", Type: field.FTStruct}},
+					},
+				},
+			},
+			panics: true,
+		},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				switch {
+				case r != nil && !test.panics:
+					t.Errorf("TestInitValidates(%s): got panic(%v), want no panic", test.desc, r)
+				case r == nil && test.panics:
+					t.Errorf("TestInitValidates(%s): got no panic, want panic", test.desc)
+				}
+			}()
+			(&Map{Name: "Outer", Fields: test.fields}).Init()
+		}()
+	}
+}
+
+func TestMustValidateFieldNums(t *testing.T) {
+	tests := []struct {
+		desc   string
+		fields []*FieldDescr
+		panics bool
+	}{
+		{
+			desc:   "field numbers in order",
+			fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}, {Name: "Int8", Type: field.FTInt8, FieldNum: 1}},
+		},
+		{
+			desc:   "FieldNum left out after field 0",
+			fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}, {Name: "Int8", Type: field.FTInt8}},
+			panics: true,
+		},
+		{
+			desc:   "fields out of order",
+			fields: []*FieldDescr{{Name: "Int8", Type: field.FTInt8, FieldNum: 1}, {Name: "Bool", Type: field.FTBool}},
+			panics: true,
+		},
+		{
+			desc:   "field numbers with a gap",
+			fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}, {Name: "Int8", Type: field.FTInt8, FieldNum: 1000}},
+		},
+		{
+			desc:   "first field is not field 0",
+			fields: []*FieldDescr{{Name: "Int8", Type: field.FTInt8, FieldNum: 1}},
+			panics: true,
+		},
+		{
+			desc: "nested Struct without field numbers",
+			fields: []*FieldDescr{
+				{
+					Name: "Sub",
+					Type: field.FTStruct,
+					Mapping: &Map{
+						Name:   "Bad",
+						Fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}, {Name: "Int8", Type: field.FTInt8}},
+					},
+				},
+			},
+			panics: true,
+		},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				switch {
+				case r != nil && !test.panics:
+					t.Errorf("TestMustValidateFieldNums(%s): got panic(%v), want no panic", test.desc, r)
+				case r == nil && test.panics:
+					t.Errorf("TestMustValidateFieldNums(%s): got no panic, want panic", test.desc)
+				}
+			}()
+			Map{Name: "Outer", Fields: test.fields}.MustValidate()
+		}()
+	}
+}
+
+func TestNeedNames(t *testing.T) {
+	named := (&Map{
+		Name:   "Named",
+		Fields: []*FieldDescr{{Name: "Bool", Type: field.FTBool}},
+	}).Init()
+	noNames := (&Map{
+		Name:    "NoNames",
+		Fields:  []*FieldDescr{{Type: field.FTBool}},
+		NoNames: true,
+	}).Init()
+
+	tests := []struct {
+		desc    string
+		m       *Map
+		wantErr bool
+	}{
+		{
+			desc: "Map with names",
+			m:    named,
+		},
+		{
+			desc:    "Map without names",
+			m:       noNames,
+			wantErr: true,
+		},
+		{
+			desc: "Map holding a Struct without names",
+			m: (&Map{
+				Name:   "Outer",
+				Fields: []*FieldDescr{{Name: "Sub", Type: field.FTStruct, Mapping: noNames}},
+			}).Init(),
+			wantErr: true,
+		},
+		{
+			desc: "Map holding a Struct with names",
+			m: (&Map{
+				Name:   "Outer",
+				Fields: []*FieldDescr{{Name: "Subs", Type: field.FTListStructs, Mapping: named}},
+			}).Init(),
+		},
+	}
+
+	for _, test := range tests {
+		err := test.m.NeedNames()
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestNeedNames(%s): got err == nil, want err != nil", test.desc)
+		case err != nil && !test.wantErr:
+			t.Errorf("TestNeedNames(%s): got err == %s, want err == nil", test.desc, err)
+		case err != nil && !errors.Is(err, ErrNoNames):
+			t.Errorf("TestNeedNames(%s): got err == %s, want it to wrap ErrNoNames", test.desc, err)
+		}
+	}
+}
+
+func TestFieldByName(t *testing.T) {
+	fields := func() []*FieldDescr {
+		return []*FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 3},
+		}
+	}
+	initialized := (&Map{Name: "Car", Fields: fields()}).Init()
+	notInitialized := &Map{Name: "Car", Fields: fields()}
+	noNames := (&Map{
+		Name:    "Car",
+		Fields:  []*FieldDescr{{Type: field.FTString}, {Type: field.FTUint16, FieldNum: 3}},
+		NoNames: true,
+	}).Init()
+
+	tests := []struct {
+		desc    string
+		m       *Map
+		name    string
+		wantNum uint16
+		wantNil bool
+	}{
+		{desc: "first field", m: initialized, name: "Name", wantNum: 0},
+		{desc: "field after a gap", m: initialized, name: "Year", wantNum: 3},
+		{desc: "Init() not called", m: notInitialized, name: "Year", wantNum: 3},
+		{desc: "no such field", m: initialized, name: "Model", wantNil: true},
+		{desc: "empty name matches no reserved field", m: initialized, name: "", wantNil: true},
+		{desc: "no names", m: noNames, name: "Year", wantNil: true},
+	}
+
+	for _, test := range tests {
+		fd := test.m.FieldByName(test.name)
+		switch {
+		case fd == nil && !test.wantNil:
+			t.Errorf("TestFieldByName(%s): got nil, want field %d", test.desc, test.wantNum)
+		case fd != nil && test.wantNil:
+			t.Errorf("TestFieldByName(%s): got field %d, want nil", test.desc, fd.FieldNum)
+		case fd != nil && fd.FieldNum != test.wantNum:
+			t.Errorf("TestFieldByName(%s): got field %d, want field %d", test.desc, fd.FieldNum, test.wantNum)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	m := (&Map{
+		Name: "Sparse",
+		Fields: []*FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+			{Name: "Model", Type: field.FTString, FieldNum: 1000},
+			{Name: "Trim", Type: field.FTString, FieldNum: 1002},
+		},
+	}).Init()
+
+	// One reserved entry holds 2-999 and another holds 1001.
+	if len(m.Fields) != 6 {
+		t.Fatalf("TestIndex: got %d entries in Fields, want 6", len(m.Fields))
+	}
+
+	tests := []struct {
+		desc      string
+		num       uint16
+		want      int
+		wantField bool
+	}{
+		{desc: "first field", num: 0, want: 0, wantField: true},
+		{desc: "field before the gap", num: 1, want: 1, wantField: true},
+		{desc: "start of a gap", num: 2, want: 2},
+		{desc: "middle of a gap", num: 500, want: 2},
+		{desc: "field after a large gap", num: 1000, want: 3, wantField: true},
+		{desc: "gap of one number", num: 1001, want: 4},
+		{desc: "last field", num: 1002, want: 5, wantField: true},
+		{desc: "beyond the last field", num: 1003, want: -1},
+	}
+
+	for _, test := range tests {
+		got := m.Index(test.num)
+		if got != test.want {
+			t.Errorf("TestIndex(%s): got %d, want %d", test.desc, got, test.want)
+			continue
+		}
+		fd := m.FieldByNumber(test.num)
+		switch {
+		case test.wantField && (fd == nil || fd.FieldNum != test.num):
+			t.Errorf("TestIndex(%s): FieldByNumber(%d): got %v, want field %d", test.desc, test.num, fd, test.num)
+		case !test.wantField && fd != nil:
+			t.Errorf("TestIndex(%s): FieldByNumber(%d): got field %d, want nil", test.desc, test.num, fd.FieldNum)
+		}
+	}
+}
@@ -344,11 +344,14 @@ func (s StructDescrImpl) Fields() []interfaces.FieldDescr {
 }
 
 // FieldDescrByName returns the FieldDescr by the name of the field. If the field
-// is not found, this will be nil.
+// is not found, this will be nil. This panics if the code was generated with "clawc -nonames".
 func (s StructDescrImpl) FieldDescrByName(name string) interfaces.FieldDescr {
 	if name == "" || unicode.IsLower(rune(name[0])) {
 		panic("cannot call FieldDescrByName if name is the empty string or starts with a lower case letter")
 	}
+	if s.Mapping != nil && s.Mapping.NoNames {
+		panic(fmt.Sprintf("cannot call FieldDescrByName on Struct %s: %s", s.Name, mapping.ErrNoNames))
+	}
 	for _, fd := range s.FieldList {
 		log.Printf("%s == %s", name, fd.Name())
 		if fd.Name() == name {
@@ -370,6 +373,9 @@ type FieldDescrImpl struct {
 	FD *mapping.FieldDescr
 	SD interfaces.StructDescr
 	EG interfaces.EnumGroup
+	// Parent is the Mapping of the Struct that holds the field. It is used to find the
+	// type of a SelfReferential field, whose FD.Mapping is nil.
+	Parent *mapping.Map
 }
 
 // Name returns the name of the field.
@@ -413,6 +419,12 @@ func (f FieldDescrImpl) ItemType() string {
 	if f.FD.Type != field.FTListStructs {
 		panic(fmt.Sprintf("cannot call ItemType() on non list of Struct(%s)", f.FD.Type))
 	}
+	if f.FD.SelfReferential {
+		if f.Parent == nil {
+			panic(fmt.Sprintf("bug: field %s is SelfReferential, but its FieldDescrImpl has no Parent", f.FD.Name))
+		}
+		return f.Parent.Name
+	}
 	return f.FD.Mapping.Name
 }
 
@@ -536,7 +548,9 @@ func (l ListBytes) Set(i int, v interfaces.Value) {
 }
 
 func (l ListBytes) Append(v interfaces.Value) {
-	l.b.Append(v.Bytes())
+	if err := l.b.Append(v.Bytes()); err != nil {
+		panic(err)
+	}
 }
 
 func (l ListBytes) New() interfaces.Struct {
@@ -569,7 +583,9 @@ func (l ListStrings) Set(i int, v interfaces.Value) {
 }
 
 func (l ListStrings) Append(v interfaces.Value) {
-	l.b.Append(conversions.UnsafeGetBytes(v.String()))
+	if err := l.b.Append(conversions.UnsafeGetBytes(v.String())); err != nil {
+		panic(err)
+	}
 }
 
 func (l ListStrings) New() interfaces.Struct {
@@ -591,7 +607,10 @@ func NewListStructs(l *structs.Structs) ListStructs {
 		Path: m.Path,
 	}
 	for _, fd := range m.Fields {
-		sd.FieldList = append(sd.FieldList, FieldDescrImpl{FD: fd})
+		if fd.Type == field.FTUnknown { // Reserved field number
+			continue
+		}
+		sd.FieldList = append(sd.FieldList, FieldDescrImpl{FD: fd, Parent: m})
 	}
 	return ListStructs{l: l, sd: sd}
 }
@@ -732,7 +751,7 @@ func (s StructImpl) NewField(descr interfaces.FieldDescr) interfaces.Value {
 		h.SetFieldType(field.FTFloat64)
 		p := []byte{0, 0, 0, 0}
 		return Value{h: h, ptr: unsafe.Pointer(&p)}
-	case field.FTBytes:
+	case field.FTBytes, field.FTFixedBytes:
 		h := header.New()
 		h.SetFieldType(field.FTBytes)
 		return Value{h: h}
@@ -819,7 +838,11 @@ func RealStruct(s interfaces.Struct) *structs.Struct {
 // GetValue allows us to get a Value from the internal Struct representation.
 // If the value of the field is not set, GetValue() returns nil.
 func GetValue(s *structs.Struct, fieldNum uint16) interfaces.Value {
-	f := s.Fields()[fieldNum]
+	idx := s.Map().Index(fieldNum)
+	if idx < 0 {
+		return nil
+	}
+	f := s.Fields()[idx]
 
 	// We pre-allocate for fields that aren't set.  So if the Header is nil, we
 	// have a nil value.
@@ -845,7 +868,7 @@ func GetValue(s *structs.Struct, fieldNum uint16) interfaces.Value {
 		return ValueOfNumber(n)
 	case field.FTUint8:
 		n := structs.MustGetNumber[uint8](s, fieldNum)
-		descr := s.Map().Fields[fieldNum]
+		descr := s.Map().FieldByNumber(fieldNum)
 		if descr.IsEnum {
 			// TODO(jdoak): This split dynamic that I'm having to do is error prone.
 			// This should be simplified. Better yet, we really should have lookup
@@ -865,7 +888,7 @@ func GetValue(s *structs.Struct, fieldNum uint16) interfaces.Value {
 		return ValueOfNumber(n)
 	case field.FTUint16:
 		n := structs.MustGetNumber[uint16](s, fieldNum)
-		descr := s.Map().Fields[fieldNum]
+		descr := s.Map().FieldByNumber(fieldNum)
 		if descr.IsEnum {
 			pkgDescr := runtime.PackageDescr(descr.FullPath)
 			eg := pkgDescr.Enums().ByName(descr.EnumGroup)
@@ -887,6 +910,8 @@ func GetValue(s *structs.Struct, fieldNum uint16) interfaces.Value {
 	case field.FTBytes:
 		b := structs.MustGetBytes(s, fieldNum)
 		return ValueOfBytes(*b)
+	case field.FTFixedBytes:
+		return ValueOfBytes(structs.MustGetFixedBytes(s, fieldNum))
 	case field.FTString:
 		b := structs.MustGetBytes(s, fieldNum)
 		return ValueOfString(conversions.ByteSlice2String(*b))
@@ -899,7 +924,10 @@ func GetValue(s *structs.Struct, fieldNum uint16) interfaces.Value {
 			Path: st.Map().Path,
 		}
 		for _, fd := range st.Map().Fields {
-			sd.FieldList = append(sd.FieldList, FieldDescrImpl{FD: fd})
+			if fd.Type == field.FTUnknown { // Reserved field number
+				continue
+			}
+			sd.FieldList = append(sd.FieldList, FieldDescrImpl{FD: fd, Parent: st.Map()})
 		}
 		return ValueOfStruct(NewStruct(st, sd))
 	case field.FTListBools:
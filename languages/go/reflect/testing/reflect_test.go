@@ -6,14 +6,17 @@ reflect has a circular dependency problem.
 */
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"strings"
 	"testing"
 
 	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
 	"github.com/bearlytools/claw/languages/go/reflect"
 	"github.com/bearlytools/claw/languages/go/reflect/internal/interfaces"
+	"github.com/bearlytools/claw/languages/go/structs"
 	"github.com/bearlytools/claw/languages/go/types/list"
 	vehicles "github.com/bearlytools/claw/testing/imports/vehicles/claw"
 	"github.com/bearlytools/claw/testing/imports/vehicles/claw/manufacturers"
@@ -108,6 +111,22 @@ func TestGetStructDecr(t *testing.T) {
 			FieldNum: 2,
 			ItemType: "Truck",
 		},
+		{
+			Name:     "Types",
+			Type:     field.FTListUint8,
+			FieldNum: 3,
+			IsEnum:   true,
+			EnumGroup: enumGroupWant{
+				Name: "Type",
+				Len:  3,
+				Size: 8,
+			},
+		},
+		{
+			Name:     "Bools",
+			Type:     field.FTListBools,
+			FieldNum: 4,
+		},
 	}
 
 	// Setup a vehicle the normal way.
@@ -182,3 +201,106 @@ func TestGetStructDecr(t *testing.T) {
 		}
 	}
 }
+
+func TestSelfReferentialItemType(t *testing.T) {
+	node := (&mapping.Map{
+		Name: "Node",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString, FieldNum: 0},
+			{Name: "Children", Type: field.FTListStructs, FieldNum: 1, SelfReferential: true},
+		},
+	}).Init()
+	tree := (&mapping.Map{
+		Name: "Tree",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Root", Type: field.FTStruct, FieldNum: 0, Mapping: node},
+		},
+	}).Init()
+
+	// This is how a generated file describes a self referential field.
+	nodeDescr := &reflect.XXXStructDescrImpl{
+		Name:    node.Name,
+		Mapping: node,
+		FieldList: []reflect.FieldDescr{
+			reflect.XXXFieldDescrImpl{FD: node.FieldByNumber(0)},
+			reflect.XXXFieldDescrImpl{FD: node.FieldByNumber(1), Parent: node},
+		},
+	}
+	if got := nodeDescr.FieldDescrByName("Children").ItemType(); got != "Node" {
+		t.Errorf("TestSelfReferentialItemType(generated): got %q, want %q", got, "Node")
+	}
+
+	// This is the descriptor built when reflecting on a Struct held in a field.
+	s := structs.New(0, tree)
+	if err := structs.SetStruct(s, 0, structs.New(0, node)); err != nil {
+		t.Fatalf("TestSelfReferentialItemType: SetStruct(): %s", err)
+	}
+	treeDescr := &reflect.XXXStructDescrImpl{
+		Name:      tree.Name,
+		Mapping:   tree,
+		FieldList: []reflect.FieldDescr{reflect.XXXFieldDescrImpl{FD: tree.FieldByNumber(0)}},
+	}
+	root := reflect.XXXNewStruct(s, treeDescr).Get(treeDescr.FieldDescrByName("Root")).Struct()
+	if got := root.Descriptor().FieldDescrByName("Children").ItemType(); got != "Node" {
+		t.Errorf("TestSelfReferentialItemType(reflected): got %q, want %q", got, "Node")
+	}
+}
+
+func TestSetFromStringEnum(t *testing.T) {
+	tests := []struct {
+		desc string
+		text string
+		want vehicles.Type
+		err  bool
+	}{
+		{desc: "Success: by name", text: "Truck", want: vehicles.Truck},
+		{desc: "Success: by number", text: "1", want: vehicles.Car},
+		{desc: "Error: unknown name", text: "Boat", err: true},
+	}
+
+	for _, test := range tests {
+		v := vehicles.NewVehicle()
+		err := structs.SetFromString(v.XXXGetStruct(), 0, test.text)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestSetFromStringEnum(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestSetFromStringEnum(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+		if v.Type() != test.want {
+			t.Errorf("TestSetFromStringEnum(%s): got %v, want %v", test.desc, v.Type(), test.want)
+		}
+	}
+}
+
+func TestEnumList(t *testing.T) {
+	v := vehicles.NewVehicle().AppendTypes().AppendTypes(vehicles.Car).AppendTypes(vehicles.Truck, vehicles.Car)
+	want := []vehicles.Type{vehicles.Car, vehicles.Truck, vehicles.Car}
+
+	buff := &bytes.Buffer{}
+	if _, err := v.XXXGetStruct().Marshal(buff); err != nil {
+		t.Fatalf("TestEnumList: Marshal(): %s", err)
+	}
+	s, err := structs.NewFromReader(buff, vehicles.XXXMappingVehicle)
+	if err != nil {
+		t.Fatalf("TestEnumList: NewFromReader(): %s", err)
+	}
+	decoded := vehicles.XXXNewFrom(s)
+
+	for _, got := range [][]vehicles.Type{v.Types().Slice(), decoded.Types().Slice()} {
+		if len(got) != len(want) {
+			t.Errorf("TestEnumList: got %v, want %v", got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("TestEnumList: got %v, want %v", got, want)
+				break
+			}
+		}
+	}
+}
@@ -105,11 +105,15 @@ func ListFrom(v any) List {
 		for _, s := range t {
 			l = append(l, conversions.UnsafeGetBytes(s))
 		}
-		b.Append(l...)
+		if err := b.Append(l...); err != nil {
+			panic(err)
+		}
 		return value.NewListStrings(b)
 	case [][]byte:
 		b := structs.NewBytes()
-		b.Append(t...)
+		if err := b.Append(t...); err != nil {
+			panic(err)
+		}
 		return value.NewListBytes(b)
 	}
 	panic(fmt.Sprintf("%T is not supported", v))
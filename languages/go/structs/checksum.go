@@ -0,0 +1,187 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/bearlytools/claw/internal/binary"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// checksumTable is the CRC-32C table used for checksummed records.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Encoder writes Structs one after another to an io.Writer, such as for an append-only log.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder creates a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Write writes s as Marshal() does. Records written this way are only concatenated, read them with
+// UnmarshalFrom() or LogIndex().
+func (e *Encoder) Write(s *Struct) error {
+	_, err := s.Marshal(e.w)
+	return err
+}
+
+// WriteChecksummed writes s in a frame that lets a Decoder detect a corrupt record and Skip() it,
+// which plain concatenation can't do. The frame is:
+//
+//	4 bytes: the length of the record
+//	4 bytes: the CRC-32C of the length
+//	the record, as written by Marshal()
+//	4 bytes: the CRC-32C of the record
+//	4 bytes: zero, which keeps frames 8 byte aligned
+//
+// The frame is written with a single Write() call.
+func (e *Encoder) WriteChecksummed(s *Struct) error {
+	size := s.Size()
+	if size > int(^uint32(0)) {
+		return fmt.Errorf("%w: a checksummed record can be at most %d bytes, the Struct is %d bytes", ErrSizeExceeded, ^uint32(0), size)
+	}
+
+	b := make([]byte, 8+size+8)
+	if _, err := s.MarshalInto(b[8 : 8+size]); err != nil {
+		return err
+	}
+	binary.Put(b[0:4], uint32(size))
+	binary.Put(b[4:8], crc32.Checksum(b[0:4], checksumTable))
+	binary.Put(b[8+size:], crc32.Checksum(b[8:8+size], checksumTable))
+
+	_, err := write(e.w, b)
+	return err
+}
+
+// Decoder reads records written with Encoder.WriteChecksummed() from an io.Reader.
+type Decoder struct {
+	r io.Reader
+	m *mapping.Map
+
+	// buf holds data read from r that has not been consumed. Decoded Structs use this memory,
+	// so it is never written to again once it has been read.
+	buf []byte
+	eof bool
+}
+
+// NewDecoder creates a new Decoder that reads records of the Struct described by m from r.
+func NewDecoder(r io.Reader, m *mapping.Map) *Decoder {
+	return &Decoder{r: r, m: m}
+}
+
+// Next decodes the next record. It returns io.EOF when there are no more records. If the record
+// fails its checksums or can't be decoded, the error wraps ErrCorruptData and the Decoder stays
+// at that record, call Skip() to move past it.
+func (d *Decoder) Next() (*Struct, error) {
+	size, err := d.check(0)
+	if err != nil {
+		return nil, err
+	}
+
+	record := d.buf[8 : size-8]
+	s := New(0, d.m)
+	rest, err := s.UnmarshalFrom(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: record is %d bytes, but the Struct in it is %d bytes", ErrCorruptData, len(record), len(record)-len(rest))
+	}
+	d.buf = d.buf[size:]
+	return s, nil
+}
+
+// Skip moves past the record that Next() is at, to the next record that passes its checksums.
+// If the record's length is intact, that is the record after it. Otherwise the data is searched,
+// 8 bytes at a time, for a frame whose length and record checksums are right, which reads until
+// one is found. It returns io.EOF if no record is left.
+func (d *Decoder) Skip() error {
+	size, err := d.check(0)
+	switch {
+	case size > 0:
+		d.buf = d.buf[size:]
+		return nil
+	case !errors.Is(err, ErrCorruptData):
+		return err
+	}
+
+	for off := 8; ; off += 8 {
+		_, err := d.check(off)
+		switch {
+		case err == nil:
+			d.buf = d.buf[off:]
+			return nil
+		case err == io.EOF || (d.eof && len(d.buf) < off+8):
+			d.buf = nil
+			return io.EOF
+		case !errors.Is(err, ErrCorruptData):
+			return err
+		}
+	}
+}
+
+// check checks the frame that starts at off in the unconsumed data. It returns the size of the frame
+// if the frame is all there and its length passed its checksum, even when the record did not. The
+// error is nil if the frame passed both checksums and io.EOF if the data ends at off.
+func (d *Decoder) check(off int) (int, error) {
+	if err := d.fill(off + 8); err != nil {
+		switch {
+		case err != io.EOF:
+			return 0, err
+		case len(d.buf) <= off:
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("%w: data ends %d bytes into a record's length", ErrCorruptData, len(d.buf)-off)
+	}
+
+	prefix := d.buf[off : off+8]
+	if crc32.Checksum(prefix[0:4], checksumTable) != binary.Get[uint32](prefix[4:8]) {
+		return 0, fmt.Errorf("%w: record length failed its checksum", ErrCorruptData)
+	}
+	length := int(binary.Get[uint32](prefix[0:4]))
+	if length%8 != 0 {
+		return 0, fmt.Errorf("%w: record length %d is not divisible by 8", ErrCorruptData, length)
+	}
+	size := 8 + length + 8
+	if err := d.fill(off + size); err != nil {
+		if err != io.EOF {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: record has length %d, but only %d bytes remain", ErrCorruptData, length, len(d.buf)-off-8)
+	}
+
+	record := d.buf[off+8 : off+8+length]
+	if crc32.Checksum(record, checksumTable) != binary.Get[uint32](d.buf[off+8+length:]) {
+		return size, fmt.Errorf("%w: record failed its checksum", ErrCorruptData)
+	}
+	return size, nil
+}
+
+// fill reads from r until at least n bytes are unconsumed. If r ends first, it returns io.EOF.
+func (d *Decoder) fill(n int) error {
+	for len(d.buf) < n {
+		if d.eof {
+			return io.EOF
+		}
+		if cap(d.buf)-len(d.buf) < n-len(d.buf) {
+			// Copy to a new array instead of growing in place, as decoded Structs use the old one.
+			c := make([]byte, len(d.buf), 2*n+4096)
+			copy(c, d.buf)
+			d.buf = c
+		}
+		read, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+read]
+		switch {
+		case err == io.EOF:
+			d.eof = true
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestChecksummedRecords(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Record",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+			{Name: "Data", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+	m.MustValidate()
+
+	buff := &bytes.Buffer{}
+	enc := NewEncoder(buff)
+	var frames []int // The offset of each frame.
+	for id := uint32(1); id <= 4; id++ {
+		frames = append(frames, buff.Len())
+		s := New(0, m)
+		MustSetNumber(s, 0, id)
+		MustSetBytes(s, 1, bytes.Repeat([]byte{byte(id)}, int(id)*5), false)
+		if err := enc.WriteChecksummed(s); err != nil {
+			t.Fatalf("TestChecksummedRecords: WriteChecksummed(): %s", err)
+		}
+	}
+	data := buff.Bytes()
+	frames = append(frames, len(data))
+
+	tests := []struct {
+		desc    string
+		corrupt func(b []byte)
+		want    []uint32 // 0 is a record that returns an error and is skipped.
+	}{
+		{
+			desc:    "no corruption",
+			corrupt: func(b []byte) {},
+			want:    []uint32{1, 2, 3, 4},
+		},
+		{
+			desc:    "corrupt record",
+			corrupt: func(b []byte) { b[frames[1]+20] ^= 0xff },
+			want:    []uint32{1, 0, 3, 4},
+		},
+		{
+			desc:    "corrupt length",
+			corrupt: func(b []byte) { b[frames[1]] ^= 0xff },
+			want:    []uint32{1, 0, 3, 4},
+		},
+		{
+			desc:    "corrupt checksum",
+			corrupt: func(b []byte) { b[frames[3]-8] ^= 0xff },
+			want:    []uint32{1, 2, 0, 4},
+		},
+		{
+			desc:    "garbage between records",
+			corrupt: func(b []byte) { copy(b[frames[2]:frames[3]], bytes.Repeat([]byte{0xaa}, frames[3]-frames[2])) },
+			want:    []uint32{1, 2, 0, 4},
+		},
+		{
+			desc:    "truncated",
+			corrupt: func(b []byte) {},
+			want:    []uint32{1, 2, 3, 0},
+		},
+	}
+
+	for _, test := range tests {
+		b := append([]byte{}, data...)
+		test.corrupt(b)
+		if test.desc == "truncated" {
+			b = b[:len(b)-12]
+		}
+
+		// Reading a byte at a time makes the Decoder refill in every possible place.
+		dec := NewDecoder(iotest.OneByteReader(bytes.NewReader(b)), m)
+		var got []uint32
+		for {
+			s, err := dec.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if !errors.Is(err, ErrCorruptData) {
+					t.Errorf("TestChecksummedRecords(%s): got err == %s, want ErrCorruptData", test.desc, err)
+				}
+				got = append(got, 0)
+				if err := dec.Skip(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("TestChecksummedRecords(%s): Skip(): %s", test.desc, err)
+				}
+				continue
+			}
+			got = append(got, MustGetNumber[uint32](s, 0))
+		}
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestChecksummedRecords(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
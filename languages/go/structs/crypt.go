@@ -0,0 +1,73 @@
+package structs
+
+import (
+	"fmt"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// Crypter encrypts and decrypts the values of String and Bytes fields that are marked with
+// [encrypted] in the .claw file (mapping.FieldDescr.Encrypted). fieldName is the Struct and field
+// name, such as "Car.Owner", which can be used to choose a key.
+type Crypter interface {
+	Encrypt(fieldName string, plaintext []byte) ([]byte, error)
+	Decrypt(fieldName string, ciphertext []byte) ([]byte, error)
+}
+
+// hasEncrypted returns true if m or any Struct it holds has an Encrypted field.
+func hasEncrypted(m *mapping.Map, seen map[*mapping.Map]bool) bool {
+	if m == nil || seen[m] {
+		return false
+	}
+	seen[m] = true
+
+	for _, fd := range m.Fields {
+		if fd.Encrypted || hasEncrypted(fd.Mapping, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// cryptFields replaces the value of every set Encrypted field in s and the Structs it holds
+// with what crypt returns. The new values are stored as bytes on the wire, unless decrypted is
+// set, in which case they get the type in the mapping.
+func cryptFields(s *Struct, crypt func(fieldName string, b []byte) ([]byte, error), decrypted bool) error {
+	for i, fd := range s.mapping.Fields {
+		f := s.fields[i]
+		if f.Header == nil {
+			continue
+		}
+
+		switch fd.Type {
+		case field.FTString, field.FTBytes:
+			if !fd.Encrypted {
+				continue
+			}
+			b := MustGetBytes(s, fd.FieldNum)
+			if len(*b) == 0 {
+				continue
+			}
+			name := s.mapping.Name + "." + fd.Name
+			v, err := crypt(name, *b)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+			if err := SetBytes(s, fd.FieldNum, v, decrypted && fd.Type == field.FTString); err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+		case field.FTStruct:
+			if err := cryptFields((*Struct)(f.Ptr), crypt, decrypted); err != nil {
+				return err
+			}
+		case field.FTListStructs:
+			for _, item := range (*Structs)(f.Ptr).Slice() {
+				if err := cryptFields(item, crypt, decrypted); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
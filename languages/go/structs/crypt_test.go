@@ -0,0 +1,94 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// prefixCrypter "encrypts" by reversing the value and adding the field name as a prefix.
+type prefixCrypter struct{}
+
+func (prefixCrypter) Encrypt(fieldName string, plaintext []byte) ([]byte, error) {
+	out := []byte(fieldName + ":")
+	for i := len(plaintext) - 1; i >= 0; i-- {
+		out = append(out, plaintext[i])
+	}
+	return out, nil
+}
+
+func (prefixCrypter) Decrypt(fieldName string, ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(fieldName+":")) {
+		return nil, fmt.Errorf("ciphertext was not for field %s", fieldName)
+	}
+	ciphertext = ciphertext[len(fieldName)+1:]
+	out := make([]byte, 0, len(ciphertext))
+	for i := len(ciphertext) - 1; i >= 0; i-- {
+		out = append(out, ciphertext[i])
+	}
+	return out, nil
+}
+
+func TestCrypter(t *testing.T) {
+	subMapping := &mapping.Map{
+		Name:   "Sub",
+		Fields: []*mapping.FieldDescr{{Name: "Secret", Type: field.FTBytes, Encrypted: true}},
+	}
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Owner", Type: field.FTString, FieldNum: 0, Encrypted: true},
+			{Name: "Model", Type: field.FTString, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: subMapping},
+		},
+	}
+	m.MustValidate()
+
+	s := New(0, m)
+	MustSetBytes(s, 0, []byte("john"), true)
+	MustSetBytes(s, 1, []byte("beetle"), true)
+	sub := New(0, subMapping)
+	MustSetBytes(sub, 0, []byte("password"), false)
+	MustSetStruct(s, 2, sub)
+
+	buff := &bytes.Buffer{}
+	if _, err := s.MarshalWithOptions(buff, MarshalOptions{Crypter: prefixCrypter{}}); err != nil {
+		t.Fatalf("TestCrypter: Marshal error: %s", err)
+	}
+	data := buff.Bytes()
+	if bytes.Contains(data, []byte("john")) || bytes.Contains(data, []byte("password")) {
+		t.Errorf("TestCrypter: encoded data had a plaintext value")
+	}
+	if !bytes.Contains(data, []byte("Car.Owner:nhoj")) || !bytes.Contains(data, []byte("beetle")) {
+		t.Errorf("TestCrypter: encoded data did not have the expected values")
+	}
+	if got := string(*MustGetBytes(s, 0)); got != "john" {
+		t.Errorf("TestCrypter: Marshal changed the Struct, Owner was %q, want %q", got, "john")
+	}
+
+	decoded, err := NewFromReaderWithOptions(bytes.NewReader(data), m, UnmarshalOptions{Crypter: prefixCrypter{}})
+	if err != nil {
+		t.Fatalf("TestCrypter: NewFromReaderWithOptions error: %s", err)
+	}
+	if got := string(*MustGetBytes(decoded, 0)); got != "john" {
+		t.Errorf("TestCrypter(Owner): got %q, want %q", got, "john")
+	}
+	if got := string(*MustGetBytes(MustGetStruct(decoded, 2), 0)); got != "password" {
+		t.Errorf("TestCrypter(Sub.Secret): got %q, want %q", got, "password")
+	}
+	if !Equal(decoded, s) {
+		t.Errorf("TestCrypter: decoded Struct was not equal to the original")
+	}
+
+	// Without a Crypter, we get the ciphertext.
+	decoded, err = NewFromReaderWithOptions(bytes.NewReader(data), m, UnmarshalOptions{})
+	if err != nil {
+		t.Fatalf("TestCrypter: NewFromReaderWithOptions error: %s", err)
+	}
+	if got := string(*MustGetBytes(decoded, 0)); got != "Car.Owner:nhoj" {
+		t.Errorf("TestCrypter(no Crypter): got %q, want %q", got, "Car.Owner:nhoj")
+	}
+}
@@ -2,6 +2,7 @@ package structs
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,30 +12,324 @@ import (
 	"github.com/bearlytools/claw/internal/binary"
 	"github.com/bearlytools/claw/internal/bits"
 	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
 	"github.com/bearlytools/claw/languages/go/structs/header"
 )
 
 var dataSizeMask = bits.Mask[uint64](24, 64)
 
-func (s *Struct) unmarshal(r io.Reader) (int, error) {
+// UnmarshalOptions are options for NewFromReaderWithOptions().
+type UnmarshalOptions struct {
+	// DisallowUnknownFields causes decoding to fail if the data has a field that is not in the
+	// mapping, including fields the mapping has reserved. The error wraps ErrFieldNotFound and
+	// names the field number. This is useful for catching typos or version skew in things like
+	// config files. By default those fields are kept, so that they are not lost if the Struct
+	// is encoded again.
+	DisallowUnknownFields bool
+	// OmitTopHeader decodes data written with MarshalOptions.OmitTopHeader. As that data has no
+	// header to say how long the Struct is, everything in the reader is decoded as the Struct. The
+	// caller must use its own framing to give a reader that ends where the Struct ends, such as a
+	// bytes.Reader over just the Struct or an io.LimitReader().
+	OmitTopHeader bool
+	// Crypter decrypts the values of String and Bytes fields marked with [encrypted] in the .claw
+	// file that were encrypted with MarshalOptions.Crypter.
+	Crypter Crypter
+	// MaxListElements is the most items a list field, at any depth, may have. A list with more
+	// causes an error wrapping ErrSizeExceeded, which is checked from the list's header before
+	// anything is allocated for it. This protects against data that claims a huge number of items.
+	// If <= 0, there is no limit. This limits items, not bytes, so it does not limit the size of
+	// the items in a list of Bytes or Structs.
+	MaxListElements int
+	// TruncateLists causes lists that have more than MaxListElements items to be cut to their
+	// first MaxListElements items instead of returning an error. The items cut are checked to be
+	// well formed, but are not decoded.
+	TruncateLists bool
+	// LazyListStructs leaves the items of lists of Structs, at any depth, undecoded until they are
+	// read from the list. The items are still checked, as Verify() does. This saves the time and
+	// memory to decode items that are never read, such as when reading a few fields from some items
+	// of a large list. Items that are never read are written back out as they were. It has no
+	// effect with DisallowUnknownFields or MaxListElements, which need every item decoded.
+	LazyListStructs bool
+	// SparseFields, if not nil, are the field numbers of the top level Struct to decode. The other
+	// fields are checked to be well formed and skipped, so the Struct only holds the fields listed,
+	// as if the rest were not set, and encoding it only writes those. This saves decoding fields,
+	// such as large lists, that won't be read. Structs held in the fields listed are decoded in full.
+	SparseFields []uint16
+	// Observer, if set, is called with measurements of the decode once it is done, even if it
+	// failed. This is for finding which messages cost the most to decode in production. It is
+	// called on the goroutine doing the decode, so it should return quickly.
+	Observer func(DecodeStats)
+}
+
+// DecodeStats are measurements of one decode. See UnmarshalOptions.Observer.
+type DecodeStats struct {
+	// Name is the name of the Struct that was decoded. It is empty if its mapping has NoNames.
+	Name string
+	// Bytes is the number of bytes read.
+	Bytes int
+	// Structs is the number of Structs decoded, including the top level Struct and the items of
+	// lists of Structs.
+	Structs int
+	// Fields is the number of fields decoded in all of the Structs. Fields not in the mapping
+	// are not counted.
+	Fields int
+	// LazyItems is the number of items of lists of Structs that were not decoded because of
+	// UnmarshalOptions.LazyListStructs.
+	LazyItems int
+	// MaxDepth is the deepest nesting of Structs. The top level Struct is at depth 1 and a Struct
+	// held in it, including an item of a list, is at depth 2.
+	MaxDepth int
+	// PoolGets is the number of values the decode took from this package's pools.
+	PoolGets int
+
+	// depth is the depth of the Struct being decoded.
+	depth int
+}
+
+// NewFromReaderWithOptions is like NewFromReader(), but decodes using opts.
+func NewFromReaderWithOptions(r io.Reader, maps *mapping.Map, opts UnmarshalOptions) (*Struct, error) {
+	s := New(0, maps)
+	s.disallowUnknown = opts.DisallowUnknownFields
+	s.maxListElements = opts.MaxListElements
+	s.truncateLists = opts.TruncateLists
+	s.lazyLists = opts.LazyListStructs
+	if opts.SparseFields != nil {
+		s.sparse = &fieldSet{}
+		for _, n := range opts.SparseFields {
+			s.sparse.add(n)
+		}
+		defer func() { s.sparse = nil }()
+	}
+	if opts.Observer != nil {
+		s.stats = &DecodeStats{Name: maps.Name}
+	}
+
+	var err error
+	if opts.OmitTopHeader {
+		err = s.unmarshalNoHeader(r)
+	} else {
+		_, err = s.unmarshalTop(r)
+	}
+	if opts.Observer != nil {
+		stats := *s.stats
+		stats.depth = 0
+		s.stats = nil
+		opts.Observer(stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Crypter != nil && hasEncrypted(s.mapping, map[*mapping.Map]bool{}) {
+		if err := cryptFields(s, opts.Crypter.Decrypt, true); err != nil {
+			return nil, fmt.Errorf("could not decrypt: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// UnmarshalFrom decodes one Struct from the front of data into s and returns the bytes after it.
+// This allows decoding a buffer of messages written one after another without an io.Reader:
+//
+//	for len(data) > 0 {
+//		s := structs.New(0, m)
+//		if data, err = s.UnmarshalFrom(data); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// s must be empty, such as one just returned by New(). On error, data is returned as rest and s
+// must not be used.
+func (s *Struct) UnmarshalFrom(data []byte) (rest []byte, err error) {
+	if s.Size() != 8 {
+		return data, fmt.Errorf("UnmarshalFrom() must be called on an empty Struct")
+	}
+	if err := s.checkFrozen(); err != nil {
+		return data, err
+	}
+
+	r := readers.Get().(*bytes.Reader)
+	defer putReader(r)
+	r.Reset(data)
+
+	n, err := s.unmarshalTop(r)
+	if err != nil {
+		return data, err
+	}
+	return data[n:], nil
+}
+
+// PeekSize returns the size of the encoded Struct that starts with header, from the Struct header in
+// its first 8 bytes. This is the number of bytes Marshal() writes for it, so a caller doing its own
+// framing can read exactly the Struct. If the data starts with a schema hash preamble (see
+// MarshalOptions.EmbedSchemaHash), pass the 8 bytes after the preamble and add 16 to the size.
+func PeekSize(header []byte) (int, error) {
+	if len(header) < 8 {
+		return 0, fmt.Errorf("%w: could only read %d bytes, a Struct header is always 8 bytes", ErrCorruptData, len(header))
+	}
+
+	h := GenericHeader(header[:8])
+	if ft := field.Type(h.FieldType()); ft != field.FTStruct {
+		return 0, fmt.Errorf("%w: expecting Struct, got %v", ErrCorruptData, ft)
+	}
+	size := h.Final40()
+	if size < 8 || size%8 != 0 {
+		return 0, fmt.Errorf("%w: Struct malformed: must have a size divisible by 8, was %d", ErrCorruptData, size)
+	}
+	return int(size), nil
+}
+
+// unmarshalTop is used instead of unmarshal() when decoding a top level Struct, which may
+// start with a schema hash preamble (see MarshalOptions.EmbedSchemaHash).
+func (s *Struct) unmarshalTop(r io.Reader) (int, error) {
+	h := header.New()
+	read, err := r.Read(h)
+	if read != 8 {
+		return read, fmt.Errorf("%w: could only read %d bytes, a Struct header is always 8 bytes", ErrCorruptData, read)
+	}
+	if err != nil {
+		return read, err
+	}
+	if s.stats != nil {
+		s.stats.Bytes += read
+	}
+	if field.Type(h.FieldType()) != field.FTSchemaHash {
+		return s.unmarshalWithHeader(h, r)
+	}
+
+	if h.Final40() != 16 {
+		return read, fmt.Errorf("%w: schema hash preamble must have size 16, had %d", ErrCorruptData, h.Final40())
+	}
+	b := make([]byte, 8)
+	n, _ := io.ReadFull(r, b)
+	read += n
+	if s.stats != nil {
+		s.stats.Bytes += n
+	}
+	if n != 8 {
+		return read, fmt.Errorf("%w: schema hash preamble was truncated", ErrCorruptData)
+	}
+	if err := s.checkSchemaHash(b); err != nil {
+		return read, err
+	}
+
+	n, err = s.unmarshal(r)
+	return read + n, err
+}
+
+// checkSchemaHash checks that the hash in b, the data of a schema hash preamble, is our mapping's.
+func (s *Struct) checkSchemaHash(b []byte) error {
+	if got, want := binary.Get[uint64](b), s.mapping.SchemaHash(); got != want {
+		return fmt.Errorf("%w: data has schema hash %x, but %s has schema hash %x", ErrSchemaMismatch, got, s.mapping.Name, want)
+	}
+	return nil
+}
+
+// UnmarshalShared is UnmarshalFrom(), except that s reads its values from data instead of from a
+// copy of it. This makes decoding cheaper for data that is mostly read, such as a memory mapped file.
+//
+// data must not be changed while s or any value gotten from it is in use. As changing s, or a list or
+// Struct in it, would write to data, s is frozen (see Freeze()) once it is decoded. Use Clone() for a
+// copy that can be changed.
+func (s *Struct) UnmarshalShared(data []byte) (rest []byte, err error) {
+	if s.Size() != 8 {
+		return data, fmt.Errorf("UnmarshalShared() must be called on an empty Struct")
+	}
+	if err := s.checkFrozen(); err != nil {
+		return data, err
+	}
+
+	s.shared = true
+	n, err := s.unmarshalShared(data)
+	if err != nil {
+		return data, err
+	}
+	s.Freeze()
+	return data[n:], nil
+}
+
+// unmarshalShared does the decoding for UnmarshalShared() and returns the number of bytes of data used.
+func (s *Struct) unmarshalShared(data []byte) (int, error) {
 	read := 0
+	if len(data) >= 16 && field.Type(GenericHeader(data[:8]).FieldType()) == field.FTSchemaHash {
+		if GenericHeader(data[:8]).Final40() != 16 {
+			return 0, fmt.Errorf("%w: schema hash preamble must have size 16, had %d", ErrCorruptData, GenericHeader(data[:8]).Final40())
+		}
+		if err := s.checkSchemaHash(data[8:16]); err != nil {
+			return 0, err
+		}
+		read = 16
+		data = data[16:]
+	}
+	n, err := s.unmarshalBytes(data)
+	return read + n, err
+}
+
+// unmarshalBytes is unmarshal(), but decodes the Struct at the front of data in place instead of
+// reading a copy of it. It returns the size of the Struct.
+func (s *Struct) unmarshalBytes(data []byte) (int, error) {
+	if len(data) < 8 {
+		return 0, fmt.Errorf("%w: could only read %d bytes, a Struct header is always 8 bytes", ErrCorruptData, len(data))
+	}
+
+	n, err := PeekSize(data)
+	if err != nil {
+		return 8, err
+	}
+	size := uint64(n)
+	if size > uint64(len(data)) {
+		return len(data), fmt.Errorf("%w: Struct has size %d, but only %d bytes of it were found", ErrCorruptData, size, len(data))
+	}
+
+	// The capacity is limited so that appending to a list can't write over the data that follows.
+	if err := s.decodeBody(data[8:size:size], int(size)); err != nil {
+		return int(size), err
+	}
+	return int(size), nil
+}
+
+// unmarshalNoHeader decodes a top level Struct that was written without its header. All of r is the Struct.
+func (s *Struct) unmarshalNoHeader(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data)+8 > maxDataSize {
+		return fmt.Errorf("%w: a Struct cannot be larger than %d bytes", ErrSizeExceeded, maxDataSize)
+	}
+
+	h := header.New()
+	h.SetFieldType(field.FTStruct)
+	h.SetFinal40(uint64(len(data) + 8))
+	_, err = s.unmarshalWithHeader(h, bytes.NewReader(data))
+	return err
+}
+
+func (s *Struct) unmarshal(r io.Reader) (int, error) {
 	h := header.New()
 	read, err := r.Read(h)
 	if read != 8 {
-		return read, fmt.Errorf("could only read %d bytes, a Struct header is always 8 bytes", read)
+		return read, fmt.Errorf("%w: could only read %d bytes, a Struct header is always 8 bytes", ErrCorruptData, read)
 	}
 	if err != nil {
 		return read, err
 	}
+	return s.unmarshalWithHeader(h, r)
+}
+
+// unmarshalWithHeader decodes a Struct whose header h has already been read from r.
+func (s *Struct) unmarshalWithHeader(h header.Generic, r io.Reader) (int, error) {
+	read := 8
 
 	ft := field.Type(h.FieldType())
 	if ft != field.FTStruct {
-		return read, fmt.Errorf("expecting Struct, got %v", ft)
+		return read, fmt.Errorf("%w: expecting Struct, got %v", ErrCorruptData, ft)
 	}
 
 	size := h.Final40()
 	if size%8 != 0 {
-		return read, fmt.Errorf("Struct malformed: must have a size divisible by 8, was %d", h.Final40())
+		return read, fmt.Errorf("%w: Struct malformed: must have a size divisible by 8, was %d", ErrCorruptData, h.Final40())
 	}
 
 	log.Println("Struct says it is: ", size)
@@ -44,26 +339,50 @@ func (s *Struct) unmarshal(r io.Reader) (int, error) {
 		return read, nil
 	}
 
-	n, err := r.Read(buffer)
+	n, err := io.ReadFull(r, buffer)
 	read += n
+	if s.stats != nil && s.stats.depth == 0 {
+		s.stats.Bytes += n // Nested Structs are read from this buffer, so only the top level counts.
+	}
 	if err != nil {
 		log.Println("this is the buffer size: ", len(buffer))
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return read, fmt.Errorf("%w: Struct has size %d, but only %d bytes of it were found", ErrCorruptData, size, n+8)
+		}
 		return read, fmt.Errorf("problem reading Struct data: %w", err)
 	}
-	if int(size-8) != n {
-		panic(fmt.Sprintf("read %d bytes, expected %d", n, size-8))
-	}
 	log.Println("struct read ", read)
-	err = s.unmarshalFields(&buffer)
-	if err != nil {
-		return read, err
+	return read, s.decodeBody(buffer, read)
+}
+
+// decodeBody decodes the fields in buffer, which is the data of a Struct of size bytes after its header.
+func (s *Struct) decodeBody(buffer []byte, size int) error {
+	if s.stats != nil {
+		s.stats.Structs++
+		s.stats.depth++
+		if s.stats.depth > s.stats.MaxDepth {
+			s.stats.MaxDepth = s.stats.depth
+		}
+		defer func() { s.stats.depth-- }()
 	}
-	st := atomic.LoadInt64(s.structTotal)
-	if read != int(st) {
-		return read, fmt.Errorf("Struct was %d in length, but only found %d worth of fields", read, st)
+	s.grown = 0
+	// Lists are cut before decoding, so that the sizes of the Structs holding them can be updated.
+	want := size
+	if s.truncateLists && s.maxListElements > 0 {
+		buffer = truncateFields(buffer, s.maxListElements)
+		want = 8 + len(buffer)
 	}
-
-	return read, nil
+	if err := s.unmarshalFields(&buffer); err != nil {
+		return err
+	}
+	// What was decoded is what a peer has, so none of it is a change for MarshalDelta().
+	s.dirty = fieldSet{}
+	// Dense fields that were not in the data are counted in our size, as we write them.
+	st := atomic.LoadInt64(s.structTotal) - int64(s.unsetDenseSize()) - s.grown
+	if want != int(st) {
+		return fmt.Errorf("%w: Struct was %d in length, but only found %d worth of fields", ErrCorruptData, want, st)
+	}
+	return nil
 }
 
 func (s *Struct) unmarshalFields(buffer *[]byte) error {
@@ -76,10 +395,15 @@ func (s *Struct) unmarshalFields(buffer *[]byte) error {
 		lastNum   int32 = -1
 	)
 
+	// start lets us calculate the offset of each field, which is from the start of the Struct header
+	// that isn't in the buffer.
+	start := len(*buffer)
+
 	entry := 1
 	for len(*buffer) > 0 {
+		offset := 8 + start - len(*buffer)
 		if len(*buffer) < 8 {
-			return fmt.Errorf("field inside Struct was malformed: not enough room for field number and field type")
+			return &DecodeError{FieldNum: uint16(lastNum + 1), Offset: offset, Err: fmt.Errorf("%w: field inside Struct was malformed: not enough room for field number and field type", ErrCorruptData)}
 		}
 		log.Println("buffer size: ", len(*buffer))
 
@@ -87,9 +411,13 @@ func (s *Struct) unmarshalFields(buffer *[]byte) error {
 		fieldNum = h.FieldNum()
 		fieldType = field.Type(h.FieldType())
 
+		// No field is encoded with FTUnknown, so this is most likely zeroed memory.
+		if fieldType == field.FTUnknown {
+			return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: fmt.Errorf("%w: the last %d bytes of the Struct are not a field", ErrTrailingData, len(*buffer))}
+		}
 		if int32(fieldNum) <= lastNum {
 			log.Println(*buffer)
-			return fmt.Errorf("Struct was malformed: field %d came after field %d", fieldNum, lastNum)
+			return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: fmt.Errorf("%w: Struct was malformed: field %d came after field %d", ErrCorruptData, fieldNum, lastNum)}
 		}
 		lastNum = int32(fieldNum)
 
@@ -97,59 +425,59 @@ func (s *Struct) unmarshalFields(buffer *[]byte) error {
 		// program writing an updated version of our Struct that has more fields. So we
 		// need to retain our data so that even though the user can't see it, we don't
 		// drop it.
-		if fieldNum >= maxFields {
+		idx := s.mapping.Index(fieldNum)
+		if s.disallowUnknown && (idx < 0 || s.mapping.Fields[idx].Type == field.FTUnknown) {
+			return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: fmt.Errorf("%w: field %d is not in the mapping", ErrFieldNotFound, fieldNum)}
+		}
+		if s.sparse != nil && !s.sparse.has(fieldNum) {
+			size, err := verifyField(*buffer, nil)
+			if err != nil {
+				return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: fmt.Errorf("field %d is not in UnmarshalOptions.SparseFields and could not be skipped: %w", fieldNum, err)}
+			}
+			// The field is not in our size, but was in the data.
+			s.grown -= int64(size)
+			*buffer = (*buffer)[size:]
+			entry++
+			continue
+		}
+		if idx < 0 {
 			log.Printf("wtf: fieldNum %d maxFields %d", fieldNum, maxFields)
 			s.excess = *buffer
 			XXXAddToTotal(s, len(s.excess))
 			return nil
 		}
+		// This is a field number that our version of the Struct has reserved, but a newer
+		// version is using. Like the excess above, we keep it so that it isn't dropped.
+		if s.mapping.Fields[idx].Type == field.FTUnknown {
+			if err := s.decodeUnknown(buffer, fieldNum); err != nil {
+				return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: err}
+			}
+			entry++
+			continue
+		}
+		if !wireTypeMatches(fieldType, s.mapping.Fields[idx].Type) {
+			return &DecodeError{
+				FieldNum: fieldNum,
+				Offset:   offset,
+				Err:      fmt.Errorf("%w: has wire type %v, but mapping says %v", ErrTypeMismatch, fieldType, s.mapping.Fields[idx].Type),
+			}
+		}
 		log.Printf("decode field %d/%d", entry, maxFields)
 		log.Println("decode fieldNum: ", fieldNum)
 		log.Printf("decode fieldType: %v", fieldType)
 
-		var err error
-		switch fieldType {
-		case field.FTBool:
-			err = s.decodeBool(buffer, fieldNum)
-		case field.FTInt8:
-			err = s.decodeNum(buffer, fieldNum, 8)
-		case field.FTInt16:
-			err = s.decodeNum(buffer, fieldNum, 16)
-		case field.FTInt32:
-			err = s.decodeNum(buffer, fieldNum, 32)
-		case field.FTInt64:
-			err = s.decodeNum(buffer, fieldNum, 64)
-		case field.FTUint8:
-			err = s.decodeNum(buffer, fieldNum, 8)
-		case field.FTUint16:
-			err = s.decodeNum(buffer, fieldNum, 16)
-		case field.FTUint32:
-			err = s.decodeNum(buffer, fieldNum, 32)
-		case field.FTUint64:
-			err = s.decodeNum(buffer, fieldNum, 64)
-		case field.FTFloat32:
-			err = s.decodeNum(buffer, fieldNum, 32)
-		case field.FTFloat64:
-			err = s.decodeNum(buffer, fieldNum, 64)
-		case field.FTString, field.FTBytes:
-			err = s.decodeBytes(buffer, fieldNum)
-		case field.FTStruct:
-			err = s.decodeStruct(buffer, fieldNum)
-		case field.FTListBools:
-			err = s.decodeListBool(buffer, fieldNum)
-		case field.FTListInt8, field.FTListInt16, field.FTListInt32, field.FTListInt64,
-			field.FTListUint8, field.FTListUint16, field.FTListUint32, field.FTListUint64,
-			field.FTListFloat32, field.FTListFloat64:
-			err = s.decodeListNumber(buffer, fieldNum)
-		case field.FTListBytes:
-			err = s.decodeListBytes(buffer, fieldNum)
-		case field.FTListStructs:
-			err = s.decodeListStruct(buffer, fieldNum)
-		default:
-			err = fmt.Errorf("got field type %v that we don't support", fieldType)
+		if s.maxListElements > 0 && field.IsList(fieldType) && h.Final40() > uint64(s.maxListElements) {
+			return &DecodeError{
+				FieldNum: fieldNum,
+				Offset:   offset,
+				Err:      fmt.Errorf("%w: list has %d items, which is more than UnmarshalOptions.MaxListElements(%d)", ErrSizeExceeded, h.Final40(), s.maxListElements),
+			}
 		}
-		if err != nil {
-			return err
+		if s.stats != nil {
+			s.stats.Fields++
+		}
+		if err := s.decodeField(buffer, fieldNum, fieldType); err != nil {
+			return &DecodeError{FieldNum: fieldNum, Offset: offset, Err: err}
 		}
 		log.Printf("finished decoding %d/%d", entry, maxFields)
 		entry++
@@ -157,17 +485,158 @@ func (s *Struct) unmarshalFields(buffer *[]byte) error {
 	return nil
 }
 
+// decodeField decodes the field at the start of the buffer, which has wire type fieldType, into
+// .fields[fieldNum] and advances the buffer for the next value.
+func (s *Struct) decodeField(buffer *[]byte, fieldNum uint16, fieldType field.Type) error {
+	switch fieldType {
+	case field.FTBool:
+		return s.decodeBool(buffer, fieldNum)
+	case field.FTInt8:
+		return s.decodeNum(buffer, fieldNum, 8)
+	case field.FTInt16:
+		return s.decodeNum(buffer, fieldNum, 16)
+	case field.FTInt32:
+		return s.decodeNum(buffer, fieldNum, 32)
+	case field.FTInt64:
+		return s.decodeNum(buffer, fieldNum, 64)
+	case field.FTUint8:
+		return s.decodeNum(buffer, fieldNum, 8)
+	case field.FTUint16:
+		return s.decodeNum(buffer, fieldNum, 16)
+	case field.FTUint32:
+		return s.decodeNum(buffer, fieldNum, 32)
+	case field.FTUint64:
+		return s.decodeNum(buffer, fieldNum, 64)
+	case field.FTFloat32:
+		return s.decodeNum(buffer, fieldNum, 32)
+	case field.FTFloat64:
+		return s.decodeNum(buffer, fieldNum, 64)
+	case field.FTString, field.FTBytes:
+		return s.decodeBytes(buffer, fieldNum)
+	case field.FTFixedBytes:
+		h := GenericHeader((*buffer)[:8])
+		if size := s.mapping.FieldByNumber(fieldNum).Size; h.Final40() != uint64(size) {
+			return fmt.Errorf("%w: FixedBytes field has %d bytes, but the mapping says %d", ErrCorruptData, h.Final40(), size)
+		}
+		return s.decodeBytes(buffer, fieldNum)
+	case field.FTStruct:
+		return s.decodeStruct(buffer, fieldNum)
+	case field.FTListBools:
+		return s.decodeListBool(buffer, fieldNum)
+	case field.FTListInt8, field.FTListInt16, field.FTListInt32, field.FTListInt64,
+		field.FTListUint8, field.FTListUint16, field.FTListUint32, field.FTListUint64,
+		field.FTListFloat32, field.FTListFloat64:
+		return s.decodeListNumber(buffer, fieldNum)
+	case field.FTListBytes:
+		return s.decodeListBytes(buffer, fieldNum)
+	case field.FTListStructs:
+		return s.decodeListStruct(buffer, fieldNum)
+	default:
+		return fmt.Errorf("got field type %v that we don't support", fieldType)
+	}
+}
+
+// truncateFields returns a copy of data, which holds the fields of a Struct, with every list,
+// at any depth, cut to its first n items and the sizes of Structs updated to match. If data is not
+// well formed, the rest of it is copied as is, so that the decoder reports the problem.
+func truncateFields(data []byte, n int) []byte {
+	out := make([]byte, 0, len(data))
+	for len(data) >= 8 {
+		size, err := verifyField(data, nil)
+		if err != nil {
+			break
+		}
+		h := GenericHeader(data[:8])
+		switch ft := h.FieldType(); {
+		case ft == field.FTStruct:
+			out = append(out, truncateStruct(data[:size], n)...)
+		case ft == field.FTListStructs:
+			items := int(h.Final40())
+			keep := items
+			if keep > n {
+				keep = n
+			}
+			start := len(out)
+			out = append(out, data[:8]...)
+			GenericHeader(out[start:]).SetFinal40(uint64(keep))
+			read := 8
+			for i := 0; i < keep; i++ {
+				end := read + int(GenericHeader(data[read:read+8]).Final40())
+				out = append(out, truncateStruct(data[read:end], n)...)
+				read = end
+			}
+		case field.IsList(ft) && h.Final40() > uint64(n):
+			out = append(out, truncateList(data[:size], n)...)
+		default:
+			out = append(out, data[:size]...)
+		}
+		data = data[size:]
+	}
+	return append(out, data...)
+}
+
+// truncateStruct returns a copy of the encoded Struct in data with its lists cut to n items.
+// See truncateFields().
+func truncateStruct(data []byte, n int) []byte {
+	fields := truncateFields(data[8:], n)
+	out := make([]byte, 8, 8+len(fields))
+	copy(out, data[:8])
+	out = append(out, fields...)
+	GenericHeader(out[:8]).SetFinal40(uint64(len(out)))
+	return out
+}
+
+// truncateList returns a copy of the encoded list in data that only has its first n items.
+// data must be a whole list of anything but Structs that has more than n items and has passed
+// verifyField().
+func truncateList(data []byte, n int) []byte {
+	h := GenericHeader(data[:8])
+	size := 8
+	switch ft := h.FieldType(); {
+	case ft == field.FTListBools:
+		size += 8 * ((n + 63) / 64)
+	case ft >= field.FTListInt8 && ft <= field.FTListFloat64:
+		size += 8 * wordsRequiredToStore(n, numberListItemSize(ft))
+	case ft == field.FTListBytes || ft == field.FTListStrings:
+		for i := 0; i < n; i++ {
+			size += 4 + int(binary.Get[uint32](data[size:size+4]))
+		}
+	}
+
+	list := make([]byte, SizeWithPadding(size))
+	copy(list, data[:size])
+	GenericHeader(list[:8]).SetFinal40(uint64(n))
+
+	// Clear the bits and bytes that held the items that were cut.
+	switch ft := h.FieldType(); {
+	case ft == field.FTListBools:
+		if n%8 != 0 {
+			list[8+n/8] &= byte(1)<<(n%8) - 1
+		}
+		for i := 8 + (n+7)/8; i < len(list); i++ {
+			list[i] = 0
+		}
+	case ft >= field.FTListInt8 && ft <= field.FTListFloat64:
+		for i := 8 + n*numberListItemSize(ft); i < len(list); i++ {
+			list[i] = 0
+		}
+	}
+	return list
+}
+
 // decodeBool will decode a boolean value from the buffer into .fields[fieldNum] and
 // advance the buffer for the next value.
 func (s *Struct) decodeBool(buffer *[]byte, fieldNum uint16) error {
+	idx := s.mapping.Index(fieldNum)
 	if len(*buffer) < 8 {
 		return fmt.Errorf("can't decode bool value, not enough bytes for bool value")
 	}
 
-	f := s.fields[fieldNum]
+	before := encodedFieldSize(s, idx)
+	f := s.fields[idx]
 	f.Header = (*buffer)[0:8]
-	s.fields[fieldNum] = f
-	XXXAddToTotal(s, 8)
+	s.fields[idx] = f
+	XXXAddToTotal(s, 8-before)
 	*buffer = (*buffer)[8:]
 	return nil
 }
@@ -175,7 +644,8 @@ func (s *Struct) decodeBool(buffer *[]byte, fieldNum uint16) error {
 // decodeNum will decode a number value from the buffer into .fields[fieldNum] and
 // advance the buffer for the next value.
 func (s *Struct) decodeNum(buffer *[]byte, fieldNum uint16, numSize int8) error {
-	if int(fieldNum) >= len(s.fields) {
+	idx := s.mapping.Index(fieldNum)
+	if idx < 0 {
 		return fmt.Errorf("fieldNum %d doesn't exist", fieldNum)
 	}
 
@@ -184,21 +654,23 @@ func (s *Struct) decodeNum(buffer *[]byte, fieldNum uint16, numSize int8) error
 		if len(*buffer) < 8 {
 			return fmt.Errorf("can't decode a 8, 16, or 32 bit number with < 64 bits")
 		}
-		f := s.fields[fieldNum]
+		before := encodedFieldSize(s, idx)
+		f := s.fields[idx]
 		f.Header = (*buffer)[:8]
-		s.fields[fieldNum] = f
-		XXXAddToTotal(s, 8)
+		s.fields[idx] = f
+		XXXAddToTotal(s, 8-before)
 		*buffer = (*buffer)[8:]
 	case 64:
 		if len(*buffer) < 16 {
 			return fmt.Errorf("can't decode a 64 bit number with < 128 bits")
 		}
-		f := s.fields[fieldNum]
+		before := encodedFieldSize(s, idx)
+		f := s.fields[idx]
 		f.Header = (*buffer)[:8]
 		v := (*buffer)[8:16]
 		f.Ptr = unsafe.Pointer(&v)
-		s.fields[fieldNum] = f
-		XXXAddToTotal(s, 16)
+		s.fields[idx] = f
+		XXXAddToTotal(s, 16-before)
 		*buffer = (*buffer)[16:]
 	default:
 		return fmt.Errorf("Struct.decodeNum() numSize was %d, must be 32 or 64", numSize)
@@ -209,6 +681,7 @@ func (s *Struct) decodeNum(buffer *[]byte, fieldNum uint16, numSize int8) error
 // decodeBytes will decode a bytes/string value from the buffer into .fields[fieldNum] and
 // advance the buffer for the next value.
 func (s *Struct) decodeBytes(buffer *[]byte, fieldNum uint16) error {
+	idx := s.mapping.Index(fieldNum)
 	l := len(*buffer)
 	if l < 8 {
 		return fmt.Errorf("Struct.decodeBytes() header was < 64 bits")
@@ -216,42 +689,63 @@ func (s *Struct) decodeBytes(buffer *[]byte, fieldNum uint16) error {
 
 	i := binary.Get[uint64]((*buffer)[:8])
 	size := bits.GetValue[uint64, uint64](i, dataSizeMask, 24)
-	if size == 0 {
-		return fmt.Errorf("Struct.decodeBytes() received a Bytes field of size 0 which is invalid")
-	}
+	// A size of 0 is a field that was set, but empty (only encoded without zero value compression).
 
 	withPadding := SizeWithPadding(size) + 8 // header + data + padding
 	if l < int(withPadding) {
 		return fmt.Errorf("Struct.decodeBytes() found string/byte field that was clipped in size, got %d, want %d", l, withPadding)
 	}
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 
 	f.Header = (*buffer)[:8]
 	b := (*buffer)[8 : 8+size] // from end of header to end of data without padding
 	f.Ptr = unsafe.Pointer(&b)
 
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	log.Println("addToTotal: ", withPadding)
 	XXXAddToTotal(s, withPadding)
 	*buffer = (*buffer)[withPadding:]
 	return nil
 }
 
+// decodeUnknown stores the field at the start of the buffer as raw bytes in the reserved entry of
+// the gap that fieldNum is in and advances the buffer for the next value. This is used for field
+// numbers that our mapping has reserved. A gap can hold more than one field, which come one after
+// the other on the wire, so they are kept in order in the same raw bytes.
+func (s *Struct) decodeUnknown(buffer *[]byte, fieldNum uint16) error {
+	size, err := verifyField(*buffer, nil)
+	if err != nil {
+		return fmt.Errorf("field %d is reserved and could not be skipped: %w", fieldNum, err)
+	}
+
+	idx := s.mapping.Index(fieldNum)
+	raw := (*buffer)[:size:size]
+	if f := s.fields[idx]; f.Header != nil {
+		raw = append(*(*[]byte)(f.Ptr), raw...)
+	}
+	s.fields[idx] = StructField{Header: raw[:8], Ptr: unsafe.Pointer(&raw)}
+	XXXAddToTotal(s, size)
+	*buffer = (*buffer)[size:]
+	return nil
+}
+
 func (s *Struct) decodeListBool(buffer *[]byte, fieldNum uint16) error {
+	idx := s.mapping.Index(fieldNum)
 	h, ptr, err := NewBoolsFromBytes(buffer, s) // This handles our additions to s.structTotal
 	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	f.Header = h
 	f.Ptr = unsafe.Pointer(ptr)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	return nil
 }
 
 func (s *Struct) decodeListBytes(buffer *[]byte, fieldNum uint16) error {
-	f := s.fields[fieldNum]
+	idx := s.mapping.Index(fieldNum)
+	f := s.fields[idx]
 
 	ptr, err := NewBytesFromBytes(buffer, s)
 	if err != nil {
@@ -259,13 +753,14 @@ func (s *Struct) decodeListBytes(buffer *[]byte, fieldNum uint16) error {
 	}
 	f.Header = ptr.header
 	f.Ptr = unsafe.Pointer(ptr)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	return nil
 }
 
 func (s *Struct) decodeListNumber(buffer *[]byte, fieldNum uint16) error {
-	m := s.mapping.Fields[int(fieldNum)]
-	f := s.fields[fieldNum]
+	idx := s.mapping.Index(fieldNum)
+	m := s.mapping.Fields[idx]
+	f := s.fields[idx]
 	f.Header = (*buffer)[:8]
 	var uptr unsafe.Pointer
 	switch m.Type {
@@ -333,27 +828,53 @@ func (s *Struct) decodeListNumber(buffer *[]byte, fieldNum uint16) error {
 		panic(fmt.Sprintf("Struct.decodeListNumber() called with field that is mapped to value with type: %v", m.Type))
 	}
 	f.Ptr = uptr
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	return nil
 }
 
+// subMapping returns the mapping of the Struct or list of Structs held in field fieldNum.
+func (s *Struct) subMapping(fieldNum uint16) (*mapping.Map, error) {
+	fd := s.mapping.FieldByNumber(fieldNum)
+	if fd.SelfReferential {
+		return s.mapping, nil
+	}
+	if fd.Mapping == nil {
+		return nil, fmt.Errorf("bug: field %d(%s) holds a Struct, but its FieldDescr has no Mapping", fieldNum, fd.Name)
+	}
+	return fd.Mapping, nil
+}
+
 func (s *Struct) decodeStruct(buffer *[]byte, fieldNum uint16) error {
 	// We need the mapping for the sub Struct.
-	m := s.mapping.Fields[fieldNum].Mapping
-	if m == nil { // This means that the contained Struct is the same mapping as the part.
-		m = s.mapping
+	m, err := s.subMapping(fieldNum)
+	if err != nil {
+		return err
 	}
 
-	// Structs use a Reader, so let's give it a reader.
-	r := readers.Get().(*bytes.Reader)
-	r.Reset(*buffer)
-	defer readers.Put(r)
-
 	sub := New(fieldNum, m)
-	n, err := sub.unmarshal(r)
+	sub.disallowUnknown = s.disallowUnknown
+	sub.maxListElements = s.maxListElements
+	sub.lazyLists = s.lazyLists
+	sub.shared = s.shared
+	sub.stats = s.stats
+
+	var n int
+	if s.shared {
+		n, err = sub.unmarshalBytes(*buffer)
+	} else {
+		// Structs use a Reader, so let's give it a reader.
+		r := readers.Get().(*bytes.Reader)
+		r.Reset(*buffer)
+		defer putReader(r)
+		if s.stats != nil {
+			s.stats.PoolGets++
+		}
+		n, err = sub.unmarshal(r)
+	}
 	if err != nil {
 		return err
 	}
+	s.grown += atomic.LoadInt64(sub.structTotal) - int64(n)
 	SetStruct(s, fieldNum, sub)
 
 	*buffer = (*buffer)[n:]
@@ -361,10 +882,14 @@ func (s *Struct) decodeStruct(buffer *[]byte, fieldNum uint16) error {
 }
 
 func (s *Struct) decodeListStruct(buffer *[]byte, fieldNum uint16) error {
+	idx := s.mapping.Index(fieldNum)
 	// We need the mapping for the sub Struct.
-	m := s.mapping.Fields[fieldNum].Mapping
+	m, err := s.subMapping(fieldNum)
+	if err != nil {
+		return err
+	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	log.Println("buffer size before: ", len(*buffer))
 	l, err := NewStructsFromBytes(buffer, s, m)
 	if err != nil {
@@ -373,6 +898,6 @@ func (s *Struct) decodeListStruct(buffer *[]byte, fieldNum uint16) error {
 	}
 	f.Header = l.header
 	f.Ptr = unsafe.Pointer(l)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	return nil
 }
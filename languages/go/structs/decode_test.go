@@ -2,17 +2,21 @@ package structs
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"reflect"
 	"testing"
+	"testing/iotest"
 
 	"github.com/bearlytools/claw/internal/binary"
 	"github.com/bearlytools/claw/internal/bits"
 	"github.com/bearlytools/claw/internal/conversions"
 	"github.com/bearlytools/claw/languages/go/field"
 	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/kylelemons/godebug/pretty"
 )
 
 func TestDecodeBool(t *testing.T) {
@@ -437,10 +441,10 @@ func TestDecodeBytes(t *testing.T) {
 			err:      true,
 		},
 		{
-			desc:     "Error: Struct size is 0",
+			desc:     "Set, but empty",
 			buf:      sizeZeroHeader,
 			fieldNum: 1,
-			err:      true,
+			want:     []byte{},
 		},
 		{
 			desc:     "Error: Not enough padding",
@@ -552,9 +556,8 @@ func TestDecodeListBool(t *testing.T) {
 			err: true,
 		},
 		{
-			desc:     "Error: Header but no data",
-			listData: boolListInBytes(0),
-			err:      true,
+			desc:     "Empty list is only a header",
+			listData: boolListInBytes(0)[:8],
 		},
 		{
 			desc:     "Success",
@@ -643,9 +646,22 @@ func TestDecodeListBytes(t *testing.T) {
 			err: true,
 		},
 		{
-			desc:     "Error: Header but no data",
-			listData: boolListInBytes(0),
-			err:      true,
+			desc: "Error: Header but no data",
+			listData: func() []byte {
+				h := NewGenericHeader()
+				h.SetFieldType(field.FTListBytes)
+				h.SetFinal40(1)
+				return h
+			}(),
+			err: true,
+		},
+		{
+			desc: "Success: set, but empty",
+			listData: func() []byte {
+				h := NewGenericHeader()
+				h.SetFieldType(field.FTListBytes)
+				return h
+			}(),
 		},
 		{
 			desc: "Success",
@@ -1176,20 +1192,20 @@ func TestDecodeStruct(t *testing.T) {
 	msg1Mapping := &mapping.Map{
 		Fields: []*mapping.FieldDescr{
 			{Name: "Bool", Type: field.FTBool}, // 0
-			{Name: "Int8", Type: field.FTInt8},
-			{Name: "Int16", Type: field.FTInt16},
-			{Name: "Int32", Type: field.FTInt32},
-			{Name: "Int64", Type: field.FTInt64}, // 4
-			{Name: "Uint8", Type: field.FTUint8},
-			{Name: "Uint16", Type: field.FTUint16},
-			{Name: "Uint32", Type: field.FTUint32},
-			{Name: "Uint64", Type: field.FTUint64},
-			{Name: "Float32", Type: field.FTFloat32},                               // 9
-			{Name: "Float64", Type: field.FTFloat64},                               // 10
-			{Name: "Bytes", Type: field.FTBytes},                                   // 11
-			{Name: "ListNumber", Type: field.FTListUint8},                          // 12
-			{Name: "ListBytes", Type: field.FTListBytes},                           // 13
-			{Name: "ListStructs", Type: field.FTListStructs, Mapping: lmsgMapping}, // 14
+			{Name: "Int8", Type: field.FTInt8, FieldNum: 1},
+			{Name: "Int16", Type: field.FTInt16, FieldNum: 2},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 3},
+			{Name: "Int64", Type: field.FTInt64, FieldNum: 4}, // 4
+			{Name: "Uint8", Type: field.FTUint8, FieldNum: 5},
+			{Name: "Uint16", Type: field.FTUint16, FieldNum: 6},
+			{Name: "Uint32", Type: field.FTUint32, FieldNum: 7},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 8},
+			{Name: "Float32", Type: field.FTFloat32, FieldNum: 9},                                // 9
+			{Name: "Float64", Type: field.FTFloat64, FieldNum: 10},                               // 10
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 11},                                   // 11
+			{Name: "ListNumber", Type: field.FTListUint8, FieldNum: 12},                          // 12
+			{Name: "ListBytes", Type: field.FTListBytes, FieldNum: 13},                           // 13
+			{Name: "ListStructs", Type: field.FTListStructs, FieldNum: 14, Mapping: lmsgMapping}, // 14
 		},
 	}
 	msg0Mapping := &mapping.Map{
@@ -1316,3 +1332,1592 @@ func TestDecodeStruct(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeParallel(t *testing.T) {
+	lmsgMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+
+	msg0Mapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "ListStructs", Type: field.FTListStructs, Mapping: lmsgMapping},
+		},
+	}
+	msg0Mapping.MustValidate()
+
+	const items = 100
+
+	s0 := New(0, msg0Mapping)
+	s0.XXXSetNoZeroTypeCompression()
+	for i := 0; i < items; i++ {
+		item := New(0, lmsgMapping)
+		MustSetNumber(item, 0, int32(i))
+		MustSetBytes(item, 1, []byte(fmt.Sprintf("item-%d", i)), false)
+		MustAppendListStruct(s0, 0, item)
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := s0.Marshal(buff); err != nil {
+		panic(err)
+	}
+	listData := buff.Bytes()[8:] // Skip the Struct header.
+
+	// hugeCount is a list header that claims far more items than the data could hold.
+	hugeCount := NewGenericHeader()
+	hugeCount.SetFieldType(field.FTListStructs)
+	hugeCount.SetFinal40(1 << 39)
+
+	tests := []struct {
+		desc    string
+		data    []byte
+		workers int
+		err     bool
+	}{
+		{desc: "Success: 1 worker", data: listData, workers: 1},
+		{desc: "Success: 4 workers", data: listData, workers: 4},
+		{desc: "Success: default workers", data: listData, workers: 0},
+		{desc: "Error: truncated list", data: listData[:len(listData)-8], workers: 4, err: true},
+		{desc: "Error: not a list of structs", data: buff.Bytes(), workers: 4, err: true},
+		{desc: "Error: more items than the data can hold", data: hugeCount, workers: 4, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := DecodeParallel(context.Background(), test.data, lmsgMapping, test.workers)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestDecodeParallel(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestDecodeParallel(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		if len(got) != items {
+			t.Errorf("TestDecodeParallel(%s): got %d items, want %d", test.desc, len(got), items)
+			continue
+		}
+		for i, s := range got {
+			if n := MustGetNumber[int32](s, 0); n != int32(i) {
+				t.Errorf("TestDecodeParallel(%s): item %d: got Int32 %d, want %d", test.desc, i, n, i)
+			}
+			want := fmt.Sprintf("item-%d", i)
+			if b := MustGetBytes(s, 1); string(*b) != want {
+				t.Errorf("TestDecodeParallel(%s): item %d: got Bytes %q, want %q", test.desc, i, string(*b), want)
+			}
+		}
+	}
+}
+
+func TestStructsDecodeParallel(t *testing.T) {
+	lmsgMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	msg0Mapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "ListStructs", Type: field.FTListStructs, Mapping: lmsgMapping},
+		},
+	}
+	msg0Mapping.MustValidate()
+
+	const items = 50
+
+	s0 := New(0, msg0Mapping)
+	for i := 0; i < items; i++ {
+		item := New(0, lmsgMapping)
+		MustSetNumber(item, 0, int32(i+1))
+		MustAppendListStruct(s0, 0, item)
+	}
+	buff := &bytes.Buffer{}
+	if _, err := s0.Marshal(buff); err != nil {
+		t.Fatalf("TestStructsDecodeParallel: Marshal(): %s", err)
+	}
+
+	for _, workers := range []int{0, 1, 4} {
+		s, err := NewFromReaderWithOptions(bytes.NewReader(buff.Bytes()), msg0Mapping, UnmarshalOptions{LazyListStructs: true})
+		if err != nil {
+			t.Fatalf("TestStructsDecodeParallel: NewFromReaderWithOptions(): %s", err)
+		}
+		l := MustGetListStruct(s, 0)
+		// An item that was already decoded is kept.
+		first := l.Get(0)
+
+		got, err := l.DecodeParallel(context.Background(), workers)
+		if err != nil {
+			t.Errorf("TestStructsDecodeParallel(%d workers): got err == %s, want err == nil", workers, err)
+			continue
+		}
+		if len(got) != items {
+			t.Errorf("TestStructsDecodeParallel(%d workers): got %d items, want %d", workers, len(got), items)
+			continue
+		}
+		if got[0] != first {
+			t.Errorf("TestStructsDecodeParallel(%d workers): the decoded item 0 was replaced", workers)
+		}
+		for i, item := range got {
+			if n := MustGetNumber[int32](item, 0); n != int32(i+1) {
+				t.Errorf("TestStructsDecodeParallel(%d workers): item %d: got %d, want %d", workers, i, n, i+1)
+			}
+		}
+		if l.raw != nil {
+			t.Errorf("TestStructsDecodeParallel(%d workers): items were left undecoded", workers)
+		}
+		if err := marshalCheck(s, buff.Len()); err != nil {
+			t.Errorf("TestStructsDecodeParallel(%d workers): %s", workers, err)
+		}
+	}
+}
+
+func TestForEachListStruct(t *testing.T) {
+	lmsgMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+
+	msg0Mapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "ListStructs", Type: field.FTListStructs, Mapping: lmsgMapping},
+		},
+	}
+	msg0Mapping.MustValidate()
+
+	const items = 10
+
+	s0 := New(0, msg0Mapping)
+	for i := 0; i < items; i++ {
+		item := New(0, lmsgMapping)
+		MustSetNumber(item, 0, int32(i+1))
+		MustAppendListStruct(s0, 0, item)
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := s0.Marshal(buff); err != nil {
+		panic(err)
+	}
+	listData := buff.Bytes()[8:] // Skip the Struct header.
+
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		desc    string
+		data    []byte
+		stopAt  int
+		want    int
+		wantErr error
+		err     bool
+
+		decodedToo bool
+	}{
+		{desc: "Success", data: listData, stopAt: -1, want: items, decodedToo: true},
+		{desc: "fn error stops the loop", data: listData, stopAt: 3, want: 4, wantErr: errStop, err: true, decodedToo: true},
+		{desc: "Error: truncated list", data: listData[:len(listData)-8], stopAt: -1, err: true},
+		{desc: "Error: not a list of structs", data: buff.Bytes(), stopAt: -1, err: true},
+	}
+
+	for _, test := range tests {
+		for _, decoded := range []bool{false, true} {
+			count := 0
+			fn := func(i int, s *Struct) error {
+				count++
+				if n := MustGetNumber[int32](s, 0); n != int32(i+1) {
+					t.Errorf("TestForEachListStruct(%s): item %d: got Int32 %d, want %d", test.desc, i, n, i+1)
+				}
+				if i == test.stopAt {
+					return errStop
+				}
+				return nil
+			}
+
+			var err error
+			if decoded {
+				// Structs.ForEach() should act the same on a decoded list.
+				if !test.decodedToo {
+					continue
+				}
+				err = MustGetListStruct(s0, 0).ForEach(fn)
+			} else {
+				err = ForEachListStruct(test.data, lmsgMapping, fn)
+			}
+			switch {
+			case err == nil && test.err:
+				t.Errorf("TestForEachListStruct(%s, decoded %v): got err == nil, want err != nil", test.desc, decoded)
+				continue
+			case err != nil && !test.err:
+				t.Errorf("TestForEachListStruct(%s, decoded %v): got err == %s, want err == nil", test.desc, decoded, err)
+				continue
+			case err != nil && test.wantErr != nil && !errors.Is(err, test.wantErr):
+				t.Errorf("TestForEachListStruct(%s, decoded %v): got err == %s, want err == %s", test.desc, decoded, err, test.wantErr)
+				continue
+			case err != nil && test.wantErr == nil:
+				continue
+			}
+
+			if count != test.want {
+				t.Errorf("TestForEachListStruct(%s, decoded %v): fn called %d times, want %d", test.desc, decoded, count, test.want)
+			}
+		}
+	}
+}
+
+func TestForEachListStructPool(t *testing.T) {
+	lmsgMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	msg0Mapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "ListStructs", Type: field.FTListStructs, Mapping: lmsgMapping},
+		},
+	}
+	msg0Mapping.MustValidate()
+
+	const items = 10
+
+	s0 := New(0, msg0Mapping)
+	for i := 0; i < items; i++ {
+		item := New(0, lmsgMapping)
+		MustSetNumber(item, 0, int32(i+1))
+		MustAppendListStruct(s0, 0, item)
+	}
+	buff := &bytes.Buffer{}
+	if _, err := s0.Marshal(buff); err != nil {
+		panic(err)
+	}
+	listData := buff.Bytes()[8:] // Skip the Struct header.
+
+	const retainAt = 4
+
+	var retained *Struct
+	seen := map[*Struct]bool{}
+	before := PoolStats().Pools["Struct"]
+	err := ForEachListStruct(listData, lmsgMapping, func(i int, s *Struct) error {
+		if s == retained {
+			t.Errorf("TestForEachListStructPool: item %d reused the retained item", i)
+		}
+		seen[s] = true
+		if i == retainAt {
+			s.Retain()
+			retained = s
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TestForEachListStructPool: got err == %s, want err == nil", err)
+	}
+	after := PoolStats().Pools["Struct"]
+
+	if got := after.Puts - before.Puts; got != items-1 {
+		t.Errorf("TestForEachListStructPool: got %d items put back in the pool, want %d", got, items-1)
+	}
+	// The items that are put back are reused for the items after them.
+	if len(seen) == items {
+		t.Errorf("TestForEachListStructPool: got %d different items, want the pool to reuse them", len(seen))
+	}
+	if n := MustGetNumber[int32](retained, 0); n != retainAt+1 {
+		t.Errorf("TestForEachListStructPool: retained item: got Int32 %d, want %d", n, retainAt+1)
+	}
+}
+
+func TestDecodeList(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Record",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Data", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+	m.MustValidate()
+
+	const items = 5
+
+	buff := &bytes.Buffer{}
+	enc := NewEncoder(buff)
+	for i := 0; i < items; i++ {
+		s := New(0, m)
+		MustSetNumber(s, 0, int32(i+1))
+		MustSetBytes(s, 1, bytes.Repeat([]byte{'x'}, i*3), false)
+		if err := enc.Write(s); err != nil {
+			panic(err)
+		}
+	}
+	data := buff.Bytes()
+
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		desc    string
+		data    []byte
+		stopAt  int
+		want    int
+		wantErr error
+	}{
+		{desc: "Success", data: data, stopAt: -1, want: items},
+		{desc: "no records", data: nil, stopAt: -1},
+		{desc: "fn error stops the loop", data: data, stopAt: 2, want: 3, wantErr: errStop},
+		{desc: "Error: truncated record", data: data[:len(data)-8], stopAt: -1, want: items - 1, wantErr: ErrCorruptData},
+		{desc: "Error: truncated header", data: append(append([]byte{}, data...), data[:4]...), stopAt: -1, want: items, wantErr: ErrCorruptData},
+	}
+
+	for _, test := range tests {
+		count := 0
+		fn := func(i int, s *Struct) error {
+			count++
+			if n := MustGetNumber[int32](s, 0); n != int32(i+1) {
+				t.Errorf("TestDecodeList(%s): item %d: got Int32 %d, want %d", test.desc, i, n, i+1)
+			}
+			if i == test.stopAt {
+				return errStop
+			}
+			return nil
+		}
+
+		// Reading a byte at a time makes sure records are read from the stream, not from a buffer.
+		err := DecodeList(iotest.OneByteReader(bytes.NewReader(test.data)), m, fn)
+		switch {
+		case test.wantErr == nil && err != nil:
+			t.Errorf("TestDecodeList(%s): got err == %s, want err == nil", test.desc, err)
+		case test.wantErr != nil && !errors.Is(err, test.wantErr):
+			t.Errorf("TestDecodeList(%s): got err == %v, want err == %s", test.desc, err, test.wantErr)
+		}
+		if count != test.want {
+			t.Errorf("TestDecodeList(%s): fn called %d times, want %d", test.desc, count, test.want)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	from := &mapping.Map{
+		Name: "From",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	to := &mapping.Map{
+		Name: "To",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Double", Type: field.FTUint64, FieldNum: 1},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Items", Type: field.FTListStructs, Mapping: from},
+		},
+	}
+	m.MustValidate()
+	to.MustValidate()
+
+	const items = 10
+
+	s := New(0, m)
+	for i := uint32(1); i <= items; i++ {
+		item := New(0, from)
+		MustSetNumber(item, 0, i)
+		MustSetBytes(item, 1, []byte(fmt.Sprintf("item %d", i)), true)
+		MustAppendListStruct(s, 0, item)
+	}
+	data := &bytes.Buffer{}
+	if _, err := s.Marshal(data); err != nil {
+		t.Fatalf("TestTransform: Marshal(): %s", err)
+	}
+
+	convert := func(i int, item *Struct) (*Struct, error) {
+		v := New(0, to)
+		MustSetBytes(v, 0, *MustGetBytes(item, 1), true)
+		MustSetNumber(v, 1, uint64(MustGetNumber[uint32](item, 0))*2)
+		return v, nil
+	}
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		desc    string
+		lazy    bool
+		fn      func(i int, item *Struct) (*Struct, error)
+		wantErr error
+	}{
+		{desc: "decoded list", fn: convert},
+		{desc: "lazy list", lazy: true, fn: convert},
+		{
+			desc: "fn returns an error",
+			fn: func(i int, item *Struct) (*Struct, error) {
+				if i == 5 {
+					return nil, errStop
+				}
+				return convert(i, item)
+			},
+			wantErr: errStop,
+		},
+		{
+			desc:    "fn returns a Struct with the wrong mapping",
+			fn:      func(i int, item *Struct) (*Struct, error) { return New(0, from), nil },
+			wantErr: ErrTypeMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := NewFromReaderWithOptions(bytes.NewReader(data.Bytes()), m, UnmarshalOptions{LazyListStructs: test.lazy})
+		if err != nil {
+			t.Fatalf("TestTransform(%s): NewFromReaderWithOptions(): %s", test.desc, err)
+		}
+		src := MustGetListStruct(got, 0)
+
+		l, err := Transform(src, to, test.fn)
+		switch {
+		case test.wantErr != nil:
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("TestTransform(%s): got err == %v, want %v", test.desc, err, test.wantErr)
+			}
+			continue
+		case err != nil:
+			t.Errorf("TestTransform(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+
+		if test.lazy {
+			for i, item := range src.data {
+				if item != nil {
+					t.Errorf("TestTransform(%s): item %d of the source list was kept decoded", test.desc, i)
+				}
+			}
+		}
+		if l.Len() != items {
+			t.Fatalf("TestTransform(%s): got %d items, want %d", test.desc, l.Len(), items)
+		}
+		for i := 0; i < items; i++ {
+			item := l.Get(i)
+			if got, want := string(*MustGetBytes(item, 0)), fmt.Sprintf("item %d", i+1); got != want {
+				t.Errorf("TestTransform(%s): item %d: got Name %q, want %q", test.desc, i, got, want)
+			}
+			if got, want := MustGetNumber[uint64](item, 1), uint64(i+1)*2; got != want {
+				t.Errorf("TestTransform(%s): item %d: got Double %d, want %d", test.desc, i, got, want)
+			}
+		}
+
+		// The new list must encode as part of a Struct.
+		top := New(0, &mapping.Map{
+			Name:   "Out",
+			Fields: []*mapping.FieldDescr{{Name: "Items", Type: field.FTListStructs, Mapping: to}},
+		})
+		MustSetListStruct(top, 0, l)
+		if _, err := top.Marshal(&bytes.Buffer{}); err != nil {
+			t.Errorf("TestTransform(%s): Marshal() of the new list: %s", test.desc, err)
+		}
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	subV2 := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	v2 := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 1, Mapping: subV2},
+			{Name: "Uint8", Type: field.FTUint8, FieldNum: 2},
+		},
+	}
+
+	subV1 := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	// Only knows the first field.
+	v1 := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	// Knows all the fields, but the Struct has a field it doesn't know.
+	v1Sub := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 1, Mapping: subV1},
+			{Name: "Uint8", Type: field.FTUint8, FieldNum: 2},
+		},
+	}
+	// Has reserved field 2.
+	v1Reserved := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 1, Mapping: subV2},
+			{Type: field.FTUnknown, FieldNum: 2},
+		},
+	}
+
+	s := New(0, v2)
+	MustSetNumber(s, 0, int32(1))
+	sub := New(0, subV2)
+	MustSetNumber(sub, 0, int32(2))
+	MustSetBytes(sub, 1, []byte("hello"), true)
+	MustSetStruct(s, 1, sub)
+	MustSetNumber(s, 2, uint8(3))
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		panic(err)
+	}
+	data := buff.Bytes()
+
+	tests := []struct {
+		desc      string
+		m         *mapping.Map
+		wantField uint16
+		err       bool
+	}{
+		{desc: "all fields known", m: v2},
+		{desc: "Error: excess fields", m: v1, wantField: 1, err: true},
+		{desc: "Error: unknown field in a sub Struct", m: v1Sub, wantField: 1, err: true},
+		{desc: "Error: reserved field", m: v1Reserved, wantField: 2, err: true},
+	}
+
+	for _, test := range tests {
+		// Without the option, everything decodes.
+		if _, err := NewFromReader(bytes.NewReader(data), test.m); err != nil {
+			t.Errorf("TestDisallowUnknownFields(%s): NewFromReader(): got err == %s, want err == nil", test.desc, err)
+		}
+
+		_, err := NewFromReaderWithOptions(bytes.NewReader(data), test.m, UnmarshalOptions{DisallowUnknownFields: true})
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestDisallowUnknownFields(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestDisallowUnknownFields(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err == nil:
+			continue
+		}
+
+		if !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("TestDisallowUnknownFields(%s): got err == %s, want ErrFieldNotFound", test.desc, err)
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) || de.FieldNum != test.wantField {
+			t.Errorf("TestDisallowUnknownFields(%s): got err == %s, want a DecodeError for field %d", test.desc, err, test.wantField)
+		}
+	}
+}
+
+func TestUnmarshalFrom(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+		},
+	}
+	m.MustValidate()
+
+	newCar := func(name string, year uint16) *Struct {
+		s := New(0, m)
+		MustSetBytes(s, 0, []byte(name), true)
+		MustSetNumber(s, 1, year)
+		return s
+	}
+	cars := []*Struct{newCar("Prius", 2020), newCar("Camry", 1999), newCar("Corolla", 2010)}
+
+	buff := &bytes.Buffer{}
+	for _, c := range cars[:2] {
+		if _, err := c.Marshal(buff); err != nil {
+			t.Fatalf("TestUnmarshalFrom: Marshal(): %s", err)
+		}
+	}
+	if _, err := cars[2].MarshalWithOptions(buff, MarshalOptions{EmbedSchemaHash: true}); err != nil {
+		t.Fatalf("TestUnmarshalFrom: MarshalWithOptions(): %s", err)
+	}
+
+	data := buff.Bytes()
+	for i, want := range cars {
+		got := New(0, m)
+		rest, err := got.UnmarshalFrom(data)
+		if err != nil {
+			t.Fatalf("TestUnmarshalFrom(message %d): got err == %s, want err == nil", i, err)
+		}
+		if !Equal(got, want) {
+			t.Errorf("TestUnmarshalFrom(message %d): did not decode the message", i)
+		}
+		if len(rest) >= len(data) {
+			t.Fatalf("TestUnmarshalFrom(message %d): rest was not shorter than data", i)
+		}
+		data = rest
+	}
+	if len(data) != 0 {
+		t.Errorf("TestUnmarshalFrom: got %d bytes left over, want 0", len(data))
+	}
+
+	// Errors.
+	full := buff.Bytes()
+	if rest, err := New(0, m).UnmarshalFrom(full[:16]); err == nil {
+		t.Errorf("TestUnmarshalFrom(truncated): got err == nil, want err != nil")
+	} else if len(rest) != 16 {
+		t.Errorf("TestUnmarshalFrom(truncated): rest was not all of data")
+	}
+	if _, err := New(0, m).UnmarshalFrom(nil); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestUnmarshalFrom(empty): got err == %v, want ErrCorruptData", err)
+	}
+	if _, err := newCar("Prius", 2020).UnmarshalFrom(full); err == nil {
+		t.Errorf("TestUnmarshalFrom(Struct not empty): got err == nil, want err != nil")
+	}
+}
+
+func TestPeekSize(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+		},
+	}
+	m.MustValidate()
+
+	s := New(0, m)
+	MustSetBytes(s, 0, []byte("Prius"), true)
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestPeekSize: Marshal(): %s", err)
+	}
+	data := buff.Bytes()
+
+	notStruct := make([]byte, 8)
+	GenericHeader(notStruct).SetFieldType(field.FTBytes)
+	badSize := append([]byte{}, data[:8]...)
+	GenericHeader(badSize).SetFinal40(12)
+
+	tests := []struct {
+		desc   string
+		header []byte
+		want   int
+		err    bool
+	}{
+		{desc: "header only", header: data[:8], want: len(data)},
+		{desc: "whole message", header: data, want: len(data)},
+		{desc: "Error: short header", header: data[:7], err: true},
+		{desc: "Error: not a Struct", header: notStruct, err: true},
+		{desc: "Error: size not divisible by 8", header: badSize, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := PeekSize(test.header)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestPeekSize(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestPeekSize(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			if !errors.Is(err, ErrCorruptData) {
+				t.Errorf("TestPeekSize(%s): got err == %s, want ErrCorruptData", test.desc, err)
+			}
+			continue
+		}
+		if got != test.want {
+			t.Errorf("TestPeekSize(%s): got %d, want %d", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Count", Type: field.FTUint32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	// junk returns a header that is appended inside the Struct.
+	junk := func(fieldNum uint16, ft field.Type) []byte {
+		h := NewGenericHeader()
+		h.SetFieldNum(fieldNum)
+		h.SetFieldType(ft)
+		return h
+	}
+	// grow adds 8 to the size in the header at b[at:].
+	grow := func(b []byte, at int) {
+		h := GenericHeader(b[at : at+8])
+		h.SetFinal40(h.Final40() + 8)
+	}
+
+	tests := []struct {
+		desc string
+		// set is called to set fields on the Struct before encoding.
+		set func(s *Struct)
+		// junk is appended to the end of the encoded Struct and the size is updated.
+		junk []byte
+		// inSub puts junk at the end of the Sub field, which must be the last field.
+		inSub bool
+		err   error
+	}{
+		{
+			desc: "no junk",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, uint32(1))
+				MustSetBytes(s, 1, []byte("hello"), true)
+			},
+		},
+		{
+			desc: "zeros",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, uint32(1))
+				MustSetBytes(s, 1, []byte("hello"), true)
+			},
+			junk: make([]byte, 8),
+			err:  ErrTrailingData,
+		},
+		{
+			desc: "zeros in an empty Struct",
+			set:  func(s *Struct) {},
+			junk: make([]byte, 8),
+			err:  ErrTrailingData,
+		},
+		{
+			desc: "field that came before",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, uint32(1))
+				MustSetBytes(s, 1, []byte("hello"), true)
+			},
+			junk: junk(0, field.FTUint32),
+			err:  ErrCorruptData,
+		},
+		{
+			desc: "junk in a Struct field",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, uint32(1))
+				n := New(2, sub)
+				MustSetBool(n, 0, true)
+				MustSetStruct(s, 2, n)
+			},
+			junk:  make([]byte, 8),
+			inSub: true,
+			err:   ErrTrailingData,
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		test.set(s)
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestTrailingData(%s): Marshal(): %s", test.desc, err)
+		}
+		b := append(buff.Bytes(), test.junk...)
+		if len(test.junk) > 0 {
+			grow(b, 0)
+			if test.inSub {
+				off, _, ok := FieldOffset(s, 2)
+				if !ok {
+					t.Fatalf("TestTrailingData(%s): Sub was not set", test.desc)
+				}
+				grow(b, off)
+			}
+		}
+
+		_, err := NewFromReader(bytes.NewReader(b), m)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("TestTrailingData(%s): got err == %s, want err == nil", test.desc, err)
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestTrailingData(%s): got err == %v, want errors.Is(err, %v)", test.desc, err, test.err)
+		case test.err != nil && !errors.Is(err, ErrCorruptData):
+			t.Errorf("TestTrailingData(%s): got err == %v, want errors.Is(err, ErrCorruptData)", test.desc, err)
+		case test.err == ErrCorruptData && errors.Is(err, ErrTrailingData):
+			t.Errorf("TestTrailingData(%s): got err == %v, want an ErrCorruptData that is not ErrTrailingData", test.desc, err)
+		}
+	}
+}
+
+func TestMaxListElements(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bools", Type: field.FTListBools},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bools", Type: field.FTListBools},
+			{Name: "Uint16s", Type: field.FTListUint16, FieldNum: 1},
+			{Name: "Bytes", Type: field.FTListBytes, FieldNum: 2},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 3, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	// build returns a Struct whose lists have up to n items: 70 bools, 5 numbers, 3 bytes
+	// and 3 Structs that each have 4 bools.
+	build := func(n int) *Struct {
+		count := func(c int) int {
+			if c > n {
+				return n
+			}
+			return c
+		}
+		bools := func(c int) *Bools {
+			b := NewBools(0)
+			for i := 0; i < count(c); i++ {
+				b.Append(i%3 == 0)
+			}
+			return b
+		}
+
+		s := New(0, m)
+		MustSetListBool(s, 0, bools(70))
+		nums := NewNumbers[uint16]()
+		for i := 0; i < count(5); i++ {
+			nums.Append(uint16(i * 7))
+		}
+		MustSetListNumber(s, 1, nums)
+		b := NewBytes()
+		for i := 0; i < count(3); i++ {
+			if err := b.Append([]byte(fmt.Sprintf("item%d", i))); err != nil {
+				panic(err)
+			}
+		}
+		MustSetListBytes(s, 2, b)
+		subs := NewStructs(sub)
+		for i := 0; i < count(3); i++ {
+			e := New(0, sub)
+			MustSetListBool(e, 0, bools(4))
+			if err := subs.Append(e); err != nil {
+				panic(err)
+			}
+		}
+		MustSetListStruct(s, 3, subs)
+		return s
+	}
+
+	full := &bytes.Buffer{}
+	if _, err := build(100).Marshal(full); err != nil {
+		t.Fatalf("TestMaxListElements: Marshal(): %s", err)
+	}
+
+	tests := []struct {
+		desc     string
+		opts     UnmarshalOptions
+		wantSize int // The size the lists were truncated to.
+		// subOnly removes the lists in Top that have more than 3 items, so only Sub.Bools does.
+		subOnly bool
+		err     error
+	}{
+		{desc: "no limit", wantSize: 100},
+		{desc: "limit above every list", opts: UnmarshalOptions{MaxListElements: 70}, wantSize: 100},
+		{desc: "limit exceeded", opts: UnmarshalOptions{MaxListElements: 69}, err: ErrSizeExceeded},
+		{desc: "limit exceeded in a sub Struct", opts: UnmarshalOptions{MaxListElements: 3}, subOnly: true, err: ErrSizeExceeded},
+		{desc: "truncate to 65", opts: UnmarshalOptions{MaxListElements: 65, TruncateLists: true}, wantSize: 65},
+		{desc: "truncate to 2", opts: UnmarshalOptions{MaxListElements: 2, TruncateLists: true}, wantSize: 2},
+		{desc: "truncate to 1", opts: UnmarshalOptions{MaxListElements: 1, TruncateLists: true}, wantSize: 1},
+	}
+
+	for _, test := range tests {
+		data := full.Bytes()
+		if test.subOnly {
+			s := build(100)
+			DeleteField(s, 0)
+			DeleteField(s, 1)
+			buff := &bytes.Buffer{}
+			if _, err := s.Marshal(buff); err != nil {
+				t.Fatalf("TestMaxListElements(%s): Marshal(): %s", test.desc, err)
+			}
+			data = buff.Bytes()
+		}
+
+		got, err := NewFromReaderWithOptions(bytes.NewReader(data), m, test.opts)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("TestMaxListElements(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestMaxListElements(%s): got err == %v, want errors.Is(err, %v)", test.desc, err, test.err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		want := build(test.wantSize)
+		if !Equal(got, want) {
+			t.Errorf("TestMaxListElements(%s): did not get the lists truncated to %d items", test.desc, test.wantSize)
+		}
+		gotBuff, wantBuff := &bytes.Buffer{}, &bytes.Buffer{}
+		if _, err := got.Marshal(gotBuff); err != nil {
+			t.Errorf("TestMaxListElements(%s): Marshal() of decoded Struct: %s", test.desc, err)
+			continue
+		}
+		want.Marshal(wantBuff)
+		if !bytes.Equal(gotBuff.Bytes(), wantBuff.Bytes()) {
+			t.Errorf("TestMaxListElements(%s): encoding of decoded Struct did not match encoding of a Struct built with %d items", test.desc, test.wantSize)
+		}
+	}
+}
+
+func TestLazyListStructs(t *testing.T) {
+	leaf := &mapping.Map{
+		Name: "Leaf",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+		},
+	}
+	item := &mapping.Map{
+		Name: "Item",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Leaves", Type: field.FTListStructs, FieldNum: 2, Mapping: leaf},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Items", Type: field.FTListStructs, Mapping: item},
+		},
+	}
+	m.MustValidate()
+
+	s := New(0, m)
+	for i := uint32(1); i <= 100; i++ {
+		it := New(0, item)
+		MustSetNumber(it, 0, i)
+		MustSetBytes(it, 1, []byte(fmt.Sprintf("item %d", i)), true)
+		l := New(0, leaf)
+		MustSetNumber(l, 0, i*10)
+		MustAppendListStruct(it, 2, l)
+		MustAppendListStruct(s, 0, it)
+	}
+	data := &bytes.Buffer{}
+	if _, err := s.Marshal(data); err != nil {
+		t.Fatalf("TestLazyListStructs: Marshal(): %s", err)
+	}
+
+	decode := func() *Struct {
+		got, err := NewFromReaderWithOptions(bytes.NewReader(data.Bytes()), m, UnmarshalOptions{LazyListStructs: true})
+		if err != nil {
+			t.Fatalf("TestLazyListStructs: NewFromReaderWithOptions(): %s", err)
+		}
+		return got
+	}
+	decoded := func(l *Structs) int {
+		n := 0
+		for _, item := range l.data {
+			if item != nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	if !Equal(decode(), s) {
+		t.Errorf("TestLazyListStructs: the lazy Struct was not Equal() to the original")
+	}
+
+	got := decode()
+	items := MustGetListStruct(got, 0)
+	if items.Len() != 100 || decoded(items) != 0 {
+		t.Fatalf("TestLazyListStructs: got %d items with %d decoded, want 100 with 0 decoded", items.Len(), decoded(items))
+	}
+	it := items.Get(41)
+	if id := MustGetNumber[uint32](it, 0); id != 42 {
+		t.Errorf("TestLazyListStructs: Get(41) had ID %d, want 42", id)
+	}
+	if n := decoded(items); n != 1 {
+		t.Errorf("TestLazyListStructs: after Get() %d items were decoded, want 1", n)
+	}
+	if id := MustGetNumber[uint32](MustGetListStruct(it, 2).Get(0), 0); id != 420 {
+		t.Errorf("TestLazyListStructs: nested Leaf had ID %d, want 420", id)
+	}
+
+	// Changing an item must change the size of the top Struct, and unread items are written as they were.
+	MustSetBytes(it, 1, []byte("changed to a longer name"), true)
+	buff := &bytes.Buffer{}
+	if _, err := got.Marshal(buff); err != nil {
+		t.Fatalf("TestLazyListStructs: Marshal() after a change: %s", err)
+	}
+	want := MustGetListStruct(s, 0).Get(41)
+	MustSetBytes(want, 1, []byte("changed to a longer name"), true)
+	if !bytes.Equal(buff.Bytes(), mustMarshal(t, s)) {
+		t.Errorf("TestLazyListStructs: the encoded lazy Struct was not the same as the eager one")
+	}
+
+	// A bad item is found when decoding, not when the item is read.
+	bad := append([]byte{}, data.Bytes()...)
+	GenericHeader(bad[16:24]).SetFinal40(7)
+	if _, err := NewFromReaderWithOptions(bytes.NewReader(bad), m, UnmarshalOptions{LazyListStructs: true}); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestLazyListStructs: decoding a corrupt item: got err == %v, want ErrCorruptData", err)
+	}
+}
+
+func mustMarshal(t *testing.T, s *Struct) []byte {
+	t.Helper()
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("Marshal(): %s", err)
+	}
+	return buff.Bytes()
+}
+
+func TestUnmarshalShared(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Record",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Count", Type: field.FTUint64, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	var data []byte
+	for _, name := range []string{"aaaa", "bbbb"} {
+		s := New(0, m)
+		MustSetBytes(s, 0, []byte(name), true)
+		MustSetNumber(s, 1, uint64(len(name)))
+		ss := New(0, sub)
+		MustSetBytes(ss, 0, []byte(name), true)
+		MustSetStruct(s, 2, ss)
+		data = append(data, mustMarshal(t, s)...)
+	}
+	first := len(data) / 2
+
+	var got []*Struct
+	rest := data
+	for len(rest) > 0 {
+		s := New(0, m)
+		var err error
+		if rest, err = s.UnmarshalShared(rest); err != nil {
+			t.Fatalf("TestUnmarshalShared: got err == %s, want err == nil", err)
+		}
+		got = append(got, s)
+	}
+	if len(got) != 2 {
+		t.Fatalf("TestUnmarshalShared: got %d records, want 2", len(got))
+	}
+
+	// Changing data changes the values, which shows they were not copied.
+	for i, s := range got {
+		name := MustGetBytes(s, 0)
+		subName := MustGetBytes(MustGetStruct(s, 2), 0)
+		start := i * first
+		for j := range data[start : start+first] {
+			if data[start+j] == 'a' || data[start+j] == 'b' {
+				data[start+j] = 'z'
+			}
+		}
+		if string(*name) != "zzzz" || string(*subName) != "zzzz" {
+			t.Errorf("TestUnmarshalShared(record %d): got names %q and %q after changing data, want %q", i, *name, *subName, "zzzz")
+		}
+		data[start+39] = 0xff // The high byte of Count, which follows the headers of the Struct, Name and Count and the data of Name.
+		if n := MustGetNumber[uint64](s, 1); n != 0xff00000000000004 {
+			t.Errorf("TestUnmarshalShared(record %d): got Count %x after changing data, want %x", i, n, uint64(0xff00000000000004))
+		}
+	}
+
+	// The Structs are frozen, so they can't be used to change data.
+	before := append([]byte(nil), data...)
+	if err := SetNumber(got[0], 1, uint64(1)); !errors.Is(err, ErrFrozen) {
+		t.Errorf("TestUnmarshalShared(SetNumber): got err == %v, want ErrFrozen", err)
+	}
+	if err := SetBytes(MustGetStruct(got[0], 2), 0, []byte("yyyy"), true); !errors.Is(err, ErrFrozen) {
+		t.Errorf("TestUnmarshalShared(SetBytes on Sub): got err == %v, want ErrFrozen", err)
+	}
+	// A Clone() can be changed without changing data.
+	c := got[0].Clone()
+	MustSetNumber(c, 1, uint64(1))
+	MustSetBytes(c, 0, []byte("yyyy"), true)
+	if !bytes.Equal(data, before) {
+		t.Errorf("TestUnmarshalShared: data was changed through the Structs decoded from it")
+	}
+
+	if _, err := New(0, m).UnmarshalShared(data[:first-8]); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestUnmarshalShared(truncated): got err == %v, want ErrCorruptData", err)
+	}
+	if _, err := got[0].UnmarshalShared(data); err == nil {
+		t.Errorf("TestUnmarshalShared(Struct not empty): got err == nil, want err != nil")
+	}
+}
+
+func TestSchemaEvolution(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	v1 := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	// v1 with fields added.
+	v2 := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Miles", Type: field.FTUint64, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: sub},
+			{Name: "Tags", Type: field.FTListStrings, FieldNum: 4},
+		},
+	}
+	// v2 with Miles removed and its number reserved.
+	v2Reserved := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Type: field.FTUnknown, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: sub},
+			{Name: "Tags", Type: field.FTListStrings, FieldNum: 4},
+		},
+	}
+	// v1 with string changed to bytes.
+	v1Bytes := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+	// v1 with Year widened to an int64.
+	v1Widened := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTInt64},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	// v1 with Year changed to unsigned.
+	v1Unsigned := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTUint32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	// v1 with Year changed to a list.
+	v1List := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Year", Type: field.FTListInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	for _, m := range []*mapping.Map{sub, v1, v2, v2Reserved, v1Bytes, v1Widened, v1Unsigned, v1List} {
+		m.MustValidate()
+	}
+
+	encode := func(m *mapping.Map) []byte {
+		s := New(0, m)
+		s.XXXSetNoZeroTypeCompression()
+		MustSetNumber(s, 0, int32(2022))
+		MustSetBytes(s, 1, []byte("Camry"), false)
+		if len(m.Fields) > 2 {
+			MustSetNumber(s, 2, uint64(0))
+			item := New(0, sub)
+			MustSetNumber(item, 0, int32(1))
+			MustSetStruct(s, 3, item)
+			l := NewBytes()
+			l.Append([]byte("red"))
+			MustSetListBytes(s, 4, l)
+		}
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			panic(err)
+		}
+		return buff.Bytes()
+	}
+
+	tests := []struct {
+		desc string
+		// sender is the mapping the data was encoded with and receiver is the one it is decoded with.
+		sender, receiver *mapping.Map
+		// wantUnset are the receiver's fields that the data does not have.
+		wantUnset []uint16
+		err       error
+	}{
+		{desc: "same schema", sender: v1, receiver: v1},
+		{desc: "sender is older", sender: v1, receiver: v2, wantUnset: []uint16{2, 3, 4}},
+		{desc: "sender is newer", sender: v2, receiver: v1},
+		{desc: "field was removed and reserved", sender: v2, receiver: v2Reserved},
+		{desc: "string changed to bytes", sender: v1, receiver: v1Bytes},
+		{desc: "Error: int32 widened to int64", sender: v1, receiver: v1Widened, err: ErrTypeMismatch},
+		{desc: "Error: int32 changed to uint32", sender: v1, receiver: v1Unsigned, err: ErrTypeMismatch},
+		{desc: "Error: int32 changed to a list", sender: v1, receiver: v1List, err: ErrTypeMismatch},
+	}
+
+	for _, test := range tests {
+		data := encode(test.sender)
+
+		s := New(0, test.receiver)
+		s.XXXSetNoZeroTypeCompression()
+		_, err := s.UnmarshalFrom(data)
+		switch {
+		case err == nil && test.err != nil:
+			t.Errorf("TestSchemaEvolution(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && test.err == nil:
+			t.Errorf("TestSchemaEvolution(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			if !errors.Is(err, test.err) {
+				t.Errorf("TestSchemaEvolution(%s): got err == %s, want %s", test.desc, err, test.err)
+			}
+			continue
+		}
+
+		if got := MustGetNumber[int32](s, 0); got != 2022 {
+			t.Errorf("TestSchemaEvolution(%s): got Year %d, want 2022", test.desc, got)
+		}
+		if got := string(*MustGetBytes(s, 1)); got != "Camry" {
+			t.Errorf("TestSchemaEvolution(%s): got Name %q, want %q", test.desc, got, "Camry")
+		}
+		for _, num := range test.wantUnset {
+			if s.IsSet(num) {
+				t.Errorf("TestSchemaEvolution(%s): got IsSet(%d) == true, want false", test.desc, num)
+			}
+		}
+
+		// The receiver must keep the fields it doesn't know, so what it encodes decodes with the
+		// sender's schema to what was sent.
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestSchemaEvolution(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		want := New(0, test.sender)
+		want.XXXSetNoZeroTypeCompression()
+		if _, err := want.UnmarshalFrom(data); err != nil {
+			t.Fatalf("TestSchemaEvolution(%s): decoding the sent data: %s", test.desc, err)
+		}
+		got := New(0, test.sender)
+		got.XXXSetNoZeroTypeCompression()
+		if _, err := got.UnmarshalFrom(buff.Bytes()); err != nil {
+			t.Errorf("TestSchemaEvolution(%s): decoding what the receiver encoded: %s", test.desc, err)
+			continue
+		}
+		if !Equal(want, got) {
+			t.Errorf("TestSchemaEvolution(%s): what the receiver encoded is not what was sent", test.desc)
+		}
+	}
+}
+
+func TestEmptyNestedStruct(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Sub", Type: field.FTStruct, Mapping: sub},
+			{Name: "Other", Type: field.FTStruct, FieldNum: 1, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	tests := []struct {
+		desc          string
+		noCompression bool
+		setZero       bool // Set Sub.Int32 to 0, which zero value compression doesn't encode.
+	}{
+		{desc: "zero value compression"},
+		{desc: "zero value compression, field set to zero", setZero: true},
+		{desc: "no zero value compression", noCompression: true},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		if test.noCompression {
+			s.XXXSetNoZeroTypeCompression()
+		}
+		item := New(0, sub)
+		if test.setZero {
+			MustSetNumber(item, 0, int32(0))
+		}
+		MustSetStruct(s, 0, item)
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestEmptyNestedStruct(%s): Marshal(): %s", test.desc, err)
+		}
+
+		got := New(0, m)
+		if test.noCompression {
+			got.XXXSetNoZeroTypeCompression()
+		}
+		if _, err := got.UnmarshalFrom(buff.Bytes()); err != nil {
+			t.Fatalf("TestEmptyNestedStruct(%s): UnmarshalFrom(): %s", test.desc, err)
+		}
+		if !got.IsSet(0) {
+			t.Errorf("TestEmptyNestedStruct(%s): got IsSet(0) == false, want true", test.desc)
+		}
+		if MustGetStruct(got, 0) == nil {
+			t.Errorf("TestEmptyNestedStruct(%s): got GetStruct(0) == nil, want an empty Struct", test.desc)
+		}
+		if got.IsSet(1) {
+			t.Errorf("TestEmptyNestedStruct(%s): got IsSet(1) == true, want false", test.desc)
+		}
+		if MustGetStruct(got, 1) != nil {
+			t.Errorf("TestEmptyNestedStruct(%s): got GetStruct(1) != nil, want nil", test.desc)
+		}
+	}
+}
+
+func TestDecodeObserver(t *testing.T) {
+	leaf := &mapping.Map{
+		Name: "Leaf",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+		},
+	}
+	item := &mapping.Map{
+		Name: "Item",
+		Fields: []*mapping.FieldDescr{
+			{Name: "ID", Type: field.FTUint32},
+			{Name: "Leaf", Type: field.FTStruct, FieldNum: 1, Mapping: leaf},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Leaf", Type: field.FTStruct, Mapping: leaf},
+			{Name: "Items", Type: field.FTListStructs, FieldNum: 1, Mapping: item},
+		},
+	}
+	m.MustValidate()
+
+	s := New(0, m)
+	l := New(0, leaf)
+	MustSetNumber(l, 0, uint32(1))
+	MustSetStruct(s, 0, l)
+	for i := uint32(1); i <= 2; i++ {
+		it := New(0, item)
+		MustSetNumber(it, 0, i)
+		l := New(0, leaf)
+		MustSetNumber(l, 0, i*10)
+		MustSetStruct(it, 1, l)
+		MustAppendListStruct(s, 1, it)
+	}
+	data := &bytes.Buffer{}
+	if _, err := s.Marshal(data); err != nil {
+		t.Fatalf("TestDecodeObserver: Marshal(): %s", err)
+	}
+	noHeader := &bytes.Buffer{}
+	if _, err := s.MarshalWithOptions(noHeader, MarshalOptions{OmitTopHeader: true}); err != nil {
+		t.Fatalf("TestDecodeObserver: MarshalWithOptions(): %s", err)
+	}
+
+	tests := []struct {
+		desc    string
+		data    []byte
+		opts    UnmarshalOptions
+		want    DecodeStats
+		wantErr bool
+	}{
+		{
+			desc: "decode everything",
+			data: data.Bytes(),
+			// Top, its Leaf, 2 Items and their Leafs. A Reader is taken from the pool for each Leaf.
+			want: DecodeStats{Name: "Top", Bytes: data.Len(), Structs: 6, Fields: 9, MaxDepth: 3, PoolGets: 3},
+		},
+		{
+			desc: "lazy lists",
+			data: data.Bytes(),
+			opts: UnmarshalOptions{LazyListStructs: true},
+			want: DecodeStats{Name: "Top", Bytes: data.Len(), Structs: 2, Fields: 3, LazyItems: 2, MaxDepth: 2, PoolGets: 1},
+		},
+		{
+			desc: "no top header",
+			data: noHeader.Bytes(),
+			opts: UnmarshalOptions{OmitTopHeader: true},
+			want: DecodeStats{Name: "Top", Bytes: noHeader.Len(), Structs: 6, Fields: 9, MaxDepth: 3, PoolGets: 3},
+		},
+		{
+			desc:    "truncated data",
+			data:    data.Bytes()[:20],
+			want:    DecodeStats{Name: "Top", Bytes: 20},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		var got []DecodeStats
+		test.opts.Observer = func(stats DecodeStats) { got = append(got, stats) }
+
+		_, err := NewFromReaderWithOptions(bytes.NewReader(test.data), m, test.opts)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestDecodeObserver(%s): got err == nil, want err != nil", test.desc)
+		case err != nil && !test.wantErr:
+			t.Errorf("TestDecodeObserver(%s): got err == %s, want err == nil", test.desc, err)
+		}
+		if diff := pretty.Compare([]DecodeStats{test.want}, got); diff != "" {
+			t.Errorf("TestDecodeObserver(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestSparseFields(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Engine",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Cylinders", Type: field.FTUint8},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+			{Name: "Owners", Type: field.FTListStrings, FieldNum: 2},
+			{Name: "Engine", Type: field.FTStruct, FieldNum: 3, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	// set sets the fields of s that are in fields.
+	set := func(s *Struct, fields ...uint16) {
+		for _, n := range fields {
+			switch n {
+			case 0:
+				MustSetBytes(s, 0, []byte("Mustang"), true)
+			case 1:
+				MustSetNumber(s, 1, uint16(1965))
+			case 2:
+				owners := NewBytes()
+				if err := owners.Append([]byte("Ann"), []byte("Bob")); err != nil {
+					t.Fatal(err)
+				}
+				MustSetListBytes(s, 2, owners)
+			case 3:
+				engine := New(0, sub)
+				MustSetNumber(engine, 0, uint8(8))
+				MustSetStruct(s, 3, engine)
+			}
+		}
+	}
+
+	full := New(0, m)
+	set(full, 0, 1, 2, 3)
+	data := &bytes.Buffer{}
+	if _, err := full.Marshal(data); err != nil {
+		t.Fatalf("TestSparseFields: Marshal(): %s", err)
+	}
+
+	tests := []struct {
+		desc   string
+		fields []uint16
+	}{
+		{desc: "nil decodes every field", fields: nil},
+		{desc: "scalar and Struct", fields: []uint16{1, 3}},
+		{desc: "String and list", fields: []uint16{0, 2}},
+		{desc: "field not in the data", fields: []uint16{1, 10}},
+		{desc: "no fields", fields: []uint16{}},
+	}
+
+	for _, test := range tests {
+		got, err := NewFromReaderWithOptions(bytes.NewReader(data.Bytes()), m, UnmarshalOptions{SparseFields: test.fields})
+		if err != nil {
+			t.Errorf("TestSparseFields(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+
+		want := New(0, m)
+		if test.fields == nil {
+			set(want, 0, 1, 2, 3)
+		} else {
+			set(want, test.fields...)
+		}
+		if !Equal(got, want) {
+			t.Errorf("TestSparseFields(%s): the decoded Struct did not hold only the fields asked for", test.desc)
+		}
+		if got.Size() != want.Size() {
+			t.Errorf("TestSparseFields(%s): got Size() %d, want %d", test.desc, got.Size(), want.Size())
+		}
+		buff := &bytes.Buffer{}
+		if _, err := got.Marshal(buff); err != nil {
+			t.Errorf("TestSparseFields(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		if buff.Len() != want.Size() {
+			t.Errorf("TestSparseFields(%s): got %d encoded bytes, want %d", test.desc, buff.Len(), want.Size())
+		}
+	}
+
+	// A field that is skipped is still checked.
+	bad := append([]byte(nil), data.Bytes()...)
+	owners, _, _ := FieldOffset(full, 2)
+	GenericHeader(bad[owners : owners+8]).SetFinal40(1000)
+	_, err := NewFromReaderWithOptions(bytes.NewReader(bad), m, UnmarshalOptions{SparseFields: []uint16{1}})
+	if !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestSparseFields(corrupt skipped field): got err == %v, want ErrCorruptData", err)
+	}
+}
+
+// BenchmarkDecodeSparse decodes a message with 2 fields set, using a mapping with 80 fields and one
+// with only those 2. The difference is what a Struct spends on the fields that are not set.
+func BenchmarkDecodeSparse(b *testing.B) {
+	wide := &mapping.Map{Name: "Wide"}
+	for i := 0; i < 80; i++ {
+		wide.Fields = append(wide.Fields, &mapping.FieldDescr{Name: fmt.Sprintf("F%d", i), Type: field.FTInt32, FieldNum: uint16(i)})
+	}
+	wide.MustValidate()
+	narrow := &mapping.Map{
+		Name: "Narrow",
+		Fields: []*mapping.FieldDescr{
+			{Name: "F0", Type: field.FTInt32},
+			{Name: "F1", Type: field.FTInt32, FieldNum: 1},
+		},
+	}
+	narrow.MustValidate()
+
+	s := New(0, narrow)
+	MustSetNumber(s, 0, int32(1))
+	MustSetNumber(s, 1, int32(2))
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		b.Fatal(err)
+	}
+	data := buff.Bytes()
+
+	for _, m := range []*mapping.Map{narrow, wide} {
+		b.Run(fmt.Sprintf("%d fields", len(m.Fields)), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := New(0, m).UnmarshalFrom(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// fieldSet is a set of field numbers. Fields below 64, which is most of them, don't need an allocation.
+type fieldSet struct {
+	low uint64
+	// high holds fields 64 and up, 64 to an entry.
+	high []uint64
+}
+
+func (f *fieldSet) add(n uint16) {
+	if n < 64 {
+		f.low |= 1 << n
+		return
+	}
+	i := int(n/64) - 1
+	if i >= len(f.high) {
+		h := make([]uint64, i+1)
+		copy(h, f.high)
+		f.high = h
+	}
+	f.high[i] |= 1 << (n % 64)
+}
+
+func (f *fieldSet) has(n uint16) bool {
+	if n < 64 {
+		return f.low&(1<<n) != 0
+	}
+	i := int(n/64) - 1
+	return i < len(f.high) && f.high[i]&(1<<(n%64)) != 0
+}
+
+func (f *fieldSet) empty() bool {
+	if f.low != 0 {
+		return false
+	}
+	for _, w := range f.high {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// markDirty records that field fieldNum of s changed, for MarshalDelta(). s may be nil.
+func (s *Struct) markDirty(fieldNum uint16) {
+	if s != nil {
+		s.dirty.add(fieldNum)
+	}
+}
+
+// ClearDirty forgets the changes to s, and every Struct it holds, so that the next MarshalDelta()
+// only has the changes made after this. Call it once a peer has applied the last delta.
+func (s *Struct) ClearDirty() {
+	s.dirty = fieldSet{}
+	for i, f := range s.fields {
+		if f.Header == nil {
+			continue
+		}
+		switch s.mapping.Fields[i].Type {
+		case field.FTStruct:
+			(*Struct)(f.Ptr).ClearDirty()
+		case field.FTListStructs:
+			// Items that are not decoded yet can't have changed.
+			for _, item := range (*Structs)(f.Ptr).data {
+				if item != nil {
+					item.ClearDirty()
+				}
+			}
+		}
+	}
+}
+
+// anyDirty reports if s, or any Struct it holds, has changed since it was decoded or ClearDirty() was called.
+func (s *Struct) anyDirty() bool {
+	if !s.dirty.empty() {
+		return true
+	}
+	for i, f := range s.fields {
+		if f.Header == nil {
+			continue
+		}
+		switch s.mapping.Fields[i].Type {
+		case field.FTStruct:
+			if (*Struct)(f.Ptr).anyDirty() {
+				return true
+			}
+		case field.FTListStructs:
+			for _, item := range (*Structs)(f.Ptr).data {
+				if item != nil && item.anyDirty() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// MarshalDelta writes out only the fields of s that changed since s was decoded or ClearDirty() was
+// last called. The output is a Struct of the same type that only has those fields set, so a peer
+// holding the earlier version can apply it with UnmarshalMerge(). A Struct field whose fields changed
+// is written with only the fields that changed, at any depth, while a Struct field that was set is
+// written with all of its fields, which UnmarshalMerge() merges into the Struct the peer has. Every
+// field that is set on a Struct that was not decoded is a change.
+//
+// UnmarshalMerge() appends lists and can't remove a field, so some changes can't be sent this way.
+// This returns an error wrapping ErrDeltaUnsupported if a field was deleted, a field was set to a
+// zero value that zero value compression leaves out, or a list or a Struct in a list changed. Use
+// Marshal() to send these.
+//
+// This does not forget the changes that were written, call ClearDirty() for that.
+func (s *Struct) MarshalDelta(w io.Writer) (int, error) {
+	p, err := s.delta()
+	if err != nil {
+		return 0, err
+	}
+	return p.Marshal(w)
+}
+
+// delta returns a Struct that shares the fields of s that changed and has no other fields, see
+// MarshalDelta(). The returned Struct must only be read.
+func (s *Struct) delta() (*Struct, error) {
+	h := NewGenericHeader()
+	copy(h, s.header)
+
+	p := &Struct{
+		header:              h,
+		mapping:             s.mapping,
+		fields:              make([]StructField, len(s.fields)),
+		structTotal:         new(int64),
+		zeroTypeCompression: s.zeroTypeCompression,
+		partial:             true,
+	}
+
+	total := 8 // the header
+	for i, fd := range s.mapping.Fields {
+		f := s.fields[i]
+		dirty := s.dirty.has(fd.FieldNum)
+
+		switch fd.Type {
+		case field.FTUnknown:
+			continue
+		case field.FTStruct:
+			switch {
+			case dirty && f.Header == nil:
+				return nil, fmt.Errorf("%w: %s was deleted", ErrDeltaUnsupported, fieldString(s, fd.FieldNum))
+			case dirty:
+				p.fields[i] = f
+			case f.Header != nil && (*Struct)(f.Ptr).anyDirty():
+				sub, err := (*Struct)(f.Ptr).delta()
+				if err != nil {
+					return nil, err
+				}
+				p.fields[i] = StructField{Header: sub.header, Ptr: unsafe.Pointer(sub)}
+			default:
+				continue
+			}
+		case field.FTListStructs:
+			if !dirty && f.Header != nil {
+				for _, item := range (*Structs)(f.Ptr).data {
+					if item != nil && item.anyDirty() {
+						dirty = true
+						break
+					}
+				}
+			}
+			if dirty {
+				return nil, fmt.Errorf("%w: the list in %s changed", ErrDeltaUnsupported, fieldString(s, fd.FieldNum))
+			}
+			continue
+		default:
+			if !dirty {
+				continue
+			}
+			if field.IsList(fd.Type) {
+				return nil, fmt.Errorf("%w: the list in %s changed", ErrDeltaUnsupported, fieldString(s, fd.FieldNum))
+			}
+			if f.Header == nil {
+				return nil, fmt.Errorf("%w: %s was deleted", ErrDeltaUnsupported, fieldString(s, fd.FieldNum))
+			}
+			if encodedFieldSize(s, i) == 0 {
+				return nil, fmt.Errorf("%w: %s was set to the zero value, which is not encoded", ErrDeltaUnsupported, fieldString(s, fd.FieldNum))
+			}
+			p.fields[i] = f
+		}
+		total += encodedFieldSize(p, i)
+	}
+
+	*p.structTotal = int64(total)
+	p.header.SetFinal40(uint64(total))
+	return p, nil
+}
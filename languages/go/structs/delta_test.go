@@ -0,0 +1,193 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestMarshalDelta(t *testing.T) {
+	person := &mapping.Map{
+		Name: "Person",
+		Fields: []*mapping.FieldDescr{
+			{Name: "First", Type: field.FTString},
+			{Name: "Age", Type: field.FTUint8, FieldNum: 1},
+		},
+	}
+	car := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+			{Name: "Miles", Type: field.FTUint64, FieldNum: 2},
+			{Name: "Owner", Type: field.FTStruct, FieldNum: 3, Mapping: person},
+			{Name: "Notes", Type: field.FTListBytes, FieldNum: 4},
+			{Name: "Drivers", Type: field.FTListStructs, FieldNum: 5, Mapping: person},
+		},
+	}
+	car.MustValidate()
+
+	newPerson := func(fieldNum uint16, first string, age uint8) *Struct {
+		p := New(fieldNum, person)
+		if first != "" {
+			MustSetBytes(p, 0, []byte(first), true)
+		}
+		if age != 0 {
+			MustSetNumber(p, 1, age)
+		}
+		return p
+	}
+	newCar := func(name string, year uint16, miles uint64, owner *Struct) *Struct {
+		c := New(0, car)
+		if name != "" {
+			MustSetBytes(c, 0, []byte(name), true)
+		}
+		if year != 0 {
+			MustSetNumber(c, 1, year)
+		}
+		if miles != 0 {
+			MustSetNumber(c, 2, miles)
+		}
+		if owner != nil {
+			MustSetStruct(c, 3, owner)
+		}
+		return c
+	}
+
+	base := newCar("Prius", 2020, 1000, newPerson(3, "Alice", 30))
+	notes := NewBytes()
+	if err := notes.Append([]byte("blue")); err != nil {
+		panic(err)
+	}
+	MustSetListBytes(base, 4, notes)
+	MustAppendListStruct(base, 5, newPerson(0, "Bob", 40))
+	baseData := &bytes.Buffer{}
+	if _, err := base.Marshal(baseData); err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		desc   string
+		change func(c *Struct)
+		want   *Struct
+		err    error
+	}{
+		{
+			desc:   "no changes",
+			change: func(c *Struct) {},
+			want:   newCar("", 0, 0, nil),
+		},
+		{
+			desc: "scalar and string fields",
+			change: func(c *Struct) {
+				MustSetBytes(c, 0, []byte("Camry"), true)
+				MustSetNumber(c, 2, uint64(2000))
+			},
+			want: newCar("Camry", 0, 2000, nil),
+		},
+		{
+			desc: "field in a nested Struct",
+			change: func(c *Struct) {
+				MustSetNumber(MustGetStruct(c, 3), 1, uint8(31))
+			},
+			want: newCar("", 0, 0, newPerson(3, "", 31)),
+		},
+		{
+			desc: "Struct field that was set",
+			change: func(c *Struct) {
+				MustSetStruct(c, 3, newPerson(3, "Carol", 25))
+			},
+			want: newCar("", 0, 0, newPerson(3, "Carol", 25)),
+		},
+		{
+			desc: "changes before ClearDirty() are not written",
+			change: func(c *Struct) {
+				// Setting a string to the value it has is a change, even though the peer has it.
+				MustSetBytes(c, 0, []byte("Prius"), true)
+				c.ClearDirty()
+				MustSetNumber(c, 2, uint64(2000))
+			},
+			want: newCar("", 0, 2000, nil),
+		},
+		{
+			desc: "Error: field deleted",
+			change: func(c *Struct) {
+				if err := DeleteBytes(c, 0); err != nil {
+					panic(err)
+				}
+			},
+			err: ErrDeltaUnsupported,
+		},
+		{
+			desc: "Error: field set to the zero value",
+			change: func(c *Struct) {
+				MustSetNumber(MustGetStruct(c, 3), 1, uint8(0))
+			},
+			err: ErrDeltaUnsupported,
+		},
+		{
+			desc: "Error: list appended to",
+			change: func(c *Struct) {
+				if err := MustGetListBytes(c, 4).Append([]byte("red")); err != nil {
+					panic(err)
+				}
+			},
+			err: ErrDeltaUnsupported,
+		},
+		{
+			desc: "Error: Struct in a list changed",
+			change: func(c *Struct) {
+				MustSetNumber(MustGetListStruct(c, 5).Get(0), 1, uint8(41))
+			},
+			err: ErrDeltaUnsupported,
+		},
+	}
+
+	for _, test := range tests {
+		c, err := NewFromReader(bytes.NewReader(baseData.Bytes()), car)
+		if err != nil {
+			panic(err)
+		}
+		test.change(c)
+
+		buff := &bytes.Buffer{}
+		n, err := c.MarshalDelta(buff)
+		switch {
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestMarshalDelta(%s): got err == %v, want err == %v", test.desc, err, test.err)
+			continue
+		case test.err == nil && err != nil:
+			t.Errorf("TestMarshalDelta(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+		if n != buff.Len() {
+			t.Errorf("TestMarshalDelta(%s): got n == %d, but wrote %d bytes", test.desc, n, buff.Len())
+		}
+
+		got, err := NewFromReader(bytes.NewReader(buff.Bytes()), car)
+		if err != nil {
+			t.Errorf("TestMarshalDelta(%s): could not decode the output: %s", test.desc, err)
+			continue
+		}
+		if !Equal(got, test.want) {
+			t.Errorf("TestMarshalDelta(%s): decoded Struct did not only hold the changed fields", test.desc)
+		}
+
+		peer, err := NewFromReader(bytes.NewReader(baseData.Bytes()), car)
+		if err != nil {
+			panic(err)
+		}
+		if err := peer.UnmarshalMerge(bytes.NewReader(buff.Bytes())); err != nil {
+			t.Errorf("TestMarshalDelta(%s): UnmarshalMerge() error: %s", test.desc, err)
+			continue
+		}
+		if !Equal(peer, c) {
+			t.Errorf("TestMarshalDelta(%s): UnmarshalMerge() of the delta did not give the changed Struct", test.desc)
+		}
+	}
+}
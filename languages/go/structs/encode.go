@@ -1,16 +1,180 @@
 package structs
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
+	"sync"
 	"sync/atomic"
 
+	"github.com/bearlytools/claw/internal/binary"
 	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
 )
 
+// MarshalOptions are options for MarshalWithOptions().
+type MarshalOptions struct {
+	// EmbedSchemaHash writes a 16 byte preamble holding the mapping's SchemaHash() before the
+	// Struct. When the data is decoded, the hash is checked against the mapping being decoded
+	// with and an ErrSchemaMismatch is returned if they differ. Data without the preamble is
+	// decoded without a check. Versions of this package without this option cannot decode data
+	// that has the preamble.
+	EmbedSchemaHash bool
+	// OmitTopHeader leaves out the 8 byte header of the top level Struct, which holds its type
+	// and size. This saves 8 bytes when the Struct is embedded in framing that already records
+	// what the message is and how long it is.
+	//
+	// The output is NOT self describing: it can only be decoded with UnmarshalOptions.OmitTopHeader,
+	// the right mapping and a reader that ends exactly where the Struct ends. This cannot be used
+	// with EmbedSchemaHash.
+	OmitTopHeader bool
+	// Crypter encrypts the values of String and Bytes fields marked with [encrypted] in the .claw
+	// file. The encrypted values are written as bytes and s is not changed. Without a Crypter,
+	// those fields are written as they are, so always marshal Structs that have encrypted fields
+	// with this set.
+	Crypter Crypter
+	// Scratch is a pool of *bytes.Buffer. If set, the Struct is composed in a buffer from the pool,
+	// which is written to the io.Writer in a single Write() and then put back. A writer that does
+	// a syscall for every Write(), such as a net.Conn, gets one call instead of one per field.
+	// Buffers are Reset() before they are used, so they may be put back holding anything. A buffer
+	// larger than SetMaxPooledBytes() allows is not put back.
+	Scratch *sync.Pool
+}
+
+// MarshalWithOptions writes out the Struct to an io.Writer using opts.
+func (s *Struct) MarshalWithOptions(w io.Writer, opts MarshalOptions) (n int, err error) {
+	if opts.Scratch != nil {
+		return s.marshalScratch(w, opts)
+	}
+	if opts.Crypter != nil && hasEncrypted(s.mapping, map[*mapping.Map]bool{}) {
+		s = s.Clone()
+		if err := cryptFields(s, opts.Crypter.Encrypt, false); err != nil {
+			return 0, fmt.Errorf("could not encrypt: %w", err)
+		}
+	}
+
+	if opts.OmitTopHeader {
+		if opts.EmbedSchemaHash {
+			return 0, fmt.Errorf("MarshalOptions.OmitTopHeader cannot be used with EmbedSchemaHash")
+		}
+		return s.marshal(w, false)
+	}
+	if opts.EmbedSchemaHash {
+		n, err = write(w, schemaHashPreamble(s.mapping))
+		if err != nil {
+			return n, err
+		}
+	}
+	written, err := s.Marshal(w)
+	return n + written, err
+}
+
+// marshalScratch composes s in a buffer from opts.Scratch and writes it to w.
+func (s *Struct) marshalScratch(w io.Writer, opts MarshalOptions) (int, error) {
+	pool := opts.Scratch
+	opts.Scratch = nil
+
+	buff, _ := pool.Get().(*bytes.Buffer)
+	if buff == nil {
+		buff = &bytes.Buffer{}
+	}
+	buff.Reset()
+	buff.Grow(s.Size() + 16) // + 16 for a schema hash preamble
+	defer func() {
+		if max := atomic.LoadInt64(&maxPooledBytes); max > 0 && int64(buff.Cap()) > max {
+			return
+		}
+		buff.Reset()
+		pool.Put(buff)
+	}()
+
+	if _, err := s.MarshalWithOptions(buff, opts); err != nil {
+		return 0, err
+	}
+	return write(w, buff.Bytes())
+}
+
+// schemaHashPreamble returns the preamble that holds m.SchemaHash(). This is a header with
+// type FTSchemaHash and a size of 16, followed by the hash.
+func schemaHashPreamble(m *mapping.Map) []byte {
+	b := make([]byte, 16)
+	h := GenericHeader(b[:8])
+	h.SetFieldType(field.FTSchemaHash)
+	h.SetFinal40(16)
+	binary.Put(b[8:], m.SchemaHash())
+	return b
+}
+
+// Size returns the number of bytes Marshal() writes for s. Use this to reserve exactly the
+// space needed before calling MarshalInto().
+func (s *Struct) Size() int {
+	return int(atomic.LoadInt64(s.structTotal))
+}
+
+// MarshalInto writes s into the start of b, which must be at least Size() bytes long. b is only
+// written to, it is never grown or replaced and nothing keeps a reference to it after this returns.
+// This means b can be memory that Go did not allocate, such as a region of a memory mapped file.
+// It returns the number of bytes written, which is Size().
+func (s *Struct) MarshalInto(b []byte) (int, error) {
+	size := s.Size()
+	if len(b) < size {
+		return 0, fmt.Errorf("%w: MarshalInto() needs %d bytes, but the buffer is %d bytes", io.ErrShortBuffer, size, len(b))
+	}
+	w := &fixedWriter{b: b[:size]}
+	return s.Marshal(w)
+}
+
+// CachedMarshal returns s in the form written by Marshal(). The result is kept and returned again
+// until s or a value it holds changes, so a Struct that is read often and changed rarely, such as
+// a config sent to many clients, is only encoded once per change. The returned []byte must not be
+// modified. Changes made by writing to memory returned by a getter, such as GetBytes(), are not seen.
+func (s *Struct) CachedMarshal() ([]byte, error) {
+	if s.cached != nil && !s.modified {
+		return s.cached, nil
+	}
+	b := make([]byte, s.Size())
+	n, err := s.MarshalInto(b)
+	if err != nil {
+		return nil, err
+	}
+	s.cached = b[:n]
+	s.modified = false
+	return s.cached, nil
+}
+
+// fixedWriter is an io.Writer that writes into a []byte that it never grows.
+type fixedWriter struct {
+	b []byte
+	n int
+}
+
+func (f *fixedWriter) Write(p []byte) (int, error) {
+	n := copy(f.b[f.n:], p)
+	f.n += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// write writes p to w. An io.Writer may write less than len(p) without an error, which would
+// leave a hole in the output, so that is returned as an io.ErrShortWrite.
+func write(w io.Writer, p []byte) (int, error) {
+	n, err := w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
 // Marshal writes out the Struct to an io.Writer.
 func (s *Struct) Marshal(w io.Writer) (n int, err error) {
+	return s.marshal(w, true)
+}
+
+// marshal writes out the Struct to w. If withHeader is false, the Struct's header is not written.
+func (s *Struct) marshal(w io.Writer, withHeader bool) (n int, err error) {
 	total := atomic.LoadInt64(s.structTotal)
 	if total%8 != 0 {
 		return 0, fmt.Errorf("Struct has an internal size(%d) that is not divisible by 8, something is bugged", total)
@@ -21,34 +185,47 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 	}
 	defer log.Println("Marshal headers says the size is: ", s.header.Final40())
 	defer log.Println("Marshal also says the total is: ", total)
-	written, err := w.Write(s.header)
-	if err != nil {
-		return written, err
+	var written int
+	if withHeader {
+		written, err = write(w, s.header)
+		if err != nil {
+			return written, err
+		}
 	}
 
 	for i, v := range s.fields {
 		if v.Header == nil {
-			log.Printf("field %d was skipped for encode", i)
-			continue
-		}
-
-		if v.Header.FieldNum() != uint16(i) {
-			return written, fmt.Errorf("bug: field %d in the index had field number %d(%s), which is a bug", i, v.Header.FieldNum(), v.Header.FieldType())
+			if !fieldEncoded(s, i) {
+				log.Printf("field %d was skipped for encode", i)
+				continue
+			}
+			v = denseField(s.mapping.Fields[i])
 		}
 
 		desc := s.mapping.Fields[i]
+		// The reserved entry of a gap holds fields from a newer version, which have their own numbers.
+		if desc.Type != field.FTUnknown && v.Header.FieldNum() != desc.FieldNum {
+			return written, fmt.Errorf("bug: field %d in the index had field number %d(%s), which is a bug", desc.FieldNum, v.Header.FieldNum(), v.Header.FieldType())
+		}
 		log.Printf("field %d was: %s", i, desc.Type)
 
 		switch desc.Type {
+		// This is a field we reserved, but was set by a newer version of the Struct.
+		case field.FTUnknown:
+			i, err := write(w, *(*[]byte)(v.Ptr))
+			written += i
+			if err != nil {
+				return written, err
+			}
 		// This handles any basic scalar type.
 		case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
 			field.FTUint16, field.FTUint32, field.FTFloat32:
-			if s.zeroTypeCompression {
+			if s.compressZero(i) {
 				if v.Header.Final40() == 0 {
 					break
 				}
 			}
-			i, err := w.Write(v.Header)
+			i, err := write(w, v.Header)
 			written += i
 			if err != nil {
 				return written, err
@@ -58,7 +235,7 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 			if v.Ptr != nil {
 				b = (*[]byte)(v.Ptr)
 			}
-			if s.zeroTypeCompression {
+			if s.compressZero(i) {
 				if b == nil {
 					break
 				}
@@ -73,23 +250,23 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 					break
 				}
 			}
-			i, err := w.Write(v.Header)
+			i, err := write(w, v.Header)
 			written += i
 			if err != nil {
 				return written, err
 			}
-			i, err = w.Write(*b)
+			i, err = write(w, *b)
 			written += i
 			if err != nil {
 				return written, err
 			}
-		case field.FTString, field.FTBytes:
+		case field.FTString, field.FTBytes, field.FTFixedBytes:
 			if s.zeroTypeCompression {
 				if v.Header.Final40() == 0 {
 					break
 				}
 			}
-			i, err := w.Write(v.Header)
+			i, err := write(w, v.Header)
 			log.Println("wrote bytes header of: ", i)
 			written += i
 			if err != nil {
@@ -99,14 +276,14 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 				break
 			}
 			b := (*[]byte)(v.Ptr)
-			i, err = w.Write(*b)
+			i, err = write(w, *b)
 			log.Println("wrote bytes data of: ", i)
 			written += i
 			if err != nil {
 				return written, err
 			}
 			pad := PaddingNeeded(written)
-			i, err = w.Write(Padding(pad))
+			i, err = write(w, Padding(pad))
 			log.Println("wrote bytes padding of: ", i)
 			written += i
 			if err != nil {
@@ -124,120 +301,83 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 			}
 		case field.FTListBools:
 			b := (*Bools)(v.Ptr)
-			if b.Len() == 0 {
-				break
-			}
-			i, err := w.Write(b.Encode())
+			i, err := write(w, b.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListInt8:
 			x := (*Numbers[int8])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListUint8:
 			x := (*Numbers[uint8])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListInt16:
 			x := (*Numbers[int16])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListUint16:
 			x := (*Numbers[uint16])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
-				written += i
 				return written, err
 			}
 		case field.FTListInt32:
 			x := (*Numbers[int32])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListUint32:
 			x := (*Numbers[uint32])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListFloat32:
 			x := (*Numbers[float32])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListInt64:
 			x := (*Numbers[int64])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListUint64:
 			x := (*Numbers[uint64])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
 		case field.FTListFloat64:
 			x := (*Numbers[float64])(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
-			i, err := w.Write(x.Encode())
+			i, err := write(w, x.Encode())
 			written += i
 			if err != nil {
 				return written, err
 			}
-		case field.FTListBytes:
+		case field.FTListBytes, field.FTListStrings:
 			x := (*Bytes)(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
 			i, err := x.Encode(w)
 			written += i
 			if err != nil {
@@ -245,9 +385,6 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 			}
 		case field.FTListStructs:
 			x := (*Structs)(v.Ptr)
-			if x.Len() == 0 {
-				break
-			}
 			log.Println("before encode: ", written)
 			n, err := x.Encode(w)
 			written += n
@@ -258,7 +395,18 @@ func (s *Struct) Marshal(w io.Writer) (n int, err error) {
 			return written, fmt.Errorf("received a field type %v that we don't support", desc.Type)
 		}
 	}
+	// These are fields from a newer version of the Struct that are past the end of our mapping.
+	if len(s.excess) > 0 {
+		i, err := write(w, s.excess)
+		written += i
+		if err != nil {
+			return written, err
+		}
+	}
 	log.Println("wrote: ", written)
+	if !withHeader {
+		total -= 8
+	}
 	if written != int(total) {
 		return written, fmt.Errorf("bug: we wrote %d data out, which is not the same as the total bytes it should take (%d)", written, total)
 	}
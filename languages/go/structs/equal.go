@@ -0,0 +1,213 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// structEnd is written after the fields of a Struct when writing the canonical form. No valid
+// field header can have this value, so it can't be confused with the start of another field.
+var structEnd = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// Equal reports whether a and b hold the same values. Two nil Structs are equal. Struct's that
+// use different mappings are never equal.
+//
+// If a Struct uses zero value compression, fields set to their zero value are equal to fields
+// that are not set, which is the same thing you would get by doing Marshal() and then decoding.
+func Equal(a, b *Struct) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.mapping != b.mapping {
+		return false
+	}
+
+	ab := &bytes.Buffer{}
+	bb := &bytes.Buffer{}
+	if err := writeCanonical(ab, a, 0); err != nil {
+		return false
+	}
+	if err := writeCanonical(bb, b, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(ab.Bytes(), bb.Bytes())
+}
+
+// Diff returns the numbers of the fields that hold different values in a and b, in order. Fields are
+// compared as Equal() compares Structs, so a field set to its zero value is the same as one that is not
+// set when zero value compression is used. Fields the mapping does not have are not compared, as in
+// SetFields(). A Struct field is reported if anything in it differs. A nil
+// Struct has no fields set. This panics if a and b use different mappings.
+func Diff(a, b *Struct) []uint16 {
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return SetFields(b)
+	case b == nil:
+		return SetFields(a)
+	}
+	if a.mapping != b.mapping {
+		panic(fmt.Sprintf("cannot Diff() a %s and a %s", a.mapping.Name, b.mapping.Name))
+	}
+
+	var out []uint16
+	ab := &bytes.Buffer{}
+	bb := &bytes.Buffer{}
+	for i := range a.fields {
+		if a.mapping.Fields[i].Type == field.FTUnknown {
+			continue
+		}
+		ab.Reset()
+		bb.Reset()
+		aErr := writeCanonicalField(ab, a, i)
+		bErr := writeCanonicalField(bb, b, i)
+		if aErr != nil || bErr != nil || !bytes.Equal(ab.Bytes(), bb.Bytes()) {
+			out = append(out, a.mapping.Fields[i].FieldNum)
+		}
+	}
+	return out
+}
+
+// HashTo writes a canonical form of s to h. Structs that are Equal() always write the same bytes,
+// so the sum of h can be used as a key representing the content of s. The mapping is not part of
+// what is written, so only compare hashes of Structs of the same type.
+// This does not call h.Reset(), so you can hash multiple Structs together.
+func HashTo(h hash.Hash, s *Struct) error {
+	if s == nil {
+		return fmt.Errorf("cannot hash a nil *Struct")
+	}
+	return writeCanonical(h, s, 0)
+}
+
+// writeCanonical writes out the fields of s in a form that is the same for any two Structs holding
+// the same values. This is close to the wire format, but drops things that can differ between
+// equal values, like the Struct's size and any padding. fieldNum is used in place of the field number
+// stored in the Struct's header, which is different for the same Struct in different places.
+func writeCanonical(w io.Writer, s *Struct, fieldNum uint16) error {
+	start := NewGenericHeader()
+	start.SetFieldNum(fieldNum)
+	start.SetFieldType(field.FTStruct)
+	if _, err := w.Write(start); err != nil {
+		return err
+	}
+
+	for i := range s.fields {
+		if err := writeCanonicalField(w, s, i); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(structEnd)
+	return err
+}
+
+// writeCanonicalField writes field i of s as writeCanonical() does, which writes nothing for a field
+// that is not set, unless it is a Dense field.
+func writeCanonicalField(w io.Writer, s *Struct, i int) error {
+	f := s.fields[i]
+	if f.Header == nil {
+		if !fieldEncoded(s, i) {
+			return nil
+		}
+		f = denseField(s.mapping.Fields[i])
+	}
+
+	switch s.mapping.Fields[i].Type {
+	case field.FTUnknown:
+		if _, err := w.Write(*(*[]byte)(f.Ptr)); err != nil {
+			return err
+		}
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		if s.compressZero(i) && f.Header.Final40() == 0 {
+			return nil
+		}
+		if _, err := w.Write(f.Header); err != nil {
+			return err
+		}
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		if f.Ptr == nil {
+			return nil
+		}
+		b := *(*[]byte)(f.Ptr)
+		if s.compressZero(i) && allZero(b) {
+			return nil
+		}
+		if _, err := w.Write(f.Header); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	case field.FTString, field.FTBytes, field.FTFixedBytes:
+		if s.zeroTypeCompression && f.Header.Final40() == 0 {
+			return nil
+		}
+		if _, err := w.Write(f.Header); err != nil {
+			return err
+		}
+		if f.Ptr == nil {
+			return nil
+		}
+		if _, err := w.Write(*(*[]byte)(f.Ptr)); err != nil {
+			return err
+		}
+	case field.FTStruct:
+		if err := writeCanonical(w, (*Struct)(f.Ptr), s.mapping.Fields[i].FieldNum); err != nil {
+			return err
+		}
+	case field.FTListBools:
+		b := (*Bools)(f.Ptr)
+		if _, err := w.Write(b.Encode()); err != nil {
+			return err
+		}
+	case field.FTListInt8, field.FTListInt16, field.FTListInt32, field.FTListInt64,
+		field.FTListUint8, field.FTListUint16, field.FTListUint32, field.FTListUint64,
+		field.FTListFloat32, field.FTListFloat64:
+		// The layout of Numbers doesn't change with the type parameter, so we can
+		// look at the encoded data without knowing the real type.
+		n := (*Numbers[uint8])(f.Ptr)
+		if _, err := w.Write(n.Encode()); err != nil {
+			return err
+		}
+	case field.FTListBytes, field.FTListStrings:
+		// An empty list of bytes is still encoded, see Bytes.Encode().
+		b := (*Bytes)(f.Ptr)
+		if _, err := w.Write(b.header); err != nil {
+			return err
+		}
+		for _, item := range b.data {
+			if _, err := w.Write(item); err != nil {
+				return err
+			}
+		}
+	case field.FTListStructs:
+		// An empty list is still encoded, see ClearList().
+		l := (*Structs)(f.Ptr)
+		if _, err := w.Write(l.header); err != nil {
+			return err
+		}
+		for index, item := range l.Slice() {
+			if err := writeCanonical(w, item, uint16(index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("field %d has type %v, which we don't support", s.mapping.Fields[i].FieldNum, s.mapping.Fields[i].Type)
+	}
+	return nil
+}
+
+func allZero(b []byte) bool {
+	for _, u := range b {
+		if u != 0 {
+			return false
+		}
+	}
+	return true
+}
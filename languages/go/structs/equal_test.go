@@ -0,0 +1,184 @@
+package structs
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestEqual(t *testing.T) {
+	m, data := verifyTestData()
+
+	decode := func() *Struct {
+		s, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+
+	other := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+
+	tests := []struct {
+		desc string
+		a    *Struct
+		b    *Struct
+		want bool
+	}{
+		{desc: "both nil", want: true},
+		{desc: "one nil", a: decode(), want: false},
+		{desc: "different mappings", a: New(0, m), b: New(0, other), want: false},
+		{desc: "same values", a: decode(), b: decode(), want: true},
+		{
+			desc: "different scalar",
+			a:    decode(),
+			b: func() *Struct {
+				s := decode()
+				MustSetNumber(s, 0, int32(4))
+				return s
+			}(),
+			want: false,
+		},
+		{
+			desc: "different sub struct",
+			a:    decode(),
+			b: func() *Struct {
+				s := decode()
+				MustSetBool(MustGetStruct(s, 3), 0, false)
+				return s
+			}(),
+			want: false,
+		},
+		{
+			desc: "different list",
+			a:    decode(),
+			b: func() *Struct {
+				s := decode()
+				MustGetListNumber[uint16](s, 5).Set(0, 100)
+				return s
+			}(),
+			want: false,
+		},
+		{
+			desc: "zero value compression: zero value equals unset",
+			a:    New(0, other),
+			b: func() *Struct {
+				s := New(0, other)
+				MustSetNumber(s, 0, int32(0))
+				return s
+			}(),
+			want: true,
+		},
+		{
+			desc: "no zero value compression: zero value does not equal unset",
+			a: func() *Struct {
+				s := New(0, other)
+				s.XXXSetNoZeroTypeCompression()
+				return s
+			}(),
+			b: func() *Struct {
+				s := New(0, other)
+				s.XXXSetNoZeroTypeCompression()
+				MustSetNumber(s, 0, int32(0))
+				return s
+			}(),
+			want: false,
+		},
+	}
+
+	for _, test := range tests {
+		got := Equal(test.a, test.b)
+		if got != test.want {
+			t.Errorf("TestEqual(%s): got %v, want %v", test.desc, got, test.want)
+			continue
+		}
+		// Hashes only cover values, so Structs with different mappings can hash the same.
+		if test.a == nil || test.b == nil || test.a.mapping != test.b.mapping {
+			continue
+		}
+
+		ha, hb := fnv.New128a(), fnv.New128a()
+		if err := HashTo(ha, test.a); err != nil {
+			t.Errorf("TestEqual(%s): HashTo(a) error: %s", test.desc, err)
+			continue
+		}
+		if err := HashTo(hb, test.b); err != nil {
+			t.Errorf("TestEqual(%s): HashTo(b) error: %s", test.desc, err)
+			continue
+		}
+		if gotHash := bytes.Equal(ha.Sum(nil), hb.Sum(nil)); gotHash != test.want {
+			t.Errorf("TestEqual(%s): hashes equal: got %v, want %v", test.desc, gotHash, test.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	m, data := verifyTestData()
+
+	decode := func() *Struct {
+		s, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		desc string
+		a    *Struct
+		b    *Struct
+		want []uint16
+	}{
+		{desc: "both nil"},
+		{desc: "one nil", a: decode(), want: SetFields(decode())},
+		{desc: "same values", a: decode(), b: decode()},
+		{
+			desc: "different scalar and list",
+			a:    decode(),
+			b: func() *Struct {
+				s := decode()
+				MustSetNumber(s, 0, int32(4))
+				MustGetListNumber[uint16](s, 5).Set(0, 100)
+				return s
+			}(),
+			want: []uint16{0, 5},
+		},
+		{
+			desc: "different sub struct",
+			a:    decode(),
+			b: func() *Struct {
+				s := decode()
+				MustSetBool(MustGetStruct(s, 3), 0, false)
+				return s
+			}(),
+			want: []uint16{3},
+		},
+		{
+			desc: "field only set in one",
+			a: func() *Struct {
+				s := decode()
+				if err := DeleteBytes(s, 2); err != nil {
+					panic(err)
+				}
+				return s
+			}(),
+			b:    decode(),
+			want: []uint16{2},
+		},
+	}
+
+	for _, test := range tests {
+		got := Diff(test.a, test.b)
+		if diff := pretty.Compare(test.want, got); diff != "" {
+			t.Errorf("TestDiff(%s): -want/+got:\n%s", test.desc, diff)
+		}
+	}
+}
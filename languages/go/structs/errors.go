@@ -0,0 +1,59 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These errors can be detected with errors.Is() on errors returned by this package.
+var (
+	// ErrFieldNotFound indicates a field number that the Struct does not have, either because it
+	// is beyond the last field or because it is a reserved number.
+	ErrFieldNotFound = errors.New("field not found")
+	// ErrTypeMismatch indicates that a field was accessed with a type that doesn't match the field's type.
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrCorruptData indicates that encoded data could not be decoded. Every *DecodeError is an ErrCorruptData.
+	ErrCorruptData = errors.New("corrupt data")
+	// ErrSizeExceeded indicates that a value or list is larger than the encoding can hold.
+	ErrSizeExceeded = errors.New("size exceeded")
+	// ErrSchemaMismatch indicates that data carried a schema hash that does not match the mapping
+	// it was decoded with. See MarshalOptions.EmbedSchemaHash.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+	// ErrTrailingData indicates that a Struct had bytes inside its declared size, after its last
+	// field, that are not a field at all, such as the zeros left by a corrupt size. A field that is
+	// malformed or out of order is only an ErrCorruptData. It is always returned in a *DecodeError,
+	// so it is also an ErrCorruptData.
+	ErrTrailingData = errors.New("trailing data")
+	// ErrFrozen indicates an attempt to change a Struct that was frozen with Freeze().
+	ErrFrozen = errors.New("frozen")
+	// ErrDeltaUnsupported indicates a change that MarshalDelta() can't write, because UnmarshalMerge()
+	// can't apply it, such as a deleted field.
+	ErrDeltaUnsupported = errors.New("delta unsupported")
+)
+
+// DecodeError is returned when a field in a Struct could not be decoded. Errors for fields in a
+// nested Struct are wrapped by the DecodeError of the field holding that Struct, so errors.As()
+// will return the outermost field.
+type DecodeError struct {
+	// FieldNum is the field number from the field's header.
+	FieldNum uint16
+	// Offset is the offset of the field's header from the start of the Struct that holds it.
+	Offset int
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.Error().
+func (d *DecodeError) Error() string {
+	return fmt.Sprintf("field %d at offset %d: %s", d.FieldNum, d.Offset, d.Err)
+}
+
+// Unwrap returns the underlying error.
+func (d *DecodeError) Unwrap() error {
+	return d.Err
+}
+
+// Is allows errors.Is(err, ErrCorruptData) to match any DecodeError.
+func (d *DecodeError) Is(target error) bool {
+	return target == ErrCorruptData
+}
@@ -0,0 +1,109 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestErrors(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 0},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+
+	tests := []struct {
+		desc string
+		f    func() error
+		want error
+	}{
+		{
+			desc: "field number past the end",
+			f: func() error {
+				_, err := GetNumber[int32](New(0, m), 2)
+				return err
+			},
+			want: ErrFieldNotFound,
+		},
+		{
+			desc: "wrong number type",
+			f: func() error {
+				return SetNumber(New(0, m), 0, int64(1))
+			},
+			want: ErrTypeMismatch,
+		},
+		{
+			desc: "wrong field type",
+			f: func() error {
+				return SetBool(New(0, m), 1, true)
+			},
+			want: ErrTypeMismatch,
+		},
+		{
+			desc: "corrupt header",
+			f: func() error {
+				_, err := NewFromReader(bytes.NewReader(make([]byte, 8)), m)
+				return err
+			},
+			want: ErrCorruptData,
+		},
+		{
+			desc: "Verify() of corrupt data",
+			f: func() error {
+				return Verify(make([]byte, 8), m)
+			},
+			want: ErrCorruptData,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.f()
+		if !errors.Is(err, test.want) {
+			t.Errorf("TestErrors(%s): got err == %v, want errors.Is(err, %v)", test.desc, err, test.want)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 0},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+	s := New(0, m)
+	MustSetNumber(s, 0, int32(1))
+	MustSetBytes(s, 1, []byte("hello"), false)
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestDecodeError: Marshal(): %s", err)
+	}
+	data := buff.Bytes()
+
+	// Change field 1 from Bytes to Bool.
+	GenericHeader(data[16:24]).SetFieldType(field.FTBool)
+
+	_, err := NewFromReader(bytes.NewReader(data), m)
+	if !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestDecodeError: got err == %v, want errors.Is(err, ErrCorruptData)", err)
+	}
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("TestDecodeError: got err == %v, want errors.Is(err, ErrTypeMismatch)", err)
+	}
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("TestDecodeError: got err == %v, want errors.As(err, *DecodeError)", err)
+	}
+	if de.FieldNum != 1 {
+		t.Errorf("TestDecodeError: FieldNum: got %d, want 1", de.FieldNum)
+	}
+	if de.Offset != 16 {
+		t.Errorf("TestDecodeError: Offset: got %d, want 16", de.Offset)
+	}
+}
@@ -0,0 +1,150 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/structs/header"
+)
+
+// ForEachListStruct decodes an encoded list of Structs (a ListStructs field, starting at its header)
+// one item at a time, calling fn with each item in order. If fn returns an error, ForEachListStruct
+// stops and returns it.
+//
+// Item i is not decoded until fn has returned for item i-1. The items are drawn from the pool used by
+// NewWithContext() and each is put back when fn returns, so it is reused for the next item. This bounds
+// memory use to about one item at a time, which is what you want for read-and-forget processing of
+// very large lists. An item must not be used after fn returns unless fn calls Retain() on it. Structs
+// and lists taken from an item that is put back stay valid, but are no longer part of it.
+//
+// Like DecodeParallel(), the items are not attached to a parent, so a retained item can be added
+// to another list with Structs.Append().
+func ForEachListStruct(data []byte, m *mapping.Map, fn func(i int, s *Struct) error) error {
+	if m == nil {
+		return fmt.Errorf("ForEachListStruct() cannot be passed a nil *mapping.Map")
+	}
+
+	elems, err := listStructElements(data)
+	if err != nil {
+		return err
+	}
+
+	r := readers.Get().(*bytes.Reader)
+	defer putReader(r)
+
+	for i, elem := range elems {
+		r.Reset(elem)
+		entry := newItem(m)
+		if _, err := entry.unmarshal(r); err != nil {
+			entry.recycle()
+			return fmt.Errorf("list item %d: %w", i, err)
+		}
+		err := fn(i, entry)
+		releaseItem(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retain keeps s from being put back in the pool when the ForEachListStruct() or DecodeList() callback
+// it was passed to returns, so the callback can keep it. This does nothing to other Structs.
+func (s *Struct) Retain() {
+	s.retained = true
+}
+
+// newItem returns an empty Struct for m from structPool for a callback of ForEachListStruct()
+// or DecodeList(). Put it back with releaseItem().
+func newItem(m *mapping.Map) *Struct {
+	s := structPool.Get().(*Struct)
+	s.reuse(0, m)
+	return s
+}
+
+// releaseItem puts s back in structPool, unless the callback called Retain() on it. A Struct the
+// callback froze can't be emptied, so it is left to the garbage collector.
+func releaseItem(s *Struct) {
+	if s.retained || s.frozen {
+		return
+	}
+	s.recycle()
+}
+
+// DecodeList decodes Structs with mapping m that were written one after another to r, such as a file
+// of records written with Encoder.Write(), calling fn with each in order. These are the items of a
+// list of Structs without the list's header, so the records don't need to be wrapped in a Struct to
+// be read as a list. It returns nil when r ends after a Struct and an error wrapping ErrCorruptData
+// if r ends inside one. If fn returns an error, DecodeList stops and returns it.
+//
+// As with ForEachListStruct(), a Struct is not read from r until fn has returned for the one before
+// it and is put back in the pool when fn returns, unless fn calls Retain() on it. So memory use is
+// about one Struct at a time.
+func DecodeList(r io.Reader, m *mapping.Map, fn func(i int, s *Struct) error) error {
+	if m == nil {
+		return fmt.Errorf("DecodeList() cannot be passed a nil *mapping.Map")
+	}
+
+	h := header.New()
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(r, h)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			return fmt.Errorf("list item %d: %w: could only read %d bytes, a Struct header is always 8 bytes", i, ErrCorruptData, n)
+		case err != nil:
+			return err
+		}
+
+		entry := newItem(m)
+		if _, err := entry.unmarshalWithHeader(h, r); err != nil {
+			entry.recycle()
+			return fmt.Errorf("list item %d: %w", i, err)
+		}
+		err = fn(i, entry)
+		releaseItem(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Transform returns a new list of Structs with mapping m that holds the result of calling fn on each
+// item of src, in order. This is for converting a list from one message shape to another. If fn returns
+// an error, Transform stops and returns it.
+//
+// Items of src that have not been decoded yet (see UnmarshalOptions.LazyListStructs) are decoded one at
+// a time for fn and are not kept in src. The results must have mapping m and not be in another Struct
+// or list, as with NewStructsFromSlice(). Like NewStructs(), the new list is not attached to a Struct
+// until it is set on one.
+func Transform(src *Structs, m *mapping.Map, fn func(i int, s *Struct) (*Struct, error)) (*Structs, error) {
+	if m == nil {
+		return nil, fmt.Errorf("Transform() cannot be passed a nil *mapping.Map")
+	}
+
+	r := readers.Get().(*bytes.Reader)
+	defer putReader(r)
+
+	out := make([]*Struct, src.Len())
+	for i := range out {
+		item := src.data[i]
+		if item == nil {
+			r.Reset(src.raw[i])
+			item = New(0, src.mapping)
+			item.lazyLists = true
+			if _, err := item.unmarshal(r); err != nil {
+				// The item passed verifyStruct(), so the decoder disagrees with it.
+				return nil, fmt.Errorf("bug: list item %d was verified, but could not be decoded: %w", i, err)
+			}
+		}
+		v, err := fn(i, item)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return NewStructsFromSlice(m, out)
+}
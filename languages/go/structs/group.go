@@ -0,0 +1,58 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+)
+
+// MarshalGroup writes out only the fields of s that are in the field group "group", which is set
+// on fields in the .claw file with the [group = name] option. The output is a Struct of the same
+// type that only has those fields set, so it can be decoded with NewFromReader() and the mapping
+// of s and then merged into a full Struct with UnmarshalMerge() or Merge(). Groups only hold top
+// level fields: a Struct field in a group is written with all of its fields.
+//
+// This returns an error wrapping ErrFieldNotFound if no field in the mapping is in group.
+func (s *Struct) MarshalGroup(w io.Writer, group string) (int, error) {
+	p, err := s.group(group)
+	if err != nil {
+		return 0, err
+	}
+	return p.Marshal(w)
+}
+
+// group returns a Struct that shares the fields of s that are in group and has no other fields.
+// The returned Struct must only be read.
+func (s *Struct) group(group string) (*Struct, error) {
+	h := NewGenericHeader()
+	copy(h, s.header)
+
+	p := &Struct{
+		header:              h,
+		mapping:             s.mapping,
+		fields:              make([]StructField, len(s.fields)),
+		structTotal:         new(int64),
+		zeroTypeCompression: s.zeroTypeCompression,
+		partial:             true,
+	}
+
+	found := false
+	total := 8 // the header
+	for i, fd := range s.mapping.Fields {
+		if fd.Group != group {
+			continue
+		}
+		found = true
+		p.fields[i] = s.fields[i]
+		if p.fields[i].Header == nil && fd.Dense {
+			p.fields[i] = denseField(fd)
+		}
+		total += encodedFieldSize(p, i)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: Struct %s has no fields in group %q", ErrFieldNotFound, s.mapping.Name, group)
+	}
+
+	*p.structTotal = int64(total)
+	p.header.SetFinal40(uint64(total))
+	return p, nil
+}
@@ -0,0 +1,125 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestMarshalGroup(t *testing.T) {
+	person := &mapping.Map{
+		Name: "Person",
+		Fields: []*mapping.FieldDescr{
+			{Name: "First", Type: field.FTString},
+		},
+	}
+	car := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString, Group: "summary"},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1, Group: "summary"},
+			{Name: "Miles", Type: field.FTUint64, FieldNum: 2},
+			{Name: "Owner", Type: field.FTStruct, FieldNum: 3, Mapping: person, Group: "owner"},
+			{Name: "Notes", Type: field.FTBytes, FieldNum: 4, Group: "owner"},
+		},
+	}
+	car.MustValidate()
+
+	newCar := func(name string, year uint16, miles uint64, owner string, notes string) *Struct {
+		c := New(0, car)
+		if name != "" {
+			MustSetBytes(c, 0, []byte(name), true)
+		}
+		if year != 0 {
+			MustSetNumber(c, 1, year)
+		}
+		if miles != 0 {
+			MustSetNumber(c, 2, miles)
+		}
+		if owner != "" {
+			p := New(3, person)
+			MustSetBytes(p, 0, []byte(owner), true)
+			MustSetStruct(c, 3, p)
+		}
+		if notes != "" {
+			MustSetBytes(c, 4, []byte(notes), false)
+		}
+		return c
+	}
+
+	tests := []struct {
+		desc   string
+		src    *Struct
+		group  string
+		want   *Struct
+		merged *Struct
+		err    error
+	}{
+		{
+			desc:   "scalar and string fields",
+			src:    newCar("Prius", 2020, 1000, "Alice", "blue"),
+			group:  "summary",
+			want:   newCar("Prius", 2020, 0, "", ""),
+			merged: newCar("Prius", 2020, 5, "Bob", "red"),
+		},
+		{
+			desc:   "Struct and bytes fields",
+			src:    newCar("Prius", 2020, 1000, "Alice", "blue"),
+			group:  "owner",
+			want:   newCar("", 0, 0, "Alice", "blue"),
+			merged: newCar("Camry", 1999, 5, "Alice", "blue"),
+		},
+		{
+			desc:   "group fields not set",
+			src:    newCar("", 0, 1000, "", ""),
+			group:  "owner",
+			want:   newCar("", 0, 0, "", ""),
+			merged: newCar("Camry", 1999, 5, "Bob", "red"),
+		},
+		{
+			desc:  "Error: no fields in group",
+			src:   newCar("Prius", 2020, 1000, "Alice", "blue"),
+			group: "engine",
+			err:   ErrFieldNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		buff := &bytes.Buffer{}
+		n, err := test.src.MarshalGroup(buff, test.group)
+		switch {
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestMarshalGroup(%s): got err == %v, want err == %v", test.desc, err, test.err)
+			continue
+		case test.err == nil && err != nil:
+			t.Errorf("TestMarshalGroup(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+		if n != buff.Len() {
+			t.Errorf("TestMarshalGroup(%s): got n == %d, but wrote %d bytes", test.desc, n, buff.Len())
+		}
+
+		got, err := NewFromReader(bytes.NewReader(buff.Bytes()), car)
+		if err != nil {
+			t.Errorf("TestMarshalGroup(%s): could not decode the output: %s", test.desc, err)
+			continue
+		}
+		if !Equal(got, test.want) {
+			t.Errorf("TestMarshalGroup(%s): decoded Struct did not only hold the group's fields", test.desc)
+		}
+
+		dst := newCar("Camry", 1999, 5, "Bob", "red")
+		if err := dst.UnmarshalMerge(bytes.NewReader(buff.Bytes())); err != nil {
+			t.Errorf("TestMarshalGroup(%s): UnmarshalMerge() error: %s", test.desc, err)
+			continue
+		}
+		if !Equal(dst, test.merged) {
+			t.Errorf("TestMarshalGroup(%s): UnmarshalMerge() did not give the merged Struct", test.desc)
+		}
+	}
+}
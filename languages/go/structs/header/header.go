@@ -1,3 +1,12 @@
+// Package header reads and writes the 8 byte header that starts every field in the Claw wire
+// format. The layout is described in docs/encoding/encoding.md:
+//
+//	2 bytes: the field number
+//	1 byte:  the field type
+//	5 bytes: the final 40 bits, which hold a value, a size or an item count depending on the type
+//
+// Encode() and Decode() are a stable API for tools that build or inspect encoded data directly.
+// They check their input and return errors instead of panicking.
 package header
 
 import (
@@ -9,6 +18,12 @@ import (
 	"github.com/bearlytools/claw/languages/go/field"
 )
 
+// Size is the size of a header in bytes.
+const Size = 8
+
+// MaxFinal40 is the largest value the final 40 bits of a header can hold.
+const MaxFinal40 = maxDataSize
+
 const maxDataSize = 1099511627775
 
 // Masks to use to pull information from a bitpacked uint64.
@@ -59,3 +74,27 @@ func (g Generic) SetFinal40(u uint64) {
 	n := conversions.BytesToNum[uint64](g[0:8])
 	*n = bits.SetValue(u, *n, 24, 64)
 }
+
+// Encode writes a header with fieldNum, fieldType and final40 to the first Size bytes of buf.
+func Encode(buf []byte, fieldNum uint16, fieldType field.Type, final40 uint64) error {
+	if len(buf) < Size {
+		return fmt.Errorf("a header needs %d bytes, but the buffer is %d bytes", Size, len(buf))
+	}
+	if final40 > MaxFinal40 {
+		return fmt.Errorf("final40 can be at most %d, was %d", uint64(MaxFinal40), final40)
+	}
+	g := Generic(buf[:Size])
+	g.SetFieldNum(fieldNum)
+	g.SetFieldType(fieldType)
+	g.SetFinal40(final40)
+	return nil
+}
+
+// Decode reads the header in the first Size bytes of buf.
+func Decode(buf []byte) (fieldNum uint16, fieldType field.Type, final40 uint64, err error) {
+	if len(buf) < Size {
+		return 0, 0, 0, fmt.Errorf("a header needs %d bytes, but the buffer is %d bytes", Size, len(buf))
+	}
+	g := Generic(buf[:Size])
+	return g.FieldNum(), g.FieldType(), g.Final40(), nil
+}
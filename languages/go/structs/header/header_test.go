@@ -0,0 +1,57 @@
+package header
+
+import (
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		desc      string
+		buf       []byte
+		fieldNum  uint16
+		fieldType field.Type
+		final40   uint64
+		wantErr   bool
+	}{
+		{desc: "zero values", buf: make([]byte, 8)},
+		{desc: "max values", buf: make([]byte, 8), fieldNum: 65535, fieldType: field.FTListStructs, final40: MaxFinal40},
+		{desc: "longer buffer", buf: make([]byte, 16), fieldNum: 3, fieldType: field.FTString, final40: 11},
+		{desc: "short buffer", buf: make([]byte, 7), fieldNum: 1, fieldType: field.FTBool, wantErr: true},
+		{desc: "final40 too big", buf: make([]byte, 8), fieldType: field.FTBytes, final40: MaxFinal40 + 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := Encode(test.buf, test.fieldNum, test.fieldType, test.final40)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestEncodeDecode(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestEncodeDecode(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		for i, b := range test.buf[Size:] {
+			if b != 0 {
+				t.Errorf("TestEncodeDecode(%s): byte %d after the header was written", test.desc, Size+i)
+			}
+		}
+
+		fieldNum, fieldType, final40, err := Decode(test.buf)
+		if err != nil {
+			t.Errorf("TestEncodeDecode(%s): Decode(): %s", test.desc, err)
+			continue
+		}
+		if fieldNum != test.fieldNum || fieldType != test.fieldType || final40 != test.final40 {
+			t.Errorf("TestEncodeDecode(%s): got (%d, %v, %d), want (%d, %v, %d)", test.desc, fieldNum, fieldType, final40, test.fieldNum, test.fieldType, test.final40)
+		}
+	}
+
+	if _, _, _, err := Decode(make([]byte, 4)); err == nil {
+		t.Errorf("TestEncodeDecode(Decode short buffer): got err == nil, want err != nil")
+	}
+}
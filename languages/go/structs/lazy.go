@@ -0,0 +1,313 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bearlytools/claw/internal/binary"
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// LazyStruct gives access to the fields of an encoded Struct that is held in an io.ReaderAt, such
+// as an *os.File, without reading the whole Struct into memory. Use OpenAt() to create one and the
+// LazyGet*() functions to read fields.
+//
+// A LazyStruct is not safe for concurrent use.
+type LazyStruct struct {
+	r       io.ReaderAt
+	mapping *mapping.Map
+	// fields holds where each field in the mapping is in r, at the field's index in the mapping's
+	// Fields (see mapping.Map.Index()). OpenAt() only
+	// accepts data whose fields are in field number order and inside the Struct, so the fields are
+	// sorted by offset and don't overlap.
+	fields []lazyField
+	// s holds the fields that have been read.
+	s *Struct
+}
+
+// lazyField is where a field is found in the io.ReaderAt. size is 0 if the field isn't encoded.
+// final40 is the Final40() of the field's header, which is the item count of a list.
+type lazyField struct {
+	offset  int64
+	size    int64
+	final40 uint64
+	loaded  bool
+}
+
+// OpenAt opens the encoded Struct described by m that starts at offset 0 in r, which has size bytes.
+// Only the Struct's header and the header of each field are read, which gives us where each field
+// is. A field's data is only read when it is asked for. Fields that m does not describe are skipped.
+//
+// If the data starts with a schema hash (see MarshalOptions.EmbedSchemaHash), it must match m or an
+// ErrSchemaMismatch is returned. Errors about the data are an ErrCorruptData.
+//
+// r must not change while the LazyStruct is in use.
+func OpenAt(r io.ReaderAt, size int64, m *mapping.Map) (*LazyStruct, error) {
+	if m == nil {
+		return nil, fmt.Errorf("OpenAt() cannot be passed a nil *mapping.Map")
+	}
+
+	var start int64
+	h := GenericHeader(make([]byte, 8))
+	if err := readAt(r, h, 0); err != nil {
+		return nil, err
+	}
+	if h.FieldType() == field.FTSchemaHash {
+		if h.Final40() != 16 {
+			return nil, fmt.Errorf("%w: schema hash preamble must have size 16, had %d", ErrCorruptData, h.Final40())
+		}
+		b := make([]byte, 8)
+		if err := readAt(r, b, 8); err != nil {
+			return nil, err
+		}
+		if got, want := binary.Get[uint64](b), m.SchemaHash(); got != want {
+			return nil, fmt.Errorf("%w: data has schema hash %x, but %s has schema hash %x", ErrSchemaMismatch, got, m.Name, want)
+		}
+		start = 16
+		if err := readAt(r, h, start); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.FieldType() != field.FTStruct {
+		return nil, fmt.Errorf("%w: expecting Struct, got %v", ErrCorruptData, h.FieldType())
+	}
+	structSize := int64(h.Final40())
+	if structSize < 8 || structSize%8 != 0 {
+		return nil, fmt.Errorf("%w: Struct malformed: must have a size divisible by 8, was %d", ErrCorruptData, structSize)
+	}
+	if start+structSize > size {
+		return nil, fmt.Errorf("%w: Struct has size %d, but only %d bytes remain", ErrCorruptData, structSize, size-start)
+	}
+
+	l := &LazyStruct{
+		r:       r,
+		mapping: m,
+		fields:  make([]lazyField, len(m.Fields)),
+		s:       New(0, m),
+	}
+
+	end := start + structSize
+	offset := start + 8
+	lastNum := -1
+	for offset < end {
+		if end-offset < 8 {
+			return nil, fmt.Errorf("%w: field at offset %d: not enough room for a field header", ErrCorruptData, offset)
+		}
+		fh := GenericHeader(make([]byte, 8))
+		if err := readAt(r, fh, offset); err != nil {
+			return nil, err
+		}
+		fieldNum := int(fh.FieldNum())
+		if fieldNum <= lastNum {
+			return nil, fmt.Errorf("%w: field at offset %d: field %d came after field %d", ErrCorruptData, offset, fieldNum, lastNum)
+		}
+		lastNum = fieldNum
+
+		n, err := lazyFieldSize(r, fh, offset, end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %d at offset %d: %s", ErrCorruptData, fieldNum, offset, err)
+		}
+
+		if fd := m.FieldByNumber(uint16(fieldNum)); fd != nil {
+			if !wireTypeMatches(fh.FieldType(), fd.Type) {
+				return nil, fmt.Errorf("%w: field %d at offset %d: has wire type %v, but mapping says %v", ErrTypeMismatch, fieldNum, offset, fh.FieldType(), fd.Type)
+			}
+			l.fields[m.Index(uint16(fieldNum))] = lazyField{offset: offset, size: n, final40: fh.Final40()}
+		}
+		offset += n
+	}
+	return l, nil
+}
+
+// IsSet returns true if field "fieldNum" is in the encoded data.
+func (l *LazyStruct) IsSet(fieldNum uint16) bool {
+	idx := l.mapping.Index(fieldNum)
+	if idx < 0 {
+		return false
+	}
+	return l.fields[idx].size != 0
+}
+
+// LazyListLen is like ListLen(), but for a LazyStruct. The count comes from the list's header,
+// which OpenAt() already read, so nothing is read from the io.ReaderAt.
+func LazyListLen(l *LazyStruct, fieldNum uint16) (int, bool) {
+	if !l.IsSet(fieldNum) {
+		return 0, false
+	}
+	idx := l.mapping.Index(fieldNum)
+	if !field.IsList(l.mapping.Fields[idx].Type) {
+		return 0, false
+	}
+	return int(l.fields[idx].final40), true
+}
+
+// load reads and decodes field "fieldNum" if it is encoded and we haven't already read it.
+func (l *LazyStruct) load(fieldNum uint16) error {
+	idx, err := validateFieldNum(fieldNum, l.mapping)
+	if err != nil {
+		return err
+	}
+	lf := l.fields[idx]
+	if lf.loaded || lf.size == 0 {
+		return nil
+	}
+
+	b := make([]byte, lf.size)
+	if err := readAt(l.r, b, lf.offset); err != nil {
+		return err
+	}
+	if err := l.s.decodeField(&b, fieldNum, GenericHeader(b[:8]).FieldType()); err != nil {
+		return &DecodeError{FieldNum: fieldNum, Offset: int(lf.offset), Err: err}
+	}
+	l.fields[idx].loaded = true
+	return nil
+}
+
+// LazyGetBool is like GetBool(), but for a LazyStruct.
+func LazyGetBool(l *LazyStruct, fieldNum uint16) (bool, error) {
+	if err := l.load(fieldNum); err != nil {
+		return false, err
+	}
+	return GetBool(l.s, fieldNum)
+}
+
+// LazyGetNumber is like GetNumber(), but for a LazyStruct.
+func LazyGetNumber[N Number](l *LazyStruct, fieldNum uint16) (N, error) {
+	if err := l.load(fieldNum); err != nil {
+		return 0, err
+	}
+	return GetNumber[N](l.s, fieldNum)
+}
+
+// LazyGetBytes is like GetBytes(), but for a LazyStruct.
+func LazyGetBytes(l *LazyStruct, fieldNum uint16) (*[]byte, error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetBytes(l.s, fieldNum)
+}
+
+// LazyGetStruct is like GetStruct(), but for a LazyStruct. The returned Struct is fully decoded.
+func LazyGetStruct(l *LazyStruct, fieldNum uint16) (*Struct, error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetStruct(l.s, fieldNum)
+}
+
+// LazyGetListBool is like GetListBool(), but for a LazyStruct.
+func LazyGetListBool(l *LazyStruct, fieldNum uint16) (*Bools, error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetListBool(l.s, fieldNum)
+}
+
+// LazyGetListNumber is like GetListNumber(), but for a LazyStruct.
+func LazyGetListNumber[N Number](l *LazyStruct, fieldNum uint16) (*Numbers[N], error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetListNumber[N](l.s, fieldNum)
+}
+
+// LazyGetListBytes is like GetListBytes(), but for a LazyStruct.
+func LazyGetListBytes(l *LazyStruct, fieldNum uint16) (*Bytes, error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetListBytes(l.s, fieldNum)
+}
+
+// LazyGetListStruct is like GetListStruct(), but for a LazyStruct. The returned Structs are fully decoded.
+func LazyGetListStruct(l *LazyStruct, fieldNum uint16) (*Structs, error) {
+	if err := l.load(fieldNum); err != nil {
+		return nil, err
+	}
+	return GetListStruct(l.s, fieldNum)
+}
+
+// lazyFieldSize returns the encoded size of the field with header h at offset in r. The field
+// must end by end. This reads the headers of list items when their sizes aren't in h.
+func lazyFieldSize(r io.ReaderAt, h GenericHeader, offset, end int64) (int64, error) {
+	final40 := int64(h.Final40())
+	var size int64
+
+	switch h.FieldType() {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		size = 8
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		size = 16
+	case field.FTString, field.FTBytes, field.FTFixedBytes:
+		size = 8 + SizeWithPadding(final40)
+	case field.FTStruct:
+		if final40 < 8 || final40%8 != 0 {
+			return 0, fmt.Errorf("Struct malformed: must have a size divisible by 8, was %d", final40)
+		}
+		size = final40
+	case field.FTListBools:
+		size = 8 + 8*((final40+63)/64)
+	case field.FTListInt8, field.FTListUint8, field.FTListInt16, field.FTListUint16,
+		field.FTListInt32, field.FTListUint32, field.FTListFloat32,
+		field.FTListInt64, field.FTListUint64, field.FTListFloat64:
+		if final40 > end-offset { // Every item is at least a byte.
+			return 0, fmt.Errorf("list of numbers has %d items, but only %d bytes remain", final40, end-offset)
+		}
+		size = 8 + int64(wordsRequiredToStore(int(final40), numberListItemSize(h.FieldType())))*8
+	case field.FTListBytes, field.FTListStrings:
+		read := int64(8)
+		b := make([]byte, 4)
+		for i := int64(0); i < final40; i++ {
+			if end-(offset+read) < 4 {
+				return 0, fmt.Errorf("list of bytes item %d did not have a valid header", i)
+			}
+			if err := readAt(r, b, offset+read); err != nil {
+				return 0, err
+			}
+			read += 4 + int64(binary.Get[uint32](b))
+			if offset+read > end {
+				return 0, fmt.Errorf("list of bytes item %d did not have enough data to match the header", i)
+			}
+		}
+		size = SizeWithPadding(read)
+	case field.FTListStructs:
+		read := int64(8)
+		ih := GenericHeader(make([]byte, 8))
+		for i := int64(0); i < final40; i++ {
+			if end-(offset+read) < 8 {
+				return 0, fmt.Errorf("list of structs item %d did not have a valid header", i)
+			}
+			if err := readAt(r, ih, offset+read); err != nil {
+				return 0, err
+			}
+			n := int64(ih.Final40())
+			if ih.FieldType() != field.FTStruct || n < 8 || n%8 != 0 {
+				return 0, fmt.Errorf("list of structs item %d was not a valid Struct", i)
+			}
+			read += n
+		}
+		size = read
+	default:
+		return 0, fmt.Errorf("got field type %v that we don't support", h.FieldType())
+	}
+
+	if offset+size > end {
+		return 0, fmt.Errorf("field of type %v needs %d bytes, but only %d remain", h.FieldType(), size, end-offset)
+	}
+	return size, nil
+}
+
+// readAt fills b from r starting at offset. Running out of data is an ErrCorruptData.
+func readAt(r io.ReaderAt, b []byte, offset int64) error {
+	n, err := r.ReadAt(b, offset)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return fmt.Errorf("%w: needed %d bytes at offset %d, but could only read %d", ErrCorruptData, len(b), offset, n)
+	}
+	return err
+}
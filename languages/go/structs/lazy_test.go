@@ -0,0 +1,109 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// countingReaderAt counts the bytes read from it.
+type countingReaderAt struct {
+	r    *bytes.Reader
+	read int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += n
+	return n, err
+}
+
+func TestOpenAt(t *testing.T) {
+	m, data := verifyTestData()
+
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	l, err := OpenAt(r, int64(len(data)), m)
+	if err != nil {
+		t.Fatalf("TestOpenAt: OpenAt() error: %s", err)
+	}
+	opened := r.read
+
+	u64, err := LazyGetNumber[uint64](l, 1)
+	if err != nil || u64 != 1<<40 {
+		t.Errorf("TestOpenAt(Uint64): got (%d, %v), want (%d, nil)", u64, err, uint64(1<<40))
+	}
+	if got := r.read - opened; got != 16 {
+		t.Errorf("TestOpenAt(Uint64): read %d bytes, want 16", got)
+	}
+	// A second get must not read again.
+	LazyGetNumber[uint64](l, 1)
+	if got := r.read - opened; got != 16 {
+		t.Errorf("TestOpenAt(Uint64 again): read %d bytes, want 16", got)
+	}
+
+	if got, err := LazyGetNumber[int32](l, 0); err != nil || got != -3 {
+		t.Errorf("TestOpenAt(Int32): got (%d, %v), want (-3, nil)", got, err)
+	}
+	if got, err := LazyGetBytes(l, 2); err != nil || string(*got) != "hello" {
+		t.Errorf("TestOpenAt(Bytes): got (%v, %v), want (hello, nil)", got, err)
+	}
+	sub, err := LazyGetStruct(l, 3)
+	if err != nil || !MustGetBool(sub, 0) {
+		t.Errorf("TestOpenAt(Sub): got error %v or Bool == false, want Bool == true", err)
+	}
+	bools, err := LazyGetListBool(l, 4)
+	if err != nil || bools.Len() != 3 || !bools.Get(2) {
+		t.Errorf("TestOpenAt(ListBools): got error %v or wrong list", err)
+	}
+	nums, err := LazyGetListNumber[uint16](l, 5)
+	if err != nil || nums.Len() != 5 || nums.Get(4) != 5 {
+		t.Errorf("TestOpenAt(ListUint16): got error %v or wrong list", err)
+	}
+	lb, err := LazyGetListBytes(l, 6)
+	if err != nil || lb.Len() != 2 || string(lb.Get(1)) != "ever" {
+		t.Errorf("TestOpenAt(ListBytes): got error %v or wrong list", err)
+	}
+	ls, err := LazyGetListStruct(l, 7)
+	if err != nil || ls.Len() != 2 {
+		t.Errorf("TestOpenAt(ListStructs): got error %v or wrong list", err)
+	}
+
+	for i := uint16(0); i < 8; i++ {
+		if !l.IsSet(i) {
+			t.Errorf("TestOpenAt: IsSet(%d) was false, want true", i)
+		}
+	}
+	if _, err := LazyGetBool(l, 8); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("TestOpenAt(field not in mapping): got err == %v, want ErrFieldNotFound", err)
+	}
+}
+
+func TestOpenAtErrors(t *testing.T) {
+	m, data := verifyTestData()
+
+	// Uint64 (field 1, at offset 16) says it is field 0, which is before Int32.
+	outOfOrder := append([]byte{}, data...)
+	GenericHeader(outOfOrder[16:24]).SetFieldNum(0)
+	// The Struct says it ends in the middle of Uint64.
+	overrun := append([]byte{}, data...)
+	GenericHeader(overrun[:8]).SetFinal40(24)
+
+	tests := []struct {
+		desc string
+		data []byte
+		size int64
+	}{
+		{desc: "empty", data: nil, size: 0},
+		{desc: "truncated", data: data[:len(data)-8], size: int64(len(data) - 8)},
+		{desc: "size too small", data: data, size: int64(len(data) - 8)},
+		{desc: "fields out of order", data: outOfOrder, size: int64(len(data))},
+		{desc: "field ends after the Struct", data: overrun, size: int64(len(data))},
+	}
+
+	for _, test := range tests {
+		_, err := OpenAt(bytes.NewReader(test.data), test.size, m)
+		if !errors.Is(err, ErrCorruptData) {
+			t.Errorf("TestOpenAtErrors(%s): got err == %v, want ErrCorruptData", test.desc, err)
+		}
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"io"
 	"log"
 	"math"
+	"reflect"
+	"sync"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/bearlytools/claw/internal/binary"
 	"github.com/bearlytools/claw/internal/bits"
@@ -24,6 +27,52 @@ type Number interface {
 	constraints.Integer | constraints.Float
 }
 
+// List is implemented by every list type: Bools, Numbers, Bytes, Strings and Structs. It is the
+// lowest common denominator of the lists and allows writing code, such as pagination or length
+// checks, that works on any list. Use the list's own type to get items without type assertions.
+type List interface {
+	// Len returns the number of items in the list.
+	Len() int
+	// Any returns the item at index as an any. The type is the same as the list's Get() returns.
+	// This panics if the index is out of range.
+	Any(index int) any
+}
+
+var (
+	_ List = &Bools{}
+	_ List = &Numbers[uint8]{}
+	_ List = &Bytes{}
+	_ List = Strings{}
+	_ List = &Structs{}
+)
+
+// ListLen returns the number of items in the list held in field fieldNum of s. It returns false
+// if the field is not set or is not a list. This works for every type of list, so code that only
+// needs a count, such as for pagination, doesn't need to know the list's type. See LazyListLen()
+// to get the count from encoded data without decoding the list.
+func ListLen(s *Struct, fieldNum uint16) (int, bool) {
+	idx := s.mapping.Index(fieldNum)
+	if idx < 0 {
+		return 0, false
+	}
+	f := s.fields[idx]
+	if f.Header == nil {
+		return 0, false
+	}
+	switch ft := s.mapping.Fields[idx].Type; {
+	case ft == field.FTListBools:
+		return (*Bools)(f.Ptr).Len(), true
+	case ft >= field.FTListInt8 && ft <= field.FTListFloat64:
+		// The layout of Numbers does not depend on the type of number.
+		return (*Numbers[uint8])(f.Ptr).Len(), true
+	case ft == field.FTListBytes || ft == field.FTListStrings:
+		return (*Bytes)(f.Ptr).Len(), true
+	case ft == field.FTListStructs:
+		return (*Structs)(f.Ptr).Len(), true
+	}
+	return 0, false
+}
+
 // Bools is a wrapper around a list of boolean values.
 type Bools struct {
 	data []byte // Includes the header
@@ -40,7 +89,10 @@ func NewBools(fieldNum uint16) *Bools {
 	h.SetFieldNum(fieldNum)
 	h.SetFieldType(field.FTListBools)
 
+	// Lists that were garbage collected are put back in the pool as they were.
 	b.data = h
+	b.len = 0
+	b.s = nil
 
 	return b
 }
@@ -53,12 +105,9 @@ func NewBoolsFromBytes(data *[]byte, s *Struct) (GenericHeader, *Bools, error) {
 	}
 
 	h := GenericHeader((*data)[:8])
-	items := h.Final40()
-	if items == 0 {
-		return nil, nil, fmt.Errorf("Struct.decodeListBool() header had item count == 0, which is not allowed")
-	}
+	items := h.Final40() // A list with zero items was set, but empty. See ClearList().
 
-	wordsNeeded := (items / 64) + 1
+	wordsNeeded := (items + 63) / 64
 	if len((*data)[8:]) < int(wordsNeeded)*8 {
 		return nil, nil, fmt.Errorf("malformed: list of boolean: header had data size not consistend with message")
 	}
@@ -80,7 +129,8 @@ func (b *Bools) Len() int {
 	return b.len
 }
 
-// Get gets a value in the list[pos].
+// Get gets a value in the list[pos]. This panics if pos is out of range, use TryGet() if
+// the index may not be valid.
 func (b *Bools) Get(index int) bool {
 	data := b.data[8:]
 
@@ -95,6 +145,19 @@ func (b *Bools) Get(index int) bool {
 	return bits.GetBit(i, uint8(indexInSlice))
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b *Bools) TryGet(index int) (value bool, ok bool) {
+	if index < 0 || index >= b.Len() {
+		return false, false
+	}
+	return b.Get(index), true
+}
+
+// Any implements List.Any().
+func (b *Bools) Any(index int) any {
+	return b.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak.
@@ -135,6 +198,9 @@ func (b *Bools) Range(ctx context.Context, from, to int) chan bool {
 
 // Set a boolean in position "pos" to "val".
 func (b *Bools) Set(index int, val bool) {
+	if err := b.s.checkFrozen(); err != nil {
+		panic(err)
+	}
 	data := b.data[8:]
 
 	if index >= b.len {
@@ -149,6 +215,10 @@ func (b *Bools) Set(index int, val bool) {
 	// Modify the bits and set it.
 	i = bits.SetBit(i, uint8(indexInSlice), val)
 	data[sliceNum] = i
+	if b.s != nil {
+		b.s.markModified()
+		b.s.markDirty(GenericHeader(b.data[:8]).FieldNum())
+	}
 }
 
 func (b *Bools) cap() int {
@@ -157,6 +227,9 @@ func (b *Bools) cap() int {
 
 // Append appends values to the list of bools.
 func (b *Bools) Append(i ...bool) {
+	if err := b.s.checkFrozen(); err != nil {
+		panic(err)
+	}
 	oldSize := len(b.data)
 
 	requiredCap := b.len + len(i) // in bits
@@ -226,46 +299,46 @@ func NewNumbers[I Number]() *Numbers[I] {
 	var sizeInBytes uint8
 	var isFloat bool
 	var ft field.Type
-	switch any(t).(type) {
+	switch numberType[I]().(type) {
 	case uint8:
-		n = pool.Get(nUint8Pool).(*Numbers[I])
+		n = getNumbers[I](nUint8Pool)
 		sizeInBytes = 1
 		ft = field.FTListUint8
 	case uint16:
-		n = pool.Get(nUint16Pool).(*Numbers[I])
+		n = getNumbers[I](nUint16Pool)
 		sizeInBytes = 2
 		ft = field.FTListUint16
 	case uint32:
-		n = pool.Get(nUint32Pool).(*Numbers[I])
+		n = getNumbers[I](nUint32Pool)
 		sizeInBytes = 4
 		ft = field.FTListUint32
 	case uint64:
-		n = pool.Get(nUint64Pool).(*Numbers[I])
+		n = getNumbers[I](nUint64Pool)
 		sizeInBytes = 8
 		ft = field.FTListUint64
 	case int8:
-		n = pool.Get(nInt8Pool).(*Numbers[I])
+		n = getNumbers[I](nInt8Pool)
 		sizeInBytes = 1
 		ft = field.FTListInt8
 	case int16:
-		n = pool.Get(nInt16Pool).(*Numbers[I])
+		n = getNumbers[I](nInt16Pool)
 		sizeInBytes = 2
 		ft = field.FTListInt16
 	case int32:
-		n = pool.Get(nInt32Pool).(*Numbers[I])
+		n = getNumbers[I](nInt32Pool)
 		sizeInBytes = 4
 		ft = field.FTListInt32
 	case int64:
-		n = pool.Get(nInt64Pool).(*Numbers[I])
+		n = getNumbers[I](nInt64Pool)
 		sizeInBytes = 8
 		ft = field.FTListInt64
 	case float32:
-		n = pool.Get(nFloat32Pool).(*Numbers[I])
+		n = getNumbers[I](nFloat32Pool)
 		sizeInBytes = 4
 		isFloat = true
 		ft = field.FTListFloat32
 	case float64:
-		n = pool.Get(nFloat64Pool).(*Numbers[I])
+		n = getNumbers[I](nFloat64Pool)
 		sizeInBytes = 8
 		isFloat = true
 		ft = field.FTListFloat64
@@ -275,13 +348,75 @@ func NewNumbers[I Number]() *Numbers[I] {
 	h := NewGenericHeader()
 	h.SetFieldType(ft)
 
+	// Lists that were garbage collected are put back in the pool as they were.
 	n.sizeInBytes = sizeInBytes
 	n.isFloat = isFloat
 	n.data = h
+	n.len = 0
+	n.s = nil
 
 	return n
 }
 
+// numberTypes caches numberType() for types defined from a number, by reflect.Type.
+var numberTypes sync.Map
+
+// numberType returns the zero value of the type that N is defined from. This allows types defined
+// from a number, such as enums, to be used where we switch on the type of number. Only types defined
+// from a number need reflection, which is done once for each type.
+func numberType[N Number]() any {
+	var t N
+	switch any(t).(type) {
+	case uint8, uint16, uint32, uint64, int8, int16, int32, int64, float32, float64:
+		return t
+	}
+	typ := reflect.TypeOf(t)
+	if v, ok := numberTypes.Load(typ); ok {
+		return v
+	}
+	v := numberKind(typ.Kind(), t)
+	numberTypes.Store(typ, v)
+	return v
+}
+
+// numberKind returns the zero value of the number type with kind k, or t if k is not a number.
+func numberKind(k reflect.Kind, t any) any {
+	switch k {
+	case reflect.Uint8:
+		return uint8(0)
+	case reflect.Uint16:
+		return uint16(0)
+	case reflect.Uint32:
+		return uint32(0)
+	case reflect.Uint64:
+		return uint64(0)
+	case reflect.Int8:
+		return int8(0)
+	case reflect.Int16:
+		return int16(0)
+	case reflect.Int32:
+		return int32(0)
+	case reflect.Int64:
+		return int64(0)
+	case reflect.Float32:
+		return float32(0)
+	case reflect.Float64:
+		return float64(0)
+	}
+	return t
+}
+
+// getNumbers gets a *Numbers from the pool with id. The pool may hold a Numbers of a different
+// type than I, but with the same underlying type (an enum instead of a uint8), which is safe
+// because the layout of Numbers doesn't depend on I.
+func getNumbers[I Number](id int) *Numbers[I] {
+	x := pool.Get(id)
+	if n, ok := x.(*Numbers[I]); ok {
+		return n
+	}
+	return (*Numbers[I])(reflect.ValueOf(x).UnsafePointer())
+}
+
 func wordsRequiredToStore(items, sizeInBytes int) int {
 	required := (sizeInBytes * items)
 	words := required / 8
@@ -299,47 +434,44 @@ func NewNumbersFromBytes[I Number](data *[]byte, s *Struct) (*Numbers[I], error)
 	}
 
 	h := GenericHeader((*data)[:8])
-	items := h.Final40()
-	if items == 0 {
-		return nil, fmt.Errorf("list of Numbers had zero items, which is an encoding error")
-	}
+	items := h.Final40() // A list with zero items was set, but empty. See ClearList().
 
 	var t I
 
 	var n *Numbers[I]
 	var sizeInBytes uint8
 	var isFloat bool
-	switch any(t).(type) {
+	switch numberType[I]().(type) {
 	case uint8:
-		n = pool.Get(nUint8Pool).(*Numbers[I])
+		n = getNumbers[I](nUint8Pool)
 		sizeInBytes = 1
 	case uint16:
-		n = pool.Get(nUint16Pool).(*Numbers[I])
+		n = getNumbers[I](nUint16Pool)
 		sizeInBytes = 2
 	case uint32:
-		n = pool.Get(nUint32Pool).(*Numbers[I])
+		n = getNumbers[I](nUint32Pool)
 		sizeInBytes = 4
 	case uint64:
-		n = pool.Get(nUint64Pool).(*Numbers[I])
+		n = getNumbers[I](nUint64Pool)
 		sizeInBytes = 8
 	case int8:
-		n = pool.Get(nInt8Pool).(*Numbers[I])
+		n = getNumbers[I](nInt8Pool)
 		sizeInBytes = 1
 	case int16:
-		n = pool.Get(nInt16Pool).(*Numbers[I])
+		n = getNumbers[I](nInt16Pool)
 		sizeInBytes = 2
 	case int32:
-		n = pool.Get(nInt32Pool).(*Numbers[I])
+		n = getNumbers[I](nInt32Pool)
 		sizeInBytes = 4
 	case int64:
-		n = pool.Get(nInt64Pool).(*Numbers[I])
+		n = getNumbers[I](nInt64Pool)
 		sizeInBytes = 8
 	case float32:
-		n = pool.Get(nFloat32Pool).(*Numbers[I])
+		n = getNumbers[I](nFloat32Pool)
 		sizeInBytes = 4
 		isFloat = true
 	case float64:
-		n = pool.Get(nFloat64Pool).(*Numbers[I])
+		n = getNumbers[I](nFloat64Pool)
 		sizeInBytes = 8
 		isFloat = true
 	default:
@@ -355,7 +487,8 @@ func NewNumbersFromBytes[I Number](data *[]byte, s *Struct) (*Numbers[I], error)
 	}
 
 	rightBound := (8 * requiredWords) + 8 // datasize(8 * requiredWords) + header(8)
-	n.data = (*data)[0:rightBound]
+	// Limit the capacity, so that Append() can't write over the data that follows.
+	n.data = (*data)[0:rightBound:rightBound]
 	n.len = int(items)
 	n.s = s
 	XXXAddToTotal(s, len(n.data))
@@ -371,7 +504,8 @@ func (n *Numbers[I]) Len() int {
 	return n.len
 }
 
-// Get gets a number stored at the index.
+// Get gets a number stored at the index. This panics if index is out of range, use TryGet()
+// if the index may not be valid.
 func (n *Numbers[I]) Get(index int) I {
 	data := n.data[8:]
 
@@ -408,6 +542,19 @@ func (n *Numbers[I]) Get(index int) I {
 	panic("should never get here")
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (n *Numbers[I]) TryGet(index int) (value I, ok bool) {
+	if index < 0 || index >= n.Len() {
+		return 0, false
+	}
+	return n.Get(index), true
+}
+
+// Any implements List.Any().
+func (n *Numbers[I]) Any(index int) any {
+	return n.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak.
@@ -448,11 +595,18 @@ func (n *Numbers[I]) Range(ctx context.Context, from, to int) chan I {
 
 // Set a number in position "index" to "value".
 func (n *Numbers[I]) Set(index int, value I) {
+	if err := n.s.checkFrozen(); err != nil {
+		panic(err)
+	}
 	data := n.data[8:]
 
 	if index >= n.len {
 		panic(fmt.Sprintf("lists.Number with len %d cannot have position %d set", n.len, index))
 	}
+	if n.s != nil {
+		n.s.markModified()
+		n.s.markDirty(GenericHeader(n.data[:8]).FieldNum())
+	}
 
 	start := index * int(n.sizeInBytes)
 
@@ -487,6 +641,9 @@ func (n *Numbers[I]) Set(index int, value I) {
 
 // Append appends values to the list of numbers.
 func (n *Numbers[I]) Append(i ...I) {
+	if err := n.s.checkFrozen(); err != nil {
+		panic(err)
+	}
 	oldSize := len(n.data)
 	defer func() {
 		updateItems(n.data[:8], n.len)
@@ -496,10 +653,19 @@ func (n *Numbers[I]) Append(i ...I) {
 	}()
 
 	requiredWords := wordsRequiredToStore(n.len+len(i), int(n.sizeInBytes))
+	size := (requiredWords * 8) + 8 // +8 is header space
 
-	c := make([]byte, (requiredWords*8)+8) // +8 is header space
-	copy(c, n.data)
-	n.data = c
+	if size <= cap(n.data) {
+		old := len(n.data)
+		n.data = n.data[:size]
+		for x := old; x < size; x++ {
+			n.data[x] = 0
+		}
+	} else {
+		c := make([]byte, size)
+		copy(c, n.data)
+		n.data = c
+	}
 
 	start := n.len
 	n.len += len(i)
@@ -508,6 +674,24 @@ func (n *Numbers[I]) Append(i ...I) {
 	}
 }
 
+// Grow makes sure the list has room for n more items, so that appending them doesn't need to
+// reallocate. This is like slices.Grow() and doesn't change the list's length or encoded size.
+func (n *Numbers[I]) Grow(items int) {
+	if err := n.s.checkFrozen(); err != nil {
+		panic(err)
+	}
+	if items < 0 {
+		panic("cannot Grow() by a negative number")
+	}
+	size := (wordsRequiredToStore(n.len+items, int(n.sizeInBytes)) * 8) + 8
+	if size <= cap(n.data) {
+		return
+	}
+	c := make([]byte, len(n.data), size)
+	copy(c, n.data)
+	n.data = c
+}
+
 // Slice converts this into a standard []I, where I is a number value. The values aren't linked, so changing
 // []I or calling n.Set(...) will have no affect on the other. If there are no
 // entries, this returns a nil slice.
@@ -516,13 +700,115 @@ func (n *Numbers[I]) Slice() []I {
 		return nil
 	}
 
-	s := make([]I, n.len)
+	s := make([]I, 0, n.len)
 	for v := range n.Range(context.Background(), 0, n.len) {
 		s = append(s, v)
 	}
 	return s
 }
 
+// SliceView returns the values in the list without copying them, which allows passing them to
+// code that works on a []I, such as vectorized math, without calling Get() for each value. If
+// there are no entries, this returns a nil slice.
+//
+// The returned slice uses the list's memory: it must be treated as read-only and is only valid
+// until the list is changed. On machines that are not little endian or if the data is not aligned
+// for I, this returns a copy like Slice().
+func (n *Numbers[I]) SliceView() []I {
+	if n.len == 0 {
+		return nil
+	}
+	p := unsafe.Pointer(&n.data[8])
+	if !nativeLittleEndian || uintptr(p)%uintptr(n.sizeInBytes) != 0 {
+		return n.Slice()
+	}
+	return unsafe.Slice((*I)(p), n.len)
+}
+
+// nativeLittleEndian is true if this machine stores numbers in memory in the same byte order that
+// they are encoded in.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// Min returns the smallest value in the list. If the list is empty, this returns 0.
+// NaN values are ignored unless every value is NaN.
+func (n *Numbers[I]) Min() I {
+	var min I
+	first := true
+	n.forEach(func(v I) {
+		if first || v < min || min != min {
+			min = v
+			first = false
+		}
+	})
+	return min
+}
+
+// Max returns the largest value in the list. If the list is empty, this returns 0.
+// NaN values are ignored unless every value is NaN.
+func (n *Numbers[I]) Max() I {
+	var max I
+	first := true
+	n.forEach(func(v I) {
+		if first || v > max || max != max {
+			max = v
+			first = false
+		}
+	})
+	return max
+}
+
+// Sum returns the sum of all values in the list. If the list is empty, this returns 0.
+// The sum is calculated in type I, so integers wrap around on overflow the same as
+// Go's + operator. If that is a concern, use Slice() and sum in a larger type.
+func (n *Numbers[I]) Sum() I {
+	var sum I
+	n.forEach(func(v I) {
+		sum += v
+	})
+	return sum
+}
+
+// forEach calls fn with every value in the list in order. This reads the packed data directly,
+// which avoids the bounds checking and size switch that Get() does for every value.
+func (n *Numbers[I]) forEach(fn func(v I)) {
+	if n.len == 0 {
+		return
+	}
+	data := n.data[8 : 8+n.len*int(n.sizeInBytes)]
+
+	switch n.sizeInBytes {
+	case 1:
+		for _, b := range data {
+			fn(I(b))
+		}
+	case 2:
+		for i := 0; i < len(data); i += 2 {
+			fn(I(binary.Get[uint16](data[i : i+2])))
+		}
+	case 4:
+		for i := 0; i < len(data); i += 4 {
+			u := binary.Get[uint32](data[i : i+4])
+			if n.isFloat {
+				fn(I(math.Float32frombits(u)))
+				continue
+			}
+			fn(I(u))
+		}
+	case 8:
+		for i := 0; i < len(data); i += 8 {
+			u := binary.Get[uint64](data[i : i+8])
+			if n.isFloat {
+				fn(I(math.Float64frombits(u)))
+				continue
+			}
+			fn(I(u))
+		}
+	}
+}
+
 // Encode returns the []byte to write to output to represent this Number. If it returns nil,
 // no output should be written.
 func (n *Numbers[I]) Encode() []byte {
@@ -546,29 +832,25 @@ type Bytes struct {
 // not attached to a Struct yet.
 func NewBytes() *Bytes {
 	b := pool.Get(bytesPool).(*Bytes)
-	if b.header == nil {
-		b.header = NewGenericHeader()
-	}
-	b.header.SetFieldNum(0)
+	// Lists that were garbage collected are put back in the pool as they were, and a decoded
+	// list's header is part of the buffer it was decoded from.
+	b.Reset()
+	b.header = NewGenericHeader()
 	b.header.SetFieldType(field.FTListBytes)
-	b.header.SetFinal40(0)
 	return b
 }
 
 // NewBytesFromBytes returns a new Bytes value.
 func NewBytesFromBytes(data *[]byte, s *Struct) (*Bytes, error) {
-	// This is an error, because if they want to encode an empty list, it should not get encoded on the
-	// wire. There is no need to distinguish a zero value on a list type from not being set.
-	if len(*data) < 16 { // list header(8) + entry header(4) + at least 4 byte (1 bytes of data + 3 padding)
-		return nil, fmt.Errorf("malformed list of bytes: must be at least 16 bytes in size")
+	if len(*data) < 8 {
+		return nil, fmt.Errorf("malformed list of bytes: must be at least 8 bytes in size")
 	}
 	b := pool.Get(bytesPool).(*Bytes)
 	b.header = (*data)[:8]
 	*data = (*data)[8:] // Move past the header
 
-	if b.header.Final40() == 0 {
-		return nil, fmt.Errorf("cannot have a ListBytes field that has zero entries")
-	}
+	// A list with zero entries is only a header. This is a list that was set, but is empty, which
+	// is different from a list that was never set.
 
 	// We need to carve up the slice into a slice of slice.
 	d := make([][]byte, b.header.Final40())
@@ -617,6 +899,7 @@ func (b *Bytes) Reset() {
 	b.data = nil
 	b.s = nil
 	b.dataSize = 0
+	b.padding = 0
 }
 
 // Len returns the number of items in the list.
@@ -624,7 +907,8 @@ func (b *Bytes) Len() int {
 	return len(b.data)
 }
 
-// Get gets a []byte stored at the index.
+// Get gets a []byte stored at the index. An empty item returns nil. This panics if index is
+// out of range, use TryGet() if the index may not be valid.
 func (b *Bytes) Get(index int) []byte {
 	if index >= b.Len() {
 		panic(fmt.Sprintf("slice out of bounds: index %d in slice of size %d", index, b.Len()))
@@ -637,6 +921,19 @@ func (b *Bytes) Get(index int) []byte {
 	return b.data[index][4:]
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b *Bytes) TryGet(index int) (value []byte, ok bool) {
+	if index < 0 || index >= b.Len() {
+		return nil, false
+	}
+	return b.Get(index), true
+}
+
+// Any implements List.Any().
+func (b *Bytes) Any(index int) any {
+	return b.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak. You should NOT modify the returned []byte slice.
@@ -677,11 +974,14 @@ func (b *Bytes) Range(ctx context.Context, from, to int) chan []byte {
 
 // Set a number in position "index" to "value".
 func (b *Bytes) Set(index int, value []byte) {
+	if err := b.s.checkFrozen(); err != nil {
+		panic(err)
+	}
 	if index >= b.Len() {
 		panic(fmt.Sprintf("slice out of bounds: index %d in slice of size %d", index, b.Len()))
 	}
-	if len(value) > math.MaxUint32 {
-		panic(fmt.Sprintf("cannot set a value > %dKiB", math.MaxUint32/1024))
+	if int64(len(value)) > maxEntrySize {
+		panic(fmt.Sprintf("cannot set a value larger than %d bytes", maxEntrySize))
 	}
 	// Record the current size of this value and end padding.  Get new value size and new
 	// padding needed. Calculate our new data size.
@@ -692,8 +992,10 @@ func (b *Bytes) Set(index int, value []byte) {
 
 	atomic.AddInt64(&b.dataSize, int64(len(value))+4) // data + entry header
 	atomic.StoreInt64(&b.padding, PaddingNeeded(b.dataSize))
+	XXXAddToTotal(b.s, int64(len(value))+4+b.padding)
 
 	b.set(index, value)
+	b.s.markDirty(b.header.FieldNum())
 }
 
 func (b *Bytes) set(index int, value []byte) {
@@ -703,41 +1005,77 @@ func (b *Bytes) set(index int, value []byte) {
 	b.data[index] = buff
 }
 
-// Append appends values to the list of []byte.
-func (b *Bytes) Append(values ...[]byte) {
-	for _, v := range values {
-		if len(v) > math.MaxUint32 {
-			panic(fmt.Sprintf("cannot set a value > %dKiB", math.MaxUint32/1024))
-		}
-	}
+// maxEntrySize is the largest value that an entry in a Bytes or Strings list can hold, as the
+// size of each entry is stored in a uint32 entry header. It is a var so tests can lower it.
+var maxEntrySize int64 = math.MaxUint32
 
-	// Remove old data size = padding.
-	if b.s != nil {
-		log.Println("removing old data size before append: ", -(b.dataSize + b.padding))
-		XXXAddToTotal(b.s, -(b.dataSize + b.padding))
+// Append appends values to the list of []byte. If any value is larger than an entry can hold
+// (4GiB - 1) or the list would become larger than maxDataSize, this returns an ErrSizeExceeded
+// and nothing is appended. Appending many values in one call is faster than one at a time, as
+// the entries share one allocation and the size of the Struct holding the list is updated once.
+func (b *Bytes) Append(values ...[]byte) error {
+	if err := b.s.checkFrozen(); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
 	}
 
-	newSize := b.dataSize // We are appending, so our new size starts at the old size
+	added := int64(0) // data + entry headers
+	for i, v := range values {
+		if int64(len(v)) > maxEntrySize {
+			return fmt.Errorf("%w: value %d has size %d, but list entries can be at most %d bytes", ErrSizeExceeded, i, len(v), maxEntrySize)
+		}
+		added += int64(len(v)) + 4
+	}
+	newSize := b.dataSize + added
+	newPadding := PaddingNeeded(newSize)
+	if newSize+newPadding+8 > maxDataSize {
+		return fmt.Errorf("%w: cannot make a list of bytes with size > %d", ErrSizeExceeded, maxDataSize)
+	}
 
-	// Create new slice that can hold our data.
+	// Make sure our slice can hold our data.
 	indexStart := len(b.data)
-	n := make([][]byte, len(b.data)+len(values))
-	copy(n, b.data)
-	b.data = n
+	b.Grow(len(values))
+	b.data = b.data[:len(b.data)+len(values)]
 
+	// All the entries are cut from one buffer. Each is capped to its size, so appending to one
+	// cannot write into the next.
+	buff := make([]byte, added)
 	for i, v := range values {
-		b.set(indexStart+i, v)
-		newSize += int64(len(v)) + 4 // data + entry header
+		n := 4 + len(v)
+		binary.Put(buff, uint32(len(v)))
+		copy(buff[4:], v)
+		b.data[indexStart+i] = buff[:n:n]
+		buff = buff[n:]
 	}
 	updateItems(b.header, len(b.data))
 
+	if b.s != nil {
+		XXXAddToTotal(b.s, (newSize+newPadding)-(b.dataSize+b.padding))
+		b.s.markDirty(b.header.FieldNum())
+	}
 	// Record our data size and padding requirements.
 	b.dataSize = newSize
-	b.padding = PaddingNeeded(newSize)
-	if b.s != nil {
-		log.Println("adding new append data size: ", b.dataSize+b.padding)
-		XXXAddToTotal(b.s, b.dataSize+b.padding) // data size + entry header size
+	b.padding = newPadding
+	return nil
+}
+
+// Grow makes sure the list has room for n more items, so that appending them doesn't need to
+// reallocate the list. This is like slices.Grow() and doesn't change the list's length or encoded size.
+func (b *Bytes) Grow(n int) {
+	if err := b.s.checkFrozen(); err != nil {
+		panic(err)
+	}
+	if n < 0 {
+		panic("cannot Grow() by a negative number")
 	}
+	if cap(b.data)-len(b.data) >= n {
+		return
+	}
+	d := make([][]byte, len(b.data), len(b.data)+n)
+	copy(d, b.data)
+	b.data = d
 }
 
 // Slice converts this into a standard [][]byte. The values aren't linked, so changing
@@ -750,33 +1088,61 @@ func (b *Bytes) Slice() [][]byte {
 
 	n := make([][]byte, len(b.data))
 	for i, v := range b.data {
+		v = v[4:] // Skip the entry header
 		n[i] = make([]byte, len(v))
 		copy(n[i], v)
 	}
 	return n
 }
 
-// Encode returns the []byte to write to output to represent this Bytes. If it returns nil,
-// no output should be written.
-func (b *Bytes) Encode(w io.Writer) (int, error) {
-	// If we have a Bytes that doesn't actually have any data, it should not be encoded as
-	// indicated by returning nil.
+// RawData returns the entries in the list in their encoded form, without the list header or
+// padding. Each entry is a 4 byte little endian size followed by that many bytes of data. If
+// there are no entries, this returns nil.
+//
+// For a list that was decoded and has not been changed, this does not copy, the returned slice
+// uses the list's memory. It must be treated as read-only and is only valid until the list is changed.
+func (b *Bytes) RawData() []byte {
 	if len(b.data) == 0 {
-		return 0, nil
+		return nil
+	}
+
+	first := b.data[0]
+	size := 0
+	contiguous := true
+	for _, v := range b.data {
+		if contiguous && (size >= cap(first) || &first[:cap(first)][size] != &v[0]) {
+			contiguous = false
+		}
+		size += len(v)
+	}
+	if contiguous {
+		return first[:size]
+	}
+
+	raw := make([]byte, 0, size)
+	for _, v := range b.data {
+		raw = append(raw, v...)
 	}
+	return raw
+}
 
-	wrote, err := w.Write(b.header)
+// Encode returns the []byte to write to output to represent this Bytes. If it returns nil,
+// no output should be written.
+func (b *Bytes) Encode(w io.Writer) (int, error) {
+	// A Bytes without any data is still encoded as its header, because a list that is set, but
+	// empty, is not the same as a list that isn't set.
+	wrote, err := write(w, b.header)
 	if err != nil {
 		return wrote, err
 	}
 	for _, item := range b.data {
-		n, err := w.Write(item)
+		n, err := write(w, item)
 		wrote += n
 		if err != nil {
 			return wrote, err
 		}
 	}
-	n, err := w.Write(Padding(int(b.padding)))
+	n, err := write(w, Padding(int(b.padding)))
 	wrote += n
 	return wrote, err
 }
@@ -803,7 +1169,8 @@ func (s Strings) Len() int {
 	return s.l.Len()
 }
 
-// Get gets a string stored at the index.
+// Get gets a string stored at the index. This panics if index is out of range, use TryGet()
+// if the index may not be valid.
 func (s Strings) Get(index int) string {
 	b := s.l.Get(index)
 	if b == nil {
@@ -812,6 +1179,19 @@ func (s Strings) Get(index int) string {
 	return conversions.ByteSlice2String(b)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (s Strings) TryGet(index int) (value string, ok bool) {
+	if index < 0 || index >= s.Len() {
+		return "", false
+	}
+	return s.Get(index), true
+}
+
+// Any implements List.Any().
+func (s Strings) Any(index int) any {
+	return s.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak. You should NOT modify the returned []byte slice.
@@ -836,13 +1216,18 @@ func (s Strings) Set(index int, value string) {
 	s.l.Set(index, conversions.UnsafeGetBytes(value))
 }
 
-// Append appends values to the list of []byte.
-func (s Strings) Append(values ...string) {
+// Append appends values to the list of strings. See Bytes.Append() for the errors.
+func (s Strings) Append(values ...string) error {
 	x := make([][]byte, len(values))
 	for i, v := range values {
 		x[i] = conversions.UnsafeGetBytes(v)
 	}
-	s.l.Append(x...)
+	return s.l.Append(x...)
+}
+
+// Grow makes sure the list has room for n more items. See Bytes.Grow().
+func (s Strings) Grow(n int) {
+	s.l.Grow(n)
 }
 
 // Slice converts this into a standard []string. The values aren't linked, so changing
@@ -870,6 +1255,10 @@ type Structs struct {
 	s                   *Struct
 	zeroTypeCompression bool
 	size                *int64 // The size of the header + all structs in the list.
+
+	// raw holds the encoded items that have not been decoded yet, see UnmarshalOptions.LazyListStructs.
+	// An item is only in raw if its entry in data is nil. This is nil when every item is decoded.
+	raw [][]byte
 }
 
 // NewStructs returns a new Structs for holding lists of Structs. This is used when creating a new list
@@ -891,6 +1280,38 @@ func NewStructs(m *mapping.Map) *Structs {
 	return s
 }
 
+// NewStructsFromSlice returns a new Structs holding elems, which must all have mapping m and not be
+// in another Struct or list. This is faster than calling Append() for each entry, as the list is
+// allocated once and its size is added up in one pass. Like NewStructs(), the list is not attached
+// to a Struct until it is set on one.
+func NewStructsFromSlice(m *mapping.Map, elems []*Struct) (*Structs, error) {
+	l := NewStructs(m)
+	if len(elems) > maxDataSize {
+		return nil, fmt.Errorf("%w: cannot have more than %d items in a list", ErrSizeExceeded, maxDataSize)
+	}
+
+	var total int64
+	for i, v := range elems {
+		switch {
+		case v == nil:
+			return nil, fmt.Errorf("entry %d cannot be a nil *Struct", i)
+		case v.parent != nil:
+			return nil, fmt.Errorf("entry %d is attached to another field", i)
+		case v.frozen:
+			return nil, fmt.Errorf("%w: entry %d is frozen, use a Clone() of it", ErrFrozen, i)
+		case v.mapping != m:
+			return nil, fmt.Errorf("%w: entry %d is a %s, not a %s", ErrTypeMismatch, i, v.mapping.Name, m.Name)
+		}
+		total += atomic.LoadInt64(v.structTotal)
+	}
+
+	l.data = make([]*Struct, len(elems))
+	copy(l.data, elems)
+	atomic.AddInt64(l.size, total)
+	updateItems(l.header, len(l.data))
+	return l, nil
+}
+
 // NewStructsFromBytes returns a new Bytes value.
 func NewStructsFromBytes(data *[]byte, s *Struct, m *mapping.Map) (*Structs, error) {
 	if m == nil {
@@ -899,42 +1320,122 @@ func NewStructsFromBytes(data *[]byte, s *Struct, m *mapping.Map) (*Structs, err
 	if s == nil {
 		panic("bug: cannot pass *Struct == nil")
 	}
-	// This is an error, because if they want to encode an empty list, it should not get encoded on the
-	// wire. There is no need to distinguish a zero value on a list type from not being set.
-	if len(*data) < 16 { // structs header(8) + 8 bytes of some field
-		return nil, fmt.Errorf("malformed list of structs: must be at least 16 bytes in size")
+	if len(*data) < 8 {
+		return nil, fmt.Errorf("malformed list of structs: must be at least 8 bytes in size")
 	}
 	d := &Structs{s: s, mapping: m, size: new(int64)}
 	d.header = (*data)[:8]
 	*data = (*data)[8:] // Move past the header
 
+	// A list with zero entries is only a header. It was set, but is empty. See ClearList().
 	if d.header.Final40() == 0 {
-		return nil, fmt.Errorf("cannot have a ListStructs field that has zero entries")
+		XXXAddToTotal(s, 8)
+		*d.size = 8
+		return d, nil
 	}
 	d.data = make([]*Struct, d.header.Final40())
+	if s.lazyLists && !s.disallowUnknown && s.maxListElements <= 0 {
+		return newLazyStructs(d, data, s)
+	}
 	reader := bytes.NewReader(*data)
 
 	read := 8 // This will hold the number of bytes we have read.
+	// An item can be larger than what was read when it is missing Dense fields, see denseField().
+	total := int64(8)
 	for i := 0; i < len(d.data); i++ {
 		if len(*data) < 8 {
 			return nil, fmt.Errorf("malformed list of structs field: an item (%d) did not have a valid header", i)
 		}
 
 		entry := New(0, m)
-		n, err := entry.unmarshal(reader)
+		entry.disallowUnknown = s.disallowUnknown
+		entry.maxListElements = s.maxListElements
+		entry.lazyLists = s.lazyLists
+		entry.shared = s.shared
+		entry.stats = s.stats
+		var n int
+		var err error
+		if s.shared {
+			n, err = entry.unmarshalBytes((*data)[read-8:])
+		} else {
+			n, err = entry.unmarshal(reader)
+		}
 		if err != nil {
 			return nil, err
 		}
 		read += n
+		total += atomic.LoadInt64(entry.structTotal)
+		// This is set after decoding, as our total already includes the entry.
+		entry.parent = s
+		entry.zeroTypeCompression = s.zeroTypeCompression
 		d.data[i] = entry
 	}
 
 	*data = (*data)[read-8:] // Move past the data (-8 is for the header we alread moved past)
-	XXXAddToTotal(s, read)   // Add header + data
-	*d.size = int64(read)
+	XXXAddToTotal(s, total)  // Add header + items
+	*d.size = total
+	s.grown += total - int64(read)
+	return d, nil
+}
+
+// newLazyStructs finishes NewStructsFromBytes() for d without decoding the items. Each item is
+// checked as Verify() does and kept in d.raw until item() decodes it.
+func newLazyStructs(d *Structs, data *[]byte, s *Struct) (*Structs, error) {
+	d.raw = make([][]byte, len(d.data))
+	if s.stats != nil {
+		s.stats.LazyItems += len(d.raw)
+	}
+	read := 0
+	for i := range d.raw {
+		n, err := verifyStruct((*data)[read:], d.mapping)
+		if err != nil {
+			return nil, fmt.Errorf("%w: list of structs item %d: %s", ErrCorruptData, i, err)
+		}
+		d.raw[i] = (*data)[read : read+n : read+n]
+		read += n
+	}
+
+	*data = (*data)[read:]
+	XXXAddToTotal(s, 8+read) // Add header + data
+	*d.size = int64(8 + read)
 	return d, nil
 }
 
+// item returns the item at index, decoding it if it has not been decoded yet.
+func (s *Structs) item(index int) *Struct {
+	if s.data[index] != nil || index >= len(s.raw) {
+		return s.data[index]
+	}
+
+	entry := New(0, s.mapping)
+	entry.lazyLists = true
+	if _, err := entry.unmarshal(bytes.NewReader(s.raw[index])); err != nil {
+		// The item passed verifyStruct(), so the decoder disagrees with it.
+		panic(fmt.Sprintf("bug: list of structs item %d was verified, but could not be decoded: %s", index, err))
+	}
+	// This is set after decoding, as our total already includes the entry.
+	entry.parent = s.s
+	if s.s != nil {
+		entry.zeroTypeCompression = s.s.zeroTypeCompression
+	}
+	// The entry is larger than its raw bytes if it is missing Dense fields, see denseField().
+	if d := atomic.LoadInt64(entry.structTotal) - int64(len(s.raw[index])); d != 0 {
+		atomic.AddInt64(s.size, d)
+		XXXAddToTotal(s.s, d)
+	}
+	s.data[index] = entry
+	s.raw[index] = nil
+	return entry
+}
+
+// decodeAll decodes every item that has not been decoded yet.
+func (s *Structs) decodeAll() {
+	for i := range s.raw {
+		s.item(i)
+	}
+	s.raw = nil
+}
+
 // New creates a new *Struct that can be stored in Structs.
 func (s *Structs) New() *Struct {
 	return New(0, s.mapping)
@@ -945,10 +1446,25 @@ func (s *Structs) New() *Struct {
 func (s *Structs) Reset() {
 	s.header = nil
 	s.data = nil
+	s.raw = nil
 	s.s = nil
 	*s.size = 0
 }
 
+// total returns the size of the header and all the items. This is added up from the items instead of
+// using .size, as an item can change size after it was added to the list.
+func (s *Structs) total() int64 {
+	total := int64(8)
+	for i, item := range s.data {
+		if item == nil {
+			total += int64(len(s.raw[i]))
+			continue
+		}
+		total += atomic.LoadInt64(item.structTotal)
+	}
+	return total
+}
+
 // Map returns the Map for all entries in this list of Structs.
 func (s *Structs) Map() *mapping.Map {
 	return s.mapping
@@ -959,13 +1475,27 @@ func (s *Structs) Len() int {
 	return len(s.data)
 }
 
-// Get gets a *Struct stored at the index.
+// Get gets a *Struct stored at the index. This panics if index is out of range, use TryGet()
+// if the index may not be valid.
 func (s *Structs) Get(index int) *Struct {
 	if index >= s.Len() {
 		panic(fmt.Sprintf("slice out of bounds: index %d in slice of size %d", index, s.Len()))
 	}
 
-	return s.data[index]
+	return s.item(index)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (s *Structs) TryGet(index int) (value *Struct, ok bool) {
+	if index < 0 || index >= s.Len() {
+		return nil, false
+	}
+	return s.item(index), true
+}
+
+// Any implements List.Any().
+func (s *Structs) Any(index int) any {
+	return s.Get(index)
 }
 
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
@@ -1006,8 +1536,23 @@ func (s *Structs) Range(ctx context.Context, from, to int) chan *Struct {
 	return ch
 }
 
+// ForEach calls fn for each item in the list in order. If fn returns an error, ForEach stops
+// and returns it. To iterate over a list that has not been decoded yet without holding all of
+// its items in memory, use ForEachListStruct().
+func (s *Structs) ForEach(fn func(i int, s *Struct) error) error {
+	for i := range s.data {
+		if err := fn(i, s.item(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Set a number in position "index" to "value".
 func (s *Structs) Set(index int, value *Struct) error {
+	if err := s.s.checkFrozen(); err != nil {
+		return err
+	}
 	if index >= len(s.data) {
 		return fmt.Errorf("index %d is not valid", index)
 	}
@@ -1019,29 +1564,40 @@ func (s *Structs) Set(index int, value *Struct) error {
 	if value.parent != nil {
 		return fmt.Errorf("cannot add a *Struct to a list of structs that is attached to another field")
 	}
+	if value.frozen {
+		return fmt.Errorf("%w: cannot add a frozen *Struct to a list of structs, add a Clone() of it", ErrFrozen)
+	}
 
 	// If the mapping pointers are not pointing to the same place, then the Structs aren't the same.
 	if value.mapping != s.mapping {
 		return fmt.Errorf("you are attempting to set index %d to a Struct with a different type that the list", index)
 
 	}
-	s.data[index] = value
 
 	// Remove the size of the current entry.
-	old := s.data[index]
+	old := s.item(index)
+	old.parent = nil
 	oldSize := atomic.LoadInt64(old.structTotal)
 	XXXAddToTotal(s.s, -oldSize)
 	atomic.AddInt64(s.size, -oldSize)
 
+	value.setZeroTypeCompression(s.zeroTypeCompression)
+	value.parent = s.s
+	s.data[index] = value
+
 	// Add the new size.
 	newSize := atomic.LoadInt64(value.structTotal)
 	XXXAddToTotal(s.s, newSize)
 	atomic.AddInt64(s.size, newSize)
+	s.s.markDirty(s.header.FieldNum())
 	return nil
 }
 
 // Append appends values to the list of []byte.
 func (s *Structs) Append(values ...*Struct) error {
+	if err := s.s.checkFrozen(); err != nil {
+		return err
+	}
 	oldSize := atomic.LoadInt64(s.size)
 
 	var total int64
@@ -1053,14 +1609,16 @@ func (s *Structs) Append(values ...*Struct) error {
 			// TODO(jdoak): If this is true, deep clone the Struct and attach the copy.
 			return fmt.Errorf("entry %d is attached to another field", i)
 		}
-		// Update our value's parent.
-		v.parent = s.s
-
+		if v.frozen {
+			return fmt.Errorf("%w: entry %d is frozen, append a Clone() of it", ErrFrozen, i)
+		}
 		// If the mapping pointers are pointing to the same place, then the Structs aren't the same.
 		if v.mapping != s.mapping {
 			return fmt.Errorf("you are attempting to set index %d to a Struct with a different type that the list", i)
 		}
-		v.zeroTypeCompression = s.zeroTypeCompression
+		v.setZeroTypeCompression(s.zeroTypeCompression)
+		// Update our value's parent.
+		v.parent = s.s
 		total += atomic.LoadInt64(v.structTotal)
 	}
 	s.data = append(s.data, values...)
@@ -1068,36 +1626,55 @@ func (s *Structs) Append(values ...*Struct) error {
 	// Update the total the list sees.
 	atomic.AddInt64(s.size, total)
 	XXXAddToTotal(s.s, atomic.LoadInt64(s.size)-oldSize)
+	s.s.markDirty(s.header.FieldNum())
 
 	updateItems(s.header, len(s.data))
 	return nil
 }
 
+// Grow makes sure the list has room for n more items, so that appending them doesn't need to
+// reallocate. This is like slices.Grow() and doesn't change the list's length or encoded size.
+func (s *Structs) Grow(n int) {
+	if err := s.s.checkFrozen(); err != nil {
+		panic(err)
+	}
+	if n < 0 {
+		panic("cannot Grow() by a negative number")
+	}
+	if cap(s.data)-len(s.data) >= n {
+		return
+	}
+	d := make([]*Struct, len(s.data), len(s.data)+n)
+	copy(d, s.data)
+	s.data = d
+}
+
 // Slice converts this into a standard []*Struct.
 func (s *Structs) Slice() []*Struct {
 	if len(s.data) == 0 {
 		return nil
 	}
+	s.decodeAll()
 	return s.data
 }
 
-// Encode returns the []byte to write to output to represent this Structs. If it returns nil,
-// no output should be written.
+// Encode writes this Structs to w. A list with no items is only its header.
 func (s *Structs) Encode(w io.Writer) (int, error) {
-	// If we have a Structs that doesn't actually have any data, it should not be encoded as
-	// indicated by returning nil.
-	if len(s.data) == 0 {
-		return 0, nil
-	}
-
-	wrote, err := w.Write(s.header)
+	wrote, err := write(w, s.header)
 	if err != nil {
 		return wrote, err
 	}
 	log.Println("header was: ", wrote)
 	for index, item := range s.data {
-		item.header.SetFieldNum(uint16(index))
-		n, err := item.Marshal(w)
+		var n int
+		if item == nil { // Not decoded, so it is unchanged.
+			raw := s.raw[index]
+			GenericHeader(raw[:8]).SetFieldNum(uint16(index))
+			n, err = write(w, raw)
+		} else {
+			item.header.SetFieldNum(uint16(index))
+			n, err = item.Marshal(w)
+		}
 		wrote += n
 		log.Println("wrote item: ", n)
 		if err != nil {
@@ -1114,7 +1691,5 @@ func updateItems(b []byte, items int) {
 		panic(fmt.Sprintf("cannot add more that %d into a list", maxDataSize))
 	}
 	// Write to the header our new size.
-	u := binary.Get[uint64](b[:8])
-	u = bits.SetValue(uint64(items), u, 24, 64)
-	binary.Put(b[:8], u)
+	GenericHeader(b[:8]).SetFinal40(uint64(items))
 }
@@ -1,9 +1,12 @@
 package structs
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"reflect"
 	"testing"
 
 	"github.com/bearlytools/claw/internal/bits"
@@ -232,6 +235,108 @@ func TestNumberFloat(t *testing.T) {
 	}
 }
 
+func TestNumberAggregates(t *testing.T) {
+	t.Run("int8", func(t *testing.T) {
+		n := NewNumbers[int8]()
+		if n.Min() != 0 || n.Max() != 0 || n.Sum() != 0 {
+			t.Errorf("TestNumberAggregates(int8 empty): got min %d, max %d, sum %d, want all 0", n.Min(), n.Max(), n.Sum())
+		}
+		n.Append(-3, 100, -128, 7)
+		if got := n.Min(); got != -128 {
+			t.Errorf("TestNumberAggregates(int8): Min(): got %d, want %d", got, -128)
+		}
+		if got := n.Max(); got != 100 {
+			t.Errorf("TestNumberAggregates(int8): Max(): got %d, want %d", got, 100)
+		}
+		if got := n.Sum(); got != -24 {
+			t.Errorf("TestNumberAggregates(int8): Sum(): got %d, want %d", got, -24)
+		}
+	})
+
+	t.Run("uint16", func(t *testing.T) {
+		n := NewNumbers[uint16]()
+		n.Append(300, 2, 65535)
+		if got := n.Min(); got != 2 {
+			t.Errorf("TestNumberAggregates(uint16): Min(): got %d, want %d", got, 2)
+		}
+		if got := n.Max(); got != 65535 {
+			t.Errorf("TestNumberAggregates(uint16): Max(): got %d, want %d", got, 65535)
+		}
+		if got := n.Sum(); got != 301 { // Wraps around
+			t.Errorf("TestNumberAggregates(uint16): Sum(): got %d, want %d", got, 301)
+		}
+	})
+
+	t.Run("int64", func(t *testing.T) {
+		n := NewNumbers[int64]()
+		n.Append(-1<<40, 5, 1<<41)
+		if got := n.Min(); got != -1<<40 {
+			t.Errorf("TestNumberAggregates(int64): Min(): got %d, want %d", got, int64(-1<<40))
+		}
+		if got := n.Max(); got != 1<<41 {
+			t.Errorf("TestNumberAggregates(int64): Max(): got %d, want %d", got, int64(1<<41))
+		}
+		if got := n.Sum(); got != 1<<40+5 {
+			t.Errorf("TestNumberAggregates(int64): Sum(): got %d, want %d", got, int64(1<<40+5))
+		}
+	})
+
+	t.Run("float32", func(t *testing.T) {
+		n := NewNumbers[float32]()
+		n.Append(float32(math.NaN()), 1.5, -2.25, 8)
+		if got := n.Min(); got != -2.25 {
+			t.Errorf("TestNumberAggregates(float32): Min(): got %v, want %v", got, -2.25)
+		}
+		if got := n.Max(); got != 8 {
+			t.Errorf("TestNumberAggregates(float32): Max(): got %v, want %v", got, 8)
+		}
+	})
+
+	t.Run("float64", func(t *testing.T) {
+		n := NewNumbers[float64]()
+		n.Append(1.5, -2.25, 8)
+		if got := n.Min(); got != -2.25 {
+			t.Errorf("TestNumberAggregates(float64): Min(): got %v, want %v", got, -2.25)
+		}
+		if got := n.Max(); got != 8 {
+			t.Errorf("TestNumberAggregates(float64): Max(): got %v, want %v", got, 8)
+		}
+		if got := n.Sum(); got != 7.25 {
+			t.Errorf("TestNumberAggregates(float64): Sum(): got %v, want %v", got, 7.25)
+		}
+	})
+}
+
+func TestNumberType(t *testing.T) {
+	type color uint8
+	type temp float32
+
+	if _, ok := numberType[color]().(uint8); !ok {
+		t.Errorf("TestNumberType(color): got %T, want uint8", numberType[color]())
+	}
+	if _, ok := numberType[temp]().(float32); !ok {
+		t.Errorf("TestNumberType(temp): got %T, want float32", numberType[temp]())
+	}
+	// The reflection for a type defined from a number is only done once.
+	if _, ok := numberTypes.Load(reflect.TypeOf(color(0))); !ok {
+		t.Errorf("TestNumberType(color): the type was not cached")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { numberType[color]() }); allocs != 0 {
+		t.Errorf("TestNumberType(color): got %v allocs, want 0", allocs)
+	}
+	// Number types don't need reflection at all.
+	numberType[uint16]()
+	if _, ok := numberTypes.Load(reflect.TypeOf(uint16(0))); ok {
+		t.Errorf("TestNumberType(uint16): the type was cached, but should not need reflection")
+	}
+
+	n := NewNumbers[color]()
+	n.Append(1, 2)
+	if got := n.Slice(); !reflect.DeepEqual(got, []color{1, 2}) || n.sizeInBytes != 1 {
+		t.Errorf("TestNumberType(color): NewNumbers(): got %v with item size %d, want [1 2] with item size 1", got, n.sizeInBytes)
+	}
+}
+
 func TestBytes(t *testing.T) {
 	// Sets our header to message type 20, field number 5 and 1 entry.
 	h := NewGenericHeader()
@@ -312,3 +417,520 @@ func TestBytes(t *testing.T) {
 		t.Fatalf("TestBytes(total count): internal 'total' counter, got %d bytes, want %d bytes", *s.structTotal, 56)
 	}
 }
+
+func TestBytesAppendEntrySize(t *testing.T) {
+	// The largest entry fits in the uint32 entry header.
+	if maxEntrySize != math.MaxUint32 {
+		t.Errorf("TestBytesAppendEntrySize: maxEntrySize was %d, want %d", maxEntrySize, int64(math.MaxUint32))
+	}
+
+	// Lower the limit so that tooBig doesn't need 4GiB behind it.
+	defer func(old int64) { maxEntrySize = old }(maxEntrySize)
+	maxEntrySize = 8
+
+	small := []byte("hello")
+	tooBig := make([]byte, maxEntrySize+1)
+
+	tests := []struct {
+		desc   string
+		append func(b *Bytes) error
+	}{
+		{
+			desc:   "Bytes",
+			append: func(b *Bytes) error { return b.Append(tooBig) },
+		},
+		{
+			desc:   "Bytes with a good value first",
+			append: func(b *Bytes) error { return b.Append(small, tooBig) },
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, &mapping.Map{Fields: []*mapping.FieldDescr{{Type: field.FTListBytes}}})
+		b := NewBytes()
+		MustSetListBytes(s, 0, b)
+		if err := b.Append([]byte("existing")); err != nil {
+			t.Fatalf("TestBytesAppendEntrySize(%s): Append error: %s", test.desc, err)
+		}
+		total := *s.structTotal
+
+		if err := test.append(b); !errors.Is(err, ErrSizeExceeded) {
+			t.Errorf("TestBytesAppendEntrySize(%s): got err == %v, want ErrSizeExceeded", test.desc, err)
+		}
+		if b.Len() != 1 {
+			t.Errorf("TestBytesAppendEntrySize(%s): list had %d entries, want 1", test.desc, b.Len())
+		}
+		if *s.structTotal != total {
+			t.Errorf("TestBytesAppendEntrySize(%s): Struct total changed from %d to %d", test.desc, total, *s.structTotal)
+		}
+	}
+}
+
+func TestGrow(t *testing.T) {
+	t.Run("Numbers", func(t *testing.T) {
+		n := NewNumbers[uint16]()
+		n.Append(1)
+		n.Grow(10)
+		if n.Len() != 1 || len(n.data) != 16 {
+			t.Fatalf("TestGrow(Numbers): Grow() changed the list: len %d, data size %d", n.Len(), len(n.data))
+		}
+		ptr := &n.data[:cap(n.data)][0]
+		n.Append(2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+		if &n.data[0] != ptr {
+			t.Errorf("TestGrow(Numbers): Append() after Grow() reallocated")
+		}
+		for i := 0; i < n.Len(); i++ {
+			if got := n.Get(i); got != uint16(i+1) {
+				t.Errorf("TestGrow(Numbers): Get(%d): got %d, want %d", i, got, i+1)
+			}
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		b := NewBytes()
+		b.Append([]byte("a"))
+		b.Grow(3)
+		if cap(b.data) < 4 {
+			t.Fatalf("TestGrow(Bytes): got cap %d, want >= 4", cap(b.data))
+		}
+		ptr := &b.data[:cap(b.data)][0]
+		b.Append([]byte("b"), []byte("c"), []byte("d"))
+		if &b.data[0] != ptr {
+			t.Errorf("TestGrow(Bytes): Append() after Grow() reallocated")
+		}
+		if got := string(b.Get(3)); got != "d" {
+			t.Errorf("TestGrow(Bytes): Get(3): got %q, want %q", got, "d")
+		}
+	})
+
+	t.Run("Structs", func(t *testing.T) {
+		m, _ := verifyTestData()
+		s := New(0, m)
+		if err := GrowListStruct(s, 7, 3); err != nil {
+			t.Fatalf("TestGrow(Structs): GrowListStruct(): %s", err)
+		}
+		if s.IsSet(7) || MustGetListStruct(s, 7) != nil {
+			t.Errorf("TestGrow(Structs): GrowListStruct() made the list visible")
+		}
+		if err := marshalCheck(s, 8); err != nil {
+			t.Errorf("TestGrow(Structs): GrowListStruct() changed the size: %s", err)
+		}
+
+		sub := m.Fields[7].Mapping
+		MustAppendListStruct(s, 7, New(0, sub), New(0, sub), New(0, sub))
+		l := MustGetListStruct(s, 7)
+		if l.Len() != 3 || cap(l.data) != 3 {
+			t.Errorf("TestGrow(Structs): got len %d, cap %d, want 3, 3", l.Len(), cap(l.data))
+		}
+		if err := marshalCheck(s, 40); err != nil {
+			t.Errorf("TestGrow(Structs): %s", err)
+		}
+	})
+
+	t.Run("decoded Numbers", func(t *testing.T) {
+		// A decoded list points into the decode buffer, appending must not write over the next field.
+		m, data := verifyTestData()
+		s, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			t.Fatalf("TestGrow(decoded Numbers): NewFromReader(): %s", err)
+		}
+		MustGetListNumber[uint16](s, 5).Append(6, 7, 8, 9, 10, 11, 12, 13)
+		if got := string(MustGetListBytes(s, 6).Get(0)); got != "what" {
+			t.Errorf("TestGrow(decoded Numbers): Append() changed the next field: got %q, want %q", got, "what")
+		}
+	})
+}
+
+func TestBytesListRoundTrip(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "List", Type: field.FTListBytes},
+		},
+	}
+
+	tests := []struct {
+		desc  string
+		set   bool
+		items []string
+	}{
+		{desc: "not set"},
+		{desc: "set, but empty", set: true},
+		{desc: "one empty item", set: true, items: []string{""}},
+		{desc: "only empty items", set: true, items: []string{"", "", ""}},
+		{desc: "empty items between others", set: true, items: []string{"", "a", "", "bc", ""}},
+		{desc: "no empty items", set: true, items: []string{"hello", "world"}},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		if test.set {
+			b := NewBytes()
+			MustSetListBytes(s, 0, b)
+			for _, item := range test.items {
+				b.Append([]byte(item))
+			}
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestBytesListRoundTrip(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		if err := Verify(buff.Bytes(), m); err != nil {
+			t.Errorf("TestBytesListRoundTrip(%s): Verify(): %s", test.desc, err)
+			continue
+		}
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestBytesListRoundTrip(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+		if !Equal(got, s) {
+			t.Errorf("TestBytesListRoundTrip(%s): decoded Struct did not equal the original", test.desc)
+		}
+
+		l := MustGetListBytes(got, 0)
+		if !test.set {
+			if l != nil {
+				t.Errorf("TestBytesListRoundTrip(%s): got a list, want nil", test.desc)
+			}
+			continue
+		}
+		if l == nil {
+			t.Errorf("TestBytesListRoundTrip(%s): got nil list, want a list", test.desc)
+			continue
+		}
+		if l.Len() != len(test.items) {
+			t.Errorf("TestBytesListRoundTrip(%s): Len(): got %d, want %d", test.desc, l.Len(), len(test.items))
+			continue
+		}
+		for i, want := range test.items {
+			if got := string(l.Get(i)); got != want {
+				t.Errorf("TestBytesListRoundTrip(%s): Get(%d): got %q, want %q", test.desc, i, got, want)
+			}
+		}
+	}
+}
+
+func testSliceView[I Number](t *testing.T, values ...I) {
+	t.Helper()
+	n := NewNumbers[I]()
+	if got := n.SliceView(); got != nil {
+		t.Errorf("TestSliceView(%T, empty): got %v, want nil", values, got)
+	}
+	n.Append(values...)
+	if got := n.SliceView(); !reflect.DeepEqual(got, values) {
+		t.Errorf("TestSliceView(%T): got %v, want %v", values, got, values)
+	}
+}
+
+func TestSliceView(t *testing.T) {
+	testSliceView[int8](t, -1, 2, -3)
+	testSliceView[uint8](t, 1, 2, 255)
+	testSliceView[int16](t, -1, 2, math.MaxInt16)
+	testSliceView[uint16](t, 1, 2, math.MaxUint16)
+	testSliceView[int32](t, -1, 2, math.MinInt32)
+	testSliceView[uint32](t, 1, 2, math.MaxUint32)
+	testSliceView[int64](t, -1, 2, math.MinInt64)
+	testSliceView[uint64](t, 1, 2, math.MaxUint64)
+	testSliceView[float32](t, -1.5, 2.25, math.MaxFloat32)
+	testSliceView[float64](t, -1.5, 2.25, math.MaxFloat64)
+
+	// The view is of the list's memory, so it sees changes made with Set().
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestSliceView: NewFromReader(): %s", err)
+	}
+	nums := MustGetListNumber[uint16](s, 5)
+	view := nums.SliceView()
+	nums.Set(0, 100)
+	if nativeLittleEndian && view[0] != 100 {
+		t.Errorf("TestSliceView(decoded): the view did not see the change from Set(), got %d, want 100", view[0])
+	}
+}
+
+func TestBytesRawData(t *testing.T) {
+	want := []byte{4, 0, 0, 0, 'w', 'h', 'a', 't', 0, 0, 0, 0, 4, 0, 0, 0, 'e', 'v', 'e', 'r'}
+
+	b := NewBytes()
+	if got := b.RawData(); got != nil {
+		t.Errorf("TestBytesRawData(empty): got %v, want nil", got)
+	}
+	b.Append([]byte("what"), []byte{}, []byte("ever"))
+	if got := b.RawData(); !bytes.Equal(got, want) {
+		t.Errorf("TestBytesRawData(new list): got %v, want %v", got, want)
+	}
+
+	// A decoded list has all of its entries next to each other, so nothing is copied.
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestBytesRawData: NewFromReader(): %s", err)
+	}
+	lb := MustGetListBytes(s, 6)
+	got := lb.RawData()
+	want = []byte{4, 0, 0, 0, 'w', 'h', 'a', 't', 4, 0, 0, 0, 'e', 'v', 'e', 'r'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("TestBytesRawData(decoded): got %v, want %v", got, want)
+	}
+	if &got[0] != &lb.data[0][0] {
+		t.Errorf("TestBytesRawData(decoded): RawData() copied the entries")
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	bools := NewBools(0)
+	bools.Append(true)
+	nums := NewNumbers[int16]()
+	nums.Append(-2)
+	lb := NewBytes()
+	lb.Append([]byte("hi"))
+	m := &mapping.Map{Fields: []*mapping.FieldDescr{{Name: "Bool", Type: field.FTBool}}}
+	ls := NewStructs(m)
+	item := New(0, m)
+	ls.Append(item)
+
+	for _, index := range []int{-1, 0, 1} {
+		wantOK := index == 0
+
+		if v, ok := bools.TryGet(index); ok != wantOK || (ok && !v) {
+			t.Errorf("TestTryGet(Bools[%d]): got (%v, %v), want ok == %v", index, v, ok, wantOK)
+		}
+		if v, ok := nums.TryGet(index); ok != wantOK || (ok && v != -2) {
+			t.Errorf("TestTryGet(Numbers[%d]): got (%v, %v), want ok == %v", index, v, ok, wantOK)
+		}
+		if v, ok := lb.TryGet(index); ok != wantOK || (ok && string(v) != "hi") {
+			t.Errorf("TestTryGet(Bytes[%d]): got (%q, %v), want ok == %v", index, v, ok, wantOK)
+		}
+		if v, ok := ls.TryGet(index); ok != wantOK || (ok && v != item) {
+			t.Errorf("TestTryGet(Structs[%d]): got (%p, %v), want ok == %v", index, v, ok, wantOK)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	bools := NewBools(0)
+	bools.Append(true, false)
+	nums := NewNumbers[int16]()
+	nums.Append(-2, 3)
+	lb := NewBytes()
+	lb.Append([]byte("hi"), []byte("there"))
+	m := &mapping.Map{Fields: []*mapping.FieldDescr{{Name: "Bool", Type: field.FTBool}}}
+	ls := NewStructs(m)
+	item0, item1 := New(0, m), New(0, m)
+	ls.Append(item0, item1)
+
+	// page is the kind of helper List is for, it returns the items on page "page" of size "size".
+	page := func(l List, page, size int) []any {
+		var out []any
+		for i := page * size; i < l.Len() && i < (page+1)*size; i++ {
+			out = append(out, l.Any(i))
+		}
+		return out
+	}
+
+	tests := []struct {
+		desc string
+		list List
+		want []any
+	}{
+		{desc: "Bools", list: bools, want: []any{false}},
+		{desc: "Numbers", list: nums, want: []any{int16(3)}},
+		{desc: "Bytes", list: lb, want: []any{[]byte("there")}},
+		{desc: "Structs", list: ls, want: []any{item1}},
+	}
+
+	for _, test := range tests {
+		got := page(test.list, 1, 1)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TestList(%s): got %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+func BenchmarkStringsAppend(b *testing.B) {
+	// Strings is a view over Bytes, so its Bytes is held in a bytes list field.
+	m := &mapping.Map{
+		Name: "Command",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Args", Type: field.FTListBytes},
+		},
+	}
+	values := make([]string, 100)
+	for i := range values {
+		values[i] = fmt.Sprintf("--flag%d=value", i)
+	}
+
+	b.Run("individually", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := New(0, m)
+			l := Strings{l: NewBytes()}
+			MustSetListBytes(s, 0, l.l)
+			for _, v := range values {
+				if err := l.Append(v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("variadic", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := New(0, m)
+			l := Strings{l: NewBytes()}
+			MustSetListBytes(s, 0, l.l)
+			if err := l.Append(values...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestNewStructsFromSlice(t *testing.T) {
+	item := &mapping.Map{
+		Name: "Container",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+		},
+	}
+	other := &mapping.Map{
+		Name: "Volume",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Pod",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Containers", Type: field.FTListStructs, FieldNum: 1, Mapping: item},
+		},
+	}
+	m.MustValidate()
+
+	newItem := func(name string) *Struct {
+		s := New(0, item)
+		MustSetBytes(s, 0, []byte(name), true)
+		return s
+	}
+	attached := newItem("attached")
+	MustSetListStruct(New(0, m), 1, NewStructs(item))
+	MustAppendListStruct(New(0, m), 1, attached)
+
+	tests := []struct {
+		desc  string
+		elems []*Struct
+		err   bool
+	}{
+		{desc: "one item", elems: []*Struct{newItem("web")}},
+		{desc: "items", elems: []*Struct{newItem("web"), newItem("sidecar"), newItem("init")}},
+		{desc: "Error: nil item", elems: []*Struct{newItem("web"), nil}, err: true},
+		{desc: "Error: attached item", elems: []*Struct{attached}, err: true},
+		{desc: "Error: wrong type", elems: []*Struct{New(0, other)}, err: true},
+	}
+
+	if l, err := NewStructsFromSlice(item, nil); err != nil {
+		t.Errorf("TestNewStructsFromSlice(empty): got err == %s, want err == nil", err)
+	} else if l.Len() != 0 {
+		t.Errorf("TestNewStructsFromSlice(empty): got Len() %d, want 0", l.Len())
+	}
+
+	for _, test := range tests {
+		l, err := NewStructsFromSlice(item, test.elems)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestNewStructsFromSlice(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestNewStructsFromSlice(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		// Build the same Struct with Append() to compare against.
+		want := New(0, m)
+		MustSetBytes(want, 0, []byte("pod"), true)
+		wl := NewStructs(item)
+		MustSetListStruct(want, 1, wl)
+		for _, e := range test.elems {
+			if err := wl.Append(e.Clone()); err != nil {
+				t.Fatalf("TestNewStructsFromSlice(%s): Append(): %s", test.desc, err)
+			}
+		}
+
+		s := New(0, m)
+		MustSetBytes(s, 0, []byte("pod"), true)
+		MustSetListStruct(s, 1, l)
+		if s.Size() != want.Size() {
+			t.Errorf("TestNewStructsFromSlice(%s): got Size() %d, want %d", test.desc, s.Size(), want.Size())
+		}
+		for i, e := range test.elems {
+			if l.Get(i) != e || e.parent != s {
+				t.Errorf("TestNewStructsFromSlice(%s): item %d was not attached to the Struct", test.desc, i)
+			}
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestNewStructsFromSlice(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestNewStructsFromSlice(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+		if !Equal(got, want) {
+			t.Errorf("TestNewStructsFromSlice(%s): decoded Struct was not the same as one built with Append()", test.desc)
+		}
+	}
+}
+
+func TestListLen(t *testing.T) {
+	m, data := verifyTestData()
+
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestListLen: NewFromReader(): %s", err)
+	}
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	l, err := OpenAt(r, int64(len(data)), m)
+	if err != nil {
+		t.Fatalf("TestListLen: OpenAt(): %s", err)
+	}
+	opened := r.read
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		want     int
+		wantOK   bool
+	}{
+		{desc: "not a list", fieldNum: 0},
+		{desc: "list of bools", fieldNum: 4, want: 3, wantOK: true},
+		{desc: "list of numbers", fieldNum: 5, want: 5, wantOK: true},
+		{desc: "list of bytes", fieldNum: 6, want: 2, wantOK: true},
+		{desc: "list of Structs", fieldNum: 7, want: 2, wantOK: true},
+		{desc: "field not in mapping", fieldNum: 8},
+	}
+
+	for _, test := range tests {
+		if got, ok := ListLen(s, test.fieldNum); got != test.want || ok != test.wantOK {
+			t.Errorf("TestListLen(%s): ListLen(): got (%d, %v), want (%d, %v)", test.desc, got, ok, test.want, test.wantOK)
+		}
+		if got, ok := LazyListLen(l, test.fieldNum); got != test.want || ok != test.wantOK {
+			t.Errorf("TestListLen(%s): LazyListLen(): got (%d, %v), want (%d, %v)", test.desc, got, ok, test.want, test.wantOK)
+		}
+	}
+	if r.read != opened {
+		t.Errorf("TestListLen: LazyListLen() read %d bytes, want 0", r.read-opened)
+	}
+
+	DeleteField(s, 7)
+	if _, ok := ListLen(s, 7); ok {
+		t.Errorf("TestListLen(deleted field): ListLen(): got ok == true, want false")
+	}
+}
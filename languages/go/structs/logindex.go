@@ -0,0 +1,57 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// LogIndex returns the offset of each record in r, which has size bytes and holds encoded Structs
+// written one after another, such as an append-only log. A record may start with a schema hash
+// (see MarshalOptions.EmbedSchemaHash), in which case its offset is where the schema hash starts.
+//
+// Only the header of each record is read, the records themselves are not read or checked. Pass
+// an offset to OpenAt() with an io.NewSectionReader() or read the record at that offset to decode it.
+// Errors about the data are an ErrCorruptData and say which record was bad.
+func LogIndex(r io.ReaderAt, size int64) ([]int64, error) {
+	var offsets []int64
+	h := GenericHeader(make([]byte, 8))
+
+	for offset := int64(0); offset < size; {
+		start := offset
+		if size-offset < 8 {
+			return nil, fmt.Errorf("%w: record %d at offset %d: not enough room for a header", ErrCorruptData, len(offsets), start)
+		}
+		if err := readAt(r, h, offset); err != nil {
+			return nil, fmt.Errorf("record %d at offset %d: %w", len(offsets), start, err)
+		}
+		if h.FieldType() == field.FTSchemaHash {
+			if h.Final40() != 16 {
+				return nil, fmt.Errorf("%w: record %d at offset %d: schema hash preamble must have size 16, had %d", ErrCorruptData, len(offsets), start, h.Final40())
+			}
+			offset += 16
+			if size-offset < 8 {
+				return nil, fmt.Errorf("%w: record %d at offset %d: not enough room for a header after the schema hash", ErrCorruptData, len(offsets), start)
+			}
+			if err := readAt(r, h, offset); err != nil {
+				return nil, fmt.Errorf("record %d at offset %d: %w", len(offsets), start, err)
+			}
+		}
+
+		if h.FieldType() != field.FTStruct {
+			return nil, fmt.Errorf("%w: record %d at offset %d: expecting Struct, got %v", ErrCorruptData, len(offsets), start, h.FieldType())
+		}
+		n := int64(h.Final40())
+		if n < 8 || n%8 != 0 {
+			return nil, fmt.Errorf("%w: record %d at offset %d: Struct must have a size divisible by 8, was %d", ErrCorruptData, len(offsets), start, n)
+		}
+		if n > size-offset {
+			return nil, fmt.Errorf("%w: record %d at offset %d: Struct has size %d, but only %d bytes remain", ErrCorruptData, len(offsets), start, n, size-offset)
+		}
+
+		offsets = append(offsets, start)
+		offset += n
+	}
+	return offsets, nil
+}
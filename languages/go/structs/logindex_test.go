@@ -0,0 +1,49 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestLogIndex(t *testing.T) {
+	m, record := verifyTestData()
+
+	buff := &bytes.Buffer{}
+	var want []int64
+	for i := 0; i < 3; i++ {
+		want = append(want, int64(buff.Len()))
+		if i == 1 {
+			buff.Write(schemaHashPreamble(m))
+		}
+		buff.Write(record)
+	}
+	data := buff.Bytes()
+
+	got, err := LogIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("TestLogIndex: got err == %s, want err == nil", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("TestLogIndex: got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("TestLogIndex: record %d: got offset %d, want %d", i, got[i], want[i])
+		}
+	}
+
+	// We can open any record from its offset.
+	last := got[len(got)-1]
+	l, err := OpenAt(bytes.NewReader(data[last:]), int64(len(data))-last, m)
+	if err != nil {
+		t.Fatalf("TestLogIndex: OpenAt() on the last record: %s", err)
+	}
+	if v, err := LazyGetNumber[int32](l, 0); err != nil || v != -3 {
+		t.Errorf("TestLogIndex: last record Int32: got (%d, %v), want (-3, nil)", v, err)
+	}
+
+	if _, err := LogIndex(bytes.NewReader(data), int64(len(data)-8)); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("TestLogIndex(truncated): got err == %v, want ErrCorruptData", err)
+	}
+}
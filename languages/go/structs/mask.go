@@ -0,0 +1,112 @@
+package structs
+
+import (
+	"fmt"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// FieldMask is a set of field paths in a Struct, which is used with ApplyMask() to update only
+// some fields of a Struct, such as for a PATCH request. A path is the field numbers from the top
+// Struct down to the field, so []uint16{1, 0} is field 0 of the Struct held in field 1. An empty
+// path is every field. Generated code has a typed mask for each Struct that builds a FieldMask.
+type FieldMask struct {
+	paths [][]uint16
+}
+
+// NewFieldMask creates a new FieldMask holding paths.
+func NewFieldMask(paths ...[]uint16) *FieldMask {
+	m := &FieldMask{}
+	for _, p := range paths {
+		m.Add(p...)
+	}
+	return m
+}
+
+// Add adds the path to the mask.
+func (m *FieldMask) Add(path ...uint16) {
+	m.paths = append(m.paths, append([]uint16{}, path...))
+}
+
+// Paths returns the paths in the mask in the order they were added.
+func (m *FieldMask) Paths() [][]uint16 {
+	return m.paths
+}
+
+// ApplyMask copies the fields in mask from src to dst, which must have the same mapping. A field
+// in the mask that is not set in src is deleted from dst. Structs that hold a masked field are
+// created in dst as needed. Fields not in the mask are not changed. dst does not share any memory
+// with src afterwards.
+//
+// The mask is checked against the mapping before anything is copied, so a bad mask does not
+// leave dst partly updated.
+func ApplyMask(dst, src *Struct, mask *FieldMask) error {
+	if dst == nil || src == nil {
+		return fmt.Errorf("cannot ApplyMask() a nil *Struct")
+	}
+	if dst.mapping != src.mapping {
+		return fmt.Errorf("%w: cannot ApplyMask() Structs with different mappings (%s and %s)", ErrTypeMismatch, dst.mapping.Name, src.mapping.Name)
+	}
+	if mask == nil {
+		return nil
+	}
+
+	for _, path := range mask.paths {
+		m := src.mapping
+		for i, num := range path {
+			fd := m.FieldByNumber(num)
+			if fd == nil {
+				return fmt.Errorf("%w: mask path %v: field %d is not in Struct %s", ErrFieldNotFound, path, num, m.Name)
+			}
+			if i < len(path)-1 && fd.Type != field.FTStruct {
+				return fmt.Errorf("%w: mask path %v: field %s is a %v, not a Struct", ErrTypeMismatch, path, fd.Name, fd.Type)
+			}
+			if !fd.SelfReferential {
+				m = fd.Mapping
+			}
+		}
+	}
+
+	for _, path := range mask.paths {
+		if err := applyPath(dst, src, path); err != nil {
+			return fmt.Errorf("mask path %v: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// applyPath copies the field at path from src to dst. The path must be valid for the mapping.
+func applyPath(dst, src *Struct, path []uint16) error {
+	if len(path) == 0 {
+		for _, fd := range src.mapping.Fields {
+			if fd.Type == field.FTUnknown {
+				continue
+			}
+			if err := CopyField(dst, fd.FieldNum, src, fd.FieldNum); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(path) == 1 {
+		return CopyField(dst, path[0], src, path[0])
+	}
+
+	num := path[0]
+	sub := MustGetStruct(src, num)
+	dsub := MustGetStruct(dst, num)
+	switch {
+	case sub == nil && dsub == nil:
+		// There is nothing to copy or to delete.
+		return nil
+	case sub == nil:
+		sub = New(0, dsub.mapping)
+	case dsub == nil:
+		dsub = New(num, sub.mapping)
+		dsub.zeroTypeCompression = dst.zeroTypeCompression
+		if err := SetStruct(dst, num, dsub); err != nil {
+			return err
+		}
+	}
+	return applyPath(dsub, sub, path[1:])
+}
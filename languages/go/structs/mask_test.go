@@ -0,0 +1,169 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestApplyMask(t *testing.T) {
+	person := &mapping.Map{
+		Name: "Person",
+		Fields: []*mapping.FieldDescr{
+			{Name: "First", Type: field.FTString},
+			{Name: "Age", Type: field.FTUint8, FieldNum: 1},
+		},
+	}
+	car := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+			{Name: "Owner", Type: field.FTStruct, FieldNum: 2, Mapping: person},
+			{Name: "Spare", Type: field.FTStruct, FieldNum: 3, SelfReferential: true},
+		},
+	}
+	car.MustValidate()
+
+	newPerson := func(first string, age uint8) *Struct {
+		p := New(0, person)
+		if first != "" {
+			MustSetBytes(p, 0, []byte(first), true)
+		}
+		if age != 0 {
+			MustSetNumber(p, 1, age)
+		}
+		return p
+	}
+	newCar := func(name string, year uint16, owner *Struct) *Struct {
+		c := New(0, car)
+		if name != "" {
+			MustSetBytes(c, 0, []byte(name), true)
+		}
+		if year != 0 {
+			MustSetNumber(c, 1, year)
+		}
+		if owner != nil {
+			MustSetStruct(c, 2, owner)
+		}
+		return c
+	}
+
+	tests := []struct {
+		desc string
+		dst  *Struct
+		src  *Struct
+		mask *FieldMask
+		want *Struct
+		err  error
+	}{
+		{
+			desc: "top level field",
+			dst:  newCar("old", 2000, newPerson("Bob", 30)),
+			src:  newCar("new", 2020, newPerson("Alice", 40)),
+			mask: NewFieldMask([]uint16{0}),
+			want: newCar("new", 2000, newPerson("Bob", 30)),
+		},
+		{
+			desc: "field in a Struct that dst does not have",
+			dst:  newCar("old", 2000, nil),
+			src:  newCar("new", 2020, newPerson("Alice", 40)),
+			mask: NewFieldMask([]uint16{2, 0}),
+			want: newCar("old", 2000, newPerson("Alice", 0)),
+		},
+		{
+			desc: "field in a Struct that src does not have is deleted",
+			dst:  newCar("old", 2000, newPerson("Bob", 30)),
+			src:  newCar("new", 2020, nil),
+			mask: NewFieldMask([]uint16{2, 1}, []uint16{1}),
+			want: newCar("old", 2020, newPerson("Bob", 0)),
+		},
+		{
+			desc: "field in a Struct of the same type",
+			dst:  newCar("old", 2000, nil),
+			src: func() *Struct {
+				c := newCar("new", 2020, nil)
+				MustSetStruct(c, 3, newCar("spare", 2010, nil))
+				return c
+			}(),
+			mask: NewFieldMask([]uint16{3, 1}),
+			want: func() *Struct {
+				c := newCar("old", 2000, nil)
+				MustSetStruct(c, 3, newCar("", 2010, nil))
+				return c
+			}(),
+		},
+		{
+			desc: "whole Struct field",
+			dst:  newCar("old", 2000, newPerson("Bob", 30)),
+			src:  newCar("new", 2020, newPerson("Alice", 0)),
+			mask: NewFieldMask([]uint16{2}),
+			want: newCar("old", 2000, newPerson("Alice", 0)),
+		},
+		{
+			desc: "empty path is every field",
+			dst:  newCar("old", 2000, newPerson("Bob", 30)),
+			src:  newCar("new", 0, newPerson("Alice", 40)),
+			mask: NewFieldMask([]uint16{}),
+			want: newCar("new", 0, newPerson("Alice", 40)),
+		},
+		{
+			desc: "nil mask",
+			dst:  newCar("old", 2000, nil),
+			src:  newCar("new", 2020, nil),
+			want: newCar("old", 2000, nil),
+		},
+		{
+			desc: "Error: field not in mapping",
+			dst:  newCar("old", 2000, nil),
+			src:  newCar("new", 2020, nil),
+			mask: NewFieldMask([]uint16{0}, []uint16{2, 5}),
+			want: newCar("old", 2000, nil),
+			err:  ErrFieldNotFound,
+		},
+		{
+			desc: "Error: path through a field that is not a Struct",
+			dst:  newCar("old", 2000, nil),
+			src:  newCar("new", 2020, nil),
+			mask: NewFieldMask([]uint16{1, 0}),
+			want: newCar("old", 2000, nil),
+			err:  ErrTypeMismatch,
+		},
+		{
+			desc: "Error: different mappings",
+			dst:  newCar("old", 2000, nil),
+			src:  newPerson("Alice", 40),
+			mask: NewFieldMask([]uint16{0}),
+			want: newCar("old", 2000, nil),
+			err:  ErrTypeMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		err := ApplyMask(test.dst, test.src, test.mask)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("TestApplyMask(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestApplyMask(%s): got err == %v, want %v", test.desc, err, test.err)
+			continue
+		}
+
+		if !Equal(test.dst, test.want) {
+			t.Errorf("TestApplyMask(%s): dst did not have the wanted values", test.desc)
+		}
+		// The sizes must be right for dst to round trip.
+		buff := &bytes.Buffer{}
+		if _, err := test.dst.Marshal(buff); err != nil {
+			t.Errorf("TestApplyMask(%s): Marshal error: %s", test.desc, err)
+			continue
+		}
+		if _, err := NewFromReader(buff, car); err != nil {
+			t.Errorf("TestApplyMask(%s): NewFromReader error: %s", test.desc, err)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// UnmarshalMerge decodes a Struct from r and merges it into s with Merge(). Unlike NewFromReader(),
+// s keeps any fields that are not in the data. This is useful for applying a stream of partial
+// updates to a running object. If the data cannot be decoded, s is not changed.
+func (s *Struct) UnmarshalMerge(r io.Reader) error {
+	src := s.NewFrom()
+	if _, err := src.unmarshalTop(r); err != nil {
+		return err
+	}
+	return Merge(s, src)
+}
+
+// Merge merges the fields that are set in src into dst. src and dst must have the same mapping.
+// The rules are:
+//   - Scalars, strings and bytes in src replace the value in dst
+//   - Structs are merged with these same rules
+//   - Lists in src are appended to the list in dst
+//
+// With zero value compression, scalar fields in src that are set to the zero value are treated as
+// not set and do not replace the value in dst, the same as if src had been encoded and decoded.
+// If only dst uses zero value compression, a zero value in src removes the field from dst.
+// src is not changed and dst does not share any memory with it after the merge.
+func Merge(dst, src *Struct) error {
+	if dst == nil || src == nil {
+		return fmt.Errorf("cannot Merge() a nil *Struct")
+	}
+	if err := dst.checkFrozen(); err != nil {
+		return err
+	}
+	if dst.mapping != src.mapping {
+		return fmt.Errorf("cannot Merge() Structs with different mappings (%s and %s)", dst.mapping.Name, src.mapping.Name)
+	}
+
+	for i, sf := range src.fields {
+		if sf.Header == nil {
+			continue
+		}
+		fd := src.mapping.Fields[i]
+		fieldNum := fd.FieldNum
+
+		var err error
+		switch fd.Type {
+		case field.FTUnknown:
+			// This is data from a newer version of the Struct, we keep it as is.
+			var raw []byte
+			if old := dst.fields[i]; old.Header != nil {
+				raw = mergeUnknown(*(*[]byte)(old.Ptr), *(*[]byte)(sf.Ptr))
+				XXXAddToTotal(dst, -len(*(*[]byte)(old.Ptr)))
+			} else {
+				raw = append([]byte(nil), *(*[]byte)(sf.Ptr)...)
+			}
+			dst.fields[i] = StructField{Header: raw[:8], Ptr: unsafe.Pointer(&raw)}
+			XXXAddToTotal(dst, len(raw))
+		case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+			field.FTUint16, field.FTUint32, field.FTFloat32:
+			if sf.Header.Final40() == 0 {
+				switch {
+				case src.compressZero(i):
+					continue
+				case dst.compressZero(i):
+					mergeZero(dst, i)
+					continue
+				}
+			}
+			before := encodedFieldSize(dst, i)
+			df := dst.fields[i]
+			if df.Header == nil {
+				df.Header = NewGenericHeader()
+			}
+			copy(df.Header, sf.Header)
+			dst.fields[i] = df
+			dst.resize(i, before)
+			dst.markModified()
+			dst.markDirty(fieldNum)
+		case field.FTInt64, field.FTUint64, field.FTFloat64:
+			b := *(*[]byte)(sf.Ptr)
+			if allZero(b) {
+				switch {
+				case src.compressZero(i):
+					continue
+				case dst.compressZero(i):
+					mergeZero(dst, i)
+					continue
+				}
+			}
+			before := encodedFieldSize(dst, i)
+			df := dst.fields[i]
+			if df.Header == nil {
+				df.Header = NewGenericHeader()
+				d := make([]byte, 8)
+				df.Ptr = unsafe.Pointer(&d)
+			}
+			copy(df.Header, sf.Header)
+			copy(*(*[]byte)(df.Ptr), b)
+			dst.fields[i] = df
+			dst.resize(i, before)
+			dst.markModified()
+			dst.markDirty(fieldNum)
+		case field.FTString, field.FTBytes:
+			if src.zeroTypeCompression && sf.Header.Final40() == 0 {
+				continue
+			}
+			v := []byte{}
+			if sf.Ptr != nil {
+				v = append(v, *(*[]byte)(sf.Ptr)...)
+			}
+			err = SetBytes(dst, fieldNum, v, fd.Type == field.FTString)
+		case field.FTFixedBytes:
+			err = SetFixedBytes(dst, fieldNum, append([]byte(nil), *(*[]byte)(sf.Ptr)...))
+		case field.FTStruct:
+			sub := (*Struct)(sf.Ptr)
+			dsub := MustGetStruct(dst, fieldNum)
+			if dsub == nil {
+				dsub = New(fieldNum, sub.mapping)
+				dsub.zeroTypeCompression = dst.zeroTypeCompression
+				if err := SetStruct(dst, fieldNum, dsub); err != nil {
+					return err
+				}
+			}
+			err = Merge(dsub, sub)
+		case field.FTListBools:
+			// A list can be set, but empty, so we create it even if there is nothing to append.
+			l := (*Bools)(sf.Ptr)
+			dl := MustGetListBool(dst, fieldNum)
+			if dl == nil {
+				dl = NewBools(fieldNum)
+				if err := SetListBool(dst, fieldNum, dl); err != nil {
+					return err
+				}
+			}
+			if l.Len() > 0 {
+				dl.Append(l.Slice()...)
+			}
+		case field.FTListInt8:
+			err = mergeNumbers[int8](dst, src, fieldNum)
+		case field.FTListInt16:
+			err = mergeNumbers[int16](dst, src, fieldNum)
+		case field.FTListInt32:
+			err = mergeNumbers[int32](dst, src, fieldNum)
+		case field.FTListInt64:
+			err = mergeNumbers[int64](dst, src, fieldNum)
+		case field.FTListUint8:
+			err = mergeNumbers[uint8](dst, src, fieldNum)
+		case field.FTListUint16:
+			err = mergeNumbers[uint16](dst, src, fieldNum)
+		case field.FTListUint32:
+			err = mergeNumbers[uint32](dst, src, fieldNum)
+		case field.FTListUint64:
+			err = mergeNumbers[uint64](dst, src, fieldNum)
+		case field.FTListFloat32:
+			err = mergeNumbers[float32](dst, src, fieldNum)
+		case field.FTListFloat64:
+			err = mergeNumbers[float64](dst, src, fieldNum)
+		case field.FTListBytes, field.FTListStrings:
+			// A list of bytes can be set, but empty, so we create it even if there is nothing to append.
+			l := (*Bytes)(sf.Ptr)
+			dl := MustGetListBytes(dst, fieldNum)
+			if dl == nil {
+				dl = NewBytes()
+				if err := SetListBytes(dst, fieldNum, dl); err != nil {
+					return err
+				}
+			}
+			if l.Len() > 0 {
+				err = dl.Append(l.Slice()...)
+			}
+		case field.FTListStructs:
+			l := (*Structs)(sf.Ptr)
+			if l.Len() == 0 {
+				if MustGetListStruct(dst, fieldNum) == nil {
+					err = ClearList(dst, fieldNum)
+				}
+				break
+			}
+			items := make([]*Struct, 0, l.Len())
+			for _, item := range l.Slice() {
+				n := New(0, item.mapping)
+				n.zeroTypeCompression = item.zeroTypeCompression
+				if err := Merge(n, item); err != nil {
+					return err
+				}
+				items = append(items, n)
+			}
+			err = AppendListStruct(dst, fieldNum, items...)
+		default:
+			err = fmt.Errorf("field type %v is not supported", fd.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %w", fieldNum, err)
+		}
+	}
+	return nil
+}
+
+// mergeUnknown returns a copy of the fields in dst and src, which are the raw fields held in the
+// reserved entry of a gap (see decodeUnknown()), in field number order. A field in both comes
+// from src.
+func mergeUnknown(dst, src []byte) []byte {
+	out := make([]byte, 0, len(dst)+len(src))
+	for len(dst) > 0 || len(src) > 0 {
+		var next *[]byte
+		switch {
+		case len(dst) == 0:
+			next = &src
+		case len(src) == 0:
+			next = &dst
+		default:
+			dn, sn := GenericHeader(dst[:8]).FieldNum(), GenericHeader(src[:8]).FieldNum()
+			switch {
+			case dn < sn:
+				next = &dst
+			case sn < dn:
+				next = &src
+			default:
+				// src replaces the field in dst.
+				size, _ := verifyField(dst, nil)
+				dst = dst[size:]
+				next = &src
+			}
+		}
+		// Both were decoded with verifyField(), so this can't fail.
+		size, _ := verifyField(*next, nil)
+		out = append(out, (*next)[:size]...)
+		*next = (*next)[size:]
+	}
+	return out
+}
+
+func mergeNumbers[N Number](dst, src *Struct, fieldNum uint16) error {
+	l := MustGetListNumber[N](src, fieldNum)
+	dl := MustGetListNumber[N](dst, fieldNum)
+	if dl == nil {
+		dl = NewNumbers[N]()
+		if err := SetListNumber(dst, fieldNum, dl); err != nil {
+			return err
+		}
+	}
+	if l.Len() > 0 {
+		dl.Append(l.Slice()...)
+	}
+	return nil
+}
+
+// mergeZero removes scalar field i from s, which uses zero value compression, when it is being
+// set to the zero value.
+func mergeZero(s *Struct, i int) {
+	if s.fields[i].Header == nil {
+		return
+	}
+	XXXAddToTotal(s, -encodedFieldSize(s, i))
+	s.fields[i] = StructField{}
+	s.markModified()
+	s.markDirty(s.mapping.Fields[i].FieldNum)
+}
+
+// MergeLWW merges src into dst with last write wins, such as for syncing replicas of the same state.
+// Field "versionField" holds the version of each Struct, which must be a number that increases with
+// every write, such as a timestamp. src and dst must have the same mapping. The rules are:
+//   - If src has a higher version than dst, each field in dst is replaced with the field in src.
+//     A field that is not set in src is removed from dst. This includes the version field.
+//   - If the versions are equal or dst has the higher version, dst keeps its fields.
+//   - A Struct field set in both is the exception when its Struct has a number field with the same
+//     name as the version field. It is merged with these same rules using its own version, so that
+//     newer changes to it are kept no matter which of dst or src is newer.
+//
+// Because dst wins when the versions are equal, replicas only end up the same if two different
+// writes never have the same version. Putting a replica ID in the low bits of the version does this.
+func MergeLWW(dst, src *Struct, versionField uint16) error {
+	if dst == nil || src == nil {
+		return fmt.Errorf("cannot MergeLWW() a nil *Struct")
+	}
+	if err := dst.checkFrozen(); err != nil {
+		return err
+	}
+	if dst.mapping != src.mapping {
+		return fmt.Errorf("cannot MergeLWW() Structs with different mappings (%s and %s)", dst.mapping.Name, src.mapping.Name)
+	}
+	if _, err := validateFieldNum(versionField, dst.mapping, field.NumberTypes...); err != nil {
+		return fmt.Errorf("version field: %w", err)
+	}
+	return mergeLWW(dst, src, versionField)
+}
+
+func mergeLWW(dst, src *Struct, versionField uint16) error {
+	vi := dst.mapping.Index(versionField)
+	newer := compareField(src, dst, vi, dst.mapping.Fields[vi].Type) > 0
+	vname := dst.mapping.Fields[vi].Name
+
+	for i, fd := range dst.mapping.Fields {
+		if fd.Type == field.FTUnknown {
+			continue
+		}
+		fieldNum := fd.FieldNum
+
+		if fd.Type == field.FTStruct && dst.fields[i].Header != nil && src.fields[i].Header != nil {
+			m, err := dst.subMapping(fieldNum)
+			if err != nil {
+				return err
+			}
+			if subVersion, ok := lwwVersionField(m, vname); ok {
+				if err := mergeLWW((*Struct)(dst.fields[i].Ptr), (*Struct)(src.fields[i].Ptr), subVersion); err != nil {
+					return fmt.Errorf("field %d: %w", fieldNum, err)
+				}
+				continue
+			}
+		}
+		if newer {
+			if err := CopyField(dst, fieldNum, src, fieldNum); err != nil {
+				return fmt.Errorf("field %d: %w", fieldNum, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lwwVersionField returns the number of the field called name in m if it is a number field, which
+// makes it the version field of m for MergeLWW().
+func lwwVersionField(m *mapping.Map, name string) (uint16, bool) {
+	if name == "" {
+		return 0, false
+	}
+	for _, fd := range m.Fields {
+		if fd.Name != name {
+			continue
+		}
+		for _, ft := range field.NumberTypes {
+			if fd.Type == ft {
+				return fd.FieldNum, true
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// CopyField sets field "dstNum" in dst to a copy of the value in field "srcNum" of src. dst and src
+// may have different mappings, but the two fields must have the same type and fields that hold
+// Structs must have the same mapping. If the field is not set in src, it is deleted from dst.
+//
+// This is for migrating between versions of a Struct where Merge() can't be used because the field
+// numbers changed. dst does not share any memory with src after the copy.
+func CopyField(dst *Struct, dstNum uint16, src *Struct, srcNum uint16) error {
+	if dst == nil || src == nil {
+		return fmt.Errorf("cannot CopyField() with a nil *Struct")
+	}
+	if err := dst.checkFrozen(); err != nil {
+		return err
+	}
+	di, err := validateFieldNum(dstNum, dst.mapping)
+	if err != nil {
+		return fmt.Errorf("dst: %w", err)
+	}
+	si, err := validateFieldNum(srcNum, src.mapping)
+	if err != nil {
+		return fmt.Errorf("src: %w", err)
+	}
+	dfd, sfd := dst.mapping.Fields[di], src.mapping.Fields[si]
+	if dfd.Type != sfd.Type {
+		return fmt.Errorf("%w: dst field %d(%s) is a %v, but src field %d(%s) is a %v", ErrTypeMismatch, dstNum, dfd.Name, dfd.Type, srcNum, sfd.Name, sfd.Type)
+	}
+	if dfd.Type == field.FTStruct || dfd.Type == field.FTListStructs {
+		dm, err := dst.subMapping(dstNum)
+		if err != nil {
+			return err
+		}
+		sm, err := src.subMapping(srcNum)
+		if err != nil {
+			return err
+		}
+		if dm != sm {
+			return fmt.Errorf("%w: dst field %d(%s) holds %s, but src field %d(%s) holds %s", ErrTypeMismatch, dstNum, dfd.Name, dm.Name, srcNum, sfd.Name, sm.Name)
+		}
+	}
+	if dst == src && dstNum == srcNum {
+		return nil
+	}
+
+	sf := src.fields[si]
+	if sf.Header == nil {
+		DeleteField(dst, dstNum)
+		return nil
+	}
+
+	switch dfd.Type {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		before := encodedFieldSize(dst, di)
+		df := dst.fields[di]
+		if df.Header == nil {
+			df.Header = NewGenericHeader()
+		}
+		copy(df.Header, sf.Header)
+		df.Header.SetFieldNum(dstNum)
+		dst.fields[di] = df
+		dst.resize(di, before)
+		dst.markModified()
+		dst.markDirty(dstNum)
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		before := encodedFieldSize(dst, di)
+		df := dst.fields[di]
+		if df.Header == nil {
+			df.Header = NewGenericHeader()
+			d := make([]byte, 8)
+			df.Ptr = unsafe.Pointer(&d)
+		}
+		copy(df.Header, sf.Header)
+		df.Header.SetFieldNum(dstNum)
+		copy(*(*[]byte)(df.Ptr), *(*[]byte)(sf.Ptr))
+		dst.fields[di] = df
+		dst.resize(di, before)
+		dst.markModified()
+		dst.markDirty(dstNum)
+	case field.FTString, field.FTBytes:
+		v := []byte{}
+		if sf.Ptr != nil {
+			v = append(v, *(*[]byte)(sf.Ptr)...)
+		}
+		err = SetBytes(dst, dstNum, v, dfd.Type == field.FTString)
+	case field.FTFixedBytes:
+		err = SetFixedBytes(dst, dstNum, append([]byte(nil), *(*[]byte)(sf.Ptr)...))
+	case field.FTStruct:
+		err = SetStruct(dst, dstNum, (*Struct)(sf.Ptr).Clone())
+	case field.FTListBools:
+		l := NewBools(dstNum)
+		l.Append((*Bools)(sf.Ptr).Slice()...)
+		err = SetListBool(dst, dstNum, l)
+	case field.FTListInt8:
+		err = copyNumbers[int8](dst, dstNum, src, srcNum)
+	case field.FTListInt16:
+		err = copyNumbers[int16](dst, dstNum, src, srcNum)
+	case field.FTListInt32:
+		err = copyNumbers[int32](dst, dstNum, src, srcNum)
+	case field.FTListInt64:
+		err = copyNumbers[int64](dst, dstNum, src, srcNum)
+	case field.FTListUint8:
+		err = copyNumbers[uint8](dst, dstNum, src, srcNum)
+	case field.FTListUint16:
+		err = copyNumbers[uint16](dst, dstNum, src, srcNum)
+	case field.FTListUint32:
+		err = copyNumbers[uint32](dst, dstNum, src, srcNum)
+	case field.FTListUint64:
+		err = copyNumbers[uint64](dst, dstNum, src, srcNum)
+	case field.FTListFloat32:
+		err = copyNumbers[float32](dst, dstNum, src, srcNum)
+	case field.FTListFloat64:
+		err = copyNumbers[float64](dst, dstNum, src, srcNum)
+	case field.FTListBytes, field.FTListStrings:
+		l := NewBytes()
+		if sl := (*Bytes)(sf.Ptr); sl.Len() > 0 {
+			if err := l.Append(sl.Slice()...); err != nil {
+				return err
+			}
+		}
+		err = SetListBytes(dst, dstNum, l)
+	case field.FTListStructs:
+		sl := (*Structs)(sf.Ptr)
+		DeleteField(dst, dstNum)
+		if sl.Len() == 0 {
+			return ClearList(dst, dstNum)
+		}
+		items := make([]*Struct, 0, sl.Len())
+		for _, item := range sl.Slice() {
+			items = append(items, item.Clone())
+		}
+		err = AppendListStruct(dst, dstNum, items...)
+	default:
+		err = fmt.Errorf("field type %v is not supported", dfd.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("field %d: %w", dstNum, err)
+	}
+	return nil
+}
+
+func copyNumbers[N Number](dst *Struct, dstNum uint16, src *Struct, srcNum uint16) error {
+	l := NewNumbers[N]()
+	l.Append(MustGetListNumber[N](src, srcNum).Slice()...)
+	return SetListNumber(dst, dstNum, l)
+}
@@ -0,0 +1,414 @@
+package structs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestMerge(t *testing.T) {
+	m, data := verifyTestData()
+	subMapping := m.Fields[3].Mapping
+
+	decode := func() *Struct {
+		s, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+
+	other := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+
+	tests := []struct {
+		desc    string
+		dst     *Struct
+		src     *Struct
+		want    *Struct
+		wantErr bool
+	}{
+		{
+			desc:    "Error: different mappings",
+			dst:     decode(),
+			src:     New(0, other),
+			wantErr: true,
+		},
+		{
+			desc: "empty src changes nothing",
+			dst:  decode(),
+			src:  New(0, m),
+			want: decode(),
+		},
+		{
+			desc: "empty dst gets a copy of src",
+			dst:  New(0, m),
+			src:  decode(),
+			want: decode(),
+		},
+		{
+			desc: "scalars, bytes and unset Structs are replaced",
+			dst: func() *Struct {
+				s := New(0, m)
+				MustSetNumber(s, 0, int32(1))
+				MustSetBytes(s, 2, []byte("hello"), false)
+				return s
+			}(),
+			src: func() *Struct {
+				s := New(0, m)
+				MustSetNumber(s, 0, int32(7))
+				MustSetNumber(s, 1, uint64(9))
+				MustSetBytes(s, 2, []byte("bye"), false)
+				sub := New(0, subMapping)
+				MustSetBool(sub, 0, true)
+				MustSetStruct(s, 3, sub)
+				return s
+			}(),
+			want: func() *Struct {
+				s := New(0, m)
+				MustSetNumber(s, 0, int32(7))
+				MustSetNumber(s, 1, uint64(9))
+				MustSetBytes(s, 2, []byte("bye"), false)
+				sub := New(0, subMapping)
+				MustSetBool(sub, 0, true)
+				MustSetStruct(s, 3, sub)
+				return s
+			}(),
+		},
+		{
+			desc: "zero value compression: zero value does not replace",
+			dst:  decode(),
+			src: func() *Struct {
+				s := New(0, m)
+				MustSetNumber(s, 0, int32(0))
+				return s
+			}(),
+			want: decode(),
+		},
+		{
+			desc: "no zero value compression: zero value replaces",
+			dst:  decode(),
+			src: func() *Struct {
+				s := New(0, m)
+				s.XXXSetNoZeroTypeCompression()
+				MustSetNumber(s, 0, int32(0))
+				return s
+			}(),
+			want: func() *Struct {
+				s := decode()
+				MustSetNumber(s, 0, int32(0))
+				return s
+			}(),
+		},
+		{
+			desc: "lists are appended",
+			dst:  decode(),
+			src: func() *Struct {
+				s := New(0, m)
+				bools := NewBools(4)
+				MustSetListBool(s, 4, bools)
+				bools.Append(false, false)
+				nums := NewNumbers[uint16]()
+				nums.Append(6)
+				MustSetListNumber(s, 5, nums)
+				lb := NewBytes()
+				MustSetListBytes(s, 6, lb)
+				lb.Append([]byte("more"))
+				sub := New(0, subMapping)
+				MustSetBool(sub, 0, true)
+				MustAppendListStruct(s, 7, sub)
+				return s
+			}(),
+			want: func() *Struct {
+				s := decode()
+				MustGetListBool(s, 4).Append(false, false)
+				MustGetListNumber[uint16](s, 5).Append(6)
+				MustGetListBytes(s, 6).Append([]byte("more"))
+				sub := New(0, subMapping)
+				MustSetBool(sub, 0, true)
+				MustAppendListStruct(s, 7, sub)
+				return s
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		err := Merge(test.dst, test.src)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestMerge(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestMerge(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		if !Equal(test.dst, test.want) {
+			t.Errorf("TestMerge(%s): merged Struct did not equal the expected Struct", test.desc)
+			continue
+		}
+
+		// Make sure our size accounting was kept up to date by doing a round trip.
+		buff := &bytes.Buffer{}
+		if _, err := test.dst.Marshal(buff); err != nil {
+			t.Errorf("TestMerge(%s): Marshal() error: %s", test.desc, err)
+			continue
+		}
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestMerge(%s): NewFromReader() error: %s", test.desc, err)
+			continue
+		}
+		if !Equal(got, test.want) {
+			t.Errorf("TestMerge(%s): after round trip, Struct did not equal the expected Struct", test.desc)
+		}
+	}
+}
+
+func TestUnmarshalMerge(t *testing.T) {
+	m, data := verifyTestData()
+
+	s := New(0, m)
+	MustSetNumber(s, 0, int32(100))
+	nums := NewNumbers[uint16]()
+	nums.Append(9)
+	MustSetListNumber(s, 5, nums)
+
+	// Change the Uint64 field to a Uint32 field, which will fail to decode after field 0.
+	bad := append([]byte(nil), data...)
+	GenericHeader(bad[16:24]).SetFieldType(field.FTUint32)
+	if err := s.UnmarshalMerge(bytes.NewReader(bad)); err == nil {
+		t.Fatalf("TestUnmarshalMerge(bad data): got err == nil, want err != nil")
+	}
+	if got := MustGetNumber[int32](s, 0); got != 100 {
+		t.Errorf("TestUnmarshalMerge(bad data): field 0: got %d, want 100", got)
+	}
+
+	if err := s.UnmarshalMerge(bytes.NewReader(data)); err != nil {
+		t.Fatalf("TestUnmarshalMerge: got err == %s, want err == nil", err)
+	}
+	if got := MustGetNumber[int32](s, 0); got != -3 {
+		t.Errorf("TestUnmarshalMerge: field 0: got %d, want -3", got)
+	}
+	got := MustGetListNumber[uint16](s, 5).Slice()
+	want := []uint16{9, 1, 2, 3, 4, 5}
+	if len(got) != len(want) {
+		t.Fatalf("TestUnmarshalMerge: field 5: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("TestUnmarshalMerge: field 5: got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCopyField(t *testing.T) {
+	m, data := verifyTestData()
+	subMapping := m.Fields[3].Mapping
+
+	// reversed has the same fields as m, but in reverse order.
+	reversed := &mapping.Map{Name: "Reversed"}
+	for i := len(m.Fields) - 1; i >= 0; i-- {
+		fd := *m.Fields[i]
+		fd.FieldNum = uint16(len(reversed.Fields))
+		reversed.Fields = append(reversed.Fields, &fd)
+	}
+	reversed.MustValidate()
+	last := uint16(len(m.Fields) - 1)
+
+	src, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestCopyField: could not decode test data: %s", err)
+	}
+
+	// Give dst values that must be replaced.
+	dst := New(0, reversed)
+	dst.XXXSetNoZeroTypeCompression()
+	MustSetBytes(dst, last-2, []byte("old value"), false)
+	MustSetNumber(dst, last, int32(100))
+	nums := NewNumbers[uint16]()
+	nums.Append(9, 9)
+	MustSetListNumber(dst, last-5, nums)
+	MustAppendListStruct(dst, last-7, New(0, subMapping))
+
+	for i := uint16(0); i <= last; i++ {
+		if err := CopyField(dst, last-i, src, i); err != nil {
+			t.Fatalf("TestCopyField: CopyField(field %d): %s", i, err)
+		}
+	}
+
+	// The sizes must be right for this to round trip.
+	buff := &bytes.Buffer{}
+	if _, err := dst.Marshal(buff); err != nil {
+		t.Fatalf("TestCopyField: Marshal error: %s", err)
+	}
+	decoded, err := NewFromReader(buff, reversed)
+	if err != nil {
+		t.Fatalf("TestCopyField: NewFromReader error: %s", err)
+	}
+
+	back := New(0, m)
+	back.XXXSetNoZeroTypeCompression()
+	for i := uint16(0); i <= last; i++ {
+		if err := CopyField(back, i, decoded, last-i); err != nil {
+			t.Fatalf("TestCopyField: CopyField(back, field %d): %s", i, err)
+		}
+	}
+	if !Equal(back, src) {
+		t.Errorf("TestCopyField: copying the fields back did not give the original Struct")
+	}
+
+	// dst must not share memory with src.
+	MustSetBytes(src, 2, []byte("changed"), false)
+	if got := string(*MustGetBytes(dst, last-2)); got != "hello" {
+		t.Errorf("TestCopyField: changing src changed dst, got %q, want %q", got, "hello")
+	}
+
+	// An unset field deletes the field in dst.
+	if err := CopyField(dst, last, New(0, m), 0); err != nil {
+		t.Fatalf("TestCopyField(unset): %s", err)
+	}
+	if dst.IsSet(last) {
+		t.Errorf("TestCopyField(unset): field was still set")
+	}
+	if _, err := dst.Marshal(&bytes.Buffer{}); err != nil {
+		t.Errorf("TestCopyField(unset): Marshal error: %s", err)
+	}
+
+	other := &mapping.Map{Name: "Other", Fields: []*mapping.FieldDescr{{Name: "Bool", Type: field.FTBool}}}
+	otherHolder := &mapping.Map{
+		Fields: []*mapping.FieldDescr{{Name: "Sub", Type: field.FTStruct, Mapping: other}},
+	}
+	otherHolder.MustValidate()
+
+	errTests := []struct {
+		desc   string
+		dstNum uint16
+		src    *Struct
+		srcNum uint16
+	}{
+		{desc: "different types", dstNum: last, src: src, srcNum: 1},
+		{desc: "different Struct mappings", dstNum: last - 3, src: New(0, otherHolder), srcNum: 0},
+		{desc: "src field not in mapping", dstNum: last, src: src, srcNum: last + 1},
+	}
+	for _, test := range errTests {
+		if err := CopyField(dst, test.dstNum, test.src, test.srcNum); err == nil {
+			t.Errorf("TestCopyField(%s): got err == nil, want err != nil", test.desc)
+		}
+	}
+}
+
+func TestMergeLWW(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Version", Type: field.FTUint64},
+			{Name: "Value", Type: field.FTInt32, FieldNum: 1},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Doc",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Version", Type: field.FTUint64},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: sub},
+			{Name: "Note", Type: field.FTString, FieldNum: 3},
+		},
+	}
+	m.MustValidate()
+
+	type doc struct {
+		Version    uint64
+		Name, Note string
+		SubVersion uint64
+		SubValue   int32
+	}
+	toStruct := func(d doc) *Struct {
+		s := New(0, m)
+		MustSetNumber(s, 0, d.Version)
+		if d.Name != "" {
+			MustSetBytes(s, 1, []byte(d.Name), true)
+		}
+		ss := New(0, sub)
+		MustSetNumber(ss, 0, d.SubVersion)
+		MustSetNumber(ss, 1, d.SubValue)
+		MustSetStruct(s, 2, ss)
+		if d.Note != "" {
+			MustSetBytes(s, 3, []byte(d.Note), true)
+		}
+		return s
+	}
+	str := func(b *[]byte) string {
+		if b == nil {
+			return ""
+		}
+		return string(*b)
+	}
+	fromStruct := func(s *Struct) doc {
+		ss := MustGetStruct(s, 2)
+		return doc{
+			Version:    MustGetNumber[uint64](s, 0),
+			Name:       str(MustGetBytes(s, 1)),
+			Note:       str(MustGetBytes(s, 3)),
+			SubVersion: MustGetNumber[uint64](ss, 0),
+			SubValue:   MustGetNumber[int32](ss, 1),
+		}
+	}
+
+	tests := []struct {
+		desc     string
+		dst, src doc
+		want     doc
+	}{
+		{
+			desc: "src is newer, but dst's Sub is newer",
+			dst:  doc{Version: 1, Name: "dst", Note: "note", SubVersion: 5, SubValue: 1},
+			src:  doc{Version: 2, Name: "src", SubVersion: 3, SubValue: 2},
+			want: doc{Version: 2, Name: "src", SubVersion: 5, SubValue: 1},
+		},
+		{
+			desc: "dst is newer, but src's Sub is newer",
+			dst:  doc{Version: 2, Name: "dst", Note: "note", SubVersion: 3, SubValue: 1},
+			src:  doc{Version: 1, Name: "src", SubVersion: 5, SubValue: 2},
+			want: doc{Version: 2, Name: "dst", Note: "note", SubVersion: 5, SubValue: 2},
+		},
+		{
+			desc: "equal versions keep dst",
+			dst:  doc{Version: 1, Name: "dst", SubVersion: 1, SubValue: 1},
+			src:  doc{Version: 1, Name: "src", Note: "note", SubVersion: 1, SubValue: 2},
+			want: doc{Version: 1, Name: "dst", SubVersion: 1, SubValue: 1},
+		},
+	}
+
+	for _, test := range tests {
+		dst := toStruct(test.dst)
+		if err := MergeLWW(dst, toStruct(test.src), 0); err != nil {
+			t.Errorf("TestMergeLWW(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		// The sizes must still be right for the result to encode.
+		got, err := NewFromReader(bytes.NewReader(mustMarshal(t, dst)), m)
+		if err != nil {
+			t.Errorf("TestMergeLWW(%s): could not decode the result: %s", test.desc, err)
+			continue
+		}
+		if g := fromStruct(got); g != test.want {
+			t.Errorf("TestMergeLWW(%s): got %+v, want %+v", test.desc, g, test.want)
+		}
+	}
+
+	if err := MergeLWW(New(0, m), New(0, m), 1); err == nil {
+		t.Errorf("TestMergeLWW(version field is a string): got err == nil, want err != nil")
+	}
+	if err := MergeLWW(New(0, m), New(0, sub), 0); err == nil {
+		t.Errorf("TestMergeLWW(different mappings): got err == nil, want err != nil")
+	}
+}
@@ -0,0 +1,103 @@
+package structs
+
+import (
+	"fmt"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// Migrate copies the fields of src into dst, where src and dst are different versions of the same
+// Struct, such as before and after a schema change. remap gives the field number in dst for fields
+// of src that were renumbered. Other fields keep their number, unless remap gave that number to
+// another field, in which case they are dropped. Fields whose number is not in dst's mapping are
+// dropped. Fields of dst that nothing is copied to are not changed, so a new dst returns their
+// defaults.
+//
+// Fields that are copied must have the same type in src and dst. Fields that hold Structs with a
+// different mapping in dst, because that Struct changed too, are migrated the same way without
+// a remap. dst does not share any memory with src afterwards.
+func Migrate(dst, src *Struct, remap map[uint16]uint16) error {
+	if dst == nil || src == nil {
+		return fmt.Errorf("cannot Migrate() a nil *Struct")
+	}
+	if err := dst.checkFrozen(); err != nil {
+		return err
+	}
+
+	taken := make(map[uint16]bool, len(remap))
+	for _, num := range remap {
+		taken[num] = true
+	}
+
+	for _, fd := range src.mapping.Fields {
+		srcNum := fd.FieldNum
+		if fd.Type == field.FTUnknown {
+			continue
+		}
+		dstNum, ok := remap[srcNum]
+		if !ok {
+			if taken[srcNum] {
+				continue
+			}
+			dstNum = srcNum
+		}
+		if dst.mapping.FieldByNumber(dstNum) == nil {
+			continue
+		}
+		if err := migrateField(dst, dstNum, src, srcNum); err != nil {
+			return fmt.Errorf("src field %d to dst field %d: %w", srcNum, dstNum, err)
+		}
+	}
+	return nil
+}
+
+// migrateField copies field srcNum of src to field dstNum of dst. This is CopyField(), except that
+// Structs with different mappings are migrated with Migrate().
+func migrateField(dst *Struct, dstNum uint16, src *Struct, srcNum uint16) error {
+	dfd, sfd := dst.mapping.FieldByNumber(dstNum), src.mapping.FieldByNumber(srcNum)
+	if dfd.Type != sfd.Type || (dfd.Type != field.FTStruct && dfd.Type != field.FTListStructs) {
+		return CopyField(dst, dstNum, src, srcNum)
+	}
+	dm, err := dst.subMapping(dstNum)
+	if err != nil {
+		return err
+	}
+	sm, err := src.subMapping(srcNum)
+	if err != nil {
+		return err
+	}
+	if dm == sm {
+		return CopyField(dst, dstNum, src, srcNum)
+	}
+
+	sf := src.fields[src.mapping.Index(srcNum)]
+	if sf.Header == nil {
+		DeleteField(dst, dstNum)
+		return nil
+	}
+
+	if dfd.Type == field.FTStruct {
+		sub := New(dstNum, dm)
+		sub.zeroTypeCompression = dst.zeroTypeCompression
+		if err := Migrate(sub, (*Struct)(sf.Ptr), nil); err != nil {
+			return err
+		}
+		return SetStruct(dst, dstNum, sub)
+	}
+
+	sl := (*Structs)(sf.Ptr)
+	DeleteField(dst, dstNum)
+	if sl.Len() == 0 {
+		return ClearList(dst, dstNum)
+	}
+	items := make([]*Struct, 0, sl.Len())
+	for _, item := range sl.Slice() {
+		sub := New(0, dm)
+		sub.zeroTypeCompression = dst.zeroTypeCompression
+		if err := Migrate(sub, item, nil); err != nil {
+			return err
+		}
+		items = append(items, sub)
+	}
+	return AppendListStruct(dst, dstNum, items...)
+}
@@ -0,0 +1,103 @@
+package structs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestMigrate(t *testing.T) {
+	v1Sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "A", Type: field.FTInt32},
+			{Name: "B", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	v1 := (&mapping.Map{
+		Name: "Person",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Age", Type: field.FTInt32, FieldNum: 1},
+			{Name: "Old", Type: field.FTBool, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: v1Sub},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 4, Mapping: v1Sub},
+		},
+	}).Init()
+	v2Sub := (&mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "A", Type: field.FTInt32},
+			{Name: "C", Type: field.FTUint8, FieldNum: 2},
+		},
+	}).Init()
+	// Age moved from 1 to 5, Old was removed and New took field number 1.
+	v2 := (&mapping.Map{
+		Name: "Person",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "New", Type: field.FTUint16, FieldNum: 1, Default: uint16(7)},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: v2Sub},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 4, Mapping: v2Sub},
+			{Name: "Age", Type: field.FTInt32, FieldNum: 5},
+		},
+	}).Init()
+
+	newSub := func(a int32, b string) *Struct {
+		s := New(0, v1Sub)
+		MustSetNumber(s, 0, a)
+		MustSetBytes(s, 1, []byte(b), true)
+		return s
+	}
+	src := New(0, v1)
+	MustSetBytes(src, 0, []byte("John"), true)
+	MustSetNumber(src, 1, int32(30))
+	MustSetBool(src, 2, true)
+	MustSetStruct(src, 3, newSub(1, "one"))
+	MustAppendListStruct(src, 4, newSub(2, "two"), newSub(3, "three"))
+
+	dst := New(0, v2)
+	if err := Migrate(dst, src, map[uint16]uint16{1: 5}); err != nil {
+		t.Fatalf("TestMigrate: got err == %s, want err == nil", err)
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := dst.Marshal(buff); err != nil {
+		t.Fatalf("TestMigrate: Marshal(): %s", err)
+	}
+	got, err := NewFromReader(buff, v2)
+	if err != nil {
+		t.Fatalf("TestMigrate: NewFromReader(): %s", err)
+	}
+
+	if s := string(*MustGetBytes(got, 0)); s != "John" {
+		t.Errorf("TestMigrate(Name): got %q, want %q", s, "John")
+	}
+	if n := MustGetNumber[int32](got, 5); n != 30 {
+		t.Errorf("TestMigrate(Age): got %d, want 30", n)
+	}
+	if n := MustGetNumber[uint16](got, 1); n != 7 {
+		t.Errorf("TestMigrate(New): got %d, want 7", n)
+	}
+	if n := MustGetNumber[int32](MustGetStruct(got, 3), 0); n != 1 {
+		t.Errorf("TestMigrate(Sub.A): got %d, want 1", n)
+	}
+	subs := MustGetListStruct(got, 4)
+	if subs.Len() != 2 {
+		t.Fatalf("TestMigrate(Subs): got %d items, want 2", subs.Len())
+	}
+	for i, want := range []int32{2, 3} {
+		if n := MustGetNumber[int32](subs.Get(i), 0); n != want {
+			t.Errorf("TestMigrate(Subs[%d].A): got %d, want %d", i, n, want)
+		}
+	}
+
+	// A remap between fields of different types is an error.
+	err = Migrate(New(0, v2), src, map[uint16]uint16{0: 5})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("TestMigrate(type mismatch): got err == %v, want ErrTypeMismatch", err)
+	}
+}
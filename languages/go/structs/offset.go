@@ -0,0 +1,118 @@
+package structs
+
+import (
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// FieldOffset returns where field "fieldNum" would be found in the output of s.Marshal(). offset is
+// from the start of the Struct's header and size covers the field's header, data and any padding.
+// ok is false if the field is not set or would not be encoded (such as a zero value when using
+// zero value compression). A Dense field is always encoded, so ok is true for it even when it is not set.
+//
+// This is computed from what is in memory, so it is only valid until s is changed. The offsets
+// are meant for indexing stored blobs so fixed size fields can be updated in place, see PatchScalar().
+func FieldOffset(s *Struct, fieldNum uint16) (offset, size int, ok bool) {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	offset = 8 // Struct header
+	for i := 0; i < idx; i++ {
+		offset += encodedFieldSize(s, i)
+	}
+	size = encodedFieldSize(s, idx)
+	if size == 0 {
+		return 0, 0, false
+	}
+	return offset, size, true
+}
+
+// encodedSize returns the number of bytes s.Marshal() writes.
+func encodedSize(s *Struct) int {
+	size := 8
+	for i := range s.fields {
+		size += encodedFieldSize(s, i)
+	}
+	return size
+}
+
+// encodedFieldSize returns the number of bytes s.Marshal() writes for the field at index i,
+// which is 0 if the field is not written.
+func encodedFieldSize(s *Struct, i int) int {
+	if !fieldEncoded(s, i) {
+		return 0
+	}
+	f := s.fields[i]
+
+	switch s.mapping.Fields[i].Type {
+	case field.FTUnknown:
+		return len(*(*[]byte)(f.Ptr))
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		return 8
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		return 16
+	case field.FTString, field.FTBytes, field.FTFixedBytes:
+		return 8 + SizeWithPadding(int(f.Header.Final40()))
+	case field.FTStruct:
+		return encodedSize((*Struct)(f.Ptr))
+	case field.FTListBools:
+		return len((*Bools)(f.Ptr).data)
+	case field.FTListInt8, field.FTListInt16, field.FTListInt32, field.FTListInt64,
+		field.FTListUint8, field.FTListUint16, field.FTListUint32, field.FTListUint64,
+		field.FTListFloat32, field.FTListFloat64:
+		// See writeCanonical() for why this cast is safe.
+		return len((*Numbers[uint8])(f.Ptr).data)
+	case field.FTListBytes, field.FTListStrings:
+		b := (*Bytes)(f.Ptr)
+		return 8 + int(b.dataSize+b.padding)
+	case field.FTListStructs:
+		l := (*Structs)(f.Ptr)
+		size := 8 // An empty list is only its header.
+		for index, item := range l.data {
+			if item == nil { // Not decoded, so it is written as it was read.
+				size += len(l.raw[index])
+				continue
+			}
+			size += encodedSize(item)
+		}
+		return size
+	}
+	return 0
+}
+
+// fieldEncoded reports if s.Marshal() writes the field at index i. This only looks at the field's
+// header and value, it does not encode or decode anything.
+func fieldEncoded(s *Struct, i int) bool {
+	f := s.fields[i]
+	if f.Header == nil {
+		// A Dense field that is not set is written as its Default or zero value, see denseField().
+		return s.mapping.Fields[i].Dense && !s.partial
+	}
+
+	switch s.mapping.Fields[i].Type {
+	case field.FTUnknown:
+		return len(*(*[]byte)(f.Ptr)) > 0
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		return !s.compressZero(i) || f.Header.Final40() != 0
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		if f.Ptr == nil {
+			return false
+		}
+		return !s.compressZero(i) || !allZero(*(*[]byte)(f.Ptr))
+	case field.FTString, field.FTBytes:
+		return !s.zeroTypeCompression || f.Header.Final40() != 0
+	case field.FTListBools:
+		return (*Bools)(f.Ptr).data != nil
+	case field.FTListInt8, field.FTListInt16, field.FTListInt32, field.FTListInt64,
+		field.FTListUint8, field.FTListUint16, field.FTListUint32, field.FTListUint64,
+		field.FTListFloat32, field.FTListFloat64:
+		return (*Numbers[uint8])(f.Ptr).data != nil
+	case field.FTFixedBytes, field.FTStruct, field.FTListBytes, field.FTListStrings, field.FTListStructs:
+		// These always write at least their header.
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package structs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestFieldOffset(t *testing.T) {
+	m, data := verifyTestData()
+
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestFieldOffset: could not decode test data: %s", err)
+	}
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestFieldOffset: could not marshal: %s", err)
+	}
+	data = buff.Bytes()
+
+	end := 8
+	for i := range m.Fields {
+		fieldNum := uint16(i)
+		offset, size, ok := FieldOffset(s, fieldNum)
+		if !ok {
+			t.Errorf("TestFieldOffset(field %d): got ok == false, want ok == true", fieldNum)
+			continue
+		}
+		if offset != end {
+			t.Errorf("TestFieldOffset(field %d): got offset %d, want %d", fieldNum, offset, end)
+		}
+		end = offset + size
+		if end > len(data) {
+			t.Errorf("TestFieldOffset(field %d): offset(%d) + size(%d) is past the end of the data(%d)", fieldNum, offset, size, len(data))
+			break
+		}
+
+		h := GenericHeader(data[offset : offset+8])
+		if h.FieldNum() != fieldNum {
+			t.Errorf("TestFieldOffset(field %d): header at offset had field number %d", fieldNum, h.FieldNum())
+		}
+		if !wireTypeMatches(h.FieldType(), m.Fields[i].Type) {
+			t.Errorf("TestFieldOffset(field %d): header at offset had type %v, want %v", fieldNum, h.FieldType(), m.Fields[i].Type)
+		}
+	}
+	if end != len(data) {
+		t.Errorf("TestFieldOffset: fields ended at %d, but data has %d bytes", end, len(data))
+	}
+
+	// Fields that are not encoded don't have an offset.
+	m = &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 1},
+		},
+	}
+	s = New(0, m)
+	MustSetNumber(s, 0, int32(0)) // Removed by zero value compression
+	for i := range m.Fields {
+		if _, _, ok := FieldOffset(s, uint16(i)); ok {
+			t.Errorf("TestFieldOffset(field %d not encoded): got ok == true, want ok == false", i)
+		}
+	}
+	if _, _, ok := FieldOffset(s, 2); ok {
+		t.Errorf("TestFieldOffset(field not in mapping): got ok == true, want ok == false")
+	}
+}
@@ -0,0 +1,172 @@
+package structs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// DecodeParallel decodes an encoded list of Structs (a ListStructs field, starting at its header)
+// using up to "workers" goroutines. If workers <= 0, runtime.NumCPU() is used.
+//
+// Every Struct in a list starts with a header that holds its total size, so element boundaries
+// are found with a single pass over the headers before any decoding happens. The elements are
+// then decoded concurrently. The returned Structs are in list order and are not attached to a
+// parent, so they can be added to another list with Structs.Append().
+//
+// This is only worth doing for large lists of large Structs, for small lists the goroutine
+// overhead will be more than the cost of a serial decode.
+func DecodeParallel(ctx context.Context, data []byte, m *mapping.Map, workers int) ([]*Struct, error) {
+	if m == nil {
+		return nil, fmt.Errorf("DecodeParallel() cannot be passed a nil *mapping.Map")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	elems, err := listStructElements(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Struct, len(elems))
+	err = parallel(ctx, len(elems), workers, func(index int) error {
+		entry := New(0, m)
+		if _, err := entry.unmarshal(bytes.NewReader(elems[index])); err != nil {
+			return fmt.Errorf("list item %d: %w", index, err)
+		}
+		out[index] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecodeParallel decodes the items of s that have not been decoded yet (see
+// UnmarshalOptions.LazyListStructs) using up to "workers" goroutines, and returns the items in
+// list order like Slice(). If workers <= 0, runtime.NumCPU() is used. Items that were already
+// decoded are not decoded again. If an item can't be decoded, the error is returned and the
+// items that were decoded are kept.
+func (s *Structs) DecodeParallel(ctx context.Context, workers int) ([]*Struct, error) {
+	if len(s.raw) == 0 {
+		return s.Slice(), nil
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Each worker only touches the index it was given, so data and raw can be written without a lock.
+	err := parallel(ctx, len(s.raw), workers, func(index int) error {
+		if s.data[index] != nil {
+			return nil
+		}
+		entry := New(0, s.mapping)
+		entry.lazyLists = true
+		if _, err := entry.unmarshal(bytes.NewReader(s.raw[index])); err != nil {
+			return fmt.Errorf("list item %d: %w", index, err)
+		}
+		entry.parent = s.s
+		if s.s != nil {
+			entry.zeroTypeCompression = s.s.zeroTypeCompression
+		}
+		s.data[index] = entry
+		s.raw[index] = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.raw = nil
+	return s.data, nil
+}
+
+// parallel calls fn for every index in [0, n) using up to "workers" goroutines. It stops at the
+// first error fn returns, or when ctx is done, and returns that error.
+func parallel(ctx context.Context, n, workers int, fn func(index int) error) error {
+	if workers > n {
+		workers = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	errCh := make(chan error, 1)
+	indexes := make(chan int, 1)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if err := fn(index); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case indexes <- i:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// listStructElements splits the encoded list of Structs in data into the []byte for each
+// Struct in the list. This only looks at the Struct headers, nothing is decoded.
+func listStructElements(data []byte) ([][]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("malformed list of structs: must be at least 8 bytes in size")
+	}
+	h := GenericHeader(data[:8])
+	if field.Type(h.FieldType()) != field.FTListStructs {
+		return nil, fmt.Errorf("expected a list of structs, got field type %v", field.Type(h.FieldType()))
+	}
+	data = data[8:]
+
+	// Every item is at least 8 bytes, which bounds the count before we allocate for it.
+	if h.Final40() > uint64(len(data)/8) {
+		return nil, fmt.Errorf("malformed list of structs: header says it has %d items, but there are only %d bytes", h.Final40(), len(data))
+	}
+	elems := make([][]byte, h.Final40())
+	for i := range elems {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("malformed list of structs field: an item (%d) did not have a valid header", i)
+		}
+		size := GenericHeader(data[:8]).Final40()
+		if size < 8 || size%8 != 0 {
+			return nil, fmt.Errorf("malformed list of structs field: item (%d) had invalid size %d", i, size)
+		}
+		if uint64(len(data)) < size {
+			return nil, fmt.Errorf("malformed list of structs field: item (%d) had size %d, but only %d bytes remain", i, size, len(data))
+		}
+		elems[i] = data[:size]
+		data = data[size:]
+	}
+	return elems, nil
+}
@@ -0,0 +1,159 @@
+package structs
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bearlytools/claw/internal/binary"
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+	"github.com/bearlytools/claw/languages/go/reflect/enums"
+)
+
+// PatchScalar overwrites the value of field "fieldNum" in data, which must hold an encoded Struct
+// described by m. This only works for fixed size scalar fields (bools and numbers) that are present
+// in data. The value's type must match the field's type exactly, enumerated fields can be given
+// their uint8/uint16 value or an enums.EnumImpl.
+//
+// data is changed in place, nothing is decoded or re-encoded. This is useful for updating
+// counters and timestamps on stored records.
+//
+// Remember that with zero value compression, a field set to the zero value is not encoded. So a
+// field that was zero cannot be patched and patching a field to zero will not remove it.
+func PatchScalar(data []byte, m *mapping.Map, fieldNum uint16, value any) error {
+	if m == nil {
+		return fmt.Errorf("PatchScalar() cannot be passed a nil *mapping.Map")
+	}
+	fd := m.FieldByNumber(fieldNum)
+	if fd == nil {
+		return fmt.Errorf("%w: fieldNum %d is not in the mapping", ErrFieldNotFound, fieldNum)
+	}
+
+	v, err := scalarBits(fd.Type, value)
+	if err != nil {
+		return fmt.Errorf("field %d: %w", fieldNum, err)
+	}
+
+	offset, err := findField(data, fieldNum)
+	if err != nil {
+		return err
+	}
+	h := GenericHeader(data[offset : offset+8])
+	if h.FieldType() != fd.Type {
+		return fmt.Errorf("%w: field %d has wire type %v, but mapping says %v", ErrCorruptData, fieldNum, h.FieldType(), fd.Type)
+	}
+
+	switch fd.Type {
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		if offset+16 > len(data) {
+			return fmt.Errorf("%w: field %d at offset %d is clipped", ErrCorruptData, fieldNum, offset)
+		}
+		binary.Put(data[offset+8:offset+16], v)
+	default:
+		h.SetFinal40(v)
+	}
+	return nil
+}
+
+// findField returns the offset in data of the header for field "fieldNum". data must start with
+// a Struct header.
+func findField(data []byte, fieldNum uint16) (int, error) {
+	if len(data) < 8 {
+		return 0, fmt.Errorf("%w: Struct header must be 8 bytes, had %d", ErrCorruptData, len(data))
+	}
+	h := GenericHeader(data[:8])
+	if h.FieldType() != field.FTStruct {
+		return 0, fmt.Errorf("%w: expecting Struct, got %v", ErrCorruptData, h.FieldType())
+	}
+	size := h.Final40()
+	if size > uint64(len(data)) {
+		return 0, fmt.Errorf("%w: Struct has size %d, but only %d bytes remain", ErrCorruptData, size, len(data))
+	}
+
+	offset := 8
+	for offset < int(size) {
+		if int(size)-offset < 8 {
+			return 0, fmt.Errorf("%w: field at offset %d: not enough room for a field header", ErrCorruptData, offset)
+		}
+		fh := GenericHeader(data[offset : offset+8])
+		switch {
+		case fh.FieldNum() == fieldNum:
+			return offset, nil
+		case fh.FieldNum() > fieldNum: // Fields are in order, so it isn't here.
+			return 0, fmt.Errorf("%w: field %d is not present in the data", ErrFieldNotFound, fieldNum)
+		}
+		n, err := verifyField(data[offset:size], nil)
+		if err != nil {
+			return 0, &DecodeError{FieldNum: fh.FieldNum(), Offset: offset, Err: err}
+		}
+		offset += n
+	}
+	return 0, fmt.Errorf("%w: field %d is not present in the data", ErrFieldNotFound, fieldNum)
+}
+
+// scalarBits returns the encoded form of value for a field of type ft. 64 bit values are stored
+// after the header, everything else is stored in the header's last 40 bits.
+func scalarBits(ft field.Type, value any) (uint64, error) {
+	switch ft {
+	case field.FTBool:
+		if v, ok := value.(bool); ok {
+			if v {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case field.FTInt8:
+		if v, ok := value.(int8); ok {
+			return uint64(uint32(v)), nil
+		}
+	case field.FTInt16:
+		if v, ok := value.(int16); ok {
+			return uint64(uint32(v)), nil
+		}
+	case field.FTInt32:
+		if v, ok := value.(int32); ok {
+			return uint64(uint32(v)), nil
+		}
+	case field.FTInt64:
+		if v, ok := value.(int64); ok {
+			return uint64(v), nil
+		}
+	case field.FTUint8:
+		switch v := value.(type) {
+		case uint8:
+			return uint64(v), nil
+		case enums.EnumImpl:
+			if v.EnumSize == 8 {
+				return uint64(v.EnumNumber), nil
+			}
+		}
+	case field.FTUint16:
+		switch v := value.(type) {
+		case uint16:
+			return uint64(v), nil
+		case enums.EnumImpl:
+			if v.EnumSize == 16 {
+				return uint64(v.EnumNumber), nil
+			}
+		}
+	case field.FTUint32:
+		if v, ok := value.(uint32); ok {
+			return uint64(v), nil
+		}
+	case field.FTUint64:
+		if v, ok := value.(uint64); ok {
+			return v, nil
+		}
+	case field.FTFloat32:
+		if v, ok := value.(float32); ok {
+			return uint64(math.Float32bits(v)), nil
+		}
+	case field.FTFloat64:
+		if v, ok := value.(float64); ok {
+			return math.Float64bits(v), nil
+		}
+	default:
+		return 0, fmt.Errorf("%w: field type %v is not a fixed size scalar", ErrTypeMismatch, ft)
+	}
+	return 0, fmt.Errorf("%w: cannot patch a %v field with a %T", ErrTypeMismatch, ft, value)
+}
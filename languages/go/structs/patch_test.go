@@ -0,0 +1,142 @@
+package structs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestPatchScalar(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+			{Name: "Int8", Type: field.FTInt8, FieldNum: 1},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 2},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 3},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 4},
+			{Name: "Float32", Type: field.FTFloat32, FieldNum: 5},
+			{Name: "Float64", Type: field.FTFloat64, FieldNum: 6},
+			{Name: "Unset", Type: field.FTUint32, FieldNum: 7},
+		},
+	}
+	m.MustValidate()
+
+	encode := func() []byte {
+		s := New(0, m)
+		MustSetBool(s, 0, true)
+		MustSetNumber(s, 1, int8(1))
+		MustSetBytes(s, 2, []byte("hello world"), false)
+		MustSetNumber(s, 3, int32(1))
+		MustSetNumber(s, 4, uint64(1))
+		MustSetNumber(s, 5, float32(1.5))
+		MustSetNumber(s, 6, float64(1.5))
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			panic(err)
+		}
+		return buff.Bytes()
+	}
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		value    any
+		get      func(s *Struct) any
+		err      bool
+	}{
+		{
+			desc:     "Error: field not in mapping",
+			fieldNum: 8,
+			value:    uint32(1),
+			err:      true,
+		},
+		{
+			desc:     "Error: field not in data",
+			fieldNum: 7,
+			value:    uint32(1),
+			err:      true,
+		},
+		{
+			desc:     "Error: variable sized field",
+			fieldNum: 2,
+			value:    []byte("bye"),
+			err:      true,
+		},
+		{
+			desc:     "Error: wrong type",
+			fieldNum: 3,
+			value:    int64(1),
+			err:      true,
+		},
+		{
+			desc:     "bool",
+			fieldNum: 0,
+			value:    false,
+			get:      func(s *Struct) any { return MustGetBool(s, 0) },
+		},
+		{
+			desc:     "negative int8",
+			fieldNum: 1,
+			value:    int8(-8),
+			get:      func(s *Struct) any { return MustGetNumber[int8](s, 1) },
+		},
+		{
+			desc:     "int32 after a bytes field",
+			fieldNum: 3,
+			value:    int32(-100000),
+			get:      func(s *Struct) any { return MustGetNumber[int32](s, 3) },
+		},
+		{
+			desc:     "uint64",
+			fieldNum: 4,
+			value:    uint64(1 << 50),
+			get:      func(s *Struct) any { return MustGetNumber[uint64](s, 4) },
+		},
+		{
+			desc:     "float32",
+			fieldNum: 5,
+			value:    float32(-2.25),
+			get:      func(s *Struct) any { return MustGetNumber[float32](s, 5) },
+		},
+		{
+			desc:     "float64",
+			fieldNum: 6,
+			value:    float64(3.125),
+			get:      func(s *Struct) any { return MustGetNumber[float64](s, 6) },
+		},
+	}
+
+	for _, test := range tests {
+		data := encode()
+		err := PatchScalar(data, m, test.fieldNum, test.value)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestPatchScalar(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestPatchScalar(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			if !bytes.Equal(data, encode()) {
+				t.Errorf("TestPatchScalar(%s): data was changed on an error", test.desc)
+			}
+			continue
+		}
+
+		s, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			t.Errorf("TestPatchScalar(%s): could not decode patched data: %s", test.desc, err)
+			continue
+		}
+		if got := test.get(s); got != test.value {
+			t.Errorf("TestPatchScalar(%s): got %v, want %v", test.desc, got, test.value)
+		}
+		// Make sure our neighbor in the encoding wasn't touched.
+		if got := string(*MustGetBytes(s, 2)); got != "hello world" {
+			t.Errorf("TestPatchScalar(%s): bytes field: got %q, want %q", test.desc, got, "hello world")
+		}
+	}
+}
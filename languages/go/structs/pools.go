@@ -2,9 +2,18 @@ package structs
 
 import (
 	"bytes"
+	"context"
+	"expvar"
+	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 
 	autopool "github.com/johnsiilver/golib/development/autopool/blend"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
 )
 
 var ()
@@ -19,14 +28,337 @@ type StructsPool interface {
 	Put(*Struct)
 }
 
-var readers = sync.Pool{
-	New: func() any {
+// readers holds *bytes.Reader used to decode Structs. Return them with putReader().
+var readers = newCountedPool(
+	func() any {
 		return &bytes.Reader{}
 	},
+	nil,
+)
+
+// putReader returns r to readers, without the data it was reading so the pool doesn't keep that alive.
+func putReader(r *bytes.Reader) {
+	r.Reset(nil)
+	readers.Put(r)
+}
+
+// structPool holds *Struct that were returned by ReleaseAll().
+var structPool = newCountedPool(
+	func() any {
+		return &Struct{}
+	},
+	func(x any) int {
+		s := x.(*Struct)
+		return len(s.header) + cap(s.fields)*int(unsafe.Sizeof(StructField{}))
+	},
+)
+
+// maxPooledBytes is the limit set by SetMaxPooledBytes(), 0 is no limit.
+var maxPooledBytes int64
+
+// SetMaxPooledBytes stops the pools from keeping a value that holds more than n bytes, which is
+// left to the garbage collector instead. This keeps a burst of very large Structs from being held
+// by the pools after the burst is over. n <= 0 removes the limit, which is the default.
+//
+// The pools are sync.Pool(s), which can't know how much they hold in total, so this limits each
+// value. Lists are returned to their pools by the garbage collector and are not limited by this,
+// use TrimPools() to release them.
+func SetMaxPooledBytes(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&maxPooledBytes, int64(n))
+}
+
+// trimMu keeps TrimPools() calls from running at the same time.
+var trimMu sync.Mutex
+
+// TrimPools drops every value held by this package's pools, so the garbage collector can free them
+// without waiting for the couple of collections a sync.Pool normally takes. This is safe to call at
+// any time, such as when a program is told that it is low on memory. It does not run the garbage
+// collector, follow it with debug.FreeOSMemory() to return the memory to the OS right away.
+//
+// Values dropped by TrimPools() are counted in PoolStats() as Dropped, not as Gets.
+func TrimPools() {
+	trimMu.Lock()
+	defer trimMu.Unlock()
+
+	readers.trim()
+	structPool.trim()
+
+	for _, id := range autopoolNames {
+		gets, missed := pool.drain(id)
+		dropped := gets
+		t := &listTrims[id]
+		atomic.AddUint64(&t.gets, gets)
+		if missed {
+			// The last Get() was a miss, it made a value instead of dropping one.
+			atomic.AddUint64(&t.misses, 1)
+			dropped--
+		}
+		atomic.AddUint64(&t.dropped, dropped)
+	}
+}
+
+type ctxPoolKey struct{}
+
+// ctxPool records the Structs created with a Context from WithPool().
+type ctxPool struct {
+	mu      sync.Mutex
+	structs []*Struct
+}
+
+// WithPool returns a copy of ctx that has a pool attached. Structs made with NewWithContext() and
+// NewFromReaderWithContext() using the returned Context, or one derived from it, are drawn from a
+// pool and recorded. ReleaseAll() returns all of them to the pool at once, which ties the lifetime
+// of the Structs to a request instead of to each place a Struct is made.
+func WithPool(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxPoolKey{}, &ctxPool{})
+}
+
+// NewWithContext is New(), but if ctx came from WithPool() the Struct is drawn from the pool and
+// recorded for ReleaseAll(). Without a pool this is the same as New().
+func NewWithContext(ctx context.Context, fieldNum uint16, dataMap *mapping.Map) *Struct {
+	p, ok := ctx.Value(ctxPoolKey{}).(*ctxPool)
+	if !ok {
+		return New(fieldNum, dataMap)
+	}
+	if dataMap == nil {
+		panic("dataMap must not be nil")
+	}
+
+	s := structPool.Get().(*Struct)
+	s.reuse(fieldNum, dataMap)
+
+	p.mu.Lock()
+	p.structs = append(p.structs, s)
+	p.mu.Unlock()
+	return s
+}
+
+// NewFromReaderWithContext is NewFromReader(), but the top level Struct is made with NewWithContext().
+// Structs held in its fields are not drawn from the pool.
+func NewFromReaderWithContext(ctx context.Context, r io.Reader, maps *mapping.Map) (*Struct, error) {
+	s := NewWithContext(ctx, 0, maps)
+
+	if _, err := s.unmarshalTop(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReleaseAll returns every Struct made with NewWithContext() or NewFromReaderWithContext() under ctx
+// to the pool. Call this once when the request is done. None of those Structs, or values taken from
+// them, may be used afterwards. This does nothing if ctx did not come from WithPool(). ctx can still
+// be used after this, Structs made with it afterwards are released by the next ReleaseAll().
+//
+// Only the Structs made with ctx are pooled here. Each is detached from the Structs and lists in its
+// fields, which are left to the garbage collector, as are lists taken from this package's other
+// pools. Every Struct taken is put back, including one whose decode failed.
+func ReleaseAll(ctx context.Context) {
+	p, ok := ctx.Value(ctxPoolKey{}).(*ctxPool)
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	released := p.structs
+	p.structs = nil
+	p.mu.Unlock()
+
+	for _, s := range released {
+		s.recycle()
+	}
+}
+
+// recycle empties s and puts it in structPool. The Structs and lists in its fields are detached
+// from it and left to the garbage collector.
+func (s *Struct) recycle() {
+	// Detach s first, so Reset() does not change the size of a Struct that still holds it.
+	s.parent = nil
+	s.Reset()
+	s.cached = nil
+	structPool.Put(s)
+}
+
+// Prewarm puts n Structs for m in the pool used by NewWithContext(), with the memory for m's fields
+// already allocated. Call this at startup so the first requests don't pay for allocating them.
+// This stops and returns ctx.Err() if ctx is done before all n are made.
+//
+// This only fills the one pool of Structs that is shared by every mapping. It is not kept per
+// mapping, so a Struct made for m may be taken for another mapping, which reuses its memory if it
+// has room for that mapping's fields. Only the Structs are made ahead of time, not the lists or
+// Structs that their fields will hold.
+//
+// The pool is a sync.Pool, so the garbage collector can still free Structs that are not taken.
+// Prewarm only helps until the next couple of garbage collections after it is called.
+// Structs larger than SetMaxPooledBytes() allows are not kept.
+func Prewarm(ctx context.Context, m *mapping.Map, n int) error {
+	if m == nil {
+		panic("m must not be nil")
+	}
+
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := &Struct{}
+		s.reuse(0, m)
+		structPool.Put(s)
+	}
+	return nil
+}
+
+// reuse makes s an empty Struct for dataMap, as New() would, keeping memory s already has.
+func (s *Struct) reuse(fieldNum uint16, dataMap *mapping.Map) {
+	h := s.header
+	if h == nil {
+		h = NewGenericHeader()
+	}
+	for i := range h {
+		h[i] = 0
+	}
+	h.SetFieldNum(fieldNum)
+	h.SetFieldType(field.FTStruct)
+
+	fields := s.fields
+	if cap(fields) < len(dataMap.Fields) {
+		fields = make([]StructField, len(dataMap.Fields))
+	} else {
+		fields = fields[:len(dataMap.Fields)]
+		for i := range fields {
+			fields[i] = StructField{}
+		}
+	}
+
+	*s = Struct{
+		header:              h,
+		mapping:             dataMap,
+		fields:              fields,
+		structTotal:         new(int64),
+		zeroTypeCompression: true,
+	}
+	XXXAddToTotal(s, 8+denseSize(dataMap)) // the header and the Dense fields
+}
+
+// countedPool is a sync.Pool that counts its usage for PoolStats().
+type countedPool struct {
+	sync.Pool
+
+	// size returns the bytes a value holds, for SetMaxPooledBytes(). nil means values are always kept.
+	size func(x any) int
+
+	gets, misses, puts, dropped uint64
+}
+
+func newCountedPool(f func() any, size func(x any) int) *countedPool {
+	c := &countedPool{size: size}
+	c.New = func() any {
+		atomic.AddUint64(&c.misses, 1)
+		return f()
+	}
+	return c
+}
+
+// Get implements sync.Pool.Get().
+func (c *countedPool) Get() any {
+	atomic.AddUint64(&c.gets, 1)
+	return c.Pool.Get()
+}
+
+// Put implements sync.Pool.Put(). x is dropped if it holds more than SetMaxPooledBytes() allows.
+func (c *countedPool) Put(x any) {
+	if max := atomic.LoadInt64(&maxPooledBytes); max > 0 && c.size != nil && int64(c.size(x)) > max {
+		atomic.AddUint64(&c.dropped, 1)
+		return
+	}
+	atomic.AddUint64(&c.puts, 1)
+	c.Pool.Put(x)
+}
+
+// trim drops every value in c. These are not counted as Gets. Another goroutine missing at the
+// same time can end this early, which TrimPools() does not promise against. c can't hold more
+// values than were put in it, so this stops after that many Gets even if values are put back
+// while it runs.
+func (c *countedPool) trim() {
+	misses := atomic.LoadUint64(&c.misses)
+	puts := atomic.LoadUint64(&c.puts)
+	for i := uint64(0); i < puts; i++ {
+		c.Pool.Get()
+		if atomic.LoadUint64(&c.misses) != misses {
+			// The last Get() was a miss, it made a value instead of dropping one.
+			atomic.AddUint64(&c.misses, ^uint64(0))
+			return
+		}
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// PoolStat holds usage counters for a single pool.
+type PoolStat struct {
+	// Gets is the number of values taken from the pool.
+	Gets uint64
+	// Misses is the number of Gets that had to allocate a new value because the pool was empty.
+	Misses uint64
+	// Puts is the number of values returned to the pool. Lists are returned to their pools by
+	// the garbage collector, which isn't counted, so this is always 0 for them.
+	Puts uint64
+	// Dropped is the number of values that were let go instead of being reused, because TrimPools()
+	// removed them or they held more than SetMaxPooledBytes() allows.
+	Dropped uint64
+}
+
+// HitRate is the fraction of Gets that reused a value from the pool.
+func (p PoolStat) HitRate() float64 {
+	if p.Gets == 0 {
+		return 0
+	}
+	return float64(p.Gets-p.Misses) / float64(p.Gets)
+}
+
+// PoolMetrics is a snapshot of the usage of this package's internal pools.
+type PoolMetrics struct {
+	// Pools holds the stats for each pool by the name of the type it holds, such as "Bools",
+	// "Numbers[uint8]" or "bytes.Reader".
+	Pools map[string]PoolStat
+}
+
+// PoolStats returns the usage of the internal pools since the program started. This is useful for
+// tuning and for finding code that is holding onto values instead of letting them be reused.
+func PoolStats() PoolMetrics {
+	m := PoolMetrics{Pools: make(map[string]PoolStat, len(autopoolNames)+2)}
+
+	stats := pool.Stats()
+	for name, id := range autopoolNames {
+		t := &listTrims[id]
+		m.Pools[name] = PoolStat{
+			Misses:  stats[id][0] - atomic.LoadUint64(&t.misses),
+			Gets:    stats[id][1] - atomic.LoadUint64(&t.gets),
+			Dropped: atomic.LoadUint64(&t.dropped),
+		}
+	}
+	m.Pools["bytes.Reader"] = readers.stat()
+	m.Pools["Struct"] = structPool.stat()
+	return m
+}
+
+func (c *countedPool) stat() PoolStat {
+	return PoolStat{
+		Gets:    atomic.LoadUint64(&c.gets),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Puts:    atomic.LoadUint64(&c.puts),
+		Dropped: atomic.LoadUint64(&c.dropped),
+	}
+}
+
+// PublishExpvar publishes PoolStats() with the expvar package under "name". Like expvar.Publish(),
+// this panics if name is already in use.
+func PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return PoolStats() }))
 }
 
 var (
-	pool         = autopool.New()
+	pool         = listPool{autopool.New()}
 	boolPool     int
 	nUint8Pool   int
 	nUint16Pool  int
@@ -39,8 +371,42 @@ var (
 	nFloat32Pool int
 	nFloat64Pool int
 	bytesPool    int
+
+	// autopoolNames is the name of each pool in "pool" to its id, used by PoolStats().
+	autopoolNames map[string]int
+	// listTrims is what TrimPools() did to each pool in "pool", by id.
+	listTrims []trimStat
 )
 
+// listPool is the autopool.Pool that holds lists, with drain() for TrimPools().
+type listPool struct {
+	*autopool.Pool
+}
+
+// drain drops the values held in the pool for id. It returns the number of Gets that took and if
+// the last one was a miss, which made a value instead of dropping one. A pool can't hold more values
+// than it has made, which is its misses, so this stops after that many Gets even if values are put
+// back while it runs.
+func (p listPool) drain(id int) (gets uint64, missed bool) {
+	misses := p.Stats()[id][0]
+	for gets <= misses {
+		x := p.Get(id)
+		gets++
+		// Get() sets a finalizer that puts x back when it is collected, which we don't want.
+		runtime.SetFinalizer(x, nil)
+		if p.Stats()[id][0] != misses {
+			return gets, true
+		}
+	}
+	return gets, false
+}
+
+// trimStat counts the Gets TrimPools() made on a pool in "pool" to empty it, which PoolStats()
+// takes back out, and the values it dropped.
+type trimStat struct {
+	gets, misses, dropped uint64
+}
+
 func init() {
 	boolPool = pool.Add(
 		func() any {
@@ -113,6 +479,22 @@ func init() {
 			return &Bytes{}
 		},
 	)
+
+	autopoolNames = map[string]int{
+		"Bools":            boolPool,
+		"Numbers[uint8]":   nUint8Pool,
+		"Numbers[uint16]":  nUint16Pool,
+		"Numbers[uint32]":  nUint32Pool,
+		"Numbers[uint64]":  nUint64Pool,
+		"Numbers[int8]":    nInt8Pool,
+		"Numbers[int16]":   nInt16Pool,
+		"Numbers[int32]":   nInt32Pool,
+		"Numbers[int64]":   nInt64Pool,
+		"Numbers[float32]": nFloat32Pool,
+		"Numbers[float64]": nFloat64Pool,
+		"Bytes":            bytesPool,
+	}
+	listTrims = make([]trimStat, len(autopoolNames))
 }
 
 /*
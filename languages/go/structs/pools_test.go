@@ -0,0 +1,410 @@
+package structs
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	autopool "github.com/johnsiilver/golib/development/autopool/blend"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestPoolStats(t *testing.T) {
+	subMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+		},
+	}
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Sub", Type: field.FTStruct, Mapping: subMapping},
+		},
+	}
+	s := New(0, m)
+	sub := New(0, subMapping)
+	MustSetBool(sub, 0, true)
+	MustSetStruct(s, 0, sub)
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestPoolStats: Marshal(): %s", err)
+	}
+
+	before := PoolStats()
+
+	NewNumbers[uint8]()
+	NewNumbers[uint8]()
+	if _, err := NewFromReader(buff, m); err != nil { // Decoding "Sub" uses a bytes.Reader
+		t.Fatalf("TestPoolStats: NewFromReader(): %s", err)
+	}
+
+	after := PoolStats()
+
+	if got := after.Pools["Numbers[uint8]"].Gets - before.Pools["Numbers[uint8]"].Gets; got != 2 {
+		t.Errorf("TestPoolStats: Numbers[uint8] Gets: got %d, want 2", got)
+	}
+	r0, r1 := before.Pools["bytes.Reader"], after.Pools["bytes.Reader"]
+	if r1.Gets-r0.Gets != 1 || r1.Puts-r0.Puts != 1 {
+		t.Errorf("TestPoolStats: bytes.Reader: got Gets +%d, Puts +%d, want +1 for each", r1.Gets-r0.Gets, r1.Puts-r0.Puts)
+	}
+	for name, stat := range after.Pools {
+		if stat.Misses > stat.Gets {
+			t.Errorf("TestPoolStats(%s): Misses(%d) > Gets(%d)", name, stat.Misses, stat.Gets)
+		}
+	}
+
+	PublishExpvar("TestPoolStats")
+	if v := expvar.Get("TestPoolStats"); v == nil || !strings.Contains(v.String(), "Numbers[uint8]") {
+		t.Errorf("TestPoolStats: PublishExpvar() did not publish our stats")
+	}
+}
+
+func TestContextPool(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+		},
+	}
+	src := New(0, m)
+	MustSetBytes(src, 0, []byte("Prius"), true)
+	MustSetNumber(src, 1, uint16(2020))
+	buff := &bytes.Buffer{}
+	if _, err := src.Marshal(buff); err != nil {
+		t.Fatalf("TestContextPool: Marshal(): %s", err)
+	}
+
+	tests := []struct {
+		desc     string
+		ctx      context.Context
+		wantPuts uint64
+	}{
+		{desc: "no pool", ctx: context.Background(), wantPuts: 0},
+		{desc: "with pool", ctx: WithPool(context.Background()), wantPuts: 2},
+	}
+
+	for _, test := range tests {
+		before := PoolStats().Pools["Struct"]
+
+		s := NewWithContext(test.ctx, 0, m)
+		MustSetNumber(s, 1, uint16(1999))
+		d, err := NewFromReaderWithContext(test.ctx, bytes.NewReader(buff.Bytes()), m)
+		if err != nil {
+			t.Errorf("TestContextPool(%s): NewFromReaderWithContext(): %s", test.desc, err)
+			continue
+		}
+		if !Equal(d, src) {
+			t.Errorf("TestContextPool(%s): NewFromReaderWithContext() did not decode the Struct", test.desc)
+		}
+
+		ReleaseAll(test.ctx)
+		ReleaseAll(test.ctx) // Releasing again must not put the Structs in the pool twice.
+
+		if got := PoolStats().Pools["Struct"].Puts - before.Puts; got != test.wantPuts {
+			t.Errorf("TestContextPool(%s): got %d Structs released, want %d", test.desc, got, test.wantPuts)
+		}
+
+		// Structs after a release must be empty, even if they reuse a released Struct.
+		for i := 0; i < 2; i++ {
+			n := NewWithContext(test.ctx, 0, m)
+			if n.fields[0].Header != nil || n.fields[1].Header != nil || n.Size() != 8 {
+				t.Errorf("TestContextPool(%s): Struct made after ReleaseAll() was not empty", test.desc)
+			}
+		}
+		ReleaseAll(test.ctx)
+	}
+}
+
+// TestReleaseAllReturnsEverything checks that a decode, access and ReleaseAll() cycle puts back
+// everything it takes from the counted pools, including for nested Structs and lists and when
+// decoding fails. Lists are taken from pools that the garbage collector returns to, so they
+// can't leak here.
+func TestReleaseAllReturnsEverything(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Counts", Type: field.FTListUint32, FieldNum: 1},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Sub", Type: field.FTStruct, Mapping: sub},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 1, Mapping: sub},
+			{Name: "Flags", Type: field.FTListBools, FieldNum: 2},
+			{Name: "Names", Type: field.FTListBytes, FieldNum: 3},
+		},
+	}
+	m.MustValidate()
+
+	newSub := func(name string) *Struct {
+		s := New(0, sub)
+		MustSetBytes(s, 0, []byte(name), true)
+		n := NewNumbers[uint32]()
+		n.Append(1, 2, 3)
+		MustSetListNumber(s, 1, n)
+		return s
+	}
+	src := New(0, m)
+	MustSetStruct(src, 0, newSub("one"))
+	MustAppendListStruct(src, 1, newSub("two"), newSub("three"))
+	flags := NewBools(2)
+	flags.Append(true, false)
+	MustSetListBool(src, 2, flags)
+	names := NewBytes()
+	if err := names.Append([]byte("a"), []byte("b")); err != nil {
+		t.Fatalf("TestReleaseAllReturnsEverything: Append(): %s", err)
+	}
+	MustSetListBytes(src, 3, names)
+
+	buff := &bytes.Buffer{}
+	if _, err := src.Marshal(buff); err != nil {
+		t.Fatalf("TestReleaseAllReturnsEverything: Marshal(): %s", err)
+	}
+	data := buff.Bytes()
+
+	ctx := WithPool(context.Background())
+	before := PoolStats()
+
+	for i := 0; i < 3; i++ {
+		s, err := NewFromReaderWithContext(ctx, bytes.NewReader(data), m)
+		if err != nil {
+			t.Fatalf("TestReleaseAllReturnsEverything: NewFromReaderWithContext(): %s", err)
+		}
+		// Access every field, so that anything decoded on access is decoded.
+		if got := MustGetStruct(s, 0); got == nil || MustGetListNumber[uint32](got, 1).Len() != 3 {
+			t.Fatalf("TestReleaseAllReturnsEverything: Sub was not decoded")
+		}
+		for item := range MustGetListStruct(s, 1).Range(context.Background(), 0, 2) {
+			MustGetBytes(item, 0)
+		}
+		MustGetListBool(s, 2).Slice()
+		MustGetListBytes(s, 3).Get(1)
+		NewWithContext(ctx, 0, sub)
+	}
+	// A decode that fails still took a Struct from the pool.
+	if _, err := NewFromReaderWithContext(ctx, bytes.NewReader(data[:len(data)-8]), m); err == nil {
+		t.Fatalf("TestReleaseAllReturnsEverything: decoding truncated data: got err == nil, want err != nil")
+	}
+	ReleaseAll(ctx)
+
+	after := PoolStats()
+	for _, name := range []string{"Struct", "bytes.Reader"} {
+		gets := after.Pools[name].Gets - before.Pools[name].Gets
+		puts := after.Pools[name].Puts - before.Pools[name].Puts
+		if gets == 0 {
+			t.Errorf("TestReleaseAllReturnsEverything(%s): the pool was not used", name)
+		}
+		if gets != puts {
+			t.Errorf("TestReleaseAllReturnsEverything(%s): got %d Gets, but %d Puts", name, gets, puts)
+		}
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+		},
+	}
+	m.MustValidate()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		desc     string
+		ctx      context.Context
+		wantPuts uint64
+		err      bool
+	}{
+		{desc: "prewarm", ctx: context.Background(), wantPuts: 3},
+		{desc: "Error: ctx is done", ctx: canceled, err: true},
+	}
+
+	for _, test := range tests {
+		before := PoolStats().Pools["Struct"]
+
+		err := Prewarm(test.ctx, m, 3)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestPrewarm(%s): got err == nil, want err != nil", test.desc)
+		case err != nil && !test.err:
+			t.Errorf("TestPrewarm(%s): got err == %s, want err == nil", test.desc, err)
+		}
+		if got := PoolStats().Pools["Struct"].Puts - before.Puts; got != test.wantPuts {
+			t.Errorf("TestPrewarm(%s): got %d Structs put in the pool, want %d", test.desc, got, test.wantPuts)
+		}
+
+		ctx := WithPool(context.Background())
+		for i := 0; i < 3; i++ {
+			s := NewWithContext(ctx, 0, m)
+			if len(s.fields) != 2 || s.fields[0].Header != nil || s.fields[1].Header != nil || s.Size() != 8 {
+				t.Errorf("TestPrewarm(%s): Struct made after Prewarm() was not an empty Car", test.desc)
+			}
+			MustSetNumber(s, 1, uint16(2020))
+		}
+		ReleaseAll(ctx)
+	}
+}
+
+func TestSetMaxPooledBytes(t *testing.T) {
+	defer SetMaxPooledBytes(0)
+
+	m := &mapping.Map{Name: "Wide"}
+	for i := 0; i < 10; i++ {
+		m.Fields = append(m.Fields, &mapping.FieldDescr{Name: fmt.Sprintf("F%d", i), Type: field.FTUint64, FieldNum: uint16(i)})
+	}
+	m.MustValidate()
+
+	// A Struct for m holds 8 bytes of header and 10 StructField(s).
+	size := 8 + 10*int(unsafe.Sizeof(StructField{}))
+
+	tests := []struct {
+		desc        string
+		max         int
+		wantPuts    uint64
+		wantDropped uint64
+	}{
+		{desc: "no limit", max: 0, wantPuts: 1},
+		{desc: "under the limit", max: size, wantPuts: 1},
+		{desc: "over the limit", max: size - 1, wantDropped: 1},
+		{desc: "negative is no limit", max: -1, wantPuts: 1},
+	}
+
+	for _, test := range tests {
+		SetMaxPooledBytes(test.max)
+		before := PoolStats().Pools["Struct"]
+
+		if err := Prewarm(context.Background(), m, 1); err != nil {
+			panic(err)
+		}
+
+		after := PoolStats().Pools["Struct"]
+		if got := after.Puts - before.Puts; got != test.wantPuts {
+			t.Errorf("TestSetMaxPooledBytes(%s): got %d Puts, want %d", test.desc, got, test.wantPuts)
+		}
+		if got := after.Dropped - before.Dropped; got != test.wantDropped {
+			t.Errorf("TestSetMaxPooledBytes(%s): got %d Dropped, want %d", test.desc, got, test.wantDropped)
+		}
+	}
+}
+
+func TestTrimPools(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+		},
+	}
+	m.MustValidate()
+
+	if err := Prewarm(context.Background(), m, 3); err != nil {
+		panic(err)
+	}
+	before := PoolStats()
+
+	TrimPools()
+
+	after := PoolStats()
+	for name, stat := range after.Pools {
+		if stat.Gets != before.Pools[name].Gets || stat.Misses != before.Pools[name].Misses {
+			t.Errorf("TestTrimPools: pool %s: TrimPools() changed Gets or Misses: got %+v, want %+v", name, stat, before.Pools[name])
+		}
+	}
+
+	// Every Struct Prewarm() put in the pool is gone, so these all have to be made.
+	ctx := WithPool(context.Background())
+	for i := 0; i < 3; i++ {
+		NewWithContext(ctx, 0, m)
+	}
+	if got := PoolStats().Pools["Struct"].Misses - after.Pools["Struct"].Misses; got != 3 {
+		t.Errorf("TestTrimPools: got %d misses after TrimPools(), want 3", got)
+	}
+	ReleaseAll(ctx)
+}
+
+func TestListPoolDrain(t *testing.T) {
+	p := listPool{autopool.New()}
+	id := p.Add(func() any { return &Bools{} })
+
+	// Values that are still in use are not in the pool, so draining it makes one Get(), which misses.
+	held := []any{p.Get(id), p.Get(id)}
+	gets, missed := p.drain(id)
+	if gets != 1 || !missed {
+		t.Errorf("TestListPoolDrain: got (%d, %v), want (1, true)", gets, missed)
+	}
+	if got := p.Stats()[id][0]; got != 3 {
+		t.Errorf("TestListPoolDrain: got %d misses, want 3", got)
+	}
+	runtime.KeepAlive(held)
+}
+
+// BenchmarkPrewarm measures the first request after a program starts, which takes its Structs from
+// an empty pool, against one that takes them from a pool Prewarm() filled. Besides the time per
+// request, it reports the 99th percentile and the slowest of the NewWithContext() calls, which is
+// where the cost of a cold pool shows up.
+func BenchmarkPrewarm(b *testing.B) {
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Year", Type: field.FTUint16, FieldNum: 1},
+			{Name: "Miles", Type: field.FTUint64, FieldNum: 2},
+			{Name: "Owners", Type: field.FTListStrings, FieldNum: 3},
+		},
+	}
+	m.MustValidate()
+
+	const perRequest = 100
+
+	for _, prewarm := range []bool{false, true} {
+		name := "cold"
+		if prewarm {
+			name = "prewarmed"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			latencies := make([]time.Duration, 0, b.N*perRequest)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// Two collections empty a sync.Pool.
+				runtime.GC()
+				runtime.GC()
+				if prewarm {
+					if err := Prewarm(context.Background(), m, perRequest); err != nil {
+						b.Fatal(err)
+					}
+				}
+				ctx := WithPool(context.Background())
+				b.StartTimer()
+
+				for j := 0; j < perRequest; j++ {
+					start := time.Now()
+					NewWithContext(ctx, 0, m)
+					latencies = append(latencies, time.Since(start))
+				}
+
+				b.StopTimer()
+				ReleaseAll(ctx)
+				b.StartTimer()
+			}
+
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns/new")
+			b.ReportMetric(float64(latencies[len(latencies)-1].Nanoseconds()), "max-ns/new")
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package structs
+
+import (
+	"math/rand"
+
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+const (
+	// populateMaxDepth is how deep Populate() goes into Structs that hold Structs. Struct fields
+	// below this are left unset, which stops self-referential Structs from recursing forever.
+	populateMaxDepth = 3
+	// populateMaxItems is the most items Populate() puts in a list, String or Bytes.
+	populateMaxItems = 5
+)
+
+// Populate sets every field in s to a random value of the field's type, using rng for all
+// randomness. The same seed and mapping always give the same values, so a failing property
+// test can be reproduced from its seed. This is useful for checking properties, such as that
+// an encode and decode gives an equal Struct, across a schema:
+//
+//	s := structs.New(0, m)
+//	structs.Populate(s, rand.New(rand.NewSource(seed)))
+//
+// Lists, Strings and Bytes have between 1 and 5 items. Structs are populated 3 levels deep,
+// deeper Struct fields are left unset. Enum fields get random numbers, which may not be values
+// of the enum. Lists of Strings are not set. Fields already in s are replaced. If s uses zero
+// value compression, scalar fields that get their zero value are deleted, as that is what a
+// decode would give.
+func Populate(s *Struct, rng *rand.Rand) {
+	populate(s, rng, 0)
+}
+
+func populate(s *Struct, rng *rand.Rand, depth int) {
+	for _, fd := range s.mapping.Fields {
+		fieldNum := fd.FieldNum
+
+		switch fd.Type {
+		case field.FTBool:
+			populateBool(s, fieldNum, rng.Intn(2) == 1)
+		case field.FTInt8:
+			populateNumber(s, fieldNum, int8(rng.Uint32()))
+		case field.FTInt16:
+			populateNumber(s, fieldNum, int16(rng.Uint32()))
+		case field.FTInt32:
+			populateNumber(s, fieldNum, int32(rng.Uint32()))
+		case field.FTInt64:
+			populateNumber(s, fieldNum, int64(rng.Uint64()))
+		case field.FTUint8:
+			populateNumber(s, fieldNum, uint8(rng.Uint32()))
+		case field.FTUint16:
+			populateNumber(s, fieldNum, uint16(rng.Uint32()))
+		case field.FTUint32:
+			populateNumber(s, fieldNum, rng.Uint32())
+		case field.FTUint64:
+			populateNumber(s, fieldNum, rng.Uint64())
+		case field.FTFloat32:
+			populateNumber(s, fieldNum, float32(rng.NormFloat64()))
+		case field.FTFloat64:
+			populateNumber(s, fieldNum, rng.NormFloat64())
+		case field.FTString:
+			MustSetBytes(s, fieldNum, randString(rng), true)
+		case field.FTBytes:
+			MustSetBytes(s, fieldNum, randBytes(rng), false)
+		case field.FTFixedBytes:
+			b := make([]byte, fd.Size)
+			rng.Read(b)
+			MustSetFixedBytes(s, fieldNum, b)
+		case field.FTStruct:
+			if depth >= populateMaxDepth {
+				DeleteField(s, fieldNum)
+				continue
+			}
+			m, err := s.subMapping(fieldNum)
+			if err != nil {
+				panic(err)
+			}
+			sub := New(fieldNum, m)
+			sub.zeroTypeCompression = s.zeroTypeCompression
+			populate(sub, rng, depth+1)
+			MustSetStruct(s, fieldNum, sub)
+		case field.FTListBools:
+			l := NewBools(fieldNum)
+			for n := randItems(rng); n > 0; n-- {
+				l.Append(rng.Intn(2) == 1)
+			}
+			MustSetListBool(s, fieldNum, l)
+		case field.FTListInt8:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() int8 { return int8(rng.Uint32()) }))
+		case field.FTListInt16:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() int16 { return int16(rng.Uint32()) }))
+		case field.FTListInt32:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() int32 { return int32(rng.Uint32()) }))
+		case field.FTListInt64:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() int64 { return int64(rng.Uint64()) }))
+		case field.FTListUint8:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() uint8 { return uint8(rng.Uint32()) }))
+		case field.FTListUint16:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() uint16 { return uint16(rng.Uint32()) }))
+		case field.FTListUint32:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, rng.Uint32))
+		case field.FTListUint64:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, rng.Uint64))
+		case field.FTListFloat32:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, func() float32 { return float32(rng.NormFloat64()) }))
+		case field.FTListFloat64:
+			MustSetListNumber(s, fieldNum, randNumbers(rng, rng.NormFloat64))
+		case field.FTListBytes:
+			l := NewBytes()
+			for n := randItems(rng); n > 0; n-- {
+				if err := l.Append(randBytes(rng)); err != nil {
+					panic(err)
+				}
+			}
+			MustSetListBytes(s, fieldNum, l)
+		case field.FTListStructs:
+			if depth >= populateMaxDepth {
+				DeleteField(s, fieldNum)
+				continue
+			}
+			m, err := s.subMapping(fieldNum)
+			if err != nil {
+				panic(err)
+			}
+			l := NewStructs(m)
+			for n := randItems(rng); n > 0; n-- {
+				item := New(0, m)
+				item.zeroTypeCompression = s.zeroTypeCompression
+				populate(item, rng, depth+1)
+				if err := l.Append(item); err != nil {
+					panic(err)
+				}
+			}
+			MustSetListStruct(s, fieldNum, l)
+		}
+	}
+}
+
+// populateBool sets field fieldNum to value. When the field is not encoded as its zero value (see
+// compressZero()), a field set to its zero value is the same as a field that is not set, so the
+// field is deleted instead.
+func populateBool(s *Struct, fieldNum uint16, value bool) {
+	if !value && s.compressZero(s.mapping.Index(fieldNum)) {
+		DeleteField(s, fieldNum)
+		return
+	}
+	MustSetBool(s, fieldNum, value)
+}
+
+// populateNumber sets field fieldNum to value. See populateBool().
+func populateNumber[N Number](s *Struct, fieldNum uint16, value N) {
+	if value == 0 && s.compressZero(s.mapping.Index(fieldNum)) {
+		DeleteField(s, fieldNum)
+		return
+	}
+	MustSetNumber(s, fieldNum, value)
+}
+
+// randItems returns the number of items to put in a list.
+func randItems(rng *rand.Rand) int {
+	return 1 + rng.Intn(populateMaxItems)
+}
+
+// randNumbers returns a Numbers holding randItems() values from gen.
+func randNumbers[N Number](rng *rand.Rand, gen func() N) *Numbers[N] {
+	l := NewNumbers[N]()
+	for n := randItems(rng); n > 0; n-- {
+		l.Append(gen())
+	}
+	return l
+}
+
+// randBytes returns 1 to populateMaxItems random bytes.
+func randBytes(rng *rand.Rand) []byte {
+	b := make([]byte, randItems(rng))
+	rng.Read(b)
+	return b
+}
+
+// randString returns 1 to populateMaxItems random lower case letters.
+func randString(rng *rand.Rand) []byte {
+	b := make([]byte, randItems(rng))
+	for i := range b {
+		b[i] = byte('a' + rng.Intn(26))
+	}
+	return b
+}
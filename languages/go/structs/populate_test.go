@@ -0,0 +1,93 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestPopulate(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int8", Type: field.FTInt8},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Float32s", Type: field.FTListFloat32, FieldNum: 2},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+			{Name: "Int16", Type: field.FTInt16, FieldNum: 1},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 2},
+			{Name: "Float64", Type: field.FTFloat64, FieldNum: 3},
+			{Name: "Data", Type: field.FTBytes, FieldNum: 4},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 5, Mapping: sub},
+			{Name: "Bools", Type: field.FTListBools, FieldNum: 6},
+			{Name: "Int64s", Type: field.FTListInt64, FieldNum: 7},
+			{Name: "Uint8s", Type: field.FTListUint8, FieldNum: 8},
+			{Name: "Datas", Type: field.FTListBytes, FieldNum: 9},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 10, Mapping: sub},
+			{Name: "Self", Type: field.FTStruct, FieldNum: 11, SelfReferential: true},
+			{Name: "Selves", Type: field.FTListStructs, FieldNum: 12, SelfReferential: true},
+		},
+	}
+	m.MustValidate()
+
+	for _, compress := range []bool{true, false} {
+		for seed := int64(0); seed < 50; seed++ {
+			desc := fmt.Sprintf("compression %v, seed %d", compress, seed)
+			newStruct := func() *Struct {
+				s := New(0, m)
+				if !compress {
+					s.XXXSetNoZeroTypeCompression()
+				}
+				Populate(s, rand.New(rand.NewSource(seed)))
+				return s
+			}
+
+			s := newStruct()
+			if !Equal(s, newStruct()) {
+				t.Errorf("TestPopulate(%s): the same seed did not give the same Struct", desc)
+			}
+
+			// Self at depth 3 is not set, which stops the recursion.
+			deepest := MustGetStruct(MustGetStruct(MustGetStruct(s, 11), 11), 11)
+			if deepest == nil {
+				t.Fatalf("TestPopulate(%s): Self was not set 3 levels deep", desc)
+			}
+			if MustGetStruct(deepest, 11) != nil {
+				t.Errorf("TestPopulate(%s): Self was set 4 levels deep", desc)
+			}
+
+			if !compress {
+				for i, f := range s.fields {
+					if f.Header == nil {
+						t.Errorf("TestPopulate(%s): field %d was not set", desc, i)
+					}
+				}
+				// Decoding always uses zero value compression, so only the default round trips.
+				continue
+			}
+
+			buff := &bytes.Buffer{}
+			if _, err := s.Marshal(buff); err != nil {
+				t.Errorf("TestPopulate(%s): Marshal(): %s", desc, err)
+				continue
+			}
+			got, err := NewFromReader(bytes.NewReader(buff.Bytes()), m)
+			if err != nil {
+				t.Errorf("TestPopulate(%s): NewFromReader(): %s", desc, err)
+				continue
+			}
+			if !Equal(got, s) {
+				t.Errorf("TestPopulate(%s): decoded Struct was not equal to the populated Struct", desc)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bearlytools/claw/internal/binary"
+	"github.com/bearlytools/claw/languages/go/field"
+)
+
+// SortBy sorts the list in ascending order of the value in field "fieldNum" of each item. The field
+// must be a bool, number, string or bytes field (enums sort by their number). Items that don't have
+// the field set sort as the zero value. The sort is stable, so items with equal keys keep their order.
+//
+// This changes the order of the items in the list and therefore the order they are encoded in. This
+// is useful when the list is used as a set and you want the same set to always have the same encoding.
+func (s *Structs) SortBy(fieldNum uint16) error {
+	if err := s.s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
+	}
+	ft := s.mapping.Fields[idx].Type
+	switch ft {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64,
+		field.FTUint8, field.FTUint16, field.FTUint32, field.FTUint64,
+		field.FTFloat32, field.FTFloat64, field.FTString, field.FTBytes:
+	default:
+		return fmt.Errorf("%w: cannot sort by field %d, which is a %v", ErrTypeMismatch, fieldNum, ft)
+	}
+
+	s.decodeAll()
+	sort.SliceStable(s.data, func(i, j int) bool {
+		return compareField(s.data[i], s.data[j], idx, ft) < 0
+	})
+	if s.s != nil {
+		s.s.markModified()
+		s.s.markDirty(s.header.FieldNum())
+	}
+	return nil
+}
+
+// compareField compares the field at index i of the mapping (see mapping.Map.Index()), which has
+// type ft, in a and b, returning -1, 0 or 1. ft must be a scalar, string or bytes type.
+func compareField(a, b *Struct, i int, ft field.Type) int {
+	switch ft {
+	case field.FTString, field.FTBytes:
+		return bytes.Compare(fieldBytes(a, i), fieldBytes(b, i))
+	case field.FTFloat32, field.FTFloat64:
+		x, y := fieldFloat(a, i, ft), fieldFloat(b, i, ft)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64:
+		x, y := fieldInt(a, i, ft), fieldInt(b, i, ft)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	// Bools and unsigned numbers.
+	x, y := fieldBits(a, i, ft), fieldBits(b, i, ft)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// fieldBits returns the raw bits of a scalar field, or 0 if it is not set.
+func fieldBits(s *Struct, i int, ft field.Type) uint64 {
+	f := s.fields[i]
+	if f.Header == nil {
+		return 0
+	}
+	switch ft {
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		return binary.Get[uint64](*(*[]byte)(f.Ptr))
+	}
+	return f.Header.Final40()
+}
+
+func fieldInt(s *Struct, i int, ft field.Type) int64 {
+	v := fieldBits(s, i, ft)
+	switch ft {
+	case field.FTInt8:
+		return int64(int8(v))
+	case field.FTInt16:
+		return int64(int16(v))
+	case field.FTInt32:
+		return int64(int32(v))
+	}
+	return int64(v)
+}
+
+func fieldFloat(s *Struct, i int, ft field.Type) float64 {
+	v := fieldBits(s, i, ft)
+	if ft == field.FTFloat32 {
+		return float64(math.Float32frombits(uint32(v)))
+	}
+	return math.Float64frombits(v)
+}
+
+func fieldBytes(s *Struct, i int) []byte {
+	f := s.fields[i]
+	if f.Header == nil || f.Ptr == nil {
+		return nil
+	}
+	return *(*[]byte)(f.Ptr)
+}
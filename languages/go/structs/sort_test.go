@@ -0,0 +1,100 @@
+package structs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestStructsSortBy(t *testing.T) {
+	item := &mapping.Map{
+		Name: "Item",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Count", Type: field.FTInt16, FieldNum: 1},
+			{Name: "Weight", Type: field.FTFloat64, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, SelfReferential: true},
+		},
+	}
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Items", Type: field.FTListStructs, Mapping: item},
+		},
+	}
+
+	type vals struct {
+		name   string
+		count  int16
+		weight float64
+	}
+	items := []vals{
+		{name: "b", count: 3, weight: 2.5},
+		{name: "c", count: -7, weight: -1},
+		{name: "", count: 0, weight: 0}, // Not set, so it sorts as the zero value.
+		{name: "a", count: 3, weight: 100},
+	}
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		want     []string
+		err      bool
+	}{
+		{desc: "Error: field doesn't exist", fieldNum: 10, err: true},
+		{desc: "Error: can't sort by a Struct", fieldNum: 3, err: true},
+		{desc: "string", fieldNum: 0, want: []string{"", "a", "b", "c"}},
+		{desc: "signed number is stable", fieldNum: 1, want: []string{"c", "", "b", "a"}},
+		{desc: "float", fieldNum: 2, want: []string{"c", "", "b", "a"}},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		for _, v := range items {
+			x := New(0, item)
+			if v.name != "" {
+				MustSetBytes(x, 0, []byte(v.name), true)
+				MustSetNumber(x, 1, v.count)
+				MustSetNumber(x, 2, v.weight)
+			}
+			MustAppendListStruct(s, 0, x)
+		}
+		l := MustGetListStruct(s, 0)
+
+		err := l.SortBy(test.fieldNum)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestStructsSortBy(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestStructsSortBy(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		// Make sure the new order is what is encoded.
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestStructsSortBy(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		decoded, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestStructsSortBy(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+
+		dl := MustGetListStruct(decoded, 0)
+		for i, want := range test.want {
+			var got string
+			if b := MustGetBytes(dl.Get(i), 0); b != nil {
+				got = string(*b)
+			}
+			if got != want {
+				t.Errorf("TestStructsSortBy(%s): item %d: got %q, want %q", test.desc, i, got, want)
+			}
+		}
+	}
+}
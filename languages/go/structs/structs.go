@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"reflect"
 	"sync/atomic"
 	"unsafe"
 
@@ -59,6 +60,44 @@ type Struct struct {
 	// zeroTypeCompression indicates if we want to compress the encoding by ignoring
 	// scalar zero values.
 	zeroTypeCompression bool
+
+	// disallowUnknown causes decoding to fail on fields that aren't in the mapping.
+	// See UnmarshalOptions.DisallowUnknownFields.
+	disallowUnknown bool
+	// maxListElements is UnmarshalOptions.MaxListElements. truncateLists is
+	// UnmarshalOptions.TruncateLists and is only set on the top level Struct, which truncates
+	// the lists of every Struct before they are decoded.
+	maxListElements int
+	truncateLists   bool
+	// lazyLists is UnmarshalOptions.LazyListStructs.
+	lazyLists bool
+	// shared is set by UnmarshalShared(), which decodes Structs in the data passed to it instead of in a copy.
+	shared bool
+	// frozen is set by Freeze().
+	frozen bool
+	// retained is set by Retain().
+	retained bool
+	// partial is set on the Structs built by delta() and group(), which only write the fields they
+	// hold. Other Structs write their Dense fields even when they are not set.
+	partial bool
+	// stats is where the decode records its DecodeStats when UnmarshalOptions.Observer is set.
+	stats *DecodeStats
+	// grown is how much the size of s differs from the data it was decoded from, apart from its own
+	// Dense fields that are not set. The Structs s holds are larger when Dense fields are not in
+	// their data, and fields skipped because of UnmarshalOptions.SparseFields are not in our size.
+	// It is only used while decoding.
+	grown int64
+	// sparse holds the field numbers in UnmarshalOptions.SparseFields. It is only set on the top
+	// level Struct while decoding.
+	sparse *fieldSet
+
+	// cached holds the output of CachedMarshal(). It is only valid if modified is false.
+	cached []byte
+	// modified is set when s or a value it holds changes. See markModified().
+	modified bool
+	// dirty holds the fields of s that changed since it was decoded or ClearDirty() was called.
+	// See MarshalDelta().
+	dirty fieldSet
 }
 
 // New creates a NewStruct that is used to create a *Struct for a specific data type.
@@ -77,7 +116,7 @@ func New(fieldNum uint16, dataMap *mapping.Map) *Struct {
 		structTotal:         new(int64),
 		zeroTypeCompression: true,
 	}
-	XXXAddToTotal(s, 8) // the header
+	XXXAddToTotal(s, 8+denseSize(dataMap)) // the header and the Dense fields
 	return s
 }
 
@@ -85,7 +124,7 @@ func New(fieldNum uint16, dataMap *mapping.Map) *Struct {
 func NewFromReader(r io.Reader, maps *mapping.Map) (*Struct, error) {
 	s := New(0, maps)
 
-	if _, err := s.unmarshal(r); err != nil {
+	if _, err := s.unmarshalTop(r); err != nil {
 		return nil, err
 	}
 	return s, nil
@@ -100,6 +139,153 @@ func (s *Struct) XXXSetNoZeroTypeCompression() {
 	s.zeroTypeCompression = false
 }
 
+// compressZero reports if field i is left out of the encoding when it is the zero value. This is
+// true when s uses zero value compression, unless the field is Dense or has a Default. A field with
+// a Default that is left out would read as the Default, not the zero value it was set to.
+func (s *Struct) compressZero(i int) bool {
+	desc := s.mapping.Fields[i]
+	return s.zeroTypeCompression && !desc.Dense && desc.Default == nil
+}
+
+// denseSize returns the encoded size of the Dense fields in m. These are written whether they are set
+// or not, so they are part of the size of every Struct of m.
+func denseSize(m *mapping.Map) int {
+	size := 0
+	for _, fd := range m.Fields {
+		if !fd.Dense {
+			continue
+		}
+		switch fd.Type {
+		case field.FTInt64, field.FTUint64, field.FTFloat64:
+			size += 16
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// unsetDenseSize returns the encoded size of the Dense fields of s that are not set.
+func (s *Struct) unsetDenseSize() int {
+	size := 0
+	for i, fd := range s.mapping.Fields {
+		if fd.Dense && s.fields[i].Header == nil {
+			size += encodedFieldSize(s, i)
+		}
+	}
+	return size
+}
+
+// denseField returns the field that is written for the Dense field fd when it is not set, which
+// holds the field's Default, or the zero value if it has none.
+func denseField(fd *mapping.FieldDescr) StructField {
+	var v uint64
+	if fd.Default != nil {
+		v, _ = scalarBits(fd.Type, fd.Default)
+	}
+	h := NewGenericHeader()
+	h.SetFieldNum(fd.FieldNum)
+	h.SetFieldType(fd.Type)
+	switch fd.Type {
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		b := make([]byte, 8)
+		binary.Put(b, v)
+		return StructField{Header: h, Ptr: unsafe.Pointer(&b)}
+	}
+	h.SetFinal40(v)
+	return StructField{Header: h}
+}
+
+// resize adds the change in the encoded size of field i, which was "before" bytes, to the size of s.
+// Bool, number, String and Bytes fields that aren't encoded because they are the zero value (see
+// compressZero()) don't count towards the size, so setting one to or from the zero value changes it.
+func (s *Struct) resize(i int, before int) {
+	if d := encodedFieldSize(s, i) - before; d != 0 {
+		XXXAddToTotal(s, d)
+	}
+}
+
+// setZeroTypeCompression sets if s uses zero value compression and returns how much that changed
+// the size of s by. Only the size of s itself is changed, the caller must add the change to anything
+// holding s.
+func (s *Struct) setZeroTypeCompression(v bool) int64 {
+	if s.zeroTypeCompression == v {
+		return 0
+	}
+	before := 0
+	for i := range s.fields {
+		if compressible(s.mapping.Fields[i].Type) {
+			before += encodedFieldSize(s, i)
+		}
+	}
+	s.zeroTypeCompression = v
+	after := 0
+	for i := range s.fields {
+		if compressible(s.mapping.Fields[i].Type) {
+			after += encodedFieldSize(s, i)
+		}
+	}
+	d := int64(after - before)
+	if d != 0 {
+		total := atomic.AddInt64(s.structTotal, d)
+		s.header.SetFinal40(uint64(total))
+		s.modified = true
+	}
+	return d
+}
+
+// compressible reports if a field of type t is left out of the encoding when it is the zero value
+// and zero value compression is on.
+func compressible(t field.Type) bool {
+	switch t {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTUint64, field.FTFloat32, field.FTFloat64,
+		field.FTString, field.FTBytes:
+		return true
+	}
+	return false
+}
+
+// Freeze makes s, and every Struct it holds, unchangeable. Functions and methods that would change
+// them return an error wrapping ErrFrozen, or panic with one if they don't return an error. This
+// includes the lists s holds. A frozen Struct can't be added to another Struct or list, as that
+// changes it, but a Clone() of it, which is not frozen, can be. This cannot be undone.
+//
+// A frozen Struct can be read from many goroutines at once, such as a shared default value,
+// except with CachedMarshal(), which records the encoding in the Struct.
+func (s *Struct) Freeze() {
+	s.frozen = true
+	for i, f := range s.fields {
+		if f.Header == nil {
+			continue
+		}
+		switch s.mapping.Fields[i].Type {
+		case field.FTStruct:
+			(*Struct)(f.Ptr).Freeze()
+		case field.FTListStructs:
+			l := (*Structs)(f.Ptr)
+			// Decoding an item stores it in the list, which must not happen after this.
+			l.decodeAll()
+			for _, item := range l.data {
+				item.Freeze()
+			}
+		}
+	}
+}
+
+// Frozen reports if s was frozen with Freeze(), either itself or by a Struct holding it.
+func (s *Struct) Frozen() bool {
+	return s.frozen
+}
+
+// checkFrozen returns an error wrapping ErrFrozen if s is frozen. s may be nil.
+func (s *Struct) checkFrozen() error {
+	if s != nil && s.frozen {
+		return fmt.Errorf("%w: the Struct cannot be changed", ErrFrozen)
+	}
+	return nil
+}
+
 // NewFrom creates a new Struct that represents the same Struct type.
 func (s *Struct) NewFrom() *Struct {
 	h := GenericHeader(make([]byte, 8))
@@ -112,10 +298,55 @@ func (s *Struct) NewFrom() *Struct {
 		structTotal:         new(int64),
 		zeroTypeCompression: s.zeroTypeCompression,
 	}
-	XXXAddToTotal(n, 8) // the header
+	XXXAddToTotal(n, 8+denseSize(s.mapping)) // the header and the Dense fields
 	return n
 }
 
+// Clone returns a deep copy of s. The copy does not share any memory with s and has no parent.
+func (s *Struct) Clone() *Struct {
+	n := s.NewFrom()
+	if err := Merge(n, s); err != nil {
+		panic(fmt.Sprintf("bug: could not clone Struct: %s", err))
+	}
+	return n
+}
+
+// Reset removes all fields from s, leaving an empty Struct of the same type. If s is a field in
+// another Struct, that Struct's size is updated. It panics if s is frozen.
+func (s *Struct) Reset() {
+	if err := s.checkFrozen(); err != nil {
+		panic(err)
+	}
+	for i, f := range s.fields {
+		if f.Header == nil {
+			continue
+		}
+		// Detach values the user may still hold, so changing them doesn't change our size.
+		switch ft := s.mapping.Fields[i].Type; {
+		case ft == field.FTStruct:
+			(*Struct)(f.Ptr).parent = nil
+		case ft == field.FTListBools:
+			(*Bools)(f.Ptr).s = nil
+		case ft == field.FTListBytes:
+			(*Bytes)(f.Ptr).s = nil
+		case ft == field.FTListStructs:
+			l := (*Structs)(f.Ptr)
+			l.s = nil
+			for _, item := range l.data {
+				if item != nil {
+					item.parent = nil
+				}
+			}
+		case ft >= field.FTListInt8 && ft <= field.FTListFloat64:
+			// The layout of Numbers does not depend on the type of number.
+			(*Numbers[uint8])(f.Ptr).s = nil
+		}
+		s.fields[i] = StructField{}
+		s.markDirty(s.mapping.Fields[i].FieldNum)
+	}
+	XXXAddToTotal(s, int64(8+denseSize(s.mapping))-atomic.LoadInt64(s.structTotal))
+}
+
 func (s *Struct) Map() *mapping.Map {
 	return s.mapping
 }
@@ -127,25 +358,27 @@ func (s *Struct) Fields() []StructField {
 
 // IsSet determines if our Struct has a field set or not. If the fieldNum is invalid,
 // this simply returns false. If NoZeroTypeCompression is NOT set, then we will return
-// true for all scaler values, string and bytes.
+// true for all scaler values, string and bytes. FixedBytes fields are always encoded when set,
+// so they report if they were set either way.
 func (s *Struct) IsSet(fieldNum uint16) bool {
-	if int(fieldNum) > len(s.mapping.Fields) {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
 		return false
 	}
 
 	// Not type compression means that we always have a header for a value, even the zero value.
 	if !s.zeroTypeCompression {
-		return s.fields[fieldNum].Header != nil
+		return s.fields[idx].Header != nil
 	}
 
 	// Well, then if the Header isn't nil, we know it is set.
-	if s.fields[fieldNum].Header != nil {
+	if s.fields[idx].Header != nil {
 		return true
 	}
 
 	// The Header is nil, so only some types can still report if they are not set.
-	t := s.mapping.Fields[int(fieldNum)].Type
-	if t == field.FTStruct {
+	t := s.mapping.Fields[idx].Type
+	if t == field.FTStruct || t == field.FTFixedBytes {
 		return false
 	}
 	for _, lt := range field.ListTypes {
@@ -157,20 +390,39 @@ func (s *Struct) IsSet(fieldNum uint16) bool {
 	return true
 }
 
+// SetFields returns the numbers of the fields in s that Marshal() would write, in order. Unlike IsSet(),
+// a bool, number, string or bytes field that holds the zero value is not included when s uses zero value
+// compression, as it is not encoded. Fields the mapping does not have, such as ones from a newer version
+// of the Struct, are not included.
+func SetFields(s *Struct) []uint16 {
+	var nums []uint16
+	for i := range s.fields {
+		if s.mapping.Fields[i].Type == field.FTUnknown {
+			continue
+		}
+		if fieldEncoded(s, i) {
+			nums = append(nums, s.mapping.Fields[i].FieldNum)
+		}
+	}
+	return nums
+}
+
 var boolMask = bits.Mask[uint64](24, 25)
 
 // GetBool gets a bool value from field at fieldNum. This return an error if the field
 // is not a bool or fieldNum is not a valid field number. If the field is not set, it
 // returns false with no error.
 func GetBool(s *Struct, fieldNum uint16) (bool, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBool); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBool)
+	if err != nil {
 		return false, err
 	}
 
-	f := s.fields[fieldNum]
-	// Return the zero value of a non-set field.
+	f := s.fields[idx]
+	// Return the default or zero value of a non-set field.
 	if f.Header == nil {
-		return false, nil
+		b, _ := s.mapping.Fields[idx].Default.(bool)
+		return b, nil
 	}
 
 	i := binary.Get[uint64](f.Header)
@@ -180,6 +432,17 @@ func GetBool(s *Struct, fieldNum uint16) (bool, error) {
 	return false, nil
 }
 
+// GetBoolOK is GetBool(), but also reports if the field is set. When it is not, the value is the
+// field's default or false. With zero value compression a field without a default that is set to
+// false is not encoded, so it is not set after s is decoded.
+func GetBoolOK(s *Struct, fieldNum uint16) (value bool, ok bool, err error) {
+	v, err := GetBool(s, fieldNum)
+	if err != nil {
+		return false, false, err
+	}
+	return v, s.fields[s.mapping.Index(fieldNum)].Header != nil, nil
+}
+
 func MustGetBool(s *Struct, fieldNum uint16) bool {
 	b, err := GetBool(s, fieldNum)
 	if err != nil {
@@ -188,24 +451,35 @@ func MustGetBool(s *Struct, fieldNum uint16) bool {
 	return b
 }
 
-// SetBool sets a boolean value in field "fieldNum" to value "value".
+// SetBool sets a boolean value in field "fieldNum" to value "value". Setting a field to the value
+// it already holds is not a change, so a cached encoding of s (see CachedMarshal()) is kept.
 func SetBool(s *Struct, fieldNum uint16, value bool) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBool); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBool)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	before := encodedFieldSize(s, idx)
+	f := s.fields[idx]
 	if f.Header == nil {
 		f.Header = NewGenericHeader()
 		f.Header.SetFieldNum(fieldNum)
 		f.Header.SetFieldType(field.FTBool)
 
 		log.Println("parent: ", s.parent)
-		XXXAddToTotal(s, 8)
+	} else if bits.GetBit(binary.Get[uint64](f.Header), 24) == value {
+		// Setting the value the field already has is not a change.
+		return nil
 	}
 	n := conversions.BytesToNum[uint64](f.Header)
 	*n = bits.SetBit(*n, 24, value)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -218,28 +492,69 @@ func MustSetBool(s *Struct, fieldNum uint16, value bool) {
 
 // DeleteBool deletes a boolean and updates our storage total.
 func DeleteBool(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBool); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	s.fields[fieldNum].Header = nil
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBool)
+	if err != nil {
+		return err
+	}
+	if s.fields[idx].Header == nil {
+		return nil
+	}
+	before := encodedFieldSize(s, idx)
+	s.fields[idx].Header = nil
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
 	return nil
 }
 
+// defaultNumber converts the FieldDescr.Default d, which is the wire type of the field, to N.
+// N may be a type defined from the wire type, such as an Enum. A nil d returns 0.
+func defaultNumber[N Number](d any) N {
+	switch v := d.(type) {
+	case uint8:
+		return N(v)
+	case uint16:
+		return N(v)
+	case uint32:
+		return N(v)
+	case uint64:
+		return N(v)
+	case int8:
+		return N(v)
+	case int16:
+		return N(v)
+	case int32:
+		return N(v)
+	case int64:
+		return N(v)
+	case float32:
+		return N(v)
+	case float64:
+		return N(v)
+	}
+	return 0
+}
+
 // GetNumber gets a number value at fieldNum.
 func GetNumber[N Number](s *Struct, fieldNum uint16) (N, error) {
-	if err := validateFieldNum(fieldNum, s.mapping); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
 		return 0, err
 	}
-	desc := s.mapping.Fields[fieldNum]
+	desc := s.mapping.Fields[idx]
 
 	size, isFloat, err := numberToDescCheck[N](desc)
 	if err != nil {
 		return 0, fmt.Errorf("error getting field number %d: %w", fieldNum, err)
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
+	// Return the default or zero value of a non-set field.
 	if f.Header == nil {
-		return 0, nil
+		return defaultNumber[N](desc.Default), nil
 	}
 
 	if size < 64 {
@@ -258,6 +573,17 @@ func GetNumber[N Number](s *Struct, fieldNum uint16) (N, error) {
 	return N(binary.Get[uint64](b)), nil
 }
 
+// GetNumberOK is GetNumber(), but also reports if the field is set. When it is not, the value is
+// the field's default or 0. With zero value compression a field without a default that is set to 0
+// is not encoded, so it is not set after s is decoded.
+func GetNumberOK[N Number](s *Struct, fieldNum uint16) (value N, ok bool, err error) {
+	v, err := GetNumber[N](s, fieldNum)
+	if err != nil {
+		return 0, false, err
+	}
+	return v, s.fields[s.mapping.Index(fieldNum)].Header != nil, nil
+}
+
 func MustGetNumber[N Number](s *Struct, fieldNum uint16) N {
 	n, err := GetNumber[N](s, fieldNum)
 	if err != nil {
@@ -266,34 +592,23 @@ func MustGetNumber[N Number](s *Struct, fieldNum uint16) N {
 	return n
 }
 
-// SetNumber sets a number value in field "fieldNum" to value "value".
+// SetNumber sets a number value in field "fieldNum" to value "value". Setting a field to the value
+// it already holds is not a change, so a cached encoding of s (see CachedMarshal()) is kept.
 func SetNumber[N Number](s *Struct, fieldNum uint16, value N) error {
-	if err := validateFieldNum(fieldNum, s.mapping); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	desc := s.mapping.Fields[fieldNum]
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
+	}
+	desc := s.mapping.Fields[idx]
 
 	size, isFloat, err := numberToDescCheck[N](desc)
 	if err != nil {
 		return fmt.Errorf("error setting field number %d: %w", fieldNum, err)
 	}
 
-	f := s.fields[fieldNum]
-	// If the field isn't allocated, allocate space.
-	if f.Header == nil {
-		f.Header = NewGenericHeader()
-		switch size < 64 {
-		case true:
-			XXXAddToTotal(s, 8)
-		case false:
-			b := make([]byte, 8)
-			f.Ptr = unsafe.Pointer(&b)
-			XXXAddToTotal(s, 16)
-		default:
-			panic("wtf")
-		}
-	}
-
 	// Its will store up to 2 uint64s that will be written. 1 is written if we can fit our value
 	// in the header, 2 if we can't.
 	ints := [2]uint64{}
@@ -307,32 +622,41 @@ func SetNumber[N Number](s *Struct, fieldNum uint16, value N) error {
 		case true:
 			i := math.Float32bits(float32(value))
 			ints[0] = bits.SetValue(i, ints[0], 24, 64)
-			binary.Put(f.Header[:8], ints[0])
 		case false:
-			i := math.Float64bits(float64(value))
-			ints[1] = i
-			binary.Put(f.Header[:8], ints[0])
-			d := (*[]byte)(f.Ptr)
-			binary.Put(*d, ints[1])
-		default:
-			panic("wtf")
+			ints[1] = math.Float64bits(float64(value))
 		}
 	} else {
 		// Now encode the Number.
 		switch size < 64 {
 		case true:
 			ints[0] = bits.SetValue(uint32(value), ints[0], 24, 64)
-			binary.Put(f.Header[:8], ints[0])
 		case false:
 			ints[1] = uint64(value)
-			binary.Put(f.Header[:8], ints[0])
-			d := (*[]byte)(f.Ptr)
-			binary.Put(*d, ints[1])
-		default:
-			panic("wtf")
 		}
 	}
-	s.fields[fieldNum] = f
+
+	before := encodedFieldSize(s, idx)
+	f := s.fields[idx]
+	// If the field isn't allocated, allocate space.
+	if f.Header == nil {
+		f.Header = NewGenericHeader()
+		if size == 64 {
+			b := make([]byte, 8)
+			f.Ptr = unsafe.Pointer(&b)
+		}
+	} else if binary.Get[uint64](f.Header) == ints[0] && (size < 64 || binary.Get[uint64](*(*[]byte)(f.Ptr)) == ints[1]) {
+		// Setting the value the field already has is not a change.
+		return nil
+	}
+
+	binary.Put(f.Header[:8], ints[0])
+	if size == 64 {
+		binary.Put(*(*[]byte)(f.Ptr), ints[1])
+	}
+	s.fields[idx] = f
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -345,47 +669,61 @@ func MustSetNumber[N Number](s *Struct, fieldNum uint16, value N) {
 
 // DeleteNumber deletes the number and updates our storage total.
 func DeleteNumber(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	desc := s.mapping.Fields[fieldNum]
-
-	switch desc.Type {
-	case field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8, field.FTUint16, field.FTUint32, field.FTFloat32:
-		XXXAddToTotal(s, -8)
-	case field.FTInt64, field.FTUint64, field.FTFloat64:
-		XXXAddToTotal(s, -16)
-	default:
-		panic("wtf")
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
+	}
+	if s.fields[idx].Header == nil {
+		return nil
 	}
-	f := s.fields[fieldNum]
+	before := encodedFieldSize(s, idx)
+	f := s.fields[idx]
 	f.Header = nil
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetBytes returns a field of bytes (also our string as well in []byte form). If the value was not
-// set, this is returned as nil. If it was set, but empty, this will be []byte{}. It is UNSAFE to modify
-// this.
+// set, this is returned as nil. If it was set, but empty, this will be a pointer to a non-nil []byte{}.
+// With zero value compression (the default), a set, but empty, value is not encoded, so it is nil
+// after s is decoded. It is UNSAFE to modify this.
 func GetBytes(s *Struct, fieldNum uint16) (*[]byte, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString)
+	if err != nil {
 		return nil, err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil { // The zero value
 		return nil, nil
 	}
 
 	if f.Ptr == nil { // Set, but value is empty
-		return nil, nil
+		b := []byte{}
+		return &b, nil
 	}
 
 	x := (*[]byte)(f.Ptr)
 	return x, nil
 }
 
+// GetBytesOK is GetBytes(), but also reports if the field is set, which is the same as the value
+// not being nil.
+func GetBytesOK(s *Struct, fieldNum uint16) (value *[]byte, ok bool, err error) {
+	v, err := GetBytes(s, fieldNum)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, v != nil, nil
+}
+
 func MustGetBytes(s *Struct, fieldNum uint16) *[]byte {
 	b, err := GetBytes(s, fieldNum)
 	if err != nil {
@@ -394,33 +732,36 @@ func MustGetBytes(s *Struct, fieldNum uint16) *[]byte {
 	return b
 }
 
-// SetBytes sets a field of bytes (also our string as well in []byte form).
+// SetBytes sets a field of bytes (also our string as well in []byte form). A non-nil empty value
+// records that the field is set, but empty. Passing a nil value is the same as calling DeleteBytes().
+// Note that a set, but empty, value is only kept on the wire if NoZeroValueCompression is set.
 func SetBytes(s *Struct, fieldNum uint16, value []byte, isString bool) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	if len(value) == 0 {
-		return fmt.Errorf("cannot encode an empty Bytes value")
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return DeleteBytes(s, fieldNum)
 	}
 
-	if len(value) > maxDataSize {
-		return fmt.Errorf("cannot set a String or Byte field to size > 1099511627775")
+	if err := checkBytesSize(s, fieldNum, len(value)); err != nil {
+		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 
 	ftype := field.FTBytes
 	if isString {
 		ftype = field.FTString
 	}
 
-	remove := 0
+	before := encodedFieldSize(s, idx)
 	// If the field isn't allocated, allocate space.
 	if f.Header == nil {
 		f.Header = NewGenericHeader()
-	} else { // We need to remove our existing entry size total before applying our new data
-		remove += 8 + SizeWithPadding(int(f.Header.Final40()))
-		XXXAddToTotal(s, -remove)
 	}
 	f.Header.SetFieldNum(fieldNum)
 	f.Header.SetFieldType(ftype)
@@ -429,8 +770,19 @@ func SetBytes(s *Struct, fieldNum uint16, value []byte, isString bool) error {
 	f.Ptr = unsafe.Pointer(&value)
 	// We don't store any padding at this point because we don't want to do another allocation.
 	// But we do record the size it would be with padding.
-	XXXAddToTotal(s, int64(8+SizeWithPadding(len(value))))
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
+	return nil
+}
+
+// checkBytesSize returns an ErrSizeExceeded if a String or Bytes of "size" bytes can't be stored
+// in fieldNum. It takes the size instead of the value so it can be tested without the memory.
+func checkBytesSize(s *Struct, fieldNum uint16, size int) error {
+	if size > maxDataSize {
+		return fmt.Errorf("%w: cannot set %s to a String or Bytes of size %d, which is > %d", ErrSizeExceeded, fieldString(s, fieldNum), size, maxDataSize)
+	}
 	return nil
 }
 
@@ -443,31 +795,124 @@ func MustSetBytes(s *Struct, fieldNum uint16, value []byte, isString bool) {
 
 // DeleteBytes deletes a bytes field and updates our storage total.
 func DeleteBytes(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTBytes, field.FTString)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
 
+	XXXAddToTotal(s, -encodedFieldSize(s, idx))
 	f.Header = nil
-	x := (*[]byte)(f.Ptr)
-	XXXAddToTotal(s, -len(*x))
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
+	return nil
+}
+
+// GetFixedBytes returns the value of a FixedBytes field, which has the length given by the Size in
+// the field's mapping. If the value was not set, this returns nil. It is UNSAFE to modify this.
+func GetFixedBytes(s *Struct, fieldNum uint16) ([]byte, error) {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTFixedBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	f := s.fields[idx]
+	if f.Header == nil {
+		return nil, nil
+	}
+	return *(*[]byte)(f.Ptr), nil
+}
+
+func MustGetFixedBytes(s *Struct, fieldNum uint16) []byte {
+	b, err := GetFixedBytes(s, fieldNum)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// SetFixedBytes sets a FixedBytes field to value, which must have the length given by the Size in
+// the field's mapping. Like SetBytes(), value is not copied. Passing a nil value is the same as calling
+// DeleteFixedBytes(). A FixedBytes field is encoded when it is set, even if value is all zeros.
+func SetFixedBytes(s *Struct, fieldNum uint16, value []byte) error {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTFixedBytes)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return DeleteFixedBytes(s, fieldNum)
+	}
+	if size := int(s.mapping.Fields[idx].Size); len(value) != size {
+		return fmt.Errorf("%w: %s holds %d bytes, but value has %d", ErrTypeMismatch, fieldString(s, fieldNum), size, len(value))
+	}
+
+	f := s.fields[idx]
+	before := encodedFieldSize(s, idx)
+	if f.Header == nil {
+		f.Header = NewGenericHeader()
+	}
+	f.Header.SetFieldNum(fieldNum)
+	f.Header.SetFieldType(field.FTFixedBytes)
+	// The size is also in the mapping, but is kept here so that decoders without our mapping can skip the field.
+	f.Header.SetFinal40(uint64(len(value)))
+	f.Ptr = unsafe.Pointer(&value)
+
+	s.fields[idx] = f
+	s.resize(idx, before)
+	s.markModified()
+	s.markDirty(fieldNum)
+	return nil
+}
+
+func MustSetFixedBytes(s *Struct, fieldNum uint16, value []byte) {
+	if err := SetFixedBytes(s, fieldNum, value); err != nil {
+		panic(err)
+	}
+}
+
+// DeleteFixedBytes deletes a FixedBytes field and updates our storage total.
+func DeleteFixedBytes(s *Struct, fieldNum uint16) error {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTFixedBytes)
+	if err != nil {
+		return err
+	}
+
+	f := s.fields[idx]
+	if f.Header == nil {
+		return nil
+	}
+
+	XXXAddToTotal(s, -encodedFieldSize(s, idx))
+	f.Header = nil
+	f.Ptr = nil
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetStruct returns a Struct field . If the value was not set, this is returned as nil. If it was set,
 // but empty, this will be *Struct with no data.
 func GetStruct(s *Struct, fieldNum uint16) (*Struct, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTStruct); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTStruct)
+	if err != nil {
 		return nil, err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil { // The zero value
 		return nil, nil
 	}
@@ -476,6 +921,16 @@ func GetStruct(s *Struct, fieldNum uint16) (*Struct, error) {
 	return x, nil
 }
 
+// GetStructOK is GetStruct(), but also reports if the field is set, which is the same as the value
+// not being nil.
+func GetStructOK(s *Struct, fieldNum uint16) (value *Struct, ok bool, err error) {
+	v, err := GetStruct(s, fieldNum)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, v != nil, nil
+}
+
 func MustGetStruct(s *Struct, fieldNum uint16) *Struct {
 	s, err := GetStruct(s, fieldNum)
 	if err != nil {
@@ -484,7 +939,8 @@ func MustGetStruct(s *Struct, fieldNum uint16) *Struct {
 	return s
 }
 
-// SetStruct sets a Struct field.
+// SetStruct sets a Struct field. An empty value is still encoded, as its 8 byte header, even with
+// zero value compression, so after decoding the field is set and GetStruct() returns an empty Struct.
 func SetStruct(s *Struct, fieldNum uint16, value *Struct) error {
 	if s == nil {
 		return fmt.Errorf("value cannot be added to a nil Struct")
@@ -492,15 +948,22 @@ func SetStruct(s *Struct, fieldNum uint16, value *Struct) error {
 	if value == nil {
 		return fmt.Errorf("value cannot be nil, to delete a Struct use DeleteStruct()")
 	}
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTStruct); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTStruct)
+	if err != nil {
 		return err
 	}
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	if value.frozen {
+		return fmt.Errorf("%w: a frozen Struct cannot be set on %s, set a Clone() of it", ErrFrozen, fieldString(s, fieldNum))
+	}
 
-	if atomic.LoadInt64(value.structTotal) > maxDataSize {
-		return fmt.Errorf("cannot set a Struct field to size > 1099511627775")
+	if size := atomic.LoadInt64(value.structTotal); size > maxDataSize {
+		return fmt.Errorf("%w: cannot set %s to a Struct of size %d, which is > %d", ErrSizeExceeded, fieldString(s, fieldNum), size, maxDataSize)
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 
 	value.parent = s
 	value.header.SetFieldNum(fieldNum)
@@ -518,7 +981,8 @@ func SetStruct(s *Struct, fieldNum uint16, value *Struct) error {
 
 	f.Ptr = unsafe.Pointer(value)
 	XXXAddToTotal(s, atomic.LoadInt64(value.structTotal))
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -531,11 +995,15 @@ func MustSetStruct(s *Struct, fieldNum uint16, value *Struct) {
 
 // DeleteStruct deletes a Struct field and updates our storage total.
 func DeleteStruct(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTStruct); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTStruct)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
@@ -548,18 +1016,21 @@ func DeleteStruct(s *Struct, fieldNum uint16) error {
 	x := (*Struct)(f.Ptr)
 	x.parent = nil
 	XXXAddToTotal(s, -atomic.LoadInt64(x.structTotal))
+	f.Header = nil
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetListBool returns a list of bools at fieldNum.
 func GetListBool(s *Struct, fieldNum uint16) (*Bools, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBools); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBools)
+	if err != nil {
 		return nil, err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil, nil
 	}
@@ -578,21 +1049,27 @@ func MustGetListBool(s *Struct, fieldNum uint16) *Bools {
 }
 
 func SetListBool(s *Struct, fieldNum uint16, value *Bools) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBools); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBools)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header != nil { // We had a previous value stored.
 		ptr := (*Bools)(f.Ptr)
+		ptr.s = nil
 		XXXAddToTotal(s, -len(ptr.data))
 	}
 
 	f.Header = value.data[:8]
 	f.Ptr = unsafe.Pointer(value)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	value.s = s
 	XXXAddToTotal(s, len(value.data))
+	s.markDirty(fieldNum)
 	return nil
 }
 func MustSetListBool(s *Struct, fieldNum uint16, value *Bools) {
@@ -602,38 +1079,62 @@ func MustSetListBool(s *Struct, fieldNum uint16, value *Bools) {
 	}
 }
 
+// SetListBoolSlice sets field fieldNum to a list holding a copy of values. This does what NewBools(),
+// Append() and SetListBool() do, in one call. If values is empty, the field is deleted.
+func SetListBoolSlice(s *Struct, fieldNum uint16, values []bool) error {
+	if len(values) == 0 {
+		return DeleteListBools(s, fieldNum)
+	}
+	l := NewBools(fieldNum)
+	l.Append(values...)
+	return SetListBool(s, fieldNum, l)
+}
+
+func MustSetListBoolSlice(s *Struct, fieldNum uint16, values []bool) {
+	if err := SetListBoolSlice(s, fieldNum, values); err != nil {
+		panic(err)
+	}
+}
+
 // DeleteListBools deletes a list of bools field and updates our storage total.
 func DeleteListBools(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBools); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBools)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
 
 	f.Header = nil
 	ptr := (*Bools)(f.Ptr)
+	ptr.s = nil
 	XXXAddToTotal(s, -len(ptr.data))
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetListNumber returns a list of numbers at fieldNum.
 func GetListNumber[N Number](s *Struct, fieldNum uint16) (*Numbers[N], error) {
-	if err := validateFieldNum(fieldNum, s.mapping); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
 		return nil, err
 	}
-	desc := s.mapping.Fields[fieldNum]
+	desc := s.mapping.Fields[idx]
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil, nil
 	}
 
-	_, _, err := numberToDescCheck[N](desc)
+	_, _, err = numberToDescCheck[N](desc)
 	if err != nil {
 		return nil, fmt.Errorf("error getting field number %d: %w", fieldNum, err)
 	}
@@ -652,28 +1153,34 @@ func MustGetListNumber[N Number](s *Struct, fieldNum uint16) *Numbers[N] {
 }
 
 func SetListNumber[N Number](s *Struct, fieldNum uint16, value *Numbers[N]) error {
-	if err := validateFieldNum(fieldNum, s.mapping); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	desc := s.mapping.Fields[fieldNum]
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
+	}
+	desc := s.mapping.Fields[idx]
 
-	_, _, err := numberToDescCheck[N](desc)
+	_, _, err = numberToDescCheck[N](desc)
 	if err != nil {
 		return fmt.Errorf("error setting field number %d: %w", fieldNum, err)
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header != nil { // We had a previous value stored.
 		ptr := (*Numbers[N])(f.Ptr)
+		ptr.s = nil
 		XXXAddToTotal(s, -len(ptr.data))
 	}
 
 	f.Header = value.data[:8]
 	f.Header.SetFieldNum(fieldNum)
 	f.Ptr = unsafe.Pointer(value)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 	value.s = s
 	XXXAddToTotal(s, len(value.data))
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -684,41 +1191,62 @@ func MustSetListNumber[N Number](s *Struct, fieldNum uint16, value *Numbers[N])
 	}
 }
 
+// SetListNumberSlice sets field fieldNum to a list holding a copy of values. This does what
+// NewNumbers(), Append() and SetListNumber() do, in one call. If values is empty, the field is deleted.
+func SetListNumberSlice[N Number](s *Struct, fieldNum uint16, values []N) error {
+	if len(values) == 0 {
+		return DeleteListNumber[N](s, fieldNum)
+	}
+	l := NewNumbers[N]()
+	l.Append(values...)
+	return SetListNumber(s, fieldNum, l)
+}
+
+func MustSetListNumberSlice[N Number](s *Struct, fieldNum uint16, values []N) {
+	if err := SetListNumberSlice(s, fieldNum, values); err != nil {
+		panic(err)
+	}
+}
+
 // DeleteListNumber deletes a list of numbers field and updates our storage total.
 func DeleteListNumber[N Number](s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.NumericListTypes...); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-	f := s.fields[fieldNum]
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.NumericListTypes...)
+	if err != nil {
+		return err
+	}
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
-	desc := s.mapping.Fields[fieldNum]
+	desc := s.mapping.Fields[idx]
 
-	size, _, err := numberToDescCheck[N](desc)
+	_, _, err = numberToDescCheck[N](desc)
 	if err != nil {
 		return fmt.Errorf("error deleting field number %d: %w", fieldNum, err)
 	}
 
 	ptr := (*Numbers[N])(f.Ptr)
 	ptr.s = nil
-
-	reduceBy := int(f.Header.Final40()) + int(size) + 8
-	XXXAddToTotal(s, -reduceBy)
+	XXXAddToTotal(s, -len(ptr.data))
 
 	f.Header = nil
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetListStruct returns a list of Structs at fieldNum.
 func GetListStruct(s *Struct, fieldNum uint16) (*Structs, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs)
+	if err != nil {
 		return nil, err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil { // The zero value
 		return nil, nil
 	}
@@ -737,29 +1265,42 @@ func MustGetListStruct(s *Struct, fieldNum uint16) *Structs {
 
 // SetListStructs deletes all existing values and puts in the passed value.
 func SetListStructs(s *Struct, fieldNum uint16, value *Structs) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs); err != nil {
+	if err := s.checkFrozen(); err != nil {
 		return err
 	}
-
-	value.zeroTypeCompression = s.zeroTypeCompression
-	for _, v := range value.data {
-		v.parent = s
-		v.zeroTypeCompression = s.zeroTypeCompression
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs)
+	if err != nil {
+		return err
 	}
 
 	if value.Len() > maxDataSize {
-		return fmt.Errorf("cannot have more than %d items in a list", maxDataSize)
+		return fmt.Errorf("%w: cannot set %s to a list of %d items, which is > %d", ErrSizeExceeded, fieldString(s, fieldNum), value.Len(), maxDataSize)
+	}
+	if size := atomic.LoadInt64(value.size); size > maxDataSize {
+		return fmt.Errorf("%w: cannot set %s to a list of size %d, which is > %d", ErrSizeExceeded, fieldString(s, fieldNum), size, maxDataSize)
 	}
 
 	if err := DeleteListStructs(s, fieldNum); err != nil {
 		return err
 	}
 
+	value.s = s
+	value.header.SetFieldNum(fieldNum)
+	value.zeroTypeCompression = s.zeroTypeCompression
+	for _, v := range value.data {
+		if v != nil {
+			v.setZeroTypeCompression(s.zeroTypeCompression)
+			v.parent = s
+		}
+	}
+	atomic.StoreInt64(value.size, value.total())
+
 	XXXAddToTotal(s, atomic.LoadInt64(value.size))
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	f.Header = value.header
 	f.Ptr = unsafe.Pointer(value)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -771,24 +1312,32 @@ func MustSetListStruct(s *Struct, fieldNum uint16, value *Structs) {
 
 // AppendListStruct adds the values to the list of Structs at fieldNum. Existing items will be retained.
 func AppendListStruct(s *Struct, fieldNum uint16, values ...*Struct) error {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
 	if len(values) == 0 {
 		return fmt.Errorf("must add at least a single value")
 	}
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs)
+	if err != nil {
 		return err
 	}
-	f := s.fields[fieldNum]
+	for _, v := range values {
+		if v == nil {
+			return fmt.Errorf("cannot pass a nil *Struct")
+		}
+	}
+	f := s.fields[idx]
+	if f.Ptr != nil && len(values)+(*Structs)(f.Ptr).Len() > maxDataSize {
+		return fmt.Errorf("%w: cannot have more than %d items in %s", ErrSizeExceeded, maxDataSize, fieldString(s, fieldNum))
+	}
 
-	// The list of structs hasn't been created yet, so create it.
+	// The list of structs hasn't been created yet, so create it. If GrowListStruct() was called,
+	// the list was created but not added.
 	if f.Header == nil {
-		fd := s.mapping.Fields[fieldNum]
-		var l *Structs
-		if fd.SelfReferential {
-			l = NewStructs(s.mapping)
-		} else {
-			l = NewStructs(fd.Mapping)
-		}
-		f.Ptr = unsafe.Pointer(l)
+		if f.Ptr == nil {
+			f.Ptr = unsafe.Pointer(newListStructFor(s, fieldNum))
+		}
 		XXXAddToTotal(s, 8) // Add the header size of Structs to our parent Struct
 	}
 
@@ -796,16 +1345,6 @@ func AppendListStruct(s *Struct, fieldNum uint16, values ...*Struct) error {
 	l.s = s
 	l.zeroTypeCompression = s.zeroTypeCompression
 
-	if len(values)+l.Len() > maxDataSize {
-		return fmt.Errorf("cannot have more than %d items in a list", maxDataSize)
-	}
-
-	for _, v := range values {
-		if v == nil {
-			return fmt.Errorf("cannot pass a nil *Struct")
-		}
-	}
-
 	if err := l.Append(values...); err != nil {
 		return err
 	}
@@ -814,12 +1353,52 @@ func AppendListStruct(s *Struct, fieldNum uint16, values ...*Struct) error {
 	f.Header = l.header
 
 	f.Ptr = unsafe.Pointer(l)
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
 
 	l.s = s
+	s.markDirty(fieldNum)
+	return nil
+}
+
+// GrowListStruct makes sure the list of Structs at fieldNum has room for n more items, so that
+// appending them doesn't need to reallocate. If the list doesn't exist, the room is reserved for
+// the list that the next AppendListStruct() creates. This does not change the encoded size.
+func GrowListStruct(s *Struct, fieldNum uint16, n int) error {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("cannot grow a list by a negative number(%d)", n)
+	}
+
+	f := s.fields[idx]
+	if f.Ptr == nil {
+		f.Ptr = unsafe.Pointer(newListStructFor(s, fieldNum))
+		s.fields[idx] = f
+	}
+	(*Structs)(f.Ptr).Grow(n)
 	return nil
 }
 
+func MustGrowListStruct(s *Struct, fieldNum uint16, n int) {
+	if err := GrowListStruct(s, fieldNum, n); err != nil {
+		panic(err)
+	}
+}
+
+// newListStructFor returns a new, empty list for the list of Structs field at fieldNum.
+func newListStructFor(s *Struct, fieldNum uint16) *Structs {
+	fd := s.mapping.FieldByNumber(fieldNum)
+	if fd.SelfReferential {
+		return NewStructs(s.mapping)
+	}
+	return NewStructs(fd.Mapping)
+}
+
 func MustAppendListStruct(s *Struct, fieldNum uint16, values ...*Struct) {
 	err := AppendListStruct(s, fieldNum, values...)
 	if err != nil {
@@ -829,29 +1408,41 @@ func MustAppendListStruct(s *Struct, fieldNum uint16, values ...*Struct) {
 
 // DeleteListStructs deletes a list of Structs field and updates our storage total.
 func DeleteListStructs(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListStructs)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
 	x := (*Structs)(f.Ptr)
-	XXXAddToTotal(s, atomic.LoadInt64(x.size))
+	x.s = nil
+	for _, item := range x.data {
+		if item != nil {
+			item.parent = nil
+		}
+	}
+	XXXAddToTotal(s, -x.total())
 	f.Header = nil
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
 // GetListBytes returns a list of bytes at fieldNum.
 func GetListBytes(s *Struct, fieldNum uint16) (*Bytes, error) {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes); err != nil {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes, field.FTListStrings)
+	if err != nil {
 		return nil, err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil, nil
 	}
@@ -870,27 +1461,28 @@ func MustGetListBytes(s *Struct, fieldNum uint16) *Bytes {
 }
 
 func SetListBytes(s *Struct, fieldNum uint16, value *Bytes) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes, field.FTListStrings)
+	if err != nil {
+		return err
+	}
+	if size := value.dataSize + value.padding + 8; size > maxDataSize {
+		return fmt.Errorf("%w: cannot set %s to a list of size %d, which is > %d", ErrSizeExceeded, fieldString(s, fieldNum), size, maxDataSize)
+	}
+	if err := DeleteListBytes(s, fieldNum); err != nil {
 		return err
 	}
 	value.s = s
 
-	f := s.fields[fieldNum]
-	if f.Header == nil {
-		value.header.SetFieldNum(fieldNum)
-		f.Header = value.header
-		f.Ptr = unsafe.Pointer(value)
-		s.fields[fieldNum] = f
-		XXXAddToTotal(s, value.dataSize+value.padding+8)
-		return nil
-	}
-	f.Header = value.data[0]
-	f.Header.SetFieldNum(fieldNum)
-	ptr := (*Bytes)(f.Ptr)
+	f := s.fields[idx]
+	value.header.SetFieldNum(fieldNum)
+	f.Header = value.header
 	f.Ptr = unsafe.Pointer(value)
-	s.fields[fieldNum] = f
-
-	XXXAddToTotal(s, value.dataSize-ptr.dataSize+value.padding-ptr.padding+8)
+	s.fields[idx] = f
+	XXXAddToTotal(s, value.dataSize+value.padding+8)
+	s.markDirty(fieldNum)
 	return nil
 }
 
@@ -908,156 +1500,325 @@ func MustSetListStrings(s *Struct, fieldNum uint16, value *Strings) {
 
 // DeleteListBytes deletes a list of bytes field and updates our storage total.
 func DeleteListBytes(s *Struct, fieldNum uint16) error {
-	if err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes); err != nil {
+	if err := s.checkFrozen(); err != nil {
+		return err
+	}
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.FTListBytes, field.FTListStrings)
+	if err != nil {
 		return err
 	}
 
-	f := s.fields[fieldNum]
+	f := s.fields[idx]
 	if f.Header == nil {
 		return nil
 	}
 
 	ptr := (*Bytes)(f.Ptr)
-	XXXAddToTotal(s, -(ptr.dataSize + ptr.padding))
+	ptr.s = nil
+	XXXAddToTotal(s, -(ptr.dataSize + ptr.padding + 8))
 
 	f.Header = nil
 	f.Ptr = nil
-	s.fields[fieldNum] = f
+	s.fields[idx] = f
+	s.markDirty(fieldNum)
 	return nil
 }
 
-type structer interface {
+// Structer is implemented by every Struct type generated by clawc. Struct() gives the *Struct that
+// functions in this package, such as Diff() and Merge(), take, and TrySetField() accepts a Structer for
+// a Struct field. The type and its *Struct share data, so a change through either is seen by the other.
+// Each generated type has a <Name>FromStruct() function that wraps a *Struct back into the type.
+type Structer interface {
+	// Struct returns the *Struct the type wraps. It is nil for the zero value of the type.
 	Struct() *Struct
 }
 
+// CheckMapping returns an error wrapping ErrTypeMismatch if s does not have mapping m, such as when
+// a *Struct is wrapped in a generated type other than the one it was made for.
+func CheckMapping(s *Struct, m *mapping.Map) error {
+	if s == nil {
+		return fmt.Errorf("%w: a nil *Struct is not a %s", ErrTypeMismatch, m.Name)
+	}
+	if s.mapping != m {
+		return fmt.Errorf("%w: Struct is a %s, not a %s", ErrTypeMismatch, s.mapping.Name, m.Name)
+	}
+	return nil
+}
+
 // SetField sets the field value at fieldNum to value. If value isn't valid for that field,
-// this will panic.
+// this will panic with the error from TrySetField().
 func SetField(s *Struct, fieldNum uint16, value any) {
-	if int(fieldNum) > len(s.fields) {
-		panic(fmt.Sprintf("fieldNum %d is invalid", fieldNum))
+	if err := TrySetField(s, fieldNum, value); err != nil {
+		panic(err)
+	}
+}
+
+// TrySetField sets the field value at fieldNum to value. value must be the Go type that is stored
+// in the field: an int8 for an int8 field, a *Numbers[uint16] for a list of uint16 and so on.
+// Enum fields can also be set with an enum value from the reflect package and Struct fields with
+// a *Struct or a type that has a Struct() *Struct method. No conversion is done between number
+// types, so an int passed for an int8 field returns an error that wraps ErrTypeMismatch.
+func TrySetField(s *Struct, fieldNum uint16, value any) error {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
 	}
+	fd := s.mapping.Fields[idx]
 
-	switch t := s.mapping.Fields[int(fieldNum)].Type; t {
+	switch fd.Type {
 	case field.FTBool:
-		v := value.(bool)
-		MustSetBool(s, fieldNum, v)
+		if v, ok := value.(bool); ok {
+			return SetBool(s, fieldNum, v)
+		}
 	case field.FTInt8:
-		v := value.(int8)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(int8); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTInt16:
-		v := value.(int16)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(int16); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTInt32:
-		v := value.(int32)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(int32); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTInt64:
-		v := value.(int64)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(int64); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTUint8:
 		switch v := value.(type) {
 		case uint8:
-			MustSetNumber(s, fieldNum, v)
+			return SetNumber(s, fieldNum, v)
 		case enums.EnumImpl:
 			if v.EnumSize != 8 {
-				panic(fmt.Sprintf("setting a Uint8 field with a Enum that has size: %d", v.EnumSize))
+				return fmt.Errorf("%w: field %d(%s) is a %v, which cannot be set with an enum of size %d", ErrTypeMismatch, fieldNum, fd.Name, fd.Type, v.EnumSize)
 			}
-			MustSetNumber(s, fieldNum, uint8(v.EnumNumber))
-		default:
-			panic(fmt.Sprintf("setting a Uint8 field with a %T", value))
+			return SetNumber(s, fieldNum, uint8(v.EnumNumber))
+		}
+		if n, ok := enumNumber(fd, value, reflect.Uint8); ok {
+			return SetNumber(s, fieldNum, uint8(n))
 		}
 	case field.FTUint16:
 		switch v := value.(type) {
 		case uint16:
-			MustSetNumber(s, fieldNum, v)
+			return SetNumber(s, fieldNum, v)
 		case enums.EnumImpl:
 			if v.EnumSize != 16 {
-				panic(fmt.Sprintf("setting a Uint16 field with a Enum that has size: %d", v.EnumSize))
+				return fmt.Errorf("%w: field %d(%s) is a %v, which cannot be set with an enum of size %d", ErrTypeMismatch, fieldNum, fd.Name, fd.Type, v.EnumSize)
 			}
-			MustSetNumber(s, fieldNum, v.EnumNumber)
-		default:
-			panic(fmt.Sprintf("setting a Uint16 field with a %T", value))
+			return SetNumber(s, fieldNum, v.EnumNumber)
+		}
+		if n, ok := enumNumber(fd, value, reflect.Uint16); ok {
+			return SetNumber(s, fieldNum, uint16(n))
 		}
 	case field.FTUint32:
-		v := value.(uint32)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(uint32); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTUint64:
-		v := value.(uint64)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(uint64); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTFloat32:
-		v := value.(float32)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(float32); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTFloat64:
-		v := value.(float64)
-		MustSetNumber(s, fieldNum, v)
+		if v, ok := value.(float64); ok {
+			return SetNumber(s, fieldNum, v)
+		}
 	case field.FTBytes:
-		v := value.([]byte)
-		MustSetBytes(s, fieldNum, v, false)
+		if v, ok := value.([]byte); ok {
+			return SetBytes(s, fieldNum, v, false)
+		}
 	case field.FTString:
-		v := value.(string)
-		MustSetBytes(s, fieldNum, conversions.UnsafeGetBytes(v), true)
+		if v, ok := value.(string); ok {
+			return SetBytes(s, fieldNum, conversions.UnsafeGetBytes(v), true)
+		}
+	case field.FTFixedBytes:
+		if v, ok := value.([]byte); ok {
+			return SetFixedBytes(s, fieldNum, v)
+		}
 	case field.FTStruct:
 		switch v := value.(type) {
-		case structer:
-			MustSetStruct(s, fieldNum, v.Struct())
+		case Structer:
+			return SetStruct(s, fieldNum, v.Struct())
 		case *Struct:
-			MustSetStruct(s, fieldNum, v)
-		default:
-			panic(fmt.Sprintf("tried to set a struct field with type %T", value))
+			return SetStruct(s, fieldNum, v)
 		}
 	case field.FTListBools:
-		v := value.(*Bools)
-		MustSetListBool(s, fieldNum, v)
+		if v, ok := value.(*Bools); ok {
+			return SetListBool(s, fieldNum, v)
+		}
 	case field.FTListInt8:
-		v := value.(*Numbers[int8])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[int8]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListInt16:
-		v := value.(*Numbers[int16])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[int16]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListInt32:
-		v := value.(*Numbers[int32])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[int32]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListInt64:
-		v := value.(*Numbers[int64])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[int64]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListUint8:
-		v := value.(*Numbers[uint8])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[uint8]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListUint16:
-		v := value.(*Numbers[uint16])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[uint16]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListUint32:
-		v := value.(*Numbers[uint32])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[uint32]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListUint64:
-		v := value.(*Numbers[uint64])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[uint64]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListFloat32:
-		v := value.(*Numbers[float32])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[float32]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListFloat64:
-		v := value.(*Numbers[float64])
-		MustSetListNumber(s, fieldNum, v)
+		if v, ok := value.(*Numbers[float64]); ok {
+			return SetListNumber(s, fieldNum, v)
+		}
 	case field.FTListBytes:
-		v := value.(*Bytes)
-		MustSetListBytes(s, fieldNum, v)
+		if v, ok := value.(*Bytes); ok {
+			return SetListBytes(s, fieldNum, v)
+		}
 	case field.FTListStrings:
-		v := value.(*Strings)
-		MustSetListStrings(s, fieldNum, v)
+		if v, ok := value.(*Strings); ok {
+			return SetListBytes(s, fieldNum, v.Bytes())
+		}
 	case field.FTListStructs:
-		v := value.(*Structs)
-		MustSetListStruct(s, fieldNum, v)
+		if v, ok := value.(*Structs); ok {
+			return SetListStructs(s, fieldNum, v)
+		}
 	default:
-		panic(fmt.Sprintf("bug: unsupported type %T", t))
+		return fmt.Errorf("bug: unsupported type %v", fd.Type)
+	}
+	return fmt.Errorf("%w: field %d(%s) is a %v, which cannot be set with a %T", ErrTypeMismatch, fieldNum, fd.Name, fd.Type, value)
+}
+
+// enumNumber returns the number in value if fd is an enum field and value is of an enum type
+// generated by clawc, such as manufacturers.Manufacturer, that is defined from a number of kind k.
+// Numbers that aren't one of the enum's values are returned as they are.
+func enumNumber(fd *mapping.FieldDescr, value any, k reflect.Kind) (uint64, bool) {
+	if !fd.IsEnum {
+		return 0, false
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != k {
+		return 0, false
+	}
+	return v.Uint(), true
+}
+
+// GetField returns the value of the field at fieldNum as the Go type TrySetField() takes for it, so
+// the value can be set on another Struct of the same type. String fields are returned as a string
+// and Bytes and FixedBytes fields as a []byte, lists as a *Bools, *Numbers[N], *Bytes, *Strings or *Structs and
+// Structs as a *Struct. Except for strings, the value shares memory with s. Enums are returned as
+// their number. A field that isn't set returns what its getter does for it.
+func GetField(s *Struct, fieldNum uint16) (any, error) {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := s.mapping.Fields[idx].Type; t {
+	case field.FTBool:
+		return GetBool(s, fieldNum)
+	case field.FTInt8:
+		return GetNumber[int8](s, fieldNum)
+	case field.FTInt16:
+		return GetNumber[int16](s, fieldNum)
+	case field.FTInt32:
+		return GetNumber[int32](s, fieldNum)
+	case field.FTInt64:
+		return GetNumber[int64](s, fieldNum)
+	case field.FTUint8:
+		return GetNumber[uint8](s, fieldNum)
+	case field.FTUint16:
+		return GetNumber[uint16](s, fieldNum)
+	case field.FTUint32:
+		return GetNumber[uint32](s, fieldNum)
+	case field.FTUint64:
+		return GetNumber[uint64](s, fieldNum)
+	case field.FTFloat32:
+		return GetNumber[float32](s, fieldNum)
+	case field.FTFloat64:
+		return GetNumber[float64](s, fieldNum)
+	case field.FTBytes, field.FTString:
+		b, err := GetBytes(s, fieldNum)
+		if err != nil {
+			return nil, err
+		}
+		var v []byte
+		if b != nil {
+			v = *b
+		}
+		if t == field.FTString {
+			return string(v), nil
+		}
+		return v, nil
+	case field.FTFixedBytes:
+		return GetFixedBytes(s, fieldNum)
+	case field.FTStruct:
+		return GetStruct(s, fieldNum)
+	case field.FTListBools:
+		return GetListBool(s, fieldNum)
+	case field.FTListInt8:
+		return GetListNumber[int8](s, fieldNum)
+	case field.FTListInt16:
+		return GetListNumber[int16](s, fieldNum)
+	case field.FTListInt32:
+		return GetListNumber[int32](s, fieldNum)
+	case field.FTListInt64:
+		return GetListNumber[int64](s, fieldNum)
+	case field.FTListUint8:
+		return GetListNumber[uint8](s, fieldNum)
+	case field.FTListUint16:
+		return GetListNumber[uint16](s, fieldNum)
+	case field.FTListUint32:
+		return GetListNumber[uint32](s, fieldNum)
+	case field.FTListUint64:
+		return GetListNumber[uint64](s, fieldNum)
+	case field.FTListFloat32:
+		return GetListNumber[float32](s, fieldNum)
+	case field.FTListFloat64:
+		return GetListNumber[float64](s, fieldNum)
+	case field.FTListBytes:
+		return GetListBytes(s, fieldNum)
+	case field.FTListStrings:
+		b, err := GetListBytes(s, fieldNum)
+		if err != nil || b == nil {
+			return nil, err
+		}
+		return &Strings{l: b}, nil
+	case field.FTListStructs:
+		return GetListStruct(s, fieldNum)
+	default:
+		return nil, fmt.Errorf("bug: unsupported type %v", t)
 	}
 }
 
 // DeleteField will delete the field entry for fieldNum.
 func DeleteField(s *Struct, fieldNum uint16) {
-	if int(fieldNum) > len(s.fields) {
-		panic(fmt.Sprintf("fieldNum %d is invalid", fieldNum))
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		panic(err)
 	}
 
-	switch t := s.mapping.Fields[int(fieldNum)].Type; t {
+	switch t := s.mapping.Fields[idx].Type; t {
 	case field.FTBool:
 		DeleteBool(s, fieldNum)
 	case field.FTInt8, field.FTInt16, field.FTInt32, field.FTInt64, field.FTUint8,
@@ -1065,6 +1826,8 @@ func DeleteField(s *Struct, fieldNum uint16) {
 		DeleteNumber(s, fieldNum)
 	case field.FTBytes, field.FTString:
 		DeleteBytes(s, fieldNum)
+	case field.FTFixedBytes:
+		DeleteFixedBytes(s, fieldNum)
 	case field.FTStruct:
 		DeleteStruct(s, fieldNum)
 	case field.FTListBools:
@@ -1100,6 +1863,54 @@ func DeleteField(s *Struct, fieldNum uint16) {
 	}
 }
 
+// ClearList sets the list at fieldNum to an empty list. Unlike deleting it, the field stays set and
+// is encoded as a list with no items, which lets a reader tell an empty list from one that was never
+// set, such as [] versus an absent field in JSON. A list already there is replaced, not changed, so
+// a *Bools or other list gotten from the field before this keeps its items.
+func ClearList(s *Struct, fieldNum uint16) error {
+	idx, err := validateFieldNum(fieldNum, s.mapping, field.ListTypes...)
+	if err != nil {
+		return err
+	}
+
+	switch t := s.mapping.Fields[idx].Type; t {
+	case field.FTListBools:
+		return SetListBool(s, fieldNum, NewBools(fieldNum))
+	case field.FTListInt8:
+		return SetListNumber(s, fieldNum, NewNumbers[int8]())
+	case field.FTListInt16:
+		return SetListNumber(s, fieldNum, NewNumbers[int16]())
+	case field.FTListInt32:
+		return SetListNumber(s, fieldNum, NewNumbers[int32]())
+	case field.FTListInt64:
+		return SetListNumber(s, fieldNum, NewNumbers[int64]())
+	case field.FTListUint8:
+		return SetListNumber(s, fieldNum, NewNumbers[uint8]())
+	case field.FTListUint16:
+		return SetListNumber(s, fieldNum, NewNumbers[uint16]())
+	case field.FTListUint32:
+		return SetListNumber(s, fieldNum, NewNumbers[uint32]())
+	case field.FTListUint64:
+		return SetListNumber(s, fieldNum, NewNumbers[uint64]())
+	case field.FTListFloat32:
+		return SetListNumber(s, fieldNum, NewNumbers[float32]())
+	case field.FTListFloat64:
+		return SetListNumber(s, fieldNum, NewNumbers[float64]())
+	case field.FTListBytes, field.FTListStrings:
+		return SetListBytes(s, fieldNum, NewBytes())
+	case field.FTListStructs:
+		return SetListStructs(s, fieldNum, newListStructFor(s, fieldNum))
+	default:
+		return fmt.Errorf("bug: unsupported list type %v", t)
+	}
+}
+
+func MustClearList(s *Struct, fieldNum uint16) {
+	if err := ClearList(s, fieldNum); err != nil {
+		panic(err)
+	}
+}
+
 // XXXAddToTotal is used to increment the sizes of everything in a struct by some value.
 func XXXAddToTotal[N int64 | int | uint | uint64](s *Struct, value N) {
 	if s == nil {
@@ -1107,6 +1918,7 @@ func XXXAddToTotal[N int64 | int | uint | uint64](s *Struct, value N) {
 	}
 	v := atomic.AddInt64(s.structTotal, int64(value))
 	s.header.SetFinal40(uint64(v))
+	s.modified = true
 	var ptr = s.parent
 	for {
 		if ptr == nil {
@@ -1114,21 +1926,46 @@ func XXXAddToTotal[N int64 | int | uint | uint64](s *Struct, value N) {
 		}
 		v := atomic.AddInt64(ptr.structTotal, int64(value))
 		ptr.header.SetFinal40(uint64(v))
+		ptr.modified = true
 		ptr = ptr.parent
 	}
 }
 
+// markModified records that s has changed, which means every Struct holding s has changed too.
+// Changes that go through XXXAddToTotal() are already recorded, this is for changes that don't
+// change the size, such as setting a number that is already set.
+func (s *Struct) markModified() {
+	for ptr := s; ptr != nil; ptr = ptr.parent {
+		ptr.modified = true
+	}
+}
+
+// fieldString describes field fieldNum of s for error messages, such as "field Car.Name(0)".
+func fieldString(s *Struct, fieldNum uint16) string {
+	fd := s.mapping.FieldByNumber(fieldNum)
+	if fd == nil || s.mapping.NoNames {
+		return fmt.Sprintf("field %d of %s", fieldNum, s.mapping.Name)
+	}
+	return fmt.Sprintf("field %s.%s(%d)", s.mapping.Name, fd.Name, fieldNum)
+}
+
 // validateFieldNum will validate that the type is described in the mapping.Map,
-// and if len(ftypes) != 0, that the ftype and mapping.Map[fieldNum].Type are the same.
-func validateFieldNum(fieldNum uint16, maps *mapping.Map, ftypes ...field.Type) error {
-	if int(fieldNum) >= len(maps.Fields) {
-		return fmt.Errorf("fieldNum %d is >= the number of possible fields (%d)", fieldNum, len(maps.Fields))
+// and if len(ftypes) != 0, that the ftype and the type in the mapping.Map are the same.
+// It returns the index of the field in the mapping's Fields, which is also its index in
+// Struct.fields (see mapping.Map.Index()).
+func validateFieldNum(fieldNum uint16, maps *mapping.Map, ftypes ...field.Type) (int, error) {
+	idx := maps.Index(fieldNum)
+	if idx < 0 {
+		return -1, fmt.Errorf("%w: fieldNum %d is > the last field of the Struct", ErrFieldNotFound, fieldNum)
+	}
+	desc := maps.Fields[idx]
+	if desc.Type == field.FTUnknown || desc.FieldNum != fieldNum {
+		return -1, fmt.Errorf("%w: fieldNum %d is not used by the Struct", ErrFieldNotFound, fieldNum)
 	}
 	if len(ftypes) == 0 {
-		return nil
+		return idx, nil
 	}
 
-	desc := maps.Fields[fieldNum]
 	found := false
 	for _, ftype := range ftypes {
 		if desc.Type == ftype {
@@ -1137,75 +1974,75 @@ func validateFieldNum(fieldNum uint16, maps *mapping.Map, ftypes ...field.Type)
 		}
 	}
 	if !found {
-		return fmt.Errorf("fieldNum(%d) was %v, which was not valid", fieldNum, desc.Type)
+		return -1, fmt.Errorf("%w: fieldNum(%d) was %v, which was not valid", ErrTypeMismatch, fieldNum, desc.Type)
 	}
-	return nil
+	return idx, nil
 }
 
 func numberToDescCheck[N Number](desc *mapping.FieldDescr) (size uint8, isFloat bool, err error) {
 	var t N
-	switch any(t).(type) {
+	switch numberType[N]().(type) {
 	case uint8:
 		switch desc.Type {
 		case field.FTUint8, field.FTListUint8:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a uint8 or []uint8 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a uint8 or []uint8 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 8
 	case uint16:
 		switch desc.Type {
 		case field.FTUint16, field.FTListUint16:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a uint16 or []uint16 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a uint16 or []uint16 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 16
 	case uint32:
 		switch desc.Type {
 		case field.FTUint32, field.FTListUint32:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a uint32 or []uint32 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a uint32 or []uint32 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 32
 	case uint64:
 		switch desc.Type {
 		case field.FTUint64, field.FTListUint64:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a uint64 or []uint64 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a uint64 or []uint64 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 64
 	case int8:
 		switch desc.Type {
 		case field.FTInt8, field.FTListInt8:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a int8 or []int8 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a int8 or []int8 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 8
 	case int16:
 		switch desc.Type {
 		case field.FTInt16, field.FTListInt16:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a int16 or []int16 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a int16 or []int16 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 16
 	case int32:
 		switch desc.Type {
 		case field.FTInt32, field.FTListInt32:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a int32 or []int32 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a int32 or []int32 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 32
 	case int64:
 		switch desc.Type {
 		case field.FTInt64, field.FTListInt64:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a int64 or []int64 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a int64 or []int64 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 64
 	case float32:
 		switch desc.Type {
 		case field.FTFloat32, field.FTListFloat32:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a float32 or []float32 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a float32 or []float32 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 32
 		isFloat = true
@@ -1213,12 +2050,12 @@ func numberToDescCheck[N Number](desc *mapping.FieldDescr) (size uint8, isFloat
 		switch desc.Type {
 		case field.FTFloat64, field.FTListFloat64:
 		default:
-			return 0, false, fmt.Errorf("fieldNum is not a float64 or []float64 type, was %v", desc.Type)
+			return 0, false, fmt.Errorf("%w: fieldNum is not a float64 or []float64 type, was %v", ErrTypeMismatch, desc.Type)
 		}
 		size = 64
 		isFloat = true
 	default:
-		return 0, false, fmt.Errorf("passed a number value of %T that we do not support", t)
+		return 0, false, fmt.Errorf("%w: passed a number value of %T that we do not support", ErrTypeMismatch, t)
 	}
 	return size, isFloat, nil
 }
@@ -2,10 +2,14 @@ package structs
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bearlytools/claw/languages/go/field"
@@ -66,21 +70,21 @@ func TestBasicEncodeDecodeStruct(t *testing.T) {
 	msg0Mapping := &mapping.Map{
 		Fields: []*mapping.FieldDescr{
 			{Name: "Bool", Type: field.FTBool}, // 1
-			{Name: "Int8", Type: field.FTInt8},
-			{Name: "Int16", Type: field.FTInt16},
-			{Name: "Int32", Type: field.FTInt32},
-			{Name: "Int64", Type: field.FTInt64}, // 5
-			{Name: "Uint8", Type: field.FTUint8},
-			{Name: "Uint16", Type: field.FTUint16},
-			{Name: "Uint32", Type: field.FTUint32},
-			{Name: "Uint64", Type: field.FTUint64},
-			{Name: "Float32", Type: field.FTFloat32},                            // 10
-			{Name: "Float64", Type: field.FTFloat64},                            // 11
-			{Name: "Bytes", Type: field.FTBytes},                                // 12
-			{Name: "Msg1", Type: field.FTStruct, Mapping: msg1Mapping},          // 13
-			{Name: "ListMsg1", Type: field.FTListStructs, Mapping: msg1Mapping}, // 14
-			{Name: "ListNumber", Type: field.FTListUint8},                       // 15
-			{Name: "ListBytes", Type: field.FTListBytes},                        // 16
+			{Name: "Int8", Type: field.FTInt8, FieldNum: 1},
+			{Name: "Int16", Type: field.FTInt16, FieldNum: 2},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 3},
+			{Name: "Int64", Type: field.FTInt64, FieldNum: 4}, // 5
+			{Name: "Uint8", Type: field.FTUint8, FieldNum: 5},
+			{Name: "Uint16", Type: field.FTUint16, FieldNum: 6},
+			{Name: "Uint32", Type: field.FTUint32, FieldNum: 7},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 8},
+			{Name: "Float32", Type: field.FTFloat32, FieldNum: 9},                             // 10
+			{Name: "Float64", Type: field.FTFloat64, FieldNum: 10},                            // 11
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 11},                                // 12
+			{Name: "Msg1", Type: field.FTStruct, FieldNum: 12, Mapping: msg1Mapping},          // 13
+			{Name: "ListMsg1", Type: field.FTListStructs, FieldNum: 13, Mapping: msg1Mapping}, // 14
+			{Name: "ListNumber", Type: field.FTListUint8, FieldNum: 14},                       // 15
+			{Name: "ListBytes", Type: field.FTListBytes, FieldNum: 15},                        // 16
 		},
 	}
 	// Number      |   Size
@@ -521,7 +525,7 @@ func TestBasicEncodeDecodeStruct(t *testing.T) {
 	}
 
 	if err := bytesList.Append([]byte("what"), []byte("ever")); err != nil {
-		t.Fatalf("TestBasicEncodeDecodeStruct(append Listbytes): error: %s", err)
+		t.Fatalf("TestBasicEncodeDecodeStruct(appending to Listbytes): %s", err)
 	}
 
 	totalWithListBytes += 16 // 2 * content(4 bytes each) + two entry headers(4 bytes)
@@ -788,13 +792,16 @@ func TestGetBool(t *testing.T) {
 				Type: field.FTBool,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTFloat32,
+				Type:     field.FTFloat32,
+				FieldNum: 1,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTBool,
+				Type:     field.FTBool,
+				FieldNum: 2,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTBool,
+				Type:     field.FTBool,
+				FieldNum: 3,
 			},
 		},
 	}
@@ -873,7 +880,8 @@ func TestSetNumber(t *testing.T) {
 				Type: field.FTFloat32,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTFloat64,
+				Type:     field.FTFloat64,
+				FieldNum: 1,
 			},
 		},
 	}
@@ -912,16 +920,20 @@ func TestGetNumber(t *testing.T) {
 				Type: field.FTUint8,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTBool,
+				Type:     field.FTBool,
+				FieldNum: 1,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTInt8,
+				Type:     field.FTInt8,
+				FieldNum: 2,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTUint64,
+				Type:     field.FTUint64,
+				FieldNum: 3,
 			},
 			&mapping.FieldDescr{
-				Type: field.FTFloat32,
+				Type:     field.FTFloat32,
+				FieldNum: 4,
 			},
 		},
 	}
@@ -1095,3 +1107,2097 @@ func TestNumberToDescCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestBytesPresence(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bytes", Type: field.FTBytes},
+			{Name: "String", Type: field.FTString, FieldNum: 1},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		value     []byte
+		wantNil   bool
+		wantSet   bool
+		wantValue string
+	}{
+		{desc: "unset", value: nil, wantNil: true, wantSet: false},
+		{desc: "set, but empty", value: []byte{}, wantSet: true},
+		{desc: "set with value", value: []byte("hello"), wantSet: true, wantValue: "hello"},
+	}
+
+	for _, test := range tests {
+		for fieldNum := uint16(0); fieldNum < 2; fieldNum++ {
+			s := New(0, m)
+			s.XXXSetNoZeroTypeCompression()
+			if test.value != nil {
+				MustSetBytes(s, fieldNum, test.value, fieldNum == 1)
+			}
+
+			// Check it before and after a round trip through the encoding.
+			buff := &bytes.Buffer{}
+			if _, err := s.Marshal(buff); err != nil {
+				t.Fatalf("TestBytesPresence(%s, field %d): Marshal error: %s", test.desc, fieldNum, err)
+			}
+			decoded, err := NewFromReader(buff, m)
+			if err != nil {
+				t.Fatalf("TestBytesPresence(%s, field %d): NewFromReader error: %s", test.desc, fieldNum, err)
+			}
+			decoded.XXXSetNoZeroTypeCompression()
+
+			for _, x := range []*Struct{s, decoded} {
+				got := MustGetBytes(x, fieldNum)
+				if (got == nil) != test.wantNil {
+					t.Errorf("TestBytesPresence(%s, field %d): got nil == %v, want nil == %v", test.desc, fieldNum, got == nil, test.wantNil)
+					continue
+				}
+				if got != nil {
+					if *got == nil {
+						t.Errorf("TestBytesPresence(%s, field %d): got a pointer to a nil []byte, want non-nil", test.desc, fieldNum)
+					}
+					if string(*got) != test.wantValue {
+						t.Errorf("TestBytesPresence(%s, field %d): got %q, want %q", test.desc, fieldNum, string(*got), test.wantValue)
+					}
+				}
+				if x.IsSet(fieldNum) != test.wantSet {
+					t.Errorf("TestBytesPresence(%s, field %d): IsSet(): got %v, want %v", test.desc, fieldNum, x.IsSet(fieldNum), test.wantSet)
+				}
+			}
+
+			// Deleting or setting to nil must return us to unset and remove the size.
+			MustSetBytes(s, fieldNum, nil, fieldNum == 1)
+			if MustGetBytes(s, fieldNum) != nil || s.IsSet(fieldNum) {
+				t.Errorf("TestBytesPresence(%s, field %d): after setting nil, field is still set", test.desc, fieldNum)
+			}
+			if *s.structTotal != 8 {
+				t.Errorf("TestBytesPresence(%s, field %d): after setting nil, structTotal was %d, want 8", test.desc, fieldNum, *s.structTotal)
+			}
+		}
+	}
+}
+
+func TestBytesPresenceCompressed(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bytes", Type: field.FTBytes},
+			{Name: "String", Type: field.FTString, FieldNum: 1},
+		},
+	}
+
+	for fieldNum := uint16(0); fieldNum < 2; fieldNum++ {
+		s := New(0, m)
+		MustSetBytes(s, fieldNum, []byte{}, fieldNum == 1)
+		if got := MustGetBytes(s, fieldNum); got == nil {
+			t.Errorf("TestBytesPresenceCompressed(field %d): a set, but empty, value was not set before encoding", fieldNum)
+		}
+
+		// With zero value compression, the empty value is the zero value and is not encoded.
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestBytesPresenceCompressed(field %d): Marshal error: %s", fieldNum, err)
+		}
+		if buff.Len() != 8 {
+			t.Errorf("TestBytesPresenceCompressed(field %d): got %d bytes encoded, want only the 8 byte header", fieldNum, buff.Len())
+		}
+		decoded, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Fatalf("TestBytesPresenceCompressed(field %d): NewFromReader error: %s", fieldNum, err)
+		}
+		// IsSet() can't tell with zero value compression, but GetBytes() can.
+		if got := MustGetBytes(decoded, fieldNum); got != nil {
+			t.Errorf("TestBytesPresenceCompressed(field %d): a set, but empty, value was set after decoding", fieldNum)
+		}
+	}
+}
+
+func TestFixedBytes(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "IP", Type: field.FTFixedBytes, Size: 4},
+		},
+	}
+	m.MustValidate()
+	// other is the same Struct, but with a different size for the field.
+	other := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "IP", Type: field.FTFixedBytes, Size: 16},
+		},
+	}
+	other.MustValidate()
+
+	tests := []struct {
+		desc      string
+		value     []byte
+		wantSet   bool
+		wantTotal int64
+		err       error
+	}{
+		{desc: "unset", value: nil, wantTotal: 8},
+		{desc: "all zeros is still encoded", value: []byte{0, 0, 0, 0}, wantSet: true, wantTotal: 24},
+		{desc: "set with value", value: []byte{10, 0, 0, 1}, wantSet: true, wantTotal: 24},
+		{desc: "Error: too short", value: []byte{10, 0, 1}, err: ErrTypeMismatch},
+		{desc: "Error: too long", value: []byte{10, 0, 0, 0, 1}, err: ErrTypeMismatch},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		err := SetFixedBytes(s, 0, test.value)
+		switch {
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestFixedBytes(%s): got err == %v, want err == %v", test.desc, err, test.err)
+			continue
+		case test.err == nil && err != nil:
+			t.Errorf("TestFixedBytes(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			if s.IsSet(0) {
+				t.Errorf("TestFixedBytes(%s): field was set after an error", test.desc)
+			}
+			continue
+		}
+		if *s.structTotal != test.wantTotal {
+			t.Errorf("TestFixedBytes(%s): got structTotal == %d, want %d", test.desc, *s.structTotal, test.wantTotal)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestFixedBytes(%s): Marshal error: %s", test.desc, err)
+		}
+		data := buff.Bytes()
+		decoded, err := NewFromReader(bytes.NewReader(data), m)
+		if err != nil {
+			t.Fatalf("TestFixedBytes(%s): NewFromReader error: %s", test.desc, err)
+		}
+
+		for _, x := range []*Struct{s, decoded} {
+			got := MustGetFixedBytes(x, 0)
+			if !bytes.Equal(got, test.value) || (got == nil) != (test.value == nil) {
+				t.Errorf("TestFixedBytes(%s): got %v, want %v", test.desc, got, test.value)
+			}
+			if x.IsSet(0) != test.wantSet {
+				t.Errorf("TestFixedBytes(%s): IsSet(): got %v, want %v", test.desc, x.IsSet(0), test.wantSet)
+			}
+		}
+
+		// A peer that expects a different size must not accept the field.
+		_, err = NewFromReader(bytes.NewReader(data), other)
+		if test.wantSet && !errors.Is(err, ErrCorruptData) {
+			t.Errorf("TestFixedBytes(%s): decoding with the wrong size: got err == %v, want err == %v", test.desc, err, ErrCorruptData)
+		}
+		if !test.wantSet && err != nil {
+			t.Errorf("TestFixedBytes(%s): decoding with the wrong size when unset: got err == %s, want err == nil", test.desc, err)
+		}
+
+		MustSetFixedBytes(s, 0, nil)
+		if MustGetFixedBytes(s, 0) != nil || s.IsSet(0) || *s.structTotal != 8 {
+			t.Errorf("TestFixedBytes(%s): after setting nil, field is still set", test.desc)
+		}
+	}
+}
+func TestDefault(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool, FieldNum: 0, Default: true},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 1, Default: int32(30)},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 2, Default: uint64(1 << 40)},
+			{Name: "Float32", Type: field.FTFloat32, FieldNum: 3, Default: float32(1.5)},
+			{Name: "NoDefault", Type: field.FTInt64, FieldNum: 4},
+		},
+	}
+
+	s := New(0, m)
+	s.XXXSetNoZeroTypeCompression()
+
+	if got := MustGetBool(s, 0); got != true {
+		t.Errorf("TestDefault(Bool): got %v, want true", got)
+	}
+	if got := MustGetNumber[int32](s, 1); got != 30 {
+		t.Errorf("TestDefault(Int32): got %d, want 30", got)
+	}
+	if got := MustGetNumber[uint64](s, 2); got != 1<<40 {
+		t.Errorf("TestDefault(Uint64): got %d, want %d", got, uint64(1<<40))
+	}
+	if got := MustGetNumber[float32](s, 3); got != 1.5 {
+		t.Errorf("TestDefault(Float32): got %v, want 1.5", got)
+	}
+	if got := MustGetNumber[int64](s, 4); got != 0 {
+		t.Errorf("TestDefault(NoDefault): got %d, want 0", got)
+	}
+	for i := uint16(0); i < 5; i++ {
+		if s.IsSet(i) {
+			t.Errorf("TestDefault(field %d): IsSet() was true, want false", i)
+		}
+	}
+
+	// Defaults are never encoded.
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestDefault: Marshal error: %s", err)
+	}
+	if buff.Len() != 8 {
+		t.Errorf("TestDefault: got encoded size %d, want 8", buff.Len())
+	}
+
+	MustSetBool(s, 0, false)
+	MustSetNumber(s, 1, int32(2))
+	if got := MustGetBool(s, 0); got != false {
+		t.Errorf("TestDefault(Bool): after set, got %v, want false", got)
+	}
+	if got := MustGetNumber[int32](s, 1); got != 2 {
+		t.Errorf("TestDefault(Int32): after set, got %d, want 2", got)
+	}
+
+	// An Enum reads its default through the wire type of the field.
+	type maker uint8
+	em := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Maker", Type: field.FTUint8, FieldNum: 0, IsEnum: true, Default: uint8(2)},
+		},
+	}
+	if got := MustGetNumber[maker](New(0, em), 0); got != 2 {
+		t.Errorf("TestDefault(Enum): got %d, want 2", got)
+	}
+}
+
+func TestDefaultZeroRoundTrip(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool, FieldNum: 0, Default: true},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 1, Default: int32(30)},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 2, Default: uint64(1 << 40)},
+			{Name: "NoDefault", Type: field.FTInt64, FieldNum: 3},
+		},
+	}
+
+	// With zero value compression, fields with a default are still encoded when set to the zero
+	// value, otherwise they would decode as the default.
+	s := New(0, m)
+	MustSetBool(s, 0, false)
+	MustSetNumber(s, 1, int32(0))
+	MustSetNumber(s, 2, uint64(0))
+	MustSetNumber(s, 3, int64(0))
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestDefaultZeroRoundTrip: Marshal error: %s", err)
+	}
+	if err := marshalCheck(s, buff.Len()); err != nil {
+		t.Errorf("TestDefaultZeroRoundTrip: %s", err)
+	}
+	// The header, Bool, Int32 and Uint64, but not NoDefault.
+	if buff.Len() != 8+8+8+16 {
+		t.Errorf("TestDefaultZeroRoundTrip: got encoded size %d, want %d", buff.Len(), 8+8+8+16)
+	}
+
+	got, err := NewFromReader(buff, m)
+	if err != nil {
+		t.Fatalf("TestDefaultZeroRoundTrip: NewFromReader error: %s", err)
+	}
+	if v := MustGetBool(got, 0); v != false {
+		t.Errorf("TestDefaultZeroRoundTrip(Bool): got %v, want false", v)
+	}
+	if v := MustGetNumber[int32](got, 1); v != 0 {
+		t.Errorf("TestDefaultZeroRoundTrip(Int32): got %d, want 0", v)
+	}
+	if v := MustGetNumber[uint64](got, 2); v != 0 {
+		t.Errorf("TestDefaultZeroRoundTrip(Uint64): got %d, want 0", v)
+	}
+	if !Equal(s, got) {
+		t.Errorf("TestDefaultZeroRoundTrip: the decoded Struct was not Equal() to the encoded one")
+	}
+}
+
+func TestSparseFieldNumbers(t *testing.T) {
+	m := (&mapping.Map{
+		Name: "Sparse",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Count", Type: field.FTInt32, FieldNum: 1000},
+			{Name: "Name", Type: field.FTString, FieldNum: 0},
+		},
+	}).Init()
+
+	// Numbers 1-999 share a single reserved entry.
+	if len(m.Fields) != 3 {
+		t.Fatalf("TestSparseFieldNumbers: Init(): got %d Fields, want 3", len(m.Fields))
+	}
+	if m.FieldByNumber(1000).Name != "Count" || m.FieldByNumber(0).Name != "Name" {
+		t.Fatalf("TestSparseFieldNumbers: FieldByNumber() did not find the declared fields")
+	}
+	for _, num := range []uint16{1, 999, 1001} {
+		if fd := m.FieldByNumber(num); fd != nil {
+			t.Errorf("TestSparseFieldNumbers: FieldByNumber(%d): got %v, want nil", num, fd)
+		}
+	}
+
+	s := New(0, m)
+	if len(s.fields) != 3 {
+		t.Errorf("TestSparseFieldNumbers: New(): got %d field slots, want 3", len(s.fields))
+	}
+	MustSetBytes(s, 0, []byte("hello"), true)
+	MustSetNumber(s, 1000, int32(10))
+	if err := SetNumber(s, 3, int32(1)); err == nil {
+		t.Errorf("TestSparseFieldNumbers: SetNumber() on a reserved field: got err == nil, want err != nil")
+	}
+	if got := SetFields(s); !reflect.DeepEqual(got, []uint16{0, 1000}) {
+		t.Errorf("TestSparseFieldNumbers: SetFields(): got %v, want [0 1000]", got)
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestSparseFieldNumbers: Marshal(): %s", err)
+	}
+	decoded, err := NewFromReader(bytes.NewReader(buff.Bytes()), m)
+	if err != nil {
+		t.Fatalf("TestSparseFieldNumbers: NewFromReader(): %s", err)
+	}
+	if got := MustGetNumber[int32](decoded, 1000); got != 10 {
+		t.Errorf("TestSparseFieldNumbers: decoded field 1000: got %d, want 10", got)
+	}
+
+	// A newer version of the Struct uses two of our reserved numbers. We must keep the fields
+	// when we decode and re-encode.
+	newer := (&mapping.Map{
+		Name: "Sparse",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Count", Type: field.FTInt32, FieldNum: 1000},
+			{Name: "Name", Type: field.FTString, FieldNum: 0},
+			{Name: "Data", Type: field.FTBytes, FieldNum: 3},
+			{Name: "Extra", Type: field.FTInt64, FieldNum: 7},
+		},
+	}).Init()
+	encodeNewer := func(extra int64) []byte {
+		ns := New(0, newer)
+		MustSetBytes(ns, 0, []byte("hello"), true)
+		MustSetBytes(ns, 3, []byte("from the future"), false)
+		MustSetNumber(ns, 7, extra)
+		MustSetNumber(ns, 1000, int32(10))
+		buff := &bytes.Buffer{}
+		if _, err := ns.Marshal(buff); err != nil {
+			t.Fatalf("TestSparseFieldNumbers: Marshal(newer): %s", err)
+		}
+		return buff.Bytes()
+	}
+	want := encodeNewer(42)
+
+	if err := Verify(want, m); err != nil {
+		t.Errorf("TestSparseFieldNumbers: Verify(newer data, older mapping): %s", err)
+	}
+	old, err := NewFromReader(bytes.NewReader(want), m)
+	if err != nil {
+		t.Fatalf("TestSparseFieldNumbers: NewFromReader(newer data, older mapping): %s", err)
+	}
+	if got := MustGetNumber[int32](old, 1000); got != 10 {
+		t.Errorf("TestSparseFieldNumbers: field after reserved fields: got %d, want 10", got)
+	}
+	buff.Reset()
+	if _, err := old.Marshal(buff); err != nil {
+		t.Fatalf("TestSparseFieldNumbers: Marshal(older): %s", err)
+	}
+	if !bytes.Equal(buff.Bytes(), want) {
+		t.Errorf("TestSparseFieldNumbers: re-encoding with older mapping did not keep the reserved fields")
+	}
+
+	// Merging replaces the reserved fields we already hold with the ones being merged in.
+	dst, err := NewFromReader(bytes.NewReader(encodeNewer(1)), m)
+	if err != nil {
+		t.Fatalf("TestSparseFieldNumbers: NewFromReader(newer data, older mapping): %s", err)
+	}
+	if err := Merge(dst, old); err != nil {
+		t.Fatalf("TestSparseFieldNumbers: Merge(): %s", err)
+	}
+	buff.Reset()
+	if _, err := dst.Marshal(buff); err != nil {
+		t.Fatalf("TestSparseFieldNumbers: Marshal(merged): %s", err)
+	}
+	if !bytes.Equal(buff.Bytes(), want) {
+		t.Errorf("TestSparseFieldNumbers: Merge() did not replace the reserved fields")
+	}
+}
+
+func TestCloneAndReset(t *testing.T) {
+	m, data := verifyTestData()
+
+	orig, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestCloneAndReset: NewFromReader(): %s", err)
+	}
+	want, _ := NewFromReader(bytes.NewReader(data), m)
+
+	clone := orig.Clone()
+	if !Equal(clone, orig) {
+		t.Fatalf("TestCloneAndReset: Clone() did not equal the original")
+	}
+
+	// Changes to the clone must not show up in the original.
+	MustSetNumber(clone, 0, int32(20))
+	MustSetBytes(clone, 2, []byte("bye"), false)
+	MustGetListNumber[uint16](clone, 5).Set(0, 100)
+	MustGetListBytes(clone, 6).Set(0, []byte("nope"))
+	if !Equal(orig, want) {
+		t.Errorf("TestCloneAndReset: changing the clone changed the original")
+	}
+
+	// Reset() a nested Struct must update the size of its parent.
+	sub := MustGetStruct(clone, 3)
+	sub.Reset()
+	if sub.fields[0].Header != nil {
+		t.Errorf("TestCloneAndReset: Reset() sub Struct: field 0 is still set")
+	}
+	buff := &bytes.Buffer{}
+	if _, err := clone.Marshal(buff); err != nil {
+		t.Errorf("TestCloneAndReset: Marshal() after sub Struct Reset(): %s", err)
+	}
+
+	l := MustGetListNumber[uint16](clone, 5)
+	clone.Reset()
+	for i := range m.Fields {
+		if clone.fields[i].Header != nil {
+			t.Errorf("TestCloneAndReset: Reset(): field %d is still set", i)
+		}
+	}
+	// A list that was removed by Reset() must not change the size of the Struct.
+	l.Append(1, 2, 3)
+	if !Equal(clone, New(0, m)) {
+		t.Errorf("TestCloneAndReset: Reset() Struct did not equal an empty Struct")
+	}
+
+	// The Struct must still be usable.
+	MustSetNumber(clone, 0, int32(1))
+	if err := marshalCheck(clone, 16); err != nil {
+		t.Errorf("TestCloneAndReset: Marshal() after Reset(): %s", err)
+	}
+}
+
+func TestEmbedSchemaHash(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestEmbedSchemaHash: NewFromReader(): %s", err)
+	}
+
+	other := &mapping.Map{
+		Name: "Other",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+
+	buff := &bytes.Buffer{}
+	if _, err := s.MarshalWithOptions(buff, MarshalOptions{}); err != nil {
+		t.Fatalf("TestEmbedSchemaHash: MarshalWithOptions(no options): %s", err)
+	}
+	if !bytes.Equal(buff.Bytes(), data) {
+		t.Errorf("TestEmbedSchemaHash: MarshalWithOptions(no options) did not match Marshal()")
+	}
+
+	buff.Reset()
+	n, err := s.MarshalWithOptions(buff, MarshalOptions{EmbedSchemaHash: true})
+	if err != nil {
+		t.Fatalf("TestEmbedSchemaHash: MarshalWithOptions(EmbedSchemaHash): %s", err)
+	}
+	if n != len(data)+16 {
+		t.Errorf("TestEmbedSchemaHash: MarshalWithOptions(EmbedSchemaHash): got %d bytes written, want %d", n, len(data)+16)
+	}
+	withHash := buff.Bytes()
+
+	if err := Verify(withHash, m); err != nil {
+		t.Errorf("TestEmbedSchemaHash: Verify(): got err == %s, want err == nil", err)
+	}
+	got, err := NewFromReader(bytes.NewReader(withHash), m)
+	if err != nil {
+		t.Fatalf("TestEmbedSchemaHash: NewFromReader(): got err == %s, want err == nil", err)
+	}
+	if !Equal(got, s) {
+		t.Errorf("TestEmbedSchemaHash: NewFromReader(): decoded Struct did not equal the original")
+	}
+
+	if err := Verify(withHash, other); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("TestEmbedSchemaHash: Verify(wrong mapping): got err == %v, want ErrSchemaMismatch", err)
+	}
+	if _, err := NewFromReader(bytes.NewReader(withHash), other); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("TestEmbedSchemaHash: NewFromReader(wrong mapping): got err == %v, want ErrSchemaMismatch", err)
+	}
+	if err := New(0, other).UnmarshalMerge(bytes.NewReader(withHash)); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("TestEmbedSchemaHash: UnmarshalMerge(wrong mapping): got err == %v, want ErrSchemaMismatch", err)
+	}
+}
+
+func TestGetField(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+		},
+	}
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int8", Type: field.FTInt8},
+			{Name: "String", Type: field.FTString, FieldNum: 1},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 2},
+			{Name: "Enum16", Type: field.FTUint16, FieldNum: 3, IsEnum: true},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 4, Mapping: sub},
+			{Name: "ListUint16", Type: field.FTListUint16, FieldNum: 5},
+			{Name: "ListStrings", Type: field.FTListStrings, FieldNum: 6},
+			{Name: "ListStructs", Type: field.FTListStructs, FieldNum: 7, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	src := New(0, m)
+	MustSetNumber(src, 0, int8(-3))
+	MustSetBytes(src, 1, []byte("hello"), true)
+	MustSetBytes(src, 2, []byte("world"), false)
+	MustSetNumber(src, 3, uint16(300))
+	item := New(0, sub)
+	MustSetBool(item, 0, true)
+	MustSetStruct(src, 4, item)
+	MustSetListNumberSlice(src, 5, []uint16{1, 2})
+	l := NewBytes()
+	l.Append([]byte("a"), []byte("b"))
+	MustSetListBytes(src, 6, l)
+	item = New(0, sub)
+	MustSetBool(item, 0, true)
+	MustAppendListStruct(src, 7, item)
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		want     any // Only checked for types that can be compared with ==.
+		err      error
+	}{
+		{desc: "int8", fieldNum: 0, want: int8(-3)},
+		{desc: "string", fieldNum: 1, want: "hello"},
+		{desc: "bytes", fieldNum: 2},
+		{desc: "enum", fieldNum: 3, want: uint16(300)},
+		{desc: "Struct", fieldNum: 4},
+		{desc: "list of numbers", fieldNum: 5},
+		{desc: "list of strings", fieldNum: 6},
+		{desc: "list of Structs", fieldNum: 7},
+		{desc: "Error: bad field number", fieldNum: 8, err: ErrFieldNotFound},
+	}
+
+	for _, test := range tests {
+		got, err := GetField(src, test.fieldNum)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("TestGetField(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestGetField(%s): got err == %v, want err == %v", test.desc, err, test.err)
+			continue
+		case err != nil:
+			continue
+		}
+		if test.want != nil && got != test.want {
+			t.Errorf("TestGetField(%s): got %v, want %v", test.desc, got, test.want)
+		}
+
+		// What GetField() returns must be what TrySetField() takes.
+		dst := New(0, m)
+		if err := TrySetField(dst, test.fieldNum, got); err != nil {
+			t.Errorf("TestGetField(%s): TrySetField() with the value: %s", test.desc, err)
+			continue
+		}
+		want := New(0, m)
+		if err := CopyField(want, test.fieldNum, src, test.fieldNum); err != nil {
+			t.Fatalf("TestGetField(%s): CopyField(): %s", test.desc, err)
+		}
+		if !Equal(dst, want) {
+			t.Errorf("TestGetField(%s): setting the value did not give the same field", test.desc)
+		}
+	}
+}
+
+func TestTrySetField(t *testing.T) {
+	type enum8 uint8
+	type enum16 uint16
+
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int8", Type: field.FTInt8},
+			{Name: "String", Type: field.FTString, FieldNum: 1},
+			{Name: "ListUint16", Type: field.FTListUint16, FieldNum: 2},
+			{Name: "Enum8", Type: field.FTUint8, FieldNum: 3, IsEnum: true},
+			{Name: "Enum16", Type: field.FTUint16, FieldNum: 4, IsEnum: true},
+			{Name: "Uint16", Type: field.FTUint16, FieldNum: 5},
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		value    any
+		err      error
+	}{
+		{desc: "Success: int8", fieldNum: 0, value: int8(-3)},
+		{desc: "Success: string", fieldNum: 1, value: "hello"},
+		{desc: "Success: list", fieldNum: 2, value: NewNumbers[uint16]()},
+		{desc: "Success: uint8 enum type", fieldNum: 3, value: enum8(2)},
+		{desc: "Success: uint16 enum type", fieldNum: 4, value: enum16(300)},
+		{desc: "Error: int for an int8", fieldNum: 0, value: 3, err: ErrTypeMismatch},
+		{desc: "Error: []byte for a string", fieldNum: 1, value: []byte("hello"), err: ErrTypeMismatch},
+		{desc: "Error: wrong list type", fieldNum: 2, value: NewNumbers[uint32](), err: ErrTypeMismatch},
+		{desc: "Error: uint8 enum type for a uint16 enum", fieldNum: 4, value: enum8(2), err: ErrTypeMismatch},
+		{desc: "Error: enum type for a field that isn't an enum", fieldNum: 5, value: enum16(2), err: ErrTypeMismatch},
+		{desc: "Error: bad field number", fieldNum: 6, value: int8(1), err: ErrFieldNotFound},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		err := TrySetField(s, test.fieldNum, test.value)
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("TestTrySetField(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestTrySetField(%s): got err == %v, want err == %v", test.desc, err, test.err)
+			continue
+		case err != nil:
+			continue
+		}
+		if !s.IsSet(test.fieldNum) {
+			t.Errorf("TestTrySetField(%s): field was not set", test.desc)
+		}
+	}
+
+	// SetField panics with the same error, which names the field.
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("TestTrySetField: SetField(): got panic(%v), want panic(ErrTypeMismatch)", r)
+		}
+	}()
+	SetField(New(0, m), 0, 3)
+}
+
+func TestMarshalInto(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestMarshalInto: NewFromReader(): %s", err)
+	}
+
+	if s.Size() != len(data) {
+		t.Fatalf("TestMarshalInto: Size(): got %d, want %d", s.Size(), len(data))
+	}
+
+	// A fixed array stands in for memory we don't own, such as an mmap. If MarshalInto() grew or
+	// replaced the slice, the data would not show up in the array.
+	var backing [4096]byte
+	if len(data) > len(backing)-8 {
+		t.Fatalf("TestMarshalInto: test data is %d bytes, which is too large for the test", len(data))
+	}
+	for i := range backing {
+		backing[i] = 0xff
+	}
+	region := backing[8 : 8+s.Size()]
+
+	n, err := s.MarshalInto(region)
+	if err != nil {
+		t.Fatalf("TestMarshalInto: MarshalInto(): got err == %s, want err == nil", err)
+	}
+	if n != len(data) {
+		t.Errorf("TestMarshalInto: MarshalInto(): got %d bytes written, want %d", n, len(data))
+	}
+	if !bytes.Equal(backing[8:8+n], data) {
+		t.Errorf("TestMarshalInto: the backing array did not hold the encoded Struct")
+	}
+	if backing[7] != 0xff || backing[8+n] != 0xff {
+		t.Errorf("TestMarshalInto: MarshalInto() wrote outside of the region it was given")
+	}
+
+	if _, err := s.MarshalInto(make([]byte, s.Size()-8)); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("TestMarshalInto: MarshalInto(short buffer): got err == %v, want io.ErrShortBuffer", err)
+	}
+}
+
+func TestCachedMarshal(t *testing.T) {
+	m, data := verifyTestData()
+	subMapping := m.Fields[3].Mapping
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestCachedMarshal: NewFromReader(): %s", err)
+	}
+
+	got, err := s.CachedMarshal()
+	if err != nil {
+		t.Fatalf("TestCachedMarshal: CachedMarshal(): %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("TestCachedMarshal: CachedMarshal() did not return the same bytes as Marshal()")
+	}
+	again, _ := s.CachedMarshal()
+	if &again[0] != &got[0] {
+		t.Errorf("TestCachedMarshal: second CachedMarshal() encoded s again, want the cached bytes")
+	}
+
+	// Each change must cause the next CachedMarshal() to encode s again. Some of these don't
+	// change the size of s.
+	tests := []struct {
+		desc   string
+		change func(s *Struct)
+	}{
+		{
+			desc:   "SetNumber on a set field",
+			change: func(s *Struct) { MustSetNumber(s, 0, int32(7)) },
+		},
+		{
+			desc: "Merge() a number",
+			change: func(s *Struct) {
+				src := New(0, m)
+				MustSetNumber(src, 0, int32(11))
+				if err := Merge(s, src); err != nil {
+					panic(err)
+				}
+			},
+		},
+		{
+			desc:   "SetBytes",
+			change: func(s *Struct) { MustSetBytes(s, 2, []byte("world"), false) },
+		},
+		{
+			desc:   "Bools.Set",
+			change: func(s *Struct) { MustGetListBool(s, 4).Set(1, true) },
+		},
+		{
+			desc:   "Numbers.Set",
+			change: func(s *Struct) { MustGetListNumber[uint16](s, 5).Set(0, 9) },
+		},
+		{
+			desc:   "Bytes.Set",
+			change: func(s *Struct) { MustGetListBytes(s, 6).Set(0, []byte("WHAT")) },
+		},
+		{
+			desc:   "field in a Struct in a list",
+			change: func(s *Struct) { MustSetBool(MustGetListStruct(s, 7).Get(0), 0, true) },
+		},
+		{
+			desc:   "SortBy",
+			change: func(s *Struct) { MustGetListStruct(s, 7).SortBy(0) },
+		},
+		{
+			desc: "Structs.Set",
+			change: func(s *Struct) {
+				item := New(0, subMapping)
+				MustSetBool(item, 0, true)
+				if err := MustGetListStruct(s, 7).Set(0, item); err != nil {
+					panic(err)
+				}
+			},
+		},
+		{
+			desc:   "DeleteField",
+			change: func(s *Struct) { DeleteField(s, 3) },
+		},
+	}
+
+	for _, test := range tests {
+		before, err := s.CachedMarshal()
+		if err != nil {
+			t.Fatalf("TestCachedMarshal(%s): CachedMarshal() before the change: %s", test.desc, err)
+		}
+		before = append([]byte(nil), before...)
+
+		test.change(s)
+
+		got, err := s.CachedMarshal()
+		if err != nil {
+			t.Errorf("TestCachedMarshal(%s): CachedMarshal(): %s", test.desc, err)
+			continue
+		}
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestCachedMarshal(%s): Marshal(): %s", test.desc, err)
+		}
+		if !bytes.Equal(got, buff.Bytes()) {
+			t.Errorf("TestCachedMarshal(%s): CachedMarshal() returned stale bytes", test.desc)
+		}
+		if bytes.Equal(got, before) {
+			t.Errorf("TestCachedMarshal(%s): encoding did not change, the test change does nothing", test.desc)
+		}
+	}
+}
+
+func TestMarshalRepeat(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestMarshalRepeat: NewFromReader(): %s", err)
+	}
+	header := append([]byte{}, s.header...)
+	size := s.Size()
+
+	// Copies of a generated type share one *Struct, so encoding any of them encodes s, which must
+	// not change it.
+	marshals := []struct {
+		desc    string
+		marshal func() ([]byte, error)
+	}{
+		{
+			desc: "Marshal()",
+			marshal: func() ([]byte, error) {
+				buff := &bytes.Buffer{}
+				_, err := s.Marshal(buff)
+				return buff.Bytes(), err
+			},
+		},
+		{
+			desc: "MarshalInto()",
+			marshal: func() ([]byte, error) {
+				b := make([]byte, s.Size())
+				n, err := s.MarshalInto(b)
+				return b[:n], err
+			},
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, test := range marshals {
+			got, err := test.marshal()
+			if err != nil {
+				t.Fatalf("TestMarshalRepeat(%s): call %d: %s", test.desc, i, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("TestMarshalRepeat(%s): call %d: did not return the bytes that were decoded", test.desc, i)
+			}
+			if !bytes.Equal(s.header, header) || s.Size() != size {
+				t.Errorf("TestMarshalRepeat(%s): call %d: changed the Struct's header or size", test.desc, i)
+			}
+		}
+	}
+}
+
+func TestSetSameValue(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestSetSameValue: NewFromReader(): %s", err)
+	}
+	sub := MustGetStruct(s, 3)
+
+	tests := []struct {
+		desc   string
+		set    func()
+		change bool
+	}{
+		{desc: "SetNumber with the same int32", set: func() { MustSetNumber(s, 0, int32(-3)) }},
+		{desc: "SetNumber with the same uint64", set: func() { MustSetNumber(s, 1, uint64(1<<40)) }},
+		{desc: "SetBool with the same value", set: func() { MustSetBool(sub, 0, true) }},
+		{desc: "SetNumber with a new uint64", set: func() { MustSetNumber(s, 1, uint64(1<<41)) }, change: true},
+		{desc: "SetNumber with a new int32", set: func() { MustSetNumber(s, 0, int32(-4)) }, change: true},
+	}
+
+	for _, test := range tests {
+		before, err := s.CachedMarshal()
+		if err != nil {
+			t.Fatalf("TestSetSameValue(%s): CachedMarshal(): %s", test.desc, err)
+		}
+		size := s.Size()
+
+		test.set()
+
+		if s.modified != test.change {
+			t.Errorf("TestSetSameValue(%s): got modified == %v, want %v", test.desc, s.modified, test.change)
+		}
+		if s.Size() != size {
+			t.Errorf("TestSetSameValue(%s): size changed from %d to %d", test.desc, size, s.Size())
+		}
+		after, err := s.CachedMarshal()
+		if err != nil {
+			t.Fatalf("TestSetSameValue(%s): CachedMarshal() after: %s", test.desc, err)
+		}
+		if reused := &after[0] == &before[0]; reused == test.change {
+			t.Errorf("TestSetSameValue(%s): got cached bytes reused == %v, want %v", test.desc, reused, !test.change)
+		}
+	}
+}
+
+// shortWriter stops writing after limit bytes, or writes one byte per call if oneByte is set, but
+// never returns an error.
+type shortWriter struct {
+	buff    bytes.Buffer
+	limit   int
+	oneByte bool
+}
+
+func (s *shortWriter) Write(p []byte) (int, error) {
+	if room := s.limit - s.buff.Len(); len(p) > room {
+		p = p[:room]
+	}
+	if s.oneByte && len(p) > 1 {
+		p = p[:1]
+	}
+	return s.buff.Write(p)
+}
+
+func TestMarshalShortWrite(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestMarshalShortWrite: NewFromReader(): %s", err)
+	}
+
+	w := &shortWriter{limit: len(data), oneByte: true}
+	if n, err := s.Marshal(w); !errors.Is(err, io.ErrShortWrite) || n != 1 {
+		t.Errorf("TestMarshalShortWrite(one byte at a time): got (%d, %v), want (1, io.ErrShortWrite)", n, err)
+	}
+
+	// Every limit stops the write in a different place, which covers the header, scalars, bytes,
+	// Structs and each list type.
+	for limit := 0; limit < len(data); limit++ {
+		w := &shortWriter{limit: limit}
+		n, err := s.Marshal(w)
+		if !errors.Is(err, io.ErrShortWrite) {
+			t.Errorf("TestMarshalShortWrite(limit %d): got err == %v, want io.ErrShortWrite", limit, err)
+			continue
+		}
+		if n != w.buff.Len() {
+			t.Errorf("TestMarshalShortWrite(limit %d): returned %d bytes written, but %d were written", limit, n, w.buff.Len())
+		}
+		if !bytes.Equal(w.buff.Bytes(), data[:w.buff.Len()]) {
+			t.Errorf("TestMarshalShortWrite(limit %d): the bytes written were not the start of the encoding", limit)
+		}
+	}
+}
+
+func TestOmitTopHeader(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestOmitTopHeader: NewFromReader(): %s", err)
+	}
+
+	buff := &bytes.Buffer{}
+	n, err := s.MarshalWithOptions(buff, MarshalOptions{OmitTopHeader: true})
+	if err != nil {
+		t.Fatalf("TestOmitTopHeader: MarshalWithOptions(OmitTopHeader): %s", err)
+	}
+	if n != len(data)-8 || !bytes.Equal(buff.Bytes(), data[8:]) {
+		t.Errorf("TestOmitTopHeader: MarshalWithOptions(OmitTopHeader): got %d bytes, want the %d bytes after the header", n, len(data)-8)
+	}
+
+	got, err := NewFromReaderWithOptions(bytes.NewReader(buff.Bytes()), m, UnmarshalOptions{OmitTopHeader: true})
+	if err != nil {
+		t.Fatalf("TestOmitTopHeader: NewFromReaderWithOptions(OmitTopHeader): %s", err)
+	}
+	again := &bytes.Buffer{}
+	if _, err := got.Marshal(again); err != nil {
+		t.Fatalf("TestOmitTopHeader: Marshal(decoded): %s", err)
+	}
+	if !bytes.Equal(again.Bytes(), data) {
+		t.Errorf("TestOmitTopHeader: decoded Struct did not encode to the original data")
+	}
+
+	if _, err := NewFromReaderWithOptions(bytes.NewReader(data), m, UnmarshalOptions{OmitTopHeader: true}); err == nil {
+		t.Errorf("TestOmitTopHeader: NewFromReaderWithOptions(OmitTopHeader) of data with a header: got err == nil, want err != nil")
+	}
+	if _, err := s.MarshalWithOptions(buff, MarshalOptions{OmitTopHeader: true, EmbedSchemaHash: true}); err == nil {
+		t.Errorf("TestOmitTopHeader: MarshalWithOptions(OmitTopHeader, EmbedSchemaHash): got err == nil, want err != nil")
+	}
+}
+
+// writeCounter counts the calls to Write().
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestMarshalScratch(t *testing.T) {
+	m, data := verifyTestData()
+	s, err := NewFromReader(bytes.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("TestMarshalScratch: NewFromReader(): %s", err)
+	}
+	small := New(0, m)
+	MustSetNumber(small, 0, int32(1))
+	want := &bytes.Buffer{}
+	if _, err := small.Marshal(want); err != nil {
+		t.Fatalf("TestMarshalScratch: Marshal(small): %s", err)
+	}
+
+	// Every buffer from the pool holds data from a use that didn't clean up after itself.
+	scratch := &sync.Pool{
+		New: func() any { return bytes.NewBufferString("left over from another use") },
+	}
+
+	tests := []struct {
+		desc string
+		s    *Struct
+		want []byte
+	}{
+		{desc: "Struct", s: s, want: data},
+		{desc: "smaller Struct", s: small, want: want.Bytes()},
+		{desc: "larger Struct", s: s, want: data},
+	}
+
+	for _, test := range tests {
+		w := &writeCounter{}
+		n, err := test.s.MarshalWithOptions(w, MarshalOptions{Scratch: scratch})
+		if err != nil {
+			t.Errorf("TestMarshalScratch(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		}
+		if n != len(test.want) || !bytes.Equal(w.Bytes(), test.want) {
+			t.Errorf("TestMarshalScratch(%s): output did not match Marshal()", test.desc)
+		}
+		if w.writes != 1 {
+			t.Errorf("TestMarshalScratch(%s): got %d calls to Write(), want 1", test.desc, w.writes)
+		}
+	}
+
+	// Buffers are put back empty. A pool without New() returns nil if nothing was put back, which
+	// sync.Pool is allowed to do.
+	scratch = &sync.Pool{}
+	if _, err := s.MarshalWithOptions(io.Discard, MarshalOptions{Scratch: scratch}); err != nil {
+		t.Fatalf("TestMarshalScratch(pool without New): %s", err)
+	}
+	if x := scratch.Get(); x != nil && x.(*bytes.Buffer).Len() != 0 {
+		t.Errorf("TestMarshalScratch: a buffer put back in the pool held %d bytes, want 0", x.(*bytes.Buffer).Len())
+	}
+
+	// A buffer larger than SetMaxPooledBytes() allows is not put back.
+	SetMaxPooledBytes(8)
+	defer SetMaxPooledBytes(0)
+	scratch = &sync.Pool{}
+	if _, err := s.MarshalWithOptions(io.Discard, MarshalOptions{Scratch: scratch}); err != nil {
+		t.Fatalf("TestMarshalScratch(SetMaxPooledBytes): %s", err)
+	}
+	if x := scratch.Get(); x != nil {
+		t.Errorf("TestMarshalScratch(SetMaxPooledBytes): a %d byte buffer was put back in the pool", x.(*bytes.Buffer).Cap())
+	}
+}
+
+func TestSizeExceededNamesField(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Engine",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Cylinders", Type: field.FTUint8},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Car",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Engine", Type: field.FTStruct, FieldNum: 1, Mapping: sub},
+			{Name: "Parts", Type: field.FTListStructs, FieldNum: 2, Mapping: sub},
+			{Name: "Notes", Type: field.FTListBytes, FieldNum: 3},
+		},
+	}
+	m.MustValidate()
+
+	tests := []struct {
+		desc string
+		set  func(s *Struct) error
+		want string
+	}{
+		{
+			// SetBytes() does this check before touching the value.
+			desc: "SetBytes",
+			set:  func(s *Struct) error { return checkBytesSize(s, 0, maxDataSize+1) },
+			want: "Car.Name(0)",
+		},
+		{
+			desc: "SetStruct",
+			set: func(s *Struct) error {
+				e := New(1, sub)
+				*e.structTotal = maxDataSize + 8
+				return SetStruct(s, 1, e)
+			},
+			want: "Car.Engine(1)",
+		},
+		{
+			desc: "SetListStructs",
+			set: func(s *Struct) error {
+				l := NewStructs(sub)
+				*l.size = maxDataSize + 8
+				return SetListStructs(s, 2, l)
+			},
+			want: "Car.Parts(2)",
+		},
+		{
+			desc: "SetListBytes",
+			set: func(s *Struct) error {
+				l := NewBytes()
+				l.dataSize = maxDataSize
+				return SetListBytes(s, 3, l)
+			},
+			want: "Car.Notes(3)",
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		err := test.set(s)
+		if !errors.Is(err, ErrSizeExceeded) {
+			t.Errorf("TestSizeExceededNamesField(%s): got err == %v, want ErrSizeExceeded", test.desc, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("TestSizeExceededNamesField(%s): got err == %q, want it to name %q", test.desc, err, test.want)
+		}
+		if s.Size() != 8 {
+			t.Errorf("TestSizeExceededNamesField(%s): the Struct's size changed to %d", test.desc, s.Size())
+		}
+	}
+}
+
+func TestSetListSlice(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Lists",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bools", Type: field.FTListBools},
+			{Name: "Int64s", Type: field.FTListInt64, FieldNum: 1},
+			{Name: "Uint8s", Type: field.FTListUint8, FieldNum: 2},
+		},
+	}
+	m.MustValidate()
+
+	tests := []struct {
+		desc   string
+		bools  []bool
+		int64s []int64
+		uint8s []uint8
+	}{
+		{desc: "one item", bools: []bool{true}, int64s: []int64{-1}, uint8s: []uint8{1}},
+		{
+			desc:   "many items",
+			bools:  make([]bool, 65),
+			int64s: []int64{1, 2, 3},
+			uint8s: []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9},
+		},
+		{desc: "empty"},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		// Values that are replaced.
+		MustSetListBoolSlice(s, 0, []bool{false, true})
+		MustSetListNumberSlice(s, 1, []int64{9})
+		MustSetListNumberSlice(s, 2, []uint8{9})
+
+		MustSetListBoolSlice(s, 0, test.bools)
+		MustSetListNumberSlice(s, 1, test.int64s)
+		MustSetListNumberSlice(s, 2, test.uint8s)
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestSetListSlice(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestSetListSlice(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+
+		var bools []bool
+		if l := MustGetListBool(got, 0); l != nil {
+			bools = l.Slice()
+		}
+		var int64s []int64
+		if l := MustGetListNumber[int64](got, 1); l != nil {
+			int64s = l.Slice()
+		}
+		var uint8s []uint8
+		if l := MustGetListNumber[uint8](got, 2); l != nil {
+			uint8s = l.Slice()
+		}
+		if !reflect.DeepEqual(bools, test.bools) {
+			t.Errorf("TestSetListSlice(%s): got bools %v, want %v", test.desc, bools, test.bools)
+		}
+		if !reflect.DeepEqual(int64s, test.int64s) {
+			t.Errorf("TestSetListSlice(%s): got int64s %v, want %v", test.desc, int64s, test.int64s)
+		}
+		if !reflect.DeepEqual(uint8s, test.uint8s) {
+			t.Errorf("TestSetListSlice(%s): got uint8s %v, want %v", test.desc, uint8s, test.uint8s)
+		}
+	}
+
+	s := New(0, m)
+	if err := SetListNumberSlice(s, 1, []int32{1}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("TestSetListSlice(wrong type): got err == %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestClearList(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "A", Type: field.FTInt32},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Lists",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bools", Type: field.FTListBools},
+			{Name: "Int32s", Type: field.FTListInt32, FieldNum: 1},
+			{Name: "Bytes", Type: field.FTListBytes, FieldNum: 2},
+			{Name: "Strings", Type: field.FTListStrings, FieldNum: 3},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 4, Mapping: sub},
+			{Name: "Name", Type: field.FTString, FieldNum: 5},
+		},
+	}
+	m.MustValidate()
+
+	tests := []struct {
+		desc     string
+		populate bool
+	}{
+		{desc: "lists were not set"},
+		{desc: "lists had items", populate: true},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		if test.populate {
+			MustSetListBoolSlice(s, 0, []bool{true, false})
+			MustSetListNumberSlice(s, 1, []int32{1, 2, 3})
+			l := NewBytes()
+			l.Append([]byte("hello"))
+			MustSetListBytes(s, 2, l)
+			l = NewBytes()
+			l.Append([]byte("world"))
+			MustSetListBytes(s, 3, l)
+			item := New(0, sub)
+			MustSetNumber(item, 0, int32(1))
+			MustAppendListStruct(s, 4, item)
+		}
+
+		for i := uint16(0); i < 5; i++ {
+			if err := ClearList(s, i); err != nil {
+				t.Fatalf("TestClearList(%s): ClearList(%d): %s", test.desc, i, err)
+			}
+		}
+		// The Struct header and an empty list header for each list.
+		if s.Size() != 48 {
+			t.Errorf("TestClearList(%s): got Size() == %d, want 48", test.desc, s.Size())
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestClearList(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		if err := Verify(buff.Bytes(), m); err != nil {
+			t.Errorf("TestClearList(%s): Verify(): %s", test.desc, err)
+		}
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestClearList(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+		for i := uint16(0); i < 5; i++ {
+			if n, ok := ListLen(got, i); !ok || n != 0 {
+				t.Errorf("TestClearList(%s): field %d: got ListLen() == (%d, %v), want (0, true)", test.desc, i, n, ok)
+			}
+		}
+	}
+
+	if err := ClearList(New(0, m), 5); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("TestClearList(not a list): got err == %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestDenseFields(t *testing.T) {
+	m := &mapping.Map{
+		Name: "Event",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Timestamp", Type: field.FTInt64, Dense: true},
+			{Name: "Code", Type: field.FTInt32, FieldNum: 1, Dense: true, Default: int32(7)},
+			{Name: "Count", Type: field.FTInt64, FieldNum: 2},
+		},
+	}
+	m.MustValidate()
+
+	setValues := func(s *Struct) {
+		MustSetNumber(s, 0, int64(1665878400))
+		MustSetNumber(s, 1, int32(3))
+	}
+
+	tests := []struct {
+		desc          string
+		set           func(s *Struct)
+		wantSet       bool
+		wantTimestamp int64
+		wantCode      int32
+	}{
+		{desc: "not set", set: func(s *Struct) {}, wantCode: 7},
+		{
+			desc: "zero values",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, int64(0))
+				MustSetNumber(s, 1, int32(0))
+			},
+			wantSet: true,
+		},
+		{desc: "set values", set: setValues, wantSet: true, wantTimestamp: 1665878400, wantCode: 3},
+		{
+			desc: "Reset()",
+			set: func(s *Struct) {
+				setValues(s)
+				s.Reset()
+			},
+			wantCode: 7,
+		},
+		{
+			desc: "deleted",
+			set: func(s *Struct) {
+				setValues(s)
+				DeleteField(s, 0)
+				DeleteField(s, 1)
+			},
+			wantCode: 7,
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		test.set(s)
+
+		// A Dense field that is not set is encoded, but is still not set.
+		if _, ok, _ := GetNumberOK[int64](s, 0); ok != test.wantSet {
+			t.Errorf("TestDenseFields(%s): GetNumberOK(Timestamp): got ok == %v, want %v", test.desc, ok, test.wantSet)
+		}
+		if offset, size, ok := FieldOffset(s, 1); !ok || offset != 24 || size != 8 {
+			t.Errorf("TestDenseFields(%s): FieldOffset(Code): got (%d, %d, %v), want (24, 8, true)", test.desc, offset, size, ok)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestDenseFields(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		// The Struct header, Timestamp's header and value and Code in its header, which is the same for any value.
+		if buff.Len() != 32 || s.Size() != 32 {
+			t.Errorf("TestDenseFields(%s): got %d encoded bytes and Size() %d, want 32", test.desc, buff.Len(), s.Size())
+		}
+
+		got, err := NewFromReader(buff, m)
+		if err != nil {
+			t.Errorf("TestDenseFields(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+		if v := MustGetNumber[int64](got, 0); v != test.wantTimestamp {
+			t.Errorf("TestDenseFields(%s): got Timestamp %d, want %d", test.desc, v, test.wantTimestamp)
+		}
+		if v := MustGetNumber[int32](got, 1); v != test.wantCode {
+			t.Errorf("TestDenseFields(%s): got Code %d, want %d", test.desc, v, test.wantCode)
+		}
+		if !Equal(s, got) {
+			t.Errorf("TestDenseFields(%s): decoded Struct is not Equal() to the original", test.desc)
+		}
+	}
+
+	// MarshalDelta() only writes what changed, which does not include Dense fields that were not.
+	s := New(0, m)
+	s.ClearDirty()
+	MustSetNumber(s, 2, int64(1))
+	buff := &bytes.Buffer{}
+	if _, err := s.MarshalDelta(buff); err != nil {
+		t.Fatalf("TestDenseFields(MarshalDelta): %s", err)
+	}
+	if buff.Len() != 24 {
+		t.Errorf("TestDenseFields(MarshalDelta): got %d encoded bytes, want 24", buff.Len())
+	}
+}
+
+// TestDenseFieldsNotInData tests that a Dense field that is missing from decoded data, such as data
+// written before the field was added, is written at its full size when the Struct is encoded again.
+func TestDenseFieldsNotInData(t *testing.T) {
+	old := &mapping.Map{
+		Name: "Event",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Count", Type: field.FTInt64},
+		},
+	}
+	old.MustValidate()
+	m := &mapping.Map{
+		Name: "Event",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Count", Type: field.FTInt64},
+			{Name: "Timestamp", Type: field.FTInt64, FieldNum: 1, Dense: true},
+			{Name: "Code", Type: field.FTInt32, FieldNum: 2, Dense: true},
+		},
+	}
+	m.MustValidate()
+	oldParent := &mapping.Map{
+		Name: "Events",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Items", Type: field.FTListStructs, Mapping: old},
+			{Name: "Last", Type: field.FTStruct, FieldNum: 1, Mapping: old},
+		},
+	}
+	oldParent.MustValidate()
+	parent := &mapping.Map{
+		Name: "Events",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Items", Type: field.FTListStructs, Mapping: m},
+			{Name: "Last", Type: field.FTStruct, FieldNum: 1, Mapping: m},
+		},
+	}
+	parent.MustValidate()
+
+	event := New(0, old)
+	MustSetNumber(event, 0, int64(5))
+	items, err := NewStructsFromSlice(old, []*Struct{event.Clone()})
+	if err != nil {
+		t.Fatalf("TestDenseFieldsNotInData: NewStructsFromSlice(): %s", err)
+	}
+	events := New(0, oldParent)
+	MustSetListStruct(events, 0, items)
+	lastEvent := New(0, oldParent)
+	MustSetStruct(lastEvent, 1, event.Clone())
+
+	tests := []struct {
+		desc string
+		data *Struct
+		m    *mapping.Map
+		opts UnmarshalOptions
+		// item gets the Event from the decoded Struct.
+		item func(s *Struct) *Struct
+		want int
+	}{
+		{
+			desc: "Struct",
+			data: event,
+			m:    m,
+			item: func(s *Struct) *Struct { return s },
+			want: 48,
+		},
+		{
+			desc: "Struct field",
+			data: lastEvent,
+			m:    parent,
+			item: func(s *Struct) *Struct { return MustGetStruct(s, 1) },
+			want: 56,
+		},
+		{
+			desc: "list item",
+			data: events,
+			m:    parent,
+			item: func(s *Struct) *Struct { return MustGetListStruct(s, 0).Get(0) },
+			want: 64,
+		},
+		{
+			desc: "lazy list item",
+			data: events,
+			m:    parent,
+			opts: UnmarshalOptions{LazyListStructs: true},
+			item: func(s *Struct) *Struct { return MustGetListStruct(s, 0).Get(0) },
+			want: 64,
+		},
+	}
+
+	for _, test := range tests {
+		data := &bytes.Buffer{}
+		if _, err := test.data.Marshal(data); err != nil {
+			t.Fatalf("TestDenseFieldsNotInData(%s): Marshal(): %s", test.desc, err)
+		}
+
+		s, err := NewFromReaderWithOptions(data, test.m, test.opts)
+		if err != nil {
+			t.Errorf("TestDenseFieldsNotInData(%s): NewFromReaderWithOptions(): %s", test.desc, err)
+			continue
+		}
+		item := test.item(s)
+		if _, ok, _ := GetNumberOK[int64](item, 1); ok {
+			t.Errorf("TestDenseFieldsNotInData(%s): GetNumberOK(Timestamp): got ok == true, want false", test.desc)
+		}
+
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Errorf("TestDenseFieldsNotInData(%s): Marshal(): %s", test.desc, err)
+			continue
+		}
+		if buff.Len() != test.want || s.Size() != test.want {
+			t.Errorf("TestDenseFieldsNotInData(%s): got %d encoded bytes and Size() %d, want %d", test.desc, buff.Len(), s.Size(), test.want)
+		}
+		got, err := NewFromReader(buff, test.m)
+		if err != nil {
+			t.Errorf("TestDenseFieldsNotInData(%s): NewFromReader(): %s", test.desc, err)
+			continue
+		}
+		if v := MustGetNumber[int64](test.item(got), 0); v != 5 {
+			t.Errorf("TestDenseFieldsNotInData(%s): got Count %d, want 5", test.desc, v)
+		}
+	}
+}
+
+// BenchmarkMarshal shows that Marshal() does not allocate buffers of its own, what is allocated is the
+// output. Reusing the output buffer, with MarshalOptions.Scratch or MarshalInto(), avoids that.
+func BenchmarkMarshal(b *testing.B) {
+	sub := &mapping.Map{
+		Name: "Container",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Name", Type: field.FTString},
+			{Name: "Port", Type: field.FTInt64, FieldNum: 1},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Pod",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Main", Type: field.FTStruct, Mapping: sub},
+			{Name: "Sidecars", Type: field.FTListStructs, FieldNum: 1, Mapping: sub},
+			{Name: "Ports", Type: field.FTListInt32, FieldNum: 2},
+			{Name: "Labels", Type: field.FTListStrings, FieldNum: 3},
+		},
+	}
+	m.MustValidate()
+
+	newContainer := func(name string, port int64) *Struct {
+		c := New(0, sub)
+		MustSetBytes(c, 0, []byte(name), false)
+		MustSetNumber(c, 1, port)
+		return c
+	}
+	s := New(0, m)
+	MustSetStruct(s, 0, newContainer("app", 8080))
+	for i := 0; i < 5; i++ {
+		MustAppendListStruct(s, 1, newContainer("sidecar", int64(9000+i)))
+	}
+	MustSetListNumberSlice(s, 2, []int32{80, 443})
+	labels := NewBytes()
+	labels.Append([]byte("app=web"), []byte("tier=frontend"))
+	MustSetListBytes(s, 3, labels)
+
+	b.Run("new buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buff := &bytes.Buffer{}
+			if _, err := s.Marshal(buff); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("scratch pool", func(b *testing.B) {
+		b.ReportAllocs()
+		opts := MarshalOptions{Scratch: &sync.Pool{}}
+		for i := 0; i < b.N; i++ {
+			if _, err := s.MarshalWithOptions(io.Discard, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reused buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		buff := make([]byte, s.Size())
+		for i := 0; i < b.N; i++ {
+			if _, err := s.MarshalInto(buff); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// FuzzSizeMatchesMarshal runs the operations described by the fuzz input on a Struct and checks after
+// each one that Size(), which Set and Delete calls keep up to date, is what Marshal() writes.
+func FuzzSizeMatchesMarshal(f *testing.F) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+			{Name: "Int8", Type: field.FTInt8, FieldNum: 1},
+			{Name: "Int64", Type: field.FTInt64, FieldNum: 2},
+			{Name: "Float64", Type: field.FTFloat64, FieldNum: 3},
+			{Name: "String", Type: field.FTString, FieldNum: 4},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 5},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 6, Mapping: sub},
+			{Name: "Bools", Type: field.FTListBools, FieldNum: 7},
+			{Name: "Int16s", Type: field.FTListInt16, FieldNum: 8},
+			{Name: "Strings", Type: field.FTListStrings, FieldNum: 9},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 10, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0, 1, 1, 0, 2, 0, 3, 5, 3, 2})
+	f.Add([]byte{0, 4, 3, 5, 8, 2, 5, 10, 9, 5, 10, 0, 7, 9, 10, 1, 3, 10, 6, 5})
+	f.Add([]byte{0, 1, 2, 7, 1, 0, 9, 5, 7, 4, 3, 6, 3, 7, 6, 9})
+	f.Add([]byte("07700900870")) // Changes an item in a list of Structs, then clears the list.
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		next := func() int {
+			if len(ops) == 0 {
+				return 0
+			}
+			b := ops[0]
+			ops = ops[1:]
+			return int(b)
+		}
+		newSub := func() *Struct {
+			item := New(0, sub)
+			if n := next(); n%2 == 1 {
+				MustSetNumber(item, 0, int32(n))
+			}
+			if n := next(); n%3 == 1 {
+				MustSetBytes(item, 1, bytes.Repeat([]byte("s"), n%13), true)
+			}
+			return item
+		}
+
+		s := New(0, m)
+		if next()%2 == 1 {
+			s.XXXSetNoZeroTypeCompression()
+		}
+
+		for step := 0; len(ops) > 0; step++ {
+			op := next() % 10
+			switch op {
+			case 0: // Set a scalar, which can be the zero value.
+				v := next()
+				switch next() % 4 {
+				case 0:
+					MustSetBool(s, 0, v%2 == 1)
+				case 1:
+					MustSetNumber(s, 1, int8(v%3))
+				case 2:
+					MustSetNumber(s, 2, int64(v%3))
+				case 3:
+					MustSetNumber(s, 3, float64(v%3))
+				}
+			case 1: // Set a string or bytes, which can be empty.
+				MustSetBytes(s, uint16(4+next()%2), bytes.Repeat([]byte("x"), next()%20), false)
+			case 2:
+				DeleteField(s, uint16(next()%11))
+			case 3:
+				MustSetStruct(s, 6, newSub())
+			case 4: // Change the Struct held in a field after it was set.
+				if item := MustGetStruct(s, 6); item != nil {
+					MustSetBytes(item, 1, bytes.Repeat([]byte("y"), next()%20), true)
+				}
+			case 5: // Add to a list.
+				switch next() % 4 {
+				case 0:
+					MustSetListBoolSlice(s, 7, append(listBools(s), next()%2 == 1))
+				case 1:
+					MustSetListNumberSlice(s, 8, append(listInt16s(s), int16(next())))
+				case 2:
+					l := MustGetListBytes(s, 9)
+					if l == nil {
+						l = NewBytes()
+						MustSetListBytes(s, 9, l)
+					}
+					l.Append(bytes.Repeat([]byte("z"), next()%10))
+				case 3:
+					MustAppendListStruct(s, 10, newSub())
+				}
+			case 6:
+				if err := ClearList(s, uint16(7+next()%4)); err != nil {
+					t.Fatalf("step %d: ClearList(): %s", step, err)
+				}
+			case 7: // Change an item in a list of Structs, which is not decoded after a decode.
+				if l := MustGetListStruct(s, 10); l != nil && l.Len() > 0 {
+					item := l.Get(next() % l.Len())
+					MustSetNumber(item, 0, int32(next()+1))
+				}
+			case 8, 9: // Encode and continue with the decoded Struct.
+				buff := &bytes.Buffer{}
+				if _, err := s.Marshal(buff); err != nil {
+					t.Fatalf("step %d: Marshal(): %s", step, err)
+				}
+				d := New(0, m)
+				d.zeroTypeCompression = s.zeroTypeCompression
+				if _, err := d.UnmarshalFrom(buff.Bytes()); err != nil {
+					t.Fatalf("step %d: UnmarshalFrom(): %s", step, err)
+				}
+				s = d
+			}
+
+			buff := &bytes.Buffer{}
+			n, err := s.Marshal(buff)
+			if err != nil {
+				t.Fatalf("step %d(op %d): Marshal(): %s", step, op, err)
+			}
+			if n != s.Size() || buff.Len() != s.Size() {
+				t.Fatalf("step %d(op %d): Size() is %d, but Marshal() wrote %d", step, op, s.Size(), buff.Len())
+			}
+		}
+	})
+}
+
+func listBools(s *Struct) []bool {
+	if l := MustGetListBool(s, 7); l != nil {
+		return l.Slice()
+	}
+	return nil
+}
+
+func listInt16s(s *Struct) []int16 {
+	if l := MustGetListNumber[int16](s, 8); l != nil {
+		return l.Slice()
+	}
+	return nil
+}
+
+func TestFreeze(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: sub},
+			{Name: "Int16s", Type: field.FTListInt16, FieldNum: 3},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 4, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	newTop := func() *Struct {
+		s := New(0, m)
+		MustSetNumber(s, 0, int32(1))
+		MustSetBytes(s, 1, []byte("name"), true)
+		MustSetStruct(s, 2, New(0, sub))
+		MustSetListNumberSlice(s, 3, []int16{1, 2})
+		MustAppendListStruct(s, 4, New(0, sub))
+		return s
+	}
+	s := newTop()
+	want := &bytes.Buffer{}
+	if _, err := s.Marshal(want); err != nil {
+		t.Fatalf("TestFreeze: Marshal(): %s", err)
+	}
+	s.Freeze()
+
+	tests := []struct {
+		desc   string
+		change func() error
+	}{
+		{desc: "SetNumber", change: func() error { return SetNumber(s, 0, int32(2)) }},
+		{desc: "DeleteNumber", change: func() error { return DeleteNumber(s, 0) }},
+		{desc: "SetBytes", change: func() error { return SetBytes(s, 1, []byte("x"), true) }},
+		{desc: "SetStruct", change: func() error { return SetStruct(s, 2, New(0, sub)) }},
+		{desc: "SetNumber on a held Struct", change: func() error { return SetNumber(MustGetStruct(s, 2), 0, int32(2)) }},
+		{desc: "SetNumber on a list item", change: func() error { return SetNumber(MustGetListStruct(s, 4).Get(0), 0, int32(2)) }},
+		{desc: "AppendListStruct", change: func() error { return AppendListStruct(s, 4, New(0, sub)) }},
+		{desc: "ClearList", change: func() error { return ClearList(s, 3) }},
+		{desc: "Merge", change: func() error { return Merge(s, newTop()) }},
+		{desc: "CopyField", change: func() error { return CopyField(s, 0, newTop(), 0) }},
+		{
+			desc: "UnmarshalFrom",
+			change: func() error {
+				e := New(0, m)
+				e.Freeze()
+				_, err := e.UnmarshalFrom(want.Bytes())
+				return err
+			},
+		},
+		{
+			desc: "Numbers.Append",
+			change: func() (err error) {
+				defer func() { err, _ = recover().(error) }()
+				MustGetListNumber[int16](s, 3).Append(3)
+				return nil
+			},
+		},
+		{
+			desc: "Reset",
+			change: func() (err error) {
+				defer func() { err, _ = recover().(error) }()
+				s.Reset()
+				return nil
+			},
+		},
+		{desc: "SetStruct of a frozen Struct", change: func() error { return SetStruct(New(0, m), 2, MustGetStruct(s, 2)) }},
+	}
+
+	for _, test := range tests {
+		if err := test.change(); !errors.Is(err, ErrFrozen) {
+			t.Errorf("TestFreeze(%s): got err == %v, want ErrFrozen", test.desc, err)
+		}
+	}
+
+	got := &bytes.Buffer{}
+	if _, err := s.Marshal(got); err != nil {
+		t.Fatalf("TestFreeze: Marshal() after Freeze(): %s", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("TestFreeze: a frozen Struct was changed")
+	}
+
+	c := s.Clone()
+	if c.Frozen() {
+		t.Errorf("TestFreeze: Clone() of a frozen Struct is frozen")
+	}
+	if err := SetNumber(c, 0, int32(2)); err != nil {
+		t.Errorf("TestFreeze: SetNumber() on a Clone(): %s", err)
+	}
+}
+
+func TestSetFields(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Name", Type: field.FTString, FieldNum: 1},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 2, Mapping: sub},
+			{Name: "Int16s", Type: field.FTListInt16, FieldNum: 3},
+			{Name: "Subs", Type: field.FTListStructs, FieldNum: 4, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	tests := []struct {
+		desc          string
+		noCompression bool
+		set           func(s *Struct)
+		want          []uint16
+	}{
+		{
+			desc: "nothing set",
+			set:  func(s *Struct) {},
+		},
+		{
+			desc: "every field",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, int32(1))
+				MustSetBytes(s, 1, []byte("name"), true)
+				MustSetStruct(s, 2, New(0, sub))
+				MustSetListNumberSlice(s, 3, []int16{1})
+				MustAppendListStruct(s, 4, New(0, sub))
+			},
+			want: []uint16{0, 1, 2, 3, 4},
+		},
+		{
+			desc: "zero values with zero value compression",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, int32(0))
+				MustSetBytes(s, 1, []byte{}, true)
+				MustSetStruct(s, 2, New(0, sub))
+			},
+			want: []uint16{2},
+		},
+		{
+			desc:          "zero values without zero value compression",
+			noCompression: true,
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, int32(0))
+				MustSetBytes(s, 1, []byte{}, true)
+			},
+			want: []uint16{0, 1},
+		},
+		{
+			desc: "empty list and a grown list",
+			set: func(s *Struct) {
+				MustClearList(s, 3)
+				MustGrowListStruct(s, 4, 10)
+			},
+			want: []uint16{3},
+		},
+		{
+			desc: "deleted field",
+			set: func(s *Struct) {
+				MustSetNumber(s, 0, int32(1))
+				MustSetBytes(s, 1, []byte("name"), true)
+				DeleteField(s, 0)
+			},
+			want: []uint16{1},
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		if test.noCompression {
+			s.XXXSetNoZeroTypeCompression()
+		}
+		test.set(s)
+		if got := SetFields(s); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TestSetFields(%s): got %v, want %v", test.desc, got, test.want)
+		}
+	}
+
+	// Presence comes from the headers, so lists are not encoded and lazy Structs are not decoded.
+	s := New(0, m)
+	MustSetListNumberSlice(s, 3, []int16{1, 2, 3})
+	MustAppendListStruct(s, 4, New(0, sub), New(0, sub))
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		t.Fatalf("TestSetFields: Marshal(): %s", err)
+	}
+	s, err := NewFromReaderWithOptions(bytes.NewReader(buff.Bytes()), m, UnmarshalOptions{LazyListStructs: true})
+	if err != nil {
+		t.Fatalf("TestSetFields: NewFromReaderWithOptions(LazyListStructs): %s", err)
+	}
+	var got []uint16
+	allocs := testing.AllocsPerRun(10, func() { got = SetFields(s) })
+	if !reflect.DeepEqual(got, []uint16{3, 4}) {
+		t.Errorf("TestSetFields(lazy): got %v, want [3 4]", got)
+	}
+	if allocs > 1 {
+		t.Errorf("TestSetFields(lazy): got %v allocs, want only the returned slice", allocs)
+	}
+	if l := MustGetListStruct(s, 4); l.raw == nil {
+		t.Errorf("TestSetFields(lazy): the list of Structs was decoded")
+	}
+}
+
+func TestGetOK(t *testing.T) {
+	sub := &mapping.Map{
+		Name: "Sub",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+		},
+	}
+	m := &mapping.Map{
+		Name: "Top",
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool, Default: true},
+			{Name: "Int32", Type: field.FTInt32, FieldNum: 1, Default: int32(30)},
+			{Name: "Name", Type: field.FTString, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: sub},
+		},
+	}
+	m.MustValidate()
+
+	type result struct {
+		Bool    bool
+		BoolOK  bool
+		Int32   int32
+		Int32OK bool
+		NameOK  bool
+		SubOK   bool
+	}
+
+	tests := []struct {
+		desc          string
+		noCompression bool
+		set           func(s *Struct)
+		want          result
+	}{
+		{
+			desc: "nothing set returns the defaults",
+			set:  func(s *Struct) {},
+			want: result{Bool: true, Int32: 30},
+		},
+		{
+			desc: "every field",
+			set: func(s *Struct) {
+				MustSetBool(s, 0, true)
+				MustSetNumber(s, 1, int32(2))
+				MustSetBytes(s, 2, []byte("name"), true)
+				MustSetStruct(s, 3, New(0, sub))
+			},
+			want: result{Bool: true, BoolOK: true, Int32: 2, Int32OK: true, NameOK: true, SubOK: true},
+		},
+		{
+			desc: "zero values with zero value compression are only set on fields with a default",
+			set: func(s *Struct) {
+				MustSetBool(s, 0, false)
+				MustSetNumber(s, 1, int32(0))
+				MustSetBytes(s, 2, []byte{}, true)
+			},
+			want: result{BoolOK: true, Int32OK: true},
+		},
+		{
+			desc:          "zero values without zero value compression are set",
+			noCompression: true,
+			set: func(s *Struct) {
+				MustSetBool(s, 0, false)
+				MustSetNumber(s, 1, int32(0))
+				MustSetBytes(s, 2, []byte{}, true)
+			},
+			want: result{BoolOK: true, Int32OK: true, NameOK: true},
+		},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		if test.noCompression {
+			s.XXXSetNoZeroTypeCompression()
+		}
+		test.set(s)
+		buff := &bytes.Buffer{}
+		if _, err := s.Marshal(buff); err != nil {
+			t.Fatalf("TestGetOK(%s): Marshal error: %s", test.desc, err)
+		}
+		s = New(0, m)
+		if test.noCompression {
+			s.XXXSetNoZeroTypeCompression()
+		}
+		if _, err := s.unmarshal(buff); err != nil {
+			t.Fatalf("TestGetOK(%s): unmarshal error: %s", test.desc, err)
+		}
+
+		var got result
+		var err error
+		if got.Bool, got.BoolOK, err = GetBoolOK(s, 0); err != nil {
+			t.Fatalf("TestGetOK(%s): GetBoolOK() error: %s", test.desc, err)
+		}
+		if got.Int32, got.Int32OK, err = GetNumberOK[int32](s, 1); err != nil {
+			t.Fatalf("TestGetOK(%s): GetNumberOK() error: %s", test.desc, err)
+		}
+		if _, got.NameOK, err = GetBytesOK(s, 2); err != nil {
+			t.Fatalf("TestGetOK(%s): GetBytesOK() error: %s", test.desc, err)
+		}
+		if _, got.SubOK, err = GetStructOK(s, 3); err != nil {
+			t.Fatalf("TestGetOK(%s): GetStructOK() error: %s", test.desc, err)
+		}
+		if got != test.want {
+			t.Errorf("TestGetOK(%s): got %+v, want %+v", test.desc, got, test.want)
+		}
+	}
+
+	s := New(0, m)
+	if _, _, err := GetNumberOK[int64](s, 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("TestGetOK(wrong type): got err == %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestCheckMapping(t *testing.T) {
+	car := &mapping.Map{
+		Name:   "Car",
+		Fields: []*mapping.FieldDescr{{Name: "Year", Type: field.FTUint16}},
+	}
+	// truck has the same fields as car, but is a different type.
+	truck := &mapping.Map{
+		Name:   "Truck",
+		Fields: []*mapping.FieldDescr{{Name: "Year", Type: field.FTUint16}},
+	}
+
+	tests := []struct {
+		desc string
+		s    *Struct
+		err  error
+	}{
+		{desc: "same mapping", s: New(0, car)},
+		{desc: "Error: nil Struct", s: nil, err: ErrTypeMismatch},
+		{desc: "Error: mapping with the same fields", s: New(0, truck), err: ErrTypeMismatch},
+	}
+
+	for _, test := range tests {
+		err := CheckMapping(test.s, car)
+		switch {
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("TestCheckMapping(%s): got err == %v, want err == %v", test.desc, err, test.err)
+		case test.err == nil && err != nil:
+			t.Errorf("TestCheckMapping(%s): got err == %s, want err == nil", test.desc, err)
+		}
+	}
+}
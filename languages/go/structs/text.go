@@ -0,0 +1,144 @@
+package structs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bearlytools/claw/internal/conversions"
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/reflect/runtime"
+)
+
+// SetFromString parses "text" according to the type of field "fieldNum" and sets the field.
+// This is meant for values that come from config files, environment variables or command line
+// flags.
+//
+// Supported field types are:
+//   - bool: anything strconv.ParseBool() accepts
+//   - integers: base 10, or base 16/8/2 with a 0x/0o/0b prefix. Values are range checked against the field size
+//   - floats: anything strconv.ParseFloat() accepts, range checked for float32
+//   - string and bytes: the text is used as is
+//   - enumerators: the name of the enumerated value or its number
+//
+// Lists and Structs cannot be set from a string.
+func SetFromString(s *Struct, fieldNum uint16, text string) error {
+	idx, err := validateFieldNum(fieldNum, s.mapping)
+	if err != nil {
+		return err
+	}
+	fd := s.mapping.Fields[idx]
+
+	switch fd.Type {
+	case field.FTBool:
+		var v bool
+		v, err = strconv.ParseBool(text)
+		if err == nil {
+			return SetBool(s, fieldNum, v)
+		}
+	case field.FTInt8:
+		var v int64
+		v, err = strconv.ParseInt(text, 0, 8)
+		if err == nil {
+			return SetNumber(s, fieldNum, int8(v))
+		}
+	case field.FTInt16:
+		var v int64
+		v, err = strconv.ParseInt(text, 0, 16)
+		if err == nil {
+			return SetNumber(s, fieldNum, int16(v))
+		}
+	case field.FTInt32:
+		var v int64
+		v, err = strconv.ParseInt(text, 0, 32)
+		if err == nil {
+			return SetNumber(s, fieldNum, int32(v))
+		}
+	case field.FTInt64:
+		var v int64
+		v, err = strconv.ParseInt(text, 0, 64)
+		if err == nil {
+			return SetNumber(s, fieldNum, v)
+		}
+	case field.FTUint8:
+		var v uint64
+		if fd.IsEnum {
+			v, err = enumFromString(fd.FullPath, fd.EnumGroup, text, 8)
+		} else {
+			v, err = strconv.ParseUint(text, 0, 8)
+		}
+		if err == nil {
+			return SetNumber(s, fieldNum, uint8(v))
+		}
+	case field.FTUint16:
+		var v uint64
+		if fd.IsEnum {
+			v, err = enumFromString(fd.FullPath, fd.EnumGroup, text, 16)
+		} else {
+			v, err = strconv.ParseUint(text, 0, 16)
+		}
+		if err == nil {
+			return SetNumber(s, fieldNum, uint16(v))
+		}
+	case field.FTUint32:
+		var v uint64
+		v, err = strconv.ParseUint(text, 0, 32)
+		if err == nil {
+			return SetNumber(s, fieldNum, uint32(v))
+		}
+	case field.FTUint64:
+		var v uint64
+		v, err = strconv.ParseUint(text, 0, 64)
+		if err == nil {
+			return SetNumber(s, fieldNum, v)
+		}
+	case field.FTFloat32:
+		var v float64
+		v, err = strconv.ParseFloat(text, 32)
+		if err == nil {
+			return SetNumber(s, fieldNum, float32(v))
+		}
+	case field.FTFloat64:
+		var v float64
+		v, err = strconv.ParseFloat(text, 64)
+		if err == nil {
+			return SetNumber(s, fieldNum, v)
+		}
+	case field.FTString:
+		return SetBytes(s, fieldNum, conversions.UnsafeGetBytes(text), true)
+	case field.FTBytes:
+		return SetBytes(s, fieldNum, []byte(text), false)
+	default:
+		return fmt.Errorf("field %d(%s) is of type %v, which cannot be set from a string", fieldNum, fd.Name, fd.Type)
+	}
+	return fmt.Errorf("field %d(%s) of type %v could not be set from %q: %w", fieldNum, fd.Name, fd.Type, text, err)
+}
+
+// enumFromString converts text, which is either the name of an enumerated value or its number,
+// into the enumerated number. pkgPath and group locate the EnumGroup in the runtime registry.
+func enumFromString(pkgPath, group, text string, size int) (uint64, error) {
+	if v, err := strconv.ParseUint(text, 0, size); err == nil {
+		return v, nil
+	}
+
+	pkg := runtime.PackageDescr(pkgPath)
+	if pkg == nil {
+		return 0, fmt.Errorf("package %q that holds the enum is not registered", pkgPath)
+	}
+	// EnumGroup is in the form "Name" or "pkg.Name" if the enum was defined in another package.
+	if i := strings.LastIndex(group, "."); i != -1 {
+		group = group[i+1:]
+	}
+	if pkg.Enums() == nil {
+		return 0, fmt.Errorf("package %q does not have any enums", pkgPath)
+	}
+	eg := pkg.Enums().ByName(group)
+	if eg == nil {
+		return 0, fmt.Errorf("package %q does not have enum %q", pkgPath, group)
+	}
+	e := eg.ByName(text)
+	if e == nil {
+		return 0, fmt.Errorf("enum %q does not have a value named %q", group, text)
+	}
+	return uint64(e.Number()), nil
+}
@@ -0,0 +1,79 @@
+package structs
+
+import (
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func TestSetFromString(t *testing.T) {
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+			{Name: "Int8", Type: field.FTInt8, FieldNum: 1},
+			{Name: "Uint16", Type: field.FTUint16, FieldNum: 2},
+			{Name: "Int64", Type: field.FTInt64, FieldNum: 3},
+			{Name: "Float32", Type: field.FTFloat32, FieldNum: 4},
+			{Name: "String", Type: field.FTString, FieldNum: 5},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 6},
+			{Name: "ListBools", Type: field.FTListBools, FieldNum: 7},
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		fieldNum uint16
+		text     string
+		want     any
+		err      bool
+	}{
+		{desc: "Success: bool", fieldNum: 0, text: "true", want: true},
+		{desc: "Error: bool", fieldNum: 0, text: "yes", err: true},
+		{desc: "Success: int8", fieldNum: 1, text: "-128", want: int8(-128)},
+		{desc: "Error: int8 out of range", fieldNum: 1, text: "128", err: true},
+		{desc: "Success: uint16 hex", fieldNum: 2, text: "0xffff", want: uint16(65535)},
+		{desc: "Error: uint16 negative", fieldNum: 2, text: "-1", err: true},
+		{desc: "Success: int64", fieldNum: 3, text: "-9223372036854775808", want: int64(-9223372036854775808)},
+		{desc: "Success: float32", fieldNum: 4, text: "1.5", want: float32(1.5)},
+		{desc: "Error: float32 out of range", fieldNum: 4, text: "1e39", err: true},
+		{desc: "Success: string", fieldNum: 5, text: "hello", want: "hello"},
+		{desc: "Success: bytes", fieldNum: 6, text: "world", want: "world"},
+		{desc: "Error: list", fieldNum: 7, text: "true", err: true},
+		{desc: "Error: bad field number", fieldNum: 8, text: "true", err: true},
+	}
+
+	for _, test := range tests {
+		s := New(0, m)
+		err := SetFromString(s, test.fieldNum, test.text)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestSetFromString(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.err:
+			t.Errorf("TestSetFromString(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		var got any
+		switch test.want.(type) {
+		case bool:
+			got = MustGetBool(s, test.fieldNum)
+		case int8:
+			got = MustGetNumber[int8](s, test.fieldNum)
+		case uint16:
+			got = MustGetNumber[uint16](s, test.fieldNum)
+		case int64:
+			got = MustGetNumber[int64](s, test.fieldNum)
+		case float32:
+			got = MustGetNumber[float32](s, test.fieldNum)
+		case string:
+			got = string(*MustGetBytes(s, test.fieldNum))
+		}
+		if got != test.want {
+			t.Errorf("TestSetFromString(%s): got %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
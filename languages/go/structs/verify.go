@@ -0,0 +1,188 @@
+package structs
+
+import (
+	"fmt"
+
+	"github.com/bearlytools/claw/internal/binary"
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+// Verify checks that data holds exactly one well formed encoded Struct described by m.
+// This walks every header, checking that sizes are within bounds, field numbers are in order
+// and that field types match the mapping, recursing into nested Structs. Nothing is decoded
+// and no memory is allocated unless an error is returned.
+//
+// Fields with numbers beyond what m describes or that m has reserved are treated as unknown
+// fields (which the decoder keeps but does not expose), so only their sizes are checked.
+//
+// If data starts with a schema hash (see MarshalOptions.EmbedSchemaHash), it must match m or an
+// ErrSchemaMismatch is returned.
+//
+// This is useful for rejecting bad input at a trust boundary before paying for a decode. Errors
+// about the data are always an ErrCorruptData.
+func Verify(data []byte, m *mapping.Map) error {
+	if m == nil {
+		return fmt.Errorf("Verify() cannot be passed a nil *mapping.Map")
+	}
+	if len(data) >= 8 && GenericHeader(data[:8]).FieldType() == field.FTSchemaHash {
+		if len(data) < 16 || GenericHeader(data[:8]).Final40() != 16 {
+			return fmt.Errorf("%w: schema hash preamble is malformed", ErrCorruptData)
+		}
+		if got, want := binary.Get[uint64](data[8:16]), m.SchemaHash(); got != want {
+			return fmt.Errorf("%w: data has schema hash %x, but %s has schema hash %x", ErrSchemaMismatch, got, m.Name, want)
+		}
+		data = data[16:]
+	}
+	n, err := verifyStruct(data, m)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptData, err)
+	}
+	if n != len(data) {
+		return fmt.Errorf("%w: Struct had size %d, but data had %d bytes", ErrCorruptData, n, len(data))
+	}
+	return nil
+}
+
+// verifyStruct verifies the Struct at the start of data and returns its size. If m == nil,
+// only the wire encoding is checked.
+func verifyStruct(data []byte, m *mapping.Map) (int, error) {
+	if len(data) < 8 {
+		return 0, fmt.Errorf("Struct header must be 8 bytes, had %d", len(data))
+	}
+	h := GenericHeader(data[:8])
+	if h.FieldType() != field.FTStruct {
+		return 0, fmt.Errorf("expecting Struct, got %v", h.FieldType())
+	}
+	size := h.Final40()
+	if size < 8 || size%8 != 0 {
+		return 0, fmt.Errorf("Struct malformed: must have a size divisible by 8, was %d", size)
+	}
+	if size > uint64(len(data)) {
+		return 0, fmt.Errorf("Struct has size %d, but only %d bytes remain", size, len(data))
+	}
+
+	buffer := data[8:size]
+	offset := 8
+	lastNum := -1
+	for len(buffer) > 0 {
+		if len(buffer) < 8 {
+			return 0, fmt.Errorf("field at offset %d: not enough room for a field header", offset)
+		}
+		fh := GenericHeader(buffer[:8])
+		fieldNum := int(fh.FieldNum())
+		if fieldNum <= lastNum {
+			return 0, fmt.Errorf("field at offset %d: field %d came after field %d", offset, fieldNum, lastNum)
+		}
+		lastNum = fieldNum
+
+		var sub *mapping.Map
+		var fd *mapping.FieldDescr
+		if m != nil {
+			fd = m.FieldByNumber(uint16(fieldNum))
+		}
+		if fd != nil {
+			if !wireTypeMatches(fh.FieldType(), fd.Type) {
+				return 0, fmt.Errorf("field %d at offset %d: has wire type %v, but mapping says %v", fieldNum, offset, fh.FieldType(), fd.Type)
+			}
+			if fd.Type == field.FTFixedBytes && fh.Final40() != uint64(fd.Size) {
+				return 0, fmt.Errorf("field %d at offset %d: FixedBytes field has %d bytes, but mapping says %d", fieldNum, offset, fh.Final40(), fd.Size)
+			}
+			sub = fd.Mapping
+			if fd.SelfReferential {
+				sub = m
+			}
+		}
+
+		n, err := verifyField(buffer, sub)
+		if err != nil {
+			return 0, fmt.Errorf("field %d at offset %d: %w", fieldNum, offset, err)
+		}
+		buffer = buffer[n:]
+		offset += n
+	}
+	return int(size), nil
+}
+
+// verifyField verifies the field at the start of data and returns its encoded size. m is only
+// used for Struct and ListStructs fields and may be nil.
+func verifyField(data []byte, m *mapping.Map) (int, error) {
+	h := GenericHeader(data[:8])
+	final40 := h.Final40()
+	size := 0
+
+	switch h.FieldType() {
+	case field.FTBool, field.FTInt8, field.FTInt16, field.FTInt32, field.FTUint8,
+		field.FTUint16, field.FTUint32, field.FTFloat32:
+		size = 8
+	case field.FTInt64, field.FTUint64, field.FTFloat64:
+		size = 16
+	case field.FTString, field.FTBytes, field.FTFixedBytes:
+		size = 8 + int(SizeWithPadding(final40))
+	case field.FTStruct:
+		return verifyStruct(data, m)
+	case field.FTListBools:
+		size = 8 + 8*int((final40+63)/64) // A list with zero items is only a header.
+	case field.FTListInt8, field.FTListUint8, field.FTListInt16, field.FTListUint16,
+		field.FTListInt32, field.FTListUint32, field.FTListFloat32,
+		field.FTListInt64, field.FTListUint64, field.FTListFloat64:
+		if final40 > uint64(len(data)) { // Every item is at least a byte.
+			return 0, fmt.Errorf("list of numbers has %d items, but only %d bytes remain", final40, len(data))
+		}
+		size = 8 + wordsRequiredToStore(int(final40), numberListItemSize(h.FieldType()))*8
+	case field.FTListBytes, field.FTListStrings:
+		read := 8 // A list of bytes may be empty, which is only a header.
+		for i := uint64(0); i < final40; i++ {
+			if len(data)-read < 4 {
+				return 0, fmt.Errorf("list of bytes item %d did not have a valid header", i)
+			}
+			read += 4 + int(binary.Get[uint32](data[read:read+4]))
+			if read > len(data) {
+				return 0, fmt.Errorf("list of bytes item %d did not have enough data to match the header", i)
+			}
+		}
+		size = SizeWithPadding(read)
+	case field.FTListStructs:
+		read := 8 // A list of structs may be empty, which is only a header.
+		for i := uint64(0); i < final40; i++ {
+			n, err := verifyStruct(data[read:], m)
+			if err != nil {
+				return 0, fmt.Errorf("list of structs item %d: %w", i, err)
+			}
+			read += n
+		}
+		size = read
+	default:
+		return 0, fmt.Errorf("got field type %v that we don't support", h.FieldType())
+	}
+
+	if size > len(data) {
+		return 0, fmt.Errorf("field of type %v needs %d bytes, but only %d remain", h.FieldType(), size, len(data))
+	}
+	return size, nil
+}
+
+// numberListItemSize returns the size in bytes of each item in a list of numbers of type ft.
+func numberListItemSize(ft field.Type) int {
+	switch ft {
+	case field.FTListInt8, field.FTListUint8:
+		return 1
+	case field.FTListInt16, field.FTListUint16:
+		return 2
+	case field.FTListInt32, field.FTListUint32, field.FTListFloat32:
+		return 4
+	}
+	return 8
+}
+
+// wireTypeMatches returns true if a field with type "wire" can be decoded into a field with type "want".
+// String and Bytes share an encoding, so do lists of them.
+func wireTypeMatches(wire, want field.Type) bool {
+	switch want {
+	case field.FTString, field.FTBytes:
+		return wire == field.FTString || wire == field.FTBytes
+	case field.FTListStrings, field.FTListBytes:
+		return wire == field.FTListStrings || wire == field.FTListBytes
+	}
+	return wire == want
+}
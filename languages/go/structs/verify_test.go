@@ -0,0 +1,140 @@
+package structs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/field"
+	"github.com/bearlytools/claw/languages/go/mapping"
+)
+
+func verifyTestData() (*mapping.Map, []byte) {
+	subMapping := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Bool", Type: field.FTBool},
+		},
+	}
+	m := &mapping.Map{
+		Fields: []*mapping.FieldDescr{
+			{Name: "Int32", Type: field.FTInt32},
+			{Name: "Uint64", Type: field.FTUint64, FieldNum: 1},
+			{Name: "Bytes", Type: field.FTBytes, FieldNum: 2},
+			{Name: "Sub", Type: field.FTStruct, FieldNum: 3, Mapping: subMapping},
+			{Name: "ListBools", Type: field.FTListBools, FieldNum: 4},
+			{Name: "ListUint16", Type: field.FTListUint16, FieldNum: 5},
+			{Name: "ListBytes", Type: field.FTListBytes, FieldNum: 6},
+			{Name: "ListStructs", Type: field.FTListStructs, FieldNum: 7, Mapping: subMapping},
+		},
+	}
+	m.MustValidate()
+
+	s := New(0, m)
+	s.XXXSetNoZeroTypeCompression()
+	MustSetNumber(s, 0, int32(-3))
+	MustSetNumber(s, 1, uint64(1<<40))
+	MustSetBytes(s, 2, []byte("hello"), false)
+	sub := New(0, subMapping)
+	MustSetBool(sub, 0, true)
+	MustSetStruct(s, 3, sub)
+
+	bools := NewBools(4)
+	MustSetListBool(s, 4, bools)
+	bools.Append(true, false, true)
+
+	nums := NewNumbers[uint16]()
+	nums.Append(1, 2, 3, 4, 5)
+	MustSetListNumber(s, 5, nums)
+
+	lb := NewBytes()
+	MustSetListBytes(s, 6, lb)
+	lb.Append([]byte("what"), []byte("ever"))
+
+	MustAppendListStruct(s, 7, New(0, subMapping), New(0, subMapping))
+
+	buff := &bytes.Buffer{}
+	if _, err := s.Marshal(buff); err != nil {
+		panic(err)
+	}
+	return m, buff.Bytes()
+}
+
+func TestVerify(t *testing.T) {
+	m, data := verifyTestData()
+
+	// Verify must never allow something the decoder will reject.
+	if _, err := NewFromReader(bytes.NewReader(data), m); err != nil {
+		t.Fatalf("TestVerify: test data could not be decoded: %s", err)
+	}
+
+	clone := func(f func(b []byte)) []byte {
+		b := make([]byte, len(data))
+		copy(b, data)
+		f(b)
+		return b
+	}
+
+	tests := []struct {
+		desc string
+		data []byte
+		err  bool
+	}{
+		{desc: "Success", data: data},
+		{desc: "Error: empty", data: nil, err: true},
+		{desc: "Error: truncated", data: data[:len(data)-8], err: true},
+		{desc: "Error: trailing data", data: append(clone(func([]byte) {}), make([]byte, 8)...), err: true},
+		{
+			desc: "Error: top level isn't a Struct",
+			data: clone(func(b []byte) { GenericHeader(b[:8]).SetFieldType(field.FTBytes) }),
+			err:  true,
+		},
+		{
+			desc: "Error: Struct size larger than data",
+			data: clone(func(b []byte) { GenericHeader(b[:8]).SetFinal40(uint64(len(b) + 8)) }),
+			err:  true,
+		},
+		{
+			desc: "Error: field type does not match mapping",
+			data: clone(func(b []byte) { GenericHeader(b[8:16]).SetFieldType(field.FTUint32) }),
+			err:  true,
+		},
+		{
+			desc: "Error: fields out of order",
+			data: clone(func(b []byte) { GenericHeader(b[16:24]).SetFieldNum(0) }),
+			err:  true,
+		},
+		{
+			desc: "Error: bytes size runs past Struct",
+			data: clone(func(b []byte) { GenericHeader(b[32:40]).SetFinal40(1 << 20) }),
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		err := Verify(test.data, m)
+		switch {
+		case err == nil && test.err:
+			t.Errorf("TestVerify(%s): got err == nil, want err != nil", test.desc)
+		case err != nil && !test.err:
+			t.Errorf("TestVerify(%s): got err == %s, want err == nil", test.desc, err)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := Verify(data, m); err != nil {
+			panic(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("TestVerify: got %v allocations, want 0", allocs)
+	}
+}
+
+func TestVerifyTruncations(t *testing.T) {
+	m, data := verifyTestData()
+
+	for i := 0; i < len(data); i++ {
+		if err := Verify(data[:i], m); err == nil {
+			t.Errorf("TestVerifyTruncations: data truncated to %d bytes: got err == nil, want err != nil", i)
+		}
+	}
+}
@@ -19,6 +19,17 @@ type Number interface {
 	constraints.Integer | constraints.Float
 }
 
+// List is implemented by every list type in this package. It is the lowest common denominator
+// of the lists and allows writing code that works on any list, see structs.List.
+type List = structs.List
+
+var (
+	_ List = Bools{}
+	_ List = Numbers[uint8]{}
+	_ List = &Bytes{}
+	_ List = Strings{}
+)
+
 // Bools is a wrapper around a list of boolean values.
 type Bools struct {
 	b *structs.Bools
@@ -44,11 +55,22 @@ func (b Bools) Len() int {
 	return b.b.Len()
 }
 
-// Get gets a value in the list[pos].
+// Get gets a value in the list[pos]. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
 func (b Bools) Get(index int) bool {
 	return b.b.Get(index)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b Bools) TryGet(index int) (value bool, ok bool) {
+	return b.b.TryGet(index)
+}
+
+// Any implements List.Any().
+func (b Bools) Any(index int) any {
+	return b.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak.
@@ -100,11 +122,22 @@ func (n Numbers[N]) Len() int {
 	return n.n.Len()
 }
 
-// Get gets a number stored at the index.
+// Get gets a number stored at the index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
 func (n Numbers[N]) Get(index int) N {
 	return n.n.Get(index)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (n Numbers[N]) TryGet(index int) (value N, ok bool) {
+	return n.n.TryGet(index)
+}
+
+// Any implements List.Any().
+func (n Numbers[N]) Any(index int) any {
+	return n.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak.
@@ -124,6 +157,13 @@ func (n Numbers[N]) Append(i ...N) Numbers[N] {
 	return n
 }
 
+// Grow makes sure the list has room for "items" more values, so that appending them doesn't
+// need to reallocate. This is like slices.Grow().
+func (n Numbers[N]) Grow(items int) Numbers[N] {
+	n.n.Grow(items)
+	return n
+}
+
 // Slice converts this into a standard []I, where I is a number value. The values aren't linked, so changing
 // []I or calling n.Set(...) will have no affect on the other. If there are no
 // entries, this returns a nil slice.
@@ -131,6 +171,30 @@ func (n Numbers[N]) Slice() []N {
 	return n.n.Slice()
 }
 
+// SliceView returns the values without copying them. The slice must be treated as read-only and
+// is only valid until the list is changed. See structs.Numbers.SliceView().
+func (n Numbers[N]) SliceView() []N {
+	return n.n.SliceView()
+}
+
+// Min returns the smallest value in the list, or 0 if the list is empty.
+// NaN values are ignored unless every value is NaN.
+func (n Numbers[N]) Min() N {
+	return n.n.Min()
+}
+
+// Max returns the largest value in the list, or 0 if the list is empty.
+// NaN values are ignored unless every value is NaN.
+func (n Numbers[N]) Max() N {
+	return n.n.Max()
+}
+
+// Sum returns the sum of all values in the list, or 0 if the list is empty.
+// Integers wrap around on overflow the same as Go's + operator.
+func (n Numbers[N]) Sum() N {
+	return n.n.Sum()
+}
+
 // Bytes represents a list of bytes.
 type Bytes struct {
 	b *structs.Bytes
@@ -161,11 +225,22 @@ func (b *Bytes) Len() int {
 	return b.b.Len()
 }
 
-// Get gets a []byte stored at the index.
+// Get gets a []byte stored at the index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
 func (b *Bytes) Get(index int) []byte {
 	return b.b.Get(index)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b *Bytes) TryGet(index int) (value []byte, ok bool) {
+	return b.b.TryGet(index)
+}
+
+// Any implements List.Any().
+func (b *Bytes) Any(index int) any {
+	return b.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak. You should NOT modify the returned []byte slice.
@@ -179,9 +254,18 @@ func (b *Bytes) Set(index int, value []byte) *Bytes {
 	return b
 }
 
-// Append appends values to the list of []byte.
+// Append appends values to the list of []byte. This panics if a value is 4GiB or larger.
 func (b *Bytes) Append(values ...[]byte) *Bytes {
-	b.b.Append()
+	if err := b.b.Append(values...); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Grow makes sure the list has room for "items" more values, so that appending them doesn't
+// need to reallocate. This is like slices.Grow().
+func (b *Bytes) Grow(items int) *Bytes {
+	b.b.Grow(items)
 	return b
 }
 
@@ -192,6 +276,12 @@ func (b *Bytes) Slice() [][]byte {
 	return b.b.Slice()
 }
 
+// RawData returns the entries in their encoded form without copying when possible.
+// See structs.Bytes.RawData().
+func (b *Bytes) RawData() []byte {
+	return b.b.RawData()
+}
+
 // String represents a list of strings.
 type Strings struct {
 	b *structs.Bytes
@@ -222,7 +312,8 @@ func (s Strings) Len() int {
 	return s.b.Len()
 }
 
-// Get gets a string stored at the index.
+// Get gets a string stored at the index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
 func (s Strings) Get(index int) string {
 	b := s.b.Get(index)
 	if b == nil {
@@ -231,6 +322,19 @@ func (s Strings) Get(index int) string {
 	return conversions.ByteSlice2String(b)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (s Strings) TryGet(index int) (value string, ok bool) {
+	if index < 0 || index >= s.Len() {
+		return "", false
+	}
+	return s.Get(index), true
+}
+
+// Any implements List.Any().
+func (s Strings) Any(index int) any {
+	return s.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak. You should NOT modify the returned []byte slice.
@@ -262,7 +366,16 @@ func (s Strings) Append(values ...string) Strings {
 	for i, v := range values {
 		x[i] = conversions.UnsafeGetBytes(v)
 	}
-	s.b.Append(x...)
+	if err := s.b.Append(x...); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Grow makes sure the list has room for "items" more values, so that appending them doesn't
+// need to reallocate. This is like slices.Grow().
+func (s Strings) Grow(items int) Strings {
+	s.b.Grow(items)
 	return s
 }
 
@@ -314,11 +427,22 @@ func (n Enums[E]) Len() int {
 	return n.n.Len()
 }
 
-// Get gets a number stored at the index.
+// Get gets a number stored at the index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
 func (n Enums[E]) Get(index int) E {
 	return n.n.Get(index)
 }
 
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (n Enums[E]) TryGet(index int) (value E, ok bool) {
+	return n.n.TryGet(index)
+}
+
+// Any implements List.Any().
+func (n Enums[E]) Any(index int) any {
+	return n.Get(index)
+}
+
 // Range ranges from "from" (inclusive) to "to" (exclusive). You must read values from
 // Range until the returned channel closes or cancel the Context passed. Otherwise
 // you will have a goroutine leak.
@@ -338,6 +462,13 @@ func (n Enums[E]) Append(i ...E) Enums[E] {
 	return n
 }
 
+// Grow makes sure the list has room for "items" more values, so that appending them doesn't
+// need to reallocate. This is like slices.Grow().
+func (n Enums[E]) Grow(items int) Enums[E] {
+	n.n.Grow(items)
+	return n
+}
+
 // Slice converts this into a standard []I, where I is a Enum. The values aren't linked, so changing
 // []I or calling n.Set(...) will have no affect on the other. If there are no
 // entries, this returns a nil slice.
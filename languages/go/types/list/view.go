@@ -0,0 +1,308 @@
+package list
+
+import (
+	"context"
+
+	"github.com/bearlytools/claw/internal/conversions"
+	"github.com/bearlytools/claw/languages/go/structs"
+)
+
+// The views in this file are the lists returned by the getters of generated <Name>View types. They
+// have the read methods of the list they wrap, but no methods that change it. A view of a list
+// that is not set is empty.
+
+var (
+	_ List = BoolsView{}
+	_ List = NumbersView[uint8]{}
+	_ List = BytesView{}
+	_ List = StringsView{}
+)
+
+// closed returns a closed channel, which is what Range() returns for a list that is not set.
+func closed[T any]() chan T {
+	ch := make(chan T)
+	close(ch)
+	return ch
+}
+
+// BoolsView is a list of bools that can only be read.
+type BoolsView struct {
+	b *structs.Bools
+}
+
+// View returns a BoolsView of b. This does not copy b, so changes made to b are seen through it.
+func (b Bools) View() BoolsView {
+	return BoolsView{b: b.b}
+}
+
+// Len returns the number of items in the list.
+func (b BoolsView) Len() int {
+	if b.b == nil {
+		return 0
+	}
+	return b.b.Len()
+}
+
+// Get gets the value at index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
+func (b BoolsView) Get(index int) bool {
+	return b.b.Get(index)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b BoolsView) TryGet(index int) (value bool, ok bool) {
+	if b.b == nil {
+		return false, false
+	}
+	return b.b.TryGet(index)
+}
+
+// Any implements List.Any().
+func (b BoolsView) Any(index int) any {
+	return b.Get(index)
+}
+
+// Range is the same as Bools.Range().
+func (b BoolsView) Range(ctx context.Context, from, to int) chan bool {
+	if b.b == nil {
+		return closed[bool]()
+	}
+	return b.b.Range(ctx, from, to)
+}
+
+// Slice returns a copy of the values as a []bool. If there are no entries, this returns a nil slice.
+func (b BoolsView) Slice() []bool {
+	if b.b == nil {
+		return nil
+	}
+	return b.b.Slice()
+}
+
+// NumbersView is a list of numbers that can only be read.
+type NumbersView[N Number] struct {
+	n *structs.Numbers[N]
+}
+
+// View returns a NumbersView of n. This does not copy n, so changes made to n are seen through it.
+func (n Numbers[N]) View() NumbersView[N] {
+	return NumbersView[N]{n: n.n}
+}
+
+// Len returns the number of items in the list.
+func (n NumbersView[N]) Len() int {
+	if n.n == nil {
+		return 0
+	}
+	return n.n.Len()
+}
+
+// Get gets the number at index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
+func (n NumbersView[N]) Get(index int) N {
+	return n.n.Get(index)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (n NumbersView[N]) TryGet(index int) (value N, ok bool) {
+	if n.n == nil {
+		return 0, false
+	}
+	return n.n.TryGet(index)
+}
+
+// Any implements List.Any().
+func (n NumbersView[N]) Any(index int) any {
+	return n.Get(index)
+}
+
+// Range is the same as Numbers.Range().
+func (n NumbersView[N]) Range(ctx context.Context, from, to int) chan N {
+	if n.n == nil {
+		return closed[N]()
+	}
+	return n.n.Range(ctx, from, to)
+}
+
+// Slice returns a copy of the values as a []N. If there are no entries, this returns a nil slice.
+func (n NumbersView[N]) Slice() []N {
+	if n.n == nil {
+		return nil
+	}
+	return n.n.Slice()
+}
+
+// Min is the same as Numbers.Min().
+func (n NumbersView[N]) Min() N {
+	if n.n == nil {
+		return 0
+	}
+	return n.n.Min()
+}
+
+// Max is the same as Numbers.Max().
+func (n NumbersView[N]) Max() N {
+	if n.n == nil {
+		return 0
+	}
+	return n.n.Max()
+}
+
+// Sum is the same as Numbers.Sum().
+func (n NumbersView[N]) Sum() N {
+	if n.n == nil {
+		return 0
+	}
+	return n.n.Sum()
+}
+
+// EnumsView is a list of enums that can only be read.
+type EnumsView[E Enum] struct {
+	n *structs.Numbers[E]
+}
+
+// View returns an EnumsView of n. This does not copy n, so changes made to n are seen through it.
+func (n Enums[E]) View() EnumsView[E] {
+	return EnumsView[E]{n: n.n}
+}
+
+// Len returns the number of items in the list.
+func (n EnumsView[E]) Len() int {
+	if n.n == nil {
+		return 0
+	}
+	return n.n.Len()
+}
+
+// Get gets the enum at index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
+func (n EnumsView[E]) Get(index int) E {
+	return n.n.Get(index)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (n EnumsView[E]) TryGet(index int) (value E, ok bool) {
+	if n.n == nil {
+		return 0, false
+	}
+	return n.n.TryGet(index)
+}
+
+// Any implements List.Any().
+func (n EnumsView[E]) Any(index int) any {
+	return n.Get(index)
+}
+
+// Range is the same as Enums.Range().
+func (n EnumsView[E]) Range(ctx context.Context, from, to int) chan E {
+	if n.n == nil {
+		return closed[E]()
+	}
+	return n.n.Range(ctx, from, to)
+}
+
+// Slice returns a copy of the values as a []E. If there are no entries, this returns a nil slice.
+func (n EnumsView[E]) Slice() []E {
+	if n.n == nil {
+		return nil
+	}
+	return n.n.Slice()
+}
+
+// BytesView is a list of []byte that can only be read. The []byte values it returns are copies,
+// so the list can't be changed through them.
+type BytesView struct {
+	b *structs.Bytes
+}
+
+// View returns a BytesView of b. This does not copy b, so changes made to b are seen through it.
+func (b Bytes) View() BytesView {
+	return BytesView{b: b.b}
+}
+
+// Len returns the number of items in the list.
+func (b BytesView) Len() int {
+	if b.b == nil {
+		return 0
+	}
+	return b.b.Len()
+}
+
+// Get returns a copy of the []byte at index. An empty item returns nil. This panics if the index
+// is out of range, use TryGet() if the index may not be valid.
+func (b BytesView) Get(index int) []byte {
+	v := b.b.Get(index)
+	if v == nil {
+		return nil
+	}
+	return append([]byte(nil), v...)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (b BytesView) TryGet(index int) (value []byte, ok bool) {
+	if index < 0 || index >= b.Len() {
+		return nil, false
+	}
+	return b.Get(index), true
+}
+
+// Any implements List.Any().
+func (b BytesView) Any(index int) any {
+	return b.Get(index)
+}
+
+// Slice returns a copy of the values as a [][]byte. If there are no entries, this returns a nil slice.
+func (b BytesView) Slice() [][]byte {
+	if b.b == nil {
+		return nil
+	}
+	return b.b.Slice()
+}
+
+// StringsView is a list of strings that can only be read.
+type StringsView struct {
+	b *structs.Bytes
+}
+
+// View returns a StringsView of s. This does not copy s, so changes made to s are seen through it.
+func (s Strings) View() StringsView {
+	return StringsView{b: s.b}
+}
+
+// Len returns the number of items in the list.
+func (s StringsView) Len() int {
+	if s.b == nil {
+		return 0
+	}
+	return s.b.Len()
+}
+
+// Get gets the string at index. This panics if the index is out of range, use TryGet()
+// if the index may not be valid.
+func (s StringsView) Get(index int) string {
+	b := s.b.Get(index)
+	if b == nil {
+		return ""
+	}
+	return conversions.ByteSlice2String(b)
+}
+
+// TryGet is like Get(), but returns ok == false instead of panicking if index is out of range.
+func (s StringsView) TryGet(index int) (value string, ok bool) {
+	if index < 0 || index >= s.Len() {
+		return "", false
+	}
+	return s.Get(index), true
+}
+
+// Any implements List.Any().
+func (s StringsView) Any(index int) any {
+	return s.Get(index)
+}
+
+// Slice returns a copy of the values as a []string. If there are no entries, this returns a nil slice.
+func (s StringsView) Slice() []string {
+	if s.b == nil {
+		return nil
+	}
+	return Strings{b: s.b}.Slice()
+}
@@ -0,0 +1,52 @@
+package vehicles
+
+import (
+	"reflect"
+	"testing"
+
+	cars "github.com/bearlytools/test_claw_imports/cars/claw"
+)
+
+func TestDiff(t *testing.T) {
+	x := NewVehicle().SetType(Car).SetTypesSlice([]Type{Car}).SetCar(cars.NewCar().SetYear(2010))
+	y := x.Clone().SetType(Truck).SetTypesSlice([]Type{Car, Truck})
+
+	if got := x.Diff(x.Clone()); got != nil {
+		t.Errorf("TestDiff(equal): got %d changes, want none", len(got))
+	}
+
+	got := x.Diff(y)
+	// Changing x and y after Diff() doesn't change what it returned.
+	x.SetType(Unknown)
+	y.SetTypesSlice(nil)
+
+	if len(got) != 2 {
+		t.Fatalf("TestDiff: got %d changes, want 2", len(got))
+	}
+
+	typ := got[0]
+	if typ.Field != "Type" || typ.FieldNum != 0 {
+		t.Errorf("TestDiff: got first change to %s(%d), want Type(0)", typ.Field, typ.FieldNum)
+	}
+	if typ.Old.Type() != Car || typ.New.Type() != Truck {
+		t.Errorf("TestDiff(Type): got %v to %v, want %v to %v", typ.Old.Type(), typ.New.Type(), Car, Truck)
+	}
+	// Old and New only hold the field that changed.
+	if typ.Old.s.IsSet(1) || typ.Old.s.IsSet(3) || typ.New.s.IsSet(1) || typ.New.s.IsSet(3) {
+		t.Errorf("TestDiff(Type): Old or New held fields other than Type")
+	}
+
+	types := got[1]
+	if types.Field != "Types" || types.FieldNum != 3 {
+		t.Errorf("TestDiff: got second change to %s(%d), want Types(3)", types.Field, types.FieldNum)
+	}
+	if got, want := types.Old.Types().Slice(), []Type{Car}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestDiff(Types): got Old %v, want %v", got, want)
+	}
+	if got, want := types.New.Types().Slice(), []Type{Car, Truck}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestDiff(Types): got New %v, want %v", got, want)
+	}
+	if types.Old.Type() != Unknown {
+		t.Errorf("TestDiff(Types): Old held fields other than Types")
+	}
+}
@@ -0,0 +1,33 @@
+package vehicles
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bearlytools/claw/languages/go/structs"
+	cars "github.com/bearlytools/test_claw_imports/cars/claw"
+)
+
+func TestVehicleFromStruct(t *testing.T) {
+	v := NewVehicle().SetType(Truck)
+
+	got, err := VehicleFromStruct(v.Struct())
+	if err != nil {
+		t.Fatalf("TestVehicleFromStruct: got err == %s, want err == nil", err)
+	}
+	if !got.Equal(v) {
+		t.Errorf("TestVehicleFromStruct: got a Vehicle that was not Equal() to the one it came from")
+	}
+	// The Vehicle shares the Struct, it is not a copy.
+	got.SetType(Car)
+	if v.Type() != Car {
+		t.Errorf("TestVehicleFromStruct: a change made to the returned Vehicle was not seen in the Struct")
+	}
+
+	if _, err := VehicleFromStruct(nil); !errors.Is(err, structs.ErrTypeMismatch) {
+		t.Errorf("TestVehicleFromStruct(nil): got err == %v, want ErrTypeMismatch", err)
+	}
+	if _, err := VehicleFromStruct(cars.NewCar().XXXGetStruct()); !errors.Is(err, structs.ErrTypeMismatch) {
+		t.Errorf("TestVehicleFromStruct(Car): got err == %v, want ErrTypeMismatch", err)
+	}
+}
@@ -5,6 +5,7 @@
 package manufacturers
 
 import (
+
     "github.com/bearlytools/claw/languages/go/reflect"
     "github.com/bearlytools/claw/languages/go/reflect/runtime"
     
@@ -60,7 +61,8 @@ var ManufacturerByValue = map[uint8]string{
     2: "Ford",
     3: "Tesla",
 } 
- 
+  
+
 
 // Everything below this line is internal details.
 
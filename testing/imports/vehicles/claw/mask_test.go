@@ -0,0 +1,26 @@
+package vehicles
+
+import (
+	"testing"
+
+	cars "github.com/bearlytools/test_claw_imports/cars/claw"
+)
+
+func TestMask(t *testing.T) {
+	src := NewVehicle().SetType(Car).SetCar(cars.NewCar().SetYear(2010).SetModel(cars.Venza))
+	dst := NewVehicle().SetType(Truck)
+
+	// cars.Car is from a package that may not have a CarMask, so Car() adds all of the field.
+	if err := NewVehicleMask().Car().Apply(dst, src); err != nil {
+		t.Fatalf("TestMask: Apply(): %s", err)
+	}
+	if got := dst.Type(); got != Truck {
+		t.Errorf("TestMask: Type(), which is not in the mask: got %v, want %v", got, Truck)
+	}
+	if got := dst.Car().Year(); got != 2010 {
+		t.Errorf("TestMask: Car().Year(): got %d, want 2010", got)
+	}
+	if got := dst.Car().Model(); got != cars.Venza {
+		t.Errorf("TestMask: Car().Model(): got %v, want %v", got, cars.Venza)
+	}
+}
@@ -5,6 +5,12 @@
 package vehicles
 
 import (
+    "bytes"
+    "database/sql/driver"
+    "fmt"
+    "hash/fnv"
+    "sync"
+
     "github.com/bearlytools/claw/languages/go/mapping"
     "github.com/bearlytools/claw/languages/go/reflect"
     "github.com/bearlytools/claw/languages/go/reflect/runtime"
@@ -12,8 +18,8 @@ import (
     "github.com/bearlytools/claw/languages/go/types/list"
     "github.com/bearlytools/claw/languages/go/field"
     
-    "github.com/bearlytools/test_claw_imports/cars/claw"
     "github.com/bearlytools/test_claw_imports/trucks"
+    "github.com/bearlytools/test_claw_imports/cars/claw"
     "github.com/bearlytools/claw/testing/imports/vehicles/claw/manufacturers"
 )
 
@@ -66,7 +72,9 @@ var TypeByValue = map[uint8]string{
 } 
 
 
-
+// Vehicle is a Claw Struct. Set methods change it in place and return it, so calls can be chained:
+// x.SetA(1).SetB(2). Copies of a Vehicle share the same data, so the returned value does not need to
+// be kept and a change made through one copy is seen by all of them.
 type Vehicle struct {
    s *structs.Struct
 }
@@ -80,6 +88,23 @@ func NewVehicle() Vehicle {
     }
 }
 
+var (
+    xxxEmptyVehicleOnce sync.Once
+    xxxEmptyVehicle Vehicle
+)
+
+// EmptyVehicle returns a Vehicle that is the same as NewVehicle(), but is made once and shared by every
+// caller. This makes x.Equal(EmptyVehicle()) a cheap check that x has not been changed from NewVehicle().
+// It is frozen (see structs.Struct.Freeze()), so its setters panic. Use NewVehicle() or its Clone() for one
+// that can be changed.
+func EmptyVehicle() Vehicle {
+    xxxEmptyVehicleOnce.Do(func() {
+        xxxEmptyVehicle = NewVehicle()
+        xxxEmptyVehicle.s.Freeze()
+    })
+    return xxxEmptyVehicle
+}
+
 // XXXNewFrom creates a new Vehicle from our internal Struct representation.
 // As with all things marked XXX*, this should not be used and has not compatibility
 // guarantees.
@@ -88,6 +113,18 @@ func NewVehicle() Vehicle {
 // show up in any documentation.
 func XXXNewFrom(s *structs.Struct) Vehicle {
     return Vehicle{s: s}
+}
+
+// VehicleFromStruct wraps s, which is usually from the Struct() method of a Vehicle, in a Vehicle.
+// The Vehicle shares s, so changes to either are seen by the other. This returns an error wrapping
+// structs.ErrTypeMismatch if s was not made with the mapping for Vehicle.
+func VehicleFromStruct(s *structs.Struct) (Vehicle, error) {
+    if err := structs.CheckMapping(s, XXXMappingVehicle); err != nil {
+        return Vehicle{}, err
+    }
+    x := Vehicle{}
+    x.XXXSetStruct(s)
+    return x, nil
 } 
 
 func (x Vehicle) Type() Type {
@@ -107,7 +144,7 @@ func (x Vehicle) Car() cars.Car {
 func (x Vehicle) SetCar(value cars.Car) Vehicle {
     structs.MustSetStruct(x.s, 1, value.XXXGetStruct())
     return x
-} 
+}  
 
 func (x Vehicle) Truck() []trucks.Truck {
     l := structs.MustGetListStruct(x.s, 2)
@@ -119,6 +156,27 @@ func (x Vehicle) Truck() []trucks.Truck {
     return vals
 }
 
+// SetTruck replaces the Truck list with values. An empty values removes the list.
+// The values must not be in another Struct or list.
+func (x Vehicle) SetTruck(values []trucks.Truck) Vehicle {
+    if len(values) == 0 {
+        if err := structs.DeleteListStructs(x.s, 2); err != nil {
+            panic(err)
+        }
+        return x
+    }
+    vals := make([]*structs.Struct, len(values))
+    for i, val := range values {
+        vals[i] = val.XXXGetStruct()
+    }
+    l, err := structs.NewStructsFromSlice(trucks.XXXMappingTruck, vals)
+    if err != nil {
+        panic(err)
+    }
+    structs.MustSetListStruct(x.s, 2, l)
+    return x
+}
+
 func (x Vehicle) AppendTruck(values ...trucks.Truck) {
     vals := make([]*structs.Struct, len(values))
     for i, val := range values {
@@ -126,6 +184,12 @@ func (x Vehicle) AppendTruck(values ...trucks.Truck) {
     }
     structs.MustAppendListStruct(x.s, 2, vals...)
 }
+
+// GrowTruck makes sure Truck has room for n more items, so that appending them doesn't need to
+// reallocate. This is like slices.Grow().
+func (x Vehicle) GrowTruck(n int) {
+    structs.MustGrowListStruct(x.s, 2, n)
+}
   
 func (x Vehicle) Types() list.Enums[Type] {
     n := structs.MustGetListNumber[Type](x.s, 3)
@@ -136,6 +200,26 @@ func (x Vehicle) SetTypes(value list.Enums[Type]) Vehicle {
     n := value.XXXNumbers()
     structs.MustSetListNumber(x.s, 3, n)
     return x
+}
+
+// SetTypesSlice sets Types to a copy of values. An empty values unsets it.
+func (x Vehicle) SetTypesSlice(values []Type) Vehicle {
+    structs.MustSetListNumberSlice(x.s, 3, values)
+    return x
+}
+
+// AppendTypes appends values to the Types list, creating it if it doesn't exist.
+func (x Vehicle) AppendTypes(values ...Type) Vehicle {
+    if len(values) == 0 {
+        return x
+    }
+    n := structs.MustGetListNumber[Type](x.s, 3)
+    if n == nil {
+        n = structs.NewNumbers[Type]()
+        structs.MustSetListNumber(x.s, 3, n)
+    }
+    n.Append(values...)
+    return x
 } 
 
 func (x Vehicle) Bools() list.Bools {
@@ -145,8 +229,163 @@ func (x Vehicle) Bools() list.Bools {
 func (x Vehicle) SetBools(value list.Bools) Vehicle {
     structs.MustSetListBool(x.s, 4, value.XXXBools())
     return x
+}
+
+// SetBoolsSlice sets Bools to a copy of values. An empty values unsets it.
+func (x Vehicle) SetBoolsSlice(values []bool) Vehicle {
+    structs.MustSetListBoolSlice(x.s, 4, values)
+    return x
 }  
 
+// Equal reports whether x and y hold the same values.
+func (x Vehicle) Equal(y Vehicle) bool {
+    return structs.Equal(x.s, y.s)
+}
+
+// VehicleChange is a field that holds different values in two Vehicles, see Vehicle.Diff().
+type VehicleChange struct {
+    // Field is the name of the field.
+    Field string
+    // FieldNum is the number of the field.
+    FieldNum uint16
+    // Old holds the field's value in the Vehicle Diff() was called on and New holds its value in
+    // the one passed to it. Each is a copy with only this field set, read the value with the getter for Field.
+    Old, New Vehicle
+}
+
+// Diff returns the fields that hold different values in x and y, in field order, which is useful
+// for recording what a change to a Vehicle did. Fields are compared as Equal() does.
+func (x Vehicle) Diff(y Vehicle) []VehicleChange {
+    nums := structs.Diff(x.s, y.s)
+    if len(nums) == 0 {
+        return nil
+    }
+    changes := make([]VehicleChange, 0, len(nums))
+    for _, n := range nums {
+        c := VehicleChange{
+            Field: XXXMappingVehicle.FieldByNumber(n).Name,
+            FieldNum: n,
+            Old: NewVehicle(),
+            New: NewVehicle(),
+        }
+        if x.s != nil {
+            if err := structs.CopyField(c.Old.s, n, x.s, n); err != nil {
+                panic(err)
+            }
+        }
+        if y.s != nil {
+            if err := structs.CopyField(c.New.s, n, y.s, n); err != nil {
+                panic(err)
+            }
+        }
+        changes = append(changes, c)
+    }
+    return changes
+}
+
+// Hash returns a hash of the content of x. Values that are Equal() have the same Hash(),
+// which allows using it as a map or cache key.
+func (x Vehicle) Hash() [16]byte {
+    h := fnv.New128a()
+    if err := structs.HashTo(h, x.s); err != nil {
+        panic(err)
+    }
+    var sum [16]byte
+    copy(sum[:], h.Sum(nil))
+    return sum
+}
+
+// Clone returns a deep copy of x. Changes to the copy do not affect x.
+func (x Vehicle) Clone() Vehicle {
+    return Vehicle{s: x.s.Clone()}
+}
+
+// ResetFields clears all fields in x. x can still be used after this.
+func (x Vehicle) ResetFields() {
+    x.s.Reset()
+}
+
+// Value implements database/sql/driver.Valuer by returning x in its encoded form.
+func (x Vehicle) Value() (driver.Value, error) {
+    if x.s == nil {
+        return nil, nil
+    }
+    buff := bytes.Buffer{}
+    if _, err := x.s.Marshal(&buff); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// Marshal returns x encoded. This does not change x, so calling it again on x, or on a copy of x,
+// returns the same bytes until x is changed.
+func (x Vehicle) Marshal() ([]byte, error) {
+    b := make([]byte, x.s.Size())
+    n, err := x.s.MarshalInto(b)
+    if err != nil {
+        return nil, err
+    }
+    return b[:n], nil
+}
+
+// MarshalGroup returns x encoded with only the fields in the field group "name", which is set with
+// the [group = name] option in the .claw file. The result decodes as a Vehicle that only
+// has those fields and can be merged into another Vehicle with its Struct().UnmarshalMerge().
+func (x Vehicle) MarshalGroup(name string) ([]byte, error) {
+    buff := bytes.Buffer{}
+    if _, err := x.s.MarshalGroup(&buff, name); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// MarshalDelta returns x encoded with only the fields that changed since x was decoded or ClearDirty()
+// was called. A peer with the earlier Vehicle applies it with its Struct().UnmarshalMerge().
+// See structs.Struct.MarshalDelta() for the changes that can't be sent this way.
+func (x Vehicle) MarshalDelta() ([]byte, error) {
+    buff := bytes.Buffer{}
+    if _, err := x.s.MarshalDelta(&buff); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// ClearDirty forgets the changes to x, so the next MarshalDelta() only has changes made after this.
+func (x Vehicle) ClearDirty() {
+    x.s.ClearDirty()
+}
+
+// UnmarshalFrom decodes one Vehicle from the front of data into x and returns the bytes
+// after it. This allows decoding messages written one after another in a []byte. On error, x is not changed.
+func (x *Vehicle) UnmarshalFrom(data []byte) (rest []byte, err error) {
+    s := structs.New(0, XXXMappingVehicle)
+    rest, err = s.UnmarshalFrom(data)
+    if err != nil {
+        return data, err
+    }
+    x.XXXSetStruct(s)
+    return rest, nil
+}
+
+// Scan implements database/sql.Scanner. src must be an encoded Vehicle in a []byte
+// or nil, which sets x to an empty Vehicle.
+func (x *Vehicle) Scan(src any) error {
+    switch v := src.(type) {
+    case nil:
+        *x = NewVehicle()
+        return nil
+    case []byte:
+        s, err := structs.NewFromReader(bytes.NewReader(v), XXXMappingVehicle)
+        if err != nil {
+            return err
+        }
+        s.XXXSetNoZeroTypeCompression()
+        *x = Vehicle{s: s}
+        return nil
+    }
+    return fmt.Errorf("cannot scan a %T into a Vehicle", src)
+}
+
 // ClawStruct returns a reflection type representing the Struct.
 func (x Vehicle) ClawStruct() reflect.Struct{
     descr := XXXStructDescrVehicle
@@ -159,19 +398,232 @@ func (x Vehicle) ClawStruct() reflect.Struct{
 // Deprecated: Not deprectated, but should not be used and should not show up in documentation.
 func (x Vehicle) XXXGetStruct() *structs.Struct {
     return x.s
+}
+
+// Struct returns the *structs.Struct that x wraps, for use with the functions in the structs package.
+// It shares data with x, see VehicleFromStruct() for going back. This implements
+// structs.Structer and claw.ClawMessage.
+func (x Vehicle) Struct() *structs.Struct {
+    return x.s
+}
+
+// Map returns the mapping for Vehicle. This implements claw.ClawMessage.
+func (x Vehicle) Map() *mapping.Map {
+    return XXXMappingVehicle
+}
+
+// HasField reports if the field called "name" is set. This is false if Vehicle has no such field.
+// The *Field() methods let code, such as a form editor, work with fields named at runtime. They do not
+// work with code generated with "clawc -nonames".
+func (x Vehicle) HasField(name string) bool {
+    fd := XXXMappingVehicle.FieldByName(name)
+    return fd != nil && x.s.IsSet(fd.FieldNum)
+}
+
+// GetField returns the value of the field called "name" in the form SetField() takes, which is
+// described by structs.GetField(). ok is false if the field is not set or Vehicle has no such field.
+func (x Vehicle) GetField(name string) (value any, ok bool) {
+    fd := XXXMappingVehicle.FieldByName(name)
+    if fd == nil || !x.s.IsSet(fd.FieldNum) {
+        return nil, false
+    }
+    v, err := structs.GetField(x.s, fd.FieldNum)
+    if err != nil {
+        return nil, false
+    }
+    return v, true
+}
+
+// SetField sets the field called "name" to value, which must be the Go type structs.TrySetField()
+// takes for the field.
+func (x Vehicle) SetField(name string, value any) error {
+    fd := XXXMappingVehicle.FieldByName(name)
+    if fd == nil {
+        return fmt.Errorf("%w: Vehicle has no field %q", structs.ErrFieldNotFound, name)
+    }
+    return structs.TrySetField(x.s, fd.FieldNum, value)
+}
+
+// DeleteField removes the field called "name".
+func (x Vehicle) DeleteField(name string) error {
+    fd := XXXMappingVehicle.FieldByName(name)
+    if fd == nil {
+        return fmt.Errorf("%w: Vehicle has no field %q", structs.ErrFieldNotFound, name)
+    }
+    structs.DeleteField(x.s, fd.FieldNum)
+    return nil
+}
+
+// XXXSetStruct sets the internal Struct representation, which is used by claw.Unmarshal().
+// Like all XXX* types/methods, this should not be used and has no compatibility guarantees.
+//
+// Deprecated: Not deprectated, but should not be used and should not show up in documentation.
+func (x *Vehicle) XXXSetStruct(s *structs.Struct) {
+    s.XXXSetNoZeroTypeCompression()
+    x.s = s
+}
+// VehicleMask is a set of fields in a Vehicle, which is used with Apply() to update
+// only those fields, such as for a PATCH request. Each method adds a field to the mask. Methods for Struct
+// fields return the mask of that Struct, so a field inside it can be added with .Owner().Name() and
+// all of it with .Owner().All().
+type VehicleMask struct {
+    m *structs.FieldMask
+    path []uint16
+}
+
+// NewVehicleMask creates an empty VehicleMask.
+func NewVehicleMask() VehicleMask {
+    return VehicleMask{m: structs.NewFieldMask()}
+}
+
+// XXXNewVehicleMask creates a VehicleMask that adds to m the fields of a Vehicle
+// held at path. Like all XXX* types/methods, this should not be used and has no compatibility guarantees.
+//
+// Deprecated: Not deprectated, but should not be used and should not show up in documentation.
+func XXXNewVehicleMask(m *structs.FieldMask, path []uint16) VehicleMask {
+    return VehicleMask{m: m, path: path}
+}
+
+// All adds all fields to the mask.
+func (x VehicleMask) All() VehicleMask {
+    x.m.Add(x.path...)
+    return x
+}
+
+// Type adds the Type field to the mask.
+func (x VehicleMask) Type() VehicleMask {
+    x.m.Add(append(x.path, 0)...)
+    return x
+}
+
+// Car adds all of the Car field to the mask. cars.Car is from another package, which
+// may have been generated without a mask type, so its fields can't be added one at a time.
+func (x VehicleMask) Car() VehicleMask {
+    x.m.Add(append(x.path, 1)...)
+    return x
+}
+
+// Truck adds the Truck field to the mask.
+func (x VehicleMask) Truck() VehicleMask {
+    x.m.Add(append(x.path, 2)...)
+    return x
+}
+
+// Types adds the Types field to the mask.
+func (x VehicleMask) Types() VehicleMask {
+    x.m.Add(append(x.path, 3)...)
+    return x
+}
+
+// Bools adds the Bools field to the mask.
+func (x VehicleMask) Bools() VehicleMask {
+    x.m.Add(append(x.path, 4)...)
+    return x
 } 
 
+// Apply copies the fields in the mask from src to dst. A field in the mask that is not set in src
+// is removed from dst. Fields not in the mask are not changed. Apply must be called on the mask
+// returned by NewVehicleMask(), not on the mask of a field.
+func (x VehicleMask) Apply(dst, src Vehicle) error {
+    if len(x.path) != 0 {
+        return fmt.Errorf("Apply() must be called on the mask created by NewVehicleMask(), not the mask of a field")
+    }
+    return structs.ApplyMask(dst.s, src.s, x.m)
+}
+
+// XXXFieldMask returns the internal FieldMask. Like all XXX* types/methods, this should not be used
+// and has no compatibility guarantees.
+//
+// Deprecated: Not deprectated, but should not be used and should not show up in documentation.
+func (x VehicleMask) XXXFieldMask() *structs.FieldMask {
+    return x.m
+}
+
+// VehicleView is a Vehicle that can only be read. It has the getters of Vehicle, but no
+// setters, and returns views for the Structs and lists in it. Use NewVehicleView() to read an encoded
+// Vehicle without copying it, or Clone() for a Vehicle that can be changed.
+type VehicleView struct {
+    s *structs.Struct
+}
+
+// NewVehicleView decodes a Vehicle from the front of data without copying it, see
+// structs.Struct.UnmarshalShared(), and returns the bytes after it. data must not be changed while the
+// VehicleView, or any value gotten from it, is in use. The VehicleView is frozen by
+// UnmarshalShared(), see structs.Struct.Freeze().
+func NewVehicleView(data []byte) (view VehicleView, rest []byte, err error) {
+    s := structs.New(0, XXXMappingVehicle)
+    s.XXXSetNoZeroTypeCompression()
+    rest, err = s.UnmarshalShared(data)
+    if err != nil {
+        return VehicleView{}, data, err
+    }
+    return VehicleView{s: s}, rest, nil
+}
+
+// View returns a VehicleView of a frozen copy of x, so changes made to x later are not seen
+// through it.
+func (x Vehicle) View() VehicleView {
+    s := x.s.Clone()
+    s.Freeze()
+    return VehicleView{s: s}
+}
+
+// XXXView returns a VehicleView that shares x's Struct, which must already be frozen. Like
+// all XXX* types/methods, this should not be used and has no compatibility guarantees.
+//
+// Deprecated: Not deprectated, but should not be used and should not show up in documentation.
+func (x Vehicle) XXXView() VehicleView {
+    return VehicleView{s: x.s}
+}
+
+// Clone returns a deep copy of x as a Vehicle, which can be changed and no longer uses the
+// data x was decoded from.
+func (x VehicleView) Clone() Vehicle {
+    return Vehicle{s: x.s.Clone()}
+}
+
+// Equal reports whether x and y hold the same values.
+func (x VehicleView) Equal(y VehicleView) bool {
+    return structs.Equal(x.s, y.s)
+}
+
+func (x VehicleView) Type() Type {
+    return Vehicle{s: x.s}.Type()
+}
+
+// Car returns the Car field. cars.Car is from another package, which may have been
+// generated without a View type, so this returns a frozen cars.Car, whose setters panic.
+func (x VehicleView) Car() cars.Car {
+    return Vehicle{s: x.s}.Car()
+}
+
+// Truck returns the Truck field. trucks.Truck is from another package, which may have been
+// generated without a View type, so this returns a frozen []trucks.Truck, whose setters panic.
+func (x VehicleView) Truck() []trucks.Truck {
+    return Vehicle{s: x.s}.Truck()
+}
+
+func (x VehicleView) Types() list.EnumsView[Type] {
+    return Vehicle{s: x.s}.Types().View()
+}
+
+func (x VehicleView) Bools() list.BoolsView {
+    return Vehicle{s: x.s}.Bools().View()
+} 
+ 
+
 // XXXDescr returns the Struct's descriptor. This should only be used
 // by the reflect package and is has no compatibility promises like all XXX fields.
 //
 // Deprecated: No deprecated, but shouldn't be used directly or show up in documentation.
 func (x Vehicle) XXXDescr() reflect.StructDescr {
     return XXXPackageDescr.Structs().Get(0)
-} 
+}  
+
 
 // Everything below this line is internal details.
 // Deprecated: Not deprecated, but shouldn't be used directly or show up in documentation.
-var XXXMappingVehicle = &mapping.Map{
+var XXXMappingVehicle = (&mapping.Map{
     Name: "Vehicle",
     Pkg: "vehicles",
     Path: "github.com/bearlytools/claw/testing/imports/vehicles/claw",
@@ -203,6 +655,8 @@ var XXXMappingVehicle = &mapping.Map{
             FullPath: "github.com/bearlytools/test_claw_imports/trucks",
             FieldNum: 2,
             IsEnum: false,
+            
+            Mapping: trucks.XXXMappingTruck,
         },
         {
             Name: "Types",
@@ -222,7 +676,7 @@ var XXXMappingVehicle = &mapping.Map{
             IsEnum: false,
         },
     },
-}
+}).Init()
 
 
 
@@ -266,29 +720,29 @@ var XXXStructDescrVehicle = &reflect.XXXStructDescrImpl{
     FieldList: []reflect.FieldDescr {
         
         reflect.XXXFieldDescrImpl{
-            FD:  XXXMappingVehicle.Fields[0],
+            FD:  XXXMappingVehicle.FieldByNumber(0),
             EG: XXXEnumGroupType, 
         }, 
         
         reflect.XXXFieldDescrImpl{
-            FD: XXXMappingVehicle.Fields[1],
+            FD: XXXMappingVehicle.FieldByNumber(1),
             SD: cars.XXXStructDescrCar,
         },
          
         
         reflect.XXXFieldDescrImpl{
-            FD: XXXMappingVehicle.Fields[2],
+            FD: XXXMappingVehicle.FieldByNumber(2),
             SD: trucks.XXXStructDescrTruck,
         },
          
         
         reflect.XXXFieldDescrImpl{
-            FD:  XXXMappingVehicle.Fields[3],
+            FD:  XXXMappingVehicle.FieldByNumber(3),
             EG: XXXEnumGroupType, 
         }, 
         
         reflect.XXXFieldDescrImpl{
-            FD:  XXXMappingVehicle.Fields[4],  
+            FD:  XXXMappingVehicle.FieldByNumber(4),  
         },  
     },
 }
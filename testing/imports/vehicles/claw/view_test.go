@@ -0,0 +1,72 @@
+package vehicles
+
+import (
+	"reflect"
+	"testing"
+
+	cars "github.com/bearlytools/test_claw_imports/cars/claw"
+)
+
+func TestView(t *testing.T) {
+	v := NewVehicle().SetType(Car).SetTypesSlice([]Type{Car, Truck})
+	v = v.SetCar(cars.NewCar().SetYear(2010))
+
+	view := v.View()
+	// View() takes a copy, so changes made to v after it are not seen.
+	v.SetTypesSlice([]Type{Unknown}).SetType(Truck)
+	v.Car().SetYear(2020)
+
+	if got := view.Type(); got != Car {
+		t.Errorf("TestView: Type(): got %v, want %v", got, Car)
+	}
+	if got := view.Types().Slice(); !reflect.DeepEqual(got, []Type{Car, Truck}) {
+		t.Errorf("TestView: Types(): got %v, want %v", got, []Type{Car, Truck})
+	}
+	if got := view.Car().Year(); got != 2010 {
+		t.Errorf("TestView: Car().Year(): got %d, want 2010", got)
+	}
+	if got := view.Bools(); got.Len() != 0 || got.Slice() != nil {
+		t.Errorf("TestView: Bools() of an unset list: got %v, want an empty list", got.Slice())
+	}
+	if !view.s.Frozen() {
+		t.Errorf("TestView: View() was not frozen")
+	}
+	// cars.Car is from a package that may not have a CarView, so the view returns a frozen cars.Car.
+	if !view.Car().XXXGetStruct().Frozen() {
+		t.Errorf("TestView: Car() was not frozen")
+	}
+
+	// The lists a view returns have no methods that change them.
+	for _, l := range []any{view.Types(), view.Bools()} {
+		typ := reflect.TypeOf(l)
+		for _, name := range []string{"Set", "Append", "Grow"} {
+			if _, ok := typ.MethodByName(name); ok {
+				t.Errorf("TestView: %s has a %s() method", typ, name)
+			}
+		}
+	}
+
+	// A Clone() can be changed without changing the view.
+	c := view.Clone().SetTypesSlice([]Type{Truck})
+	if got := c.Types().Slice(); !reflect.DeepEqual(got, []Type{Truck}) {
+		t.Errorf("TestView: Clone().Types(): got %v, want %v", got, []Type{Truck})
+	}
+	if got := view.Types().Slice(); !reflect.DeepEqual(got, []Type{Car, Truck}) {
+		t.Errorf("TestView: Types() after changing a Clone(): got %v, want %v", got, []Type{Car, Truck})
+	}
+
+	data, err := view.Clone().Marshal()
+	if err != nil {
+		t.Fatalf("TestView: Marshal(): %s", err)
+	}
+	decoded, rest, err := NewVehicleView(data)
+	if err != nil {
+		t.Fatalf("TestView: NewVehicleView(): %s", err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("TestView: NewVehicleView(): got %d bytes left over, want 0", len(rest))
+	}
+	if !decoded.Equal(view) {
+		t.Errorf("TestView: NewVehicleView() of the encoded view was not Equal() to it")
+	}
+}